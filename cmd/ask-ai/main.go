@@ -2,27 +2,51 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/x/term"
 	"github.com/spf13/pflag"
 
 	"github.com/duluk/ask-ai/pkg/LLM"
 	"github.com/duluk/ask-ai/pkg/config"
 	"github.com/duluk/ask-ai/pkg/database"
+	"github.com/duluk/ask-ai/pkg/export"
+	"github.com/duluk/ask-ai/pkg/importer"
 	"github.com/duluk/ask-ai/pkg/linewrap"
 	"github.com/duluk/ask-ai/pkg/logger"
+	"github.com/duluk/ask-ai/pkg/titles"
 	"github.com/duluk/ask-ai/pkg/tui"
 )
 
 // I'm probably writing "Ruby Go"...
 
+// sqliteCommands are the chat commands that need the history kept in SQLite
+var sqliteCommands = []string{"/tag", "/star", "/archive", "/fork", "/delete"}
+
+// startedCommands act on the conversation, which a new one only has once
+// its first prompt is recorded
+var startedCommands = []string{"/title", "/tag", "/star", "/archive", "/fork", "/delete"}
+
+// needSQLite exits unless the history is kept in SQLite, which feature needs
+func needSQLite(sqlDB *database.ChatDB, feature string) {
+	if sqlDB == nil {
+		fmt.Println("Error:", database.NeedsSQLite(feature))
+		os.Exit(1)
+	}
+}
+
 func main() {
 	var err error
 
@@ -32,6 +56,10 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.CheckConfig {
+		os.Exit(checkConfig(opts))
+	}
+
 	if opts.DumpConfig {
 		config.DumpConfig(opts)
 	}
@@ -58,47 +86,209 @@ func main() {
 		os.Exit(1)
 	}
 
+	if opts.DBMigrate {
+		if opts.DBBackend != database.BackendSQLite {
+			fmt.Println("Error migrating database:", database.NeedsSQLite("--db-migrate"))
+			os.Exit(1)
+		}
+		if err := migrate(opts); err != nil {
+			fmt.Println("Error migrating database:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// If DB exists, just opens it; otherwise, creates it first
-	db, err := database.InitializeDB(opts.DBFileName, opts.DBTable)
+	db, err := database.OpenStore(opts.DBBackend, opts.DBFileName, opts.DBTable)
 	if err != nil {
 		fmt.Println("Error opening database: ", err)
 		os.Exit(1)
 	}
 	defer db.Close()
+	// Tags, forks, statistics and the like are only kept in SQLite
+	sqlDB, _ := db.(*database.ChatDB)
 
-	if opts.ListConversations {
-		selectedID, err := tui.RunList(opts, db)
+	if sqlDB != nil {
+		if err := openEncryption(opts, sqlDB); err != nil {
+			fmt.Println("Error opening database:", err)
+			os.Exit(1)
+		}
+	}
+	if opts.DBEncrypt || opts.DBDecrypt {
+		needSQLite(sqlDB, "Encryption")
+		if err := convertEncryption(opts, sqlDB); err != nil {
+			fmt.Println("Error converting database:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.DBMerge != "" {
+		needSQLite(sqlDB, "--db-merge")
+		res, err := sqlDB.MergeFile(opts.DBMerge, opts.EncryptionKey)
 		if err != nil {
-			fmt.Println("Error listing conversations:", err)
+			fmt.Println("Error merging database:", err)
 			os.Exit(1)
 		}
-		if selectedID == 0 {
-			fmt.Println("No conversations found")
-			os.Exit(0)
+		printMerge(opts.DBMerge, res)
+		return
+	}
+
+	if opts.Prune {
+		needSQLite(sqlDB, "--prune")
+		deleted, freed, err := prune(opts, sqlDB)
+		if err != nil {
+			fmt.Println("Error pruning conversations:", err)
+			os.Exit(1)
+		}
+		if opts.Retention.IsZero() {
+			fmt.Println("No retention policy configured (database.retention); only vacuumed")
 		}
-		db.ShowConversation(selectedID)
+		fmt.Printf("Deleted %d conversations, freed %s\n", len(deleted), database.FormatSize(freed))
 		return
 	}
+	if !opts.Retention.IsZero() && sqlDB != nil {
+		// Best effort; a failure here shouldn't stop anyone from chatting
+		opts.Retention.Keep = openedConversations(opts, db)
+		deleted, freed, err := prune(opts, sqlDB)
+		if err != nil {
+			logger.Error("Error applying retention policy", "error", err)
+		} else if len(deleted) > 0 {
+			logger.Info("Applied retention policy", "deleted", deleted, "freed", freed)
+		}
+	}
 
-	if opts.SearchKeyword != "" {
-		selectedID, err := tui.RunSearch(opts, db)
+	if opts.Delete != 0 {
+		needSQLite(sqlDB, "--delete")
+		if err := sqlDB.DeleteConversation(opts.Delete); err != nil {
+			fmt.Println("Error deleting conversation:", err)
+			os.Exit(1)
+		}
+		freed, err := sqlDB.Vacuum()
 		if err != nil {
-			fmt.Println("Error searching conversations:", err)
+			fmt.Println("Error vacuuming database:", err)
 			os.Exit(1)
 		}
-		if selectedID == 0 {
-			fmt.Printf("No conversations found matching %q\n", opts.SearchKeyword)
-			os.Exit(0)
+		fmt.Printf("Deleted conversation %d, freed %s\n", opts.Delete, database.FormatSize(freed))
+		return
+	}
+
+	if opts.Retitle {
+		n, err := titles.Backfill(opts, db, func(convID int, title string, err error) {
+			if err != nil {
+				fmt.Printf("%04d: error: %v\n", convID, err)
+				return
+			}
+			fmt.Printf("%04d: %s\n", convID, title)
+		})
+		if err != nil {
+			fmt.Println("Error generating titles:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Titled %d conversations\n", n)
+		return
+	}
+
+	if opts.Import != "" {
+		needSQLite(sqlDB, "--import")
+		res, err := importer.ImportFile(sqlDB, opts.Import, opts.ImportFrom)
+		if err != nil {
+			fmt.Println("Error importing conversations:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported %d conversations", res.Imported)
+		if res.Skipped > 0 {
+			fmt.Printf(" (%d already imported)", res.Skipped)
+		}
+		fmt.Println()
+		return
+	}
+
+	if opts.Stats {
+		needSQLite(sqlDB, "--stats")
+		if opts.UseTUI {
+			err = tui.RunStats(opts, sqlDB)
+		} else {
+			var stats database.Stats
+			stats, err = sqlDB.Stats(opts.StatsFilter)
+			if err == nil {
+				err = export.WriteStats(os.Stdout, opts.StatsFormat, opts.StatsFilter, stats)
+			}
+		}
+		if err != nil {
+			fmt.Println("Error showing statistics:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.Show != 0 {
+		if err := showConversation(opts, db); err != nil {
+			fmt.Println("Error showing conversation:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.Export != "" {
+		if err := exportConversations(opts, db); err != nil {
+			fmt.Println("Error exporting conversations:", err)
+			os.Exit(1)
 		}
-		db.ShowConversation(selectedID)
 		return
 	}
 
+	// A conversation picked from the list or the search results is shown,
+	// or continued in the chat
+	if opts.ListConversations || opts.SearchKeyword != "" {
+		var choice tui.Choice
+		if opts.ListConversations {
+			choice, err = tui.RunList(opts, db)
+			if err != nil {
+				fmt.Println("Error listing conversations:", err)
+				os.Exit(1)
+			}
+			if choice.ID == 0 {
+				fmt.Println("No conversations found")
+				os.Exit(0)
+			}
+		} else {
+			choice, err = tui.RunSearch(opts, db)
+			if err != nil {
+				fmt.Println("Error searching conversations:", err)
+				os.Exit(1)
+			}
+			if choice.ID == 0 {
+				fmt.Printf("No conversations found matching %q\n", opts.SearchKeyword)
+				os.Exit(0)
+			}
+		}
+		if !choice.Continue {
+			db.ShowConversation(choice.ID)
+			return
+		}
+		opts.ConversationID = choice.ID
+		opts.UseTUI = true
+	}
+
 	model := opts.Model
 
 	/* CONTEXT? LOAD IT */
 	var convID int
 	var promptContext []LLM.LLMConversations
+	if opts.Fork != 0 {
+		// Carry on from the fork as if it had been given with --id
+		needSQLite(sqlDB, "--fork")
+		forkID, err := sqlDB.ForkConversation(opts.Fork, opts.ForkAt)
+		if err != nil {
+			fmt.Println("Error forking conversation:", err)
+			os.Exit(1)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Forked conversation %d into %d\n", opts.Fork, forkID)
+		}
+		opts.ConversationID = forkID
+	}
 	if opts.ConversationID != 0 {
 		convID = opts.ConversationID
 		logger.Debug("Conversation ID provided", "convID", convID)
@@ -111,12 +301,35 @@ func main() {
 		logger.Debug("Continuing last conversation", "convID", convID)
 	}
 
-	// Make sure we are using the correct conversation id when not provided
+	// --context starts a new conversation from the last turns of others,
+	// kept ahead of its own as it goes on
+	var recent []LLM.LLMConversations
+	if opts.ContextTurns > 0 {
+		if recent, err = db.LoadRecentTurns(opts.ContextTurns); err != nil {
+			fmt.Println("Error loading recent turns:", err)
+			os.Exit(1)
+		}
+	}
+	withRecent := func(context []LLM.LLMConversations) []LLM.LLMConversations {
+		return append(slices.Clip(recent), context...)
+	}
+
+	// --no-record sessions are kept in memory, starting from the
+	// conversation they continue, if any
+	if opts.NoRecord {
+		mem := database.NewMemoryStore()
+		if convID != 0 {
+			if err := mem.CopyConversation(db, convID); err != nil {
+				fmt.Println("Error loading conversation:", err)
+				os.Exit(1)
+			}
+		}
+		db, sqlDB = mem, nil
+	}
+
+	// A new conversation gets its ID when its first turn is recorded
 	if convID == 0 {
-		// This is a 'new' chat, not continued from previous
-		convID, _ = db.GetLastConversationID()
-		convID++
-		logger.Debug("New conversation ID", "convID", convID)
+		logger.Debug("New conversation")
 	} else {
 		// Either opts.id or opts.continue was used (determined above); we need
 		// to load the context from the convID
@@ -135,13 +348,19 @@ func main() {
 		Model:        &model,
 		SystemPrompt: &opts.SystemPrompt,
 		ConvID:       &convID,
-		Context:      promptContext,
+		Context:      withRecent(promptContext),
 		MaxTokens:    &opts.MaxTokens,
 		Temperature:  &opts.Temperature,
 		Thinking:     &opts.Thinking,
 		// Log:          log_fd,
 	}
 
+	// A chat session lasts long enough to name the conversations one-shot
+	// prompts left untitled
+	if opts.UseTUI || pflag.NArg() == 0 {
+		catchUpTitles(opts, db)
+	}
+
 	// If TUI mode is enabled, start the TUI
 	if opts.UseTUI {
 		// Ensure we don't output directly to terminal when in TUI mode
@@ -161,7 +380,8 @@ func main() {
 		prompt = pflag.Arg(0)
 		clientArgs.Prompt = &prompt
 
-		chatWithLLM(opts, clientArgs, db)
+		// Exits without waiting for a title; the next chat session names it
+		chatWithLLM(opts, clientArgs, db, false)
 	} else {
 		// Gracefully handle CTRL-C interrupt signal
 		sig := make(chan os.Signal, 1)
@@ -174,22 +394,42 @@ func main() {
 
 		for {
 			prompt = getPromptFromUser(model)
+			// /retry and /edit ask the last prompt again in place of its turn
+			replace := false
+			// /retry with another model asks only that turn of it
+			restoreModel := func() {}
 			if prompt[0] == '/' {
 				cmd := strings.Split(prompt, " ")[0]
+				if sqlDB == nil && slices.Contains(sqliteCommands, cmd) {
+					fmt.Println(database.NeedsSQLite(cmd))
+					continue
+				}
+				if convID == 0 && slices.Contains(startedCommands, cmd) {
+					fmt.Println(database.NotStarted(cmd))
+					continue
+				}
 				switch cmd {
 				case "/help", "/?":
 					fmt.Println("Special commands:")
 					fmt.Println("  /new: Start a new conversation (clear context and new conversation ID)")
 					fmt.Println("  /exit: Exit the program")
 					fmt.Println("  /context: Show the current context")
+					fmt.Println("  /retry [model]: Ask the last prompt again, optionally with another model")
+					fmt.Println("  /edit [prompt]: Revise the last prompt and ask it again")
 					fmt.Println("  /model <model>: Show the current model")
 					fmt.Println("  /id: Show the current conversation ID")
+					fmt.Println("  /title [text]: Show or set the conversation title")
+					fmt.Println("  /tag [tags]: Show the tags, or add them; '-tag' removes one")
+					fmt.Println("  /star: Star or unstar the conversation")
+					fmt.Println("  /archive: Archive or unarchive the conversation")
+					fmt.Println("  /fork [turn]: Continue in a copy of the conversation, up to a turn or all of it")
+					fmt.Println("  /delete: Delete the conversation for good and start a new one")
 					continue
 				case "/exit", "/quit":
 					fmt.Println("Goodbye!")
 					os.Exit(0)
 				case "/context":
-					fmt.Println("Context: ", promptContext)
+					fmt.Println("Context: ", withRecent(promptContext))
 					continue
 				case "/model":
 					if len(prompt) > 6 && prompt[7:] != "" {
@@ -198,25 +438,135 @@ func main() {
 					}
 					continue
 				case "/id":
-					fmt.Println("Conversation ID: ", *clientArgs.ConvID)
+					if convID == 0 {
+						fmt.Println("This conversation gets an ID with its first prompt")
+					} else {
+						fmt.Println("Conversation ID: ", convID)
+					}
 					continue
-				case "/new", "/reset":
-					// Start a new conversation: clear context and allocate a new conversation ID
-					lastID, err := db.GetLastConversationID()
+				case "/title":
+					if text := strings.TrimSpace(strings.TrimPrefix(prompt, "/title")); text != "" {
+						if err := db.SetTitle(convID, LLM.CleanTitle(text)); err != nil {
+							fmt.Println("Error setting title:", err)
+						}
+					} else if title, _ := db.GetTitle(convID); title != "" {
+						fmt.Println("Title:", title)
+					} else {
+						fmt.Println("This conversation has no title yet")
+					}
+					continue
+				case "/tag":
+					tags, err := sqlDB.EditTags(convID, strings.TrimPrefix(prompt, "/tag"))
+					if err != nil {
+						fmt.Println("Error tagging conversation:", err)
+					} else if len(tags) == 0 {
+						fmt.Println("This conversation has no tags")
+					} else {
+						fmt.Println("Tags:", strings.Join(tags, ", "))
+					}
+					continue
+				case "/star":
+					if starred, err := sqlDB.ToggleStarred(convID); err != nil {
+						fmt.Println("Error starring conversation:", err)
+					} else if starred {
+						fmt.Println("Starred conversation", convID)
+					} else {
+						fmt.Println("Unstarred conversation", convID)
+					}
+					continue
+				case "/archive":
+					if archived, err := sqlDB.ToggleArchived(convID); err != nil {
+						fmt.Println("Error archiving conversation:", err)
+					} else if archived {
+						fmt.Println("Archived conversation", convID)
+					} else {
+						fmt.Println("Unarchived conversation", convID)
+					}
+					continue
+				case "/retry", "/edit":
+					before, last, ok := LLM.SplitLastTurn(promptContext)
+					if !ok {
+						fmt.Println("There's no prompt to ask again yet")
+						continue
+					}
+					arg := strings.TrimSpace(strings.TrimPrefix(prompt, cmd))
+					if cmd == "/retry" && arg != "" {
+						prevModel, prevProvider := model, opts.Provider
+						restoreModel = func() { model, opts.Provider = prevModel, prevProvider }
+						model = arg
+						clientArgs.Model = &model
+					}
+					if cmd == "/edit" {
+						if arg == "" {
+							fmt.Println("Last prompt:", last)
+							fmt.Print("Revised prompt: ")
+							if arg = readLine(); arg == "" {
+								fmt.Println("Not edited")
+								continue
+							}
+						}
+						last = arg
+					}
+					// The replaced answer is kept as an alternative
+					prompt = last
+					clientArgs.Context = withRecent(before)
+					replace = true
+				case "/fork":
+					turn := 0
+					if arg := strings.TrimSpace(strings.TrimPrefix(prompt, "/fork")); arg != "" {
+						if turn, err = strconv.Atoi(arg); err != nil {
+							fmt.Println("Usage: /fork [turn]")
+							continue
+						}
+					}
+					forkID, err := sqlDB.ForkConversation(convID, turn)
 					if err != nil {
-						fmt.Println("Error getting last conversation ID:", err)
+						fmt.Println("Error forking conversation:", err)
+						continue
+					}
+					if promptContext, err = db.LoadConversationFromDB(forkID); err != nil {
+						fmt.Println("Error loading forked conversation:", err)
+						continue
 					}
-					convID = lastID + 1
+					fmt.Printf("Forked conversation %d into %d\n", convID, forkID)
+					convID = forkID
+					clientArgs.ConvID = &convID
+					recent = nil
+					clientArgs.Context = promptContext
+					continue
+				case "/delete":
+					fmt.Printf("Delete conversation %d for good? [y/N] ", convID)
+					if answer := strings.ToLower(strings.TrimSpace(readLine())); answer != "y" && answer != "yes" {
+						fmt.Println("Not deleted")
+						continue
+					}
+					if err := sqlDB.DeleteConversation(convID); err != nil {
+						fmt.Println("Error deleting conversation:", err)
+						continue
+					}
+					freed, err := sqlDB.Vacuum()
+					if err != nil {
+						fmt.Println("Error vacuuming database:", err)
+					}
+					fmt.Printf("Deleted conversation %d, freed %s\n", convID, database.FormatSize(freed))
+					fallthrough
+				case "/new", "/reset":
+					// Start a new conversation: clear the context; its ID
+					// comes with its first prompt
+					convID = 0
 					clientArgs.ConvID = &convID
 					promptContext = nil
+					recent = nil
 					clientArgs.Context = promptContext
-					fmt.Println("Started new conversation. New conversation ID:", convID)
+					fmt.Println("Started new conversation")
 					continue
 				}
 			}
 			clientArgs.Prompt = &prompt
 
-			chatWithLLM(opts, clientArgs, db)
+			chatWithLLM(opts, clientArgs, db, replace)
+			restoreModel()
+			titleConversation(opts, db, clientArgs)
 
 			opts.ContinueChat = true
 			promptContext, err = db.LoadConversationFromDB(*clientArgs.ConvID)
@@ -226,53 +576,21 @@ func main() {
 			// TODO: promptContext will be nil if err != nil above. That's
 			// probably what we want. Would write a test but not sure how to
 			// test the LLM functions without using tokens.
-			clientArgs.Context = promptContext
+			clientArgs.Context = withRecent(promptContext)
 		}
 	}
 }
 
-func chatWithLLM(opts *config.Options, args LLM.ClientArgs, db *database.ChatDB) {
-	model := *args.Model
-	// Parse model spec of form "provider/modelKey" or just "modelKey"
-	spec := model
-	provider := opts.Provider
-	modelKey := spec
-	// Check for explicit provider in spec
-	if idx := strings.Index(spec, "/"); idx >= 0 {
-		provider = spec[:idx]
-		modelKey = spec[idx+1:]
-		opts.Provider = provider
-		// Use only the model key for recording
-		model = modelKey
-	} else {
-		// Infer provider from modelKey if unambiguous
-		var matches []string
-		for provName, prov := range opts.Config.Models {
-			// Direct model key match
-			if _, ok := prov.Models[modelKey]; ok {
-				matches = append(matches, provName)
-			} else {
-				// Alias match
-				for _, mConf := range prov.Models {
-					if slices.Contains(mConf.Aliases, modelKey) {
-						matches = append(matches, provName)
-					}
-				}
-			}
-		}
-		if len(matches) == 1 {
-			provider = matches[0]
-			opts.Provider = provider
-		} else if len(matches) > 1 {
-			panic(fmt.Sprintf("model %q is ambiguous across providers: %v", modelKey, matches))
-		}
-	}
-
-	modelConf, err := config.GetModelConfig(opts.Config, provider, modelKey)
+// chatWithLLM sends the prompt, prints the answer as it streams in and
+// records the turn; replace records it in place of the conversation's last turn
+func chatWithLLM(opts *config.Options, args LLM.ClientArgs, db database.Store, replace bool) {
+	// The model is "provider/modelKey", or a key or alias one provider has
+	provider, model, modelConf, err := config.ResolveModel(opts.Config, opts.Provider, *args.Model)
 	if err != nil {
-		fmt.Printf("Model %q not found for provider %q\n", modelKey, provider)
+		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
+	opts.Provider = provider
 
 	// Override args with API-specific values
 	apiModel := modelConf.ModelName
@@ -284,23 +602,9 @@ func chatWithLLM(opts *config.Options, args LLM.ClientArgs, db *database.ChatDB)
 	logger.Info("Processing prompt", "->", *args.Prompt, "convID", *args.ConvID)
 	logger.Info("Using model", "provider", provider, "model", model, "temperature", apiTemp, "maxTokens", apiMax)
 
-	var client LLM.Client
-
-	switch opts.Provider {
-	case "openai":
-		apiURL := "https://api.openai.com/v1/"
-		client = LLM.NewOpenAI("openai", apiURL)
-	case "claude", "anthropic":
-		client = LLM.NewAnthropic()
-	case "gemini", "google":
-		client = LLM.NewGoogle()
-	case "ollama":
-		client = LLM.NewOllama()
-	case "grok", "xai":
-		apiURL := "https://api.x.ai/v1/"
-		client = LLM.NewOpenAI("xai", apiURL)
-	default:
-		fmt.Printf("Unknown provider: %q\n", provider)
+	client, err := LLM.NewClient(opts.Provider)
+	if err != nil {
+		fmt.Println("Error: ", err)
 		os.Exit(1)
 	}
 
@@ -309,6 +613,7 @@ func chatWithLLM(opts *config.Options, args LLM.ClientArgs, db *database.ChatDB)
 	}
 
 	// Send the chat request and start streaming responses
+	start := time.Now()
 	resp, streamChan, err := client.Chat(args, opts.ScreenTextWidth, opts.TabWidth)
 	if err != nil {
 		fmt.Println("Error: ", err)
@@ -345,6 +650,8 @@ func chatWithLLM(opts *config.Options, args LLM.ClientArgs, db *database.ChatDB)
 
 	// Collect the full response while printing chunks
 	fullResponse := ""
+	var finishReason string
+	var streamErr error
 	// Stop spinner on first chunk and wait for it to clear the line
 	spinnerStopped := false
 	for chunk := range streamChan {
@@ -355,37 +662,192 @@ func chatWithLLM(opts *config.Options, args LLM.ClientArgs, db *database.ChatDB)
 			spinnerStopped = true
 		}
 		if chunk.Error != nil {
-			fmt.Println("Error: ", chunk.Error)
-			os.Exit(1)
+			streamErr = chunk.Error
+			break
 		}
 		lw.Write([]byte(chunk.Content))
 		fullResponse += chunk.Content
+		if chunk.FinishReason != "" {
+			finishReason = chunk.FinishReason
+		}
 	}
+	latency := time.Since(start)
 
-	if !opts.Quiet {
+	if err := database.StartConversation(db, args.ConvID); err != nil {
+		fmt.Println("Error allocating conversation ID: ", err)
+		os.Exit(1)
+	}
+
+	if streamErr != nil {
+		fmt.Println("Error: ", streamErr)
+	} else if !opts.Quiet {
 		fmt.Printf("\n\n-%s (convID: %d)\n", model, *args.ConvID)
 	}
 
-	if !opts.NoRecord {
-		err = db.InsertConversation(
-			*args.Prompt,
-			fullResponse,
-			model,
-			*args.Temperature,
-			resp.InputTokens,
-			resp.OutputTokens,
-			*args.ConvID,
-		)
+	// A failed request is recorded too, with whatever arrived before it
+	// failed; --no-record only keeps it in memory for the session
+	turn := database.Turn{
+		ConvID:       *args.ConvID,
+		Prompt:       *args.Prompt,
+		Response:     fullResponse,
+		Model:        model,
+		Temperature:  *args.Temperature,
+		InputTokens:  resp.InputTokens,
+		OutputTokens: resp.OutputTokens,
+		Role:         opts.Role,
+		SystemPrompt: *args.SystemPrompt,
+		Provider:     provider,
+		MaxTokens:    apiMax,
+		FinishReason: finishReason,
+		Latency:      latency,
+	}
+	if args.Thinking != nil {
+		turn.Thinking = *args.Thinking
+	}
+	if streamErr != nil {
+		turn.Error = streamErr.Error()
+	}
+	if replace {
+		err = db.ReplaceLastTurn(turn)
+	} else {
+		err = db.InsertTurn(turn)
+	}
+	if err != nil {
+		fmt.Println("error inserting conversation into database: ", err)
+	}
+	logger.Debug("Inserted conversation into database", "convID", *args.ConvID)
+	logger.Debug("Usage stats from model", "inputTokens", resp.InputTokens, "outputTokens", resp.OutputTokens)
+
+	if streamErr != nil {
+		os.Exit(1)
+	}
+}
+
+// prune applies the retention policy and vacuums if that deleted anything,
+// or always for --prune
+func prune(opts *config.Options, db *database.ChatDB) ([]int, int64, error) {
+	deleted, err := db.Prune(opts.Retention, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(deleted) == 0 && !opts.Prune {
+		return nil, 0, nil
+	}
+	freed, err := db.Vacuum()
+	return deleted, freed, err
+}
+
+// openedConversations returns the conversations this run is asked to open,
+// with --id, --continue, --fork, --show or --export, which pruning at startup
+// mustn't delete from under it
+func openedConversations(opts *config.Options, db database.Store) []int {
+	ids := []int{opts.ConversationID, opts.Fork, opts.Show}
+	if id, err := strconv.Atoi(opts.Export); err == nil {
+		ids = append(ids, id)
+	}
+	if opts.ContinueChat {
+		if id, err := db.GetLastConversationID(); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return slices.DeleteFunc(ids, func(id int) bool { return id == 0 })
+}
+
+// exportConversations writes --export to --output, or stdout
+func exportConversations(opts *config.Options, db database.Store) error {
+	if opts.ExportOutput == "" || opts.ExportOutput == "-" {
+		return export.Export(db, opts.Export, opts.ExportFormat, os.Stdout)
+	}
+
+	f, err := os.Create(opts.ExportOutput)
+	if err != nil {
+		return err
+	}
+	if err := export.Export(db, opts.Export, opts.ExportFormat, f); err != nil {
+		f.Close()
+		os.Remove(opts.ExportOutput)
+		return err
+	}
+	return f.Close()
+}
+
+// showConversation writes the conversation for --show, through $PAGER (less
+// by default) when the output is a terminal
+func showConversation(opts *config.Options, db database.Store) error {
+	var b bytes.Buffer
+	if err := db.WriteConversation(&b, opts.Show); err != nil {
+		return err
+	}
+	if !term.IsTerminal(os.Stdout.Fd()) {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+
+	text := linewrap.NewLineWrapper(opts.ScreenTextWidth, opts.TabWidth, linewrap.NilWriter).Wrap(b.Bytes())
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less"}
+	}
+	if _, err := exec.LookPath(pager[0]); err != nil {
+		_, err := io.WriteString(os.Stdout, text)
+		return err
+	}
+	cmd := exec.Command(pager[0], pager[1:]...)
+	// Like git: quit at once if it fits on the screen, and keep it there
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// titleConversation names the conversation in the background once its first
+// exchange is recorded
+func titleConversation(opts *config.Options, db database.Store, args LLM.ClientArgs) {
+	convID := *args.ConvID
+	// Only the first exchange; turns from --context are other conversations'
+	ownTurns := slices.ContainsFunc(args.Context, func(c LLM.LLMConversations) bool { return c.ConvID == convID })
+	if opts.TitleModel == "" || opts.NoRecord || ownTurns {
+		return
+	}
+	go func() {
+		title, err := titles.Generate(opts, db, convID)
 		if err != nil {
-			fmt.Println("error inserting conversation into database: ", err)
+			logger.Info("Could not title conversation", "convID", convID, "error", err)
+			return
 		}
-		logger.Debug("Inserted conversation into database", "convID", *args.ConvID)
-		logger.Debug("Usage stats from model", "inputTokens", resp.InputTokens, "outputTokens", resp.OutputTokens)
+		logger.Debug("Titled conversation", "convID", convID, "title", title)
+	}()
+}
+
+// catchUpTitles names the latest conversations without a title in the
+// background, if a title model is configured
+func catchUpTitles(opts *config.Options, db database.Store) {
+	if opts.TitleModel == "" || opts.NoRecord {
+		return
 	}
+	go titles.Catchup(opts, db, func(convID int, title string, err error) {
+		if err != nil {
+			logger.Info("Could not title conversation", "convID", convID, "error", err)
+			return
+		}
+		logger.Debug("Titled conversation", "convID", convID, "title", title)
+	})
 }
 
 func getPromptFromUser(model string) string {
 	fmt.Printf("%s> ", model)
+	prompt := readLine()
+	if prompt == "" {
+		return getPromptFromUser(model)
+	}
+	return prompt
+}
+
+// readLine reads a line from stdin without surrounding space, exiting on EOF
+func readLine() string {
 	reader := bufio.NewReader(os.Stdin)
 	prompt, err := reader.ReadString('\n')
 	if err != nil {
@@ -398,10 +860,149 @@ func getPromptFromUser(model string) string {
 	}
 
 	// Now clean up spaces and remove the newline we just captured
-	prompt = strings.TrimSpace(prompt)
-	if prompt[len(prompt)-1] == '\n' {
-		prompt = prompt[:len(prompt)-1]
+	return strings.TrimSpace(prompt)
+}
+
+// printMerge summarizes what --db-merge brought in
+func printMerge(path string, res database.MergeResult) {
+	fmt.Printf("Merged %s: %d conversations added", path, res.Added)
+	if len(res.Remapped) > 0 {
+		fmt.Printf(" (%d under new IDs)", len(res.Remapped))
+	}
+	fmt.Printf(", %d updated, %d messages in all; %d already here\n", res.Updated, res.Messages, res.Skipped)
+	for _, id := range slices.Sorted(maps.Keys(res.Remapped)) {
+		fmt.Printf("  %d is now %d\n", id, res.Remapped[id])
+	}
+	if len(res.Diverged) > 0 {
+		fmt.Printf("Continued differently in both, left as they are here: %v\n", res.Diverged)
 	}
+}
 
-	return prompt
+// migrate runs --db-migrate, printing each step, and the SQL too with
+// --dry-run
+func migrate(opts *config.Options) error {
+	db, err := database.OpenDB(opts.DBFileName, opts.DBTable)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	from, err := db.Version()
+	if err != nil {
+		return err
+	}
+	to := opts.MigrateTo
+	if to == 0 {
+		to = database.SchemaVersion
+	}
+	steps, backup, err := db.Migrate(to, opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		fmt.Printf("Schema is at version %d; nothing to do\n", from)
+		return nil
+	}
+	if backup != "" {
+		fmt.Println("Backed up to", backup)
+	}
+	for _, step := range steps {
+		action := "Apply"
+		if step.Down {
+			action = "Undo"
+		}
+		fmt.Printf("%s migration %d: %s\n", action, step.Version, step.Name)
+		if opts.DryRun {
+			// The SQL is indented for its place in the source
+			fmt.Println(strings.ReplaceAll(strings.TrimSpace(step.SQL), "\n\t", "\n"))
+			fmt.Println()
+		}
+	}
+	if opts.DryRun {
+		fmt.Printf("Dry run: schema stays at version %d\n", from)
+	} else {
+		fmt.Printf("Schema migrated from version %d to %d\n", from, to)
+	}
+	return nil
+}
+
+// openEncryption gives the database the encryption key, or makes sure it
+// doesn't need one
+func openEncryption(opts *config.Options, db *database.ChatDB) error {
+	if opts.EncryptionKey != nil {
+		return db.SetEncryptionKey(opts.EncryptionKey)
+	}
+	encrypted, err := db.Encrypted()
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return database.ErrEncrypted
+	}
+	return nil
+}
+
+// convertEncryption runs --db-encrypt or --db-decrypt. The database is
+// vacuumed afterwards so the plaintext doesn't linger in free pages.
+func convertEncryption(opts *config.Options, db *database.ChatDB) error {
+	var n int
+	var err error
+	if opts.DBEncrypt {
+		n, err = db.EncryptAll()
+	} else {
+		n, err = db.DecryptAll()
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := db.Vacuum(); err != nil {
+		return err
+	}
+
+	if opts.DBEncrypt {
+		fmt.Printf("Encrypted %d prompts, responses, titles and system prompts\n", n)
+		if !opts.Encrypt {
+			fmt.Println("Set database.encryption.enabled to keep encrypting new ones")
+		}
+	} else {
+		fmt.Printf("Decrypted %d prompts, responses, titles and system prompts\n", n)
+		if opts.Encrypt {
+			fmt.Println("Set database.encryption.enabled to false, or new messages will be encrypted again")
+		}
+	}
+	return nil
+}
+
+// checkConfig reports the mistakes in the config file, returning the exit
+// status: 1 if any of them would keep ask-ai from working
+func checkConfig(opts *config.Options) int {
+	path := opts.ConfigFile
+	if path == "" {
+		fmt.Printf("No config file found in %s or the current directory; ask-ai runs on its defaults\n", opts.ConfigDir)
+		return 0
+	}
+	problems, err := config.CheckConfig(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	status := 0
+	for _, p := range problems {
+		msg := p.Message
+		if p.Warning {
+			msg = "warning: " + msg
+		} else {
+			status = 1
+		}
+		if p.Line > 0 {
+			fmt.Printf("%s:%d: %s\n", path, p.Line, msg)
+		} else {
+			fmt.Printf("%s: %s\n", path, msg)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Printf("%s: OK\n", path)
+	}
+	return status
 }
@@ -10,14 +10,20 @@ import (
 
 	"github.com/liushuangls/go-anthropic/v2"
 
+	"github.com/duluk/ask-ai/pkg/httpclient"
 	"github.com/duluk/ask-ai/pkg/logger"
 )
 
+// convertToAnthropicMessages skips empty messages, such as the answer to a
+// request that failed, since the API rejects empty text
 func convertToAnthropicMessages(chatHist []LLMConversations) []anthropic.Message {
-	anthropicMsgs := make([]anthropic.Message, len(chatHist))
+	anthropicMsgs := make([]anthropic.Message, 0, len(chatHist))
 
-	for i, msg := range chatHist {
+	for _, msg := range chatHist {
 		logger.Debug("Anthropic LLMConversations", "msg", msg)
+		if msg.Content == "" {
+			continue
+		}
 		var role anthropic.ChatRole
 
 		switch strings.ToLower(msg.Role) {
@@ -33,23 +39,36 @@ func convertToAnthropicMessages(chatHist []LLMConversations) []anthropic.Message
 				Text: &msg.Content,
 			},
 		}
-		anthropicMsgs[i] = anthropic.Message{
+		anthropicMsgs = append(anthropicMsgs, anthropic.Message{
 			Role:    role,
 			Content: content,
-		}
+		})
 	}
 
 	return anthropicMsgs
 }
 
-func NewAnthropic() *Anthropic {
+func NewAnthropic() (*Anthropic, error) {
 	api_key, err := getClientKey("anthropic")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	client := anthropic.NewClient(api_key)
 
-	return &Anthropic{APIKey: api_key, Client: client}
+	var clientOpts []anthropic.ClientOption
+	settings, err := httpSettings("anthropic")
+	if err != nil {
+		return nil, err
+	}
+	if !settings.IsZero() {
+		httpClient, err := httpclient.New(settings)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, anthropic.WithHTTPClient(httpClient))
+	}
+	client := anthropic.NewClient(api_key, clientOpts...)
+
+	return &Anthropic{APIKey: api_key, Client: client}, nil
 }
 
 func (cs *Anthropic) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, <-chan StreamResponse, error) {
@@ -128,6 +147,13 @@ func (cs *Anthropic) ChatStream(args ClientArgs, termWidth int, tabWidth int, st
 		return ClientResponse{}, err
 	}
 
+	stream <- StreamResponse{
+		Content:      "",
+		Done:         true,
+		Error:        nil,
+		FinishReason: string(resp.StopReason),
+	}
+
 	// I believe the stats object will be usable even if the response is empty
 	stats := resp.Usage
 	r := ClientResponse{
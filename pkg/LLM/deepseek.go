@@ -5,19 +5,51 @@ import (
 	"strings"
 
 	"github.com/duluk/ask-ai/pkg/deepseek"
+	"github.com/duluk/ask-ai/pkg/httpclient"
 )
 
-func NewDeepSeek() *DeepSeek {
+func NewDeepSeek() (*DeepSeek, error) {
 	apiKey, err := getClientKey("deepseek")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	client := deepseek.NewClient(apiKey)
 
-	return &DeepSeek{APIKey: apiKey, Client: client}
+	settings, err := httpSettings("deepseek")
+	if err != nil {
+		return nil, err
+	}
+	client.HTTPClient, err = httpclient.New(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeepSeek{APIKey: apiKey, Client: client}, nil
+}
+
+func (cs *DeepSeek) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, <-chan StreamResponse, error) {
+	responseChan := make(chan StreamResponse)
+
+	var resp ClientResponse
+	var err error
+	go func() {
+		defer close(responseChan)
+
+		// Use the streaming implementation
+		resp, err = cs.ChatStream(args, termWidth, tabWidth, responseChan)
+		if err != nil {
+			responseChan <- StreamResponse{
+				Content: "",
+				Done:    true,
+				Error:   err,
+			}
+		}
+	}()
+
+	return resp, responseChan, nil
 }
 
-func (cs *DeepSeek) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, error) {
+func (cs *DeepSeek) ChatStream(args ClientArgs, termWidth int, tabWidth int, stream chan<- StreamResponse) (ClientResponse, error) {
 	client := cs.Client
 
 	var msgCtx string
@@ -35,9 +67,14 @@ func (cs *DeepSeek) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientRe
 	const ChatModelDeepSeekChat = "deepseek-chat"
 	const ChatModelDeepSeekReasoner = "deepseek-reasoner"
 
+	model := ChatModelDeepSeekChat
+	if args.Model != nil && *args.Model != "" {
+		model = *args.Model
+	}
+
 	myInputEstimate := EstimateTokens(msgCtx + *args.Prompt + *args.SystemPrompt)
 	req := deepseek.ChatCompletionRequest{
-		Model: ChatModelDeepSeekChat,
+		Model: model,
 		Messages: []deepseek.Message{
 			{
 				Role:    "system",
@@ -52,23 +89,55 @@ func (cs *DeepSeek) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientRe
 				Content: *args.Prompt,
 			},
 		},
-		MaxTokens:   *args.MaxTokens,
-		Temperature: float64(*args.Temperature),
-		// Stream:      true,
+		MaxTokens:     *args.MaxTokens,
+		Temperature:   float64(*args.Temperature),
+		StreamOptions: &deepseek.StreamOptions{IncludeUsage: true},
 	}
 
+	var fullResponse strings.Builder
+	var usage *deepseek.Usage
+	var finishReason string
 	ctx := context.Background()
-	resp, err := client.CreateChatCompletion(ctx, req)
+	err := client.CreateChatCompletionStream(ctx, req, func(chunk deepseek.ChatCompletionChunk) {
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			return
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+
+		content := chunk.Choices[0].Delta.Content
+		if content != "" {
+			fullResponse.WriteString(content)
+			stream <- StreamResponse{
+				Content: content,
+				Done:    false,
+				Error:   nil,
+			}
+		}
+	})
 	if err != nil {
-		// Handle error
+		return ClientResponse{}, err
+	}
+
+	// Signal completion
+	stream <- StreamResponse{
+		Content:      "",
+		Done:         true,
+		Error:        nil,
+		FinishReason: finishReason,
 	}
 
 	r := ClientResponse{
-		// Text:         "NOT REAL",
-		Text:         resp.Choices[0].Message.Content,
-		InputTokens:  0,
-		OutputTokens: 0,
-		MyEstInput:   myInputEstimate,
+		Text:       fullResponse.String(),
+		MyEstInput: myInputEstimate,
+	}
+	if usage != nil {
+		r.InputTokens = int32(usage.PromptTokens)
+		r.OutputTokens = int32(usage.CompletionTokens)
 	}
 
 	return r, nil
@@ -8,6 +8,8 @@ import (
 	"github.com/google/generative-ai-go/genai"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+
+	"github.com/duluk/ask-ai/pkg/httpclient"
 )
 
 func buildPrompt(msgCtx []LLMConversations, newPrompt string) string {
@@ -19,18 +21,56 @@ func buildPrompt(msgCtx []LLMConversations, newPrompt string) string {
 	return prompt.String()
 }
 
-func NewGoogle() *Google {
+// googleFinishReason names the finish reason as the Gemini API does
+func googleFinishReason(r genai.FinishReason) string {
+	switch r {
+	case genai.FinishReasonUnspecified:
+		return ""
+	case genai.FinishReasonStop:
+		return "STOP"
+	case genai.FinishReasonMaxTokens:
+		return "MAX_TOKENS"
+	case genai.FinishReasonSafety:
+		return "SAFETY"
+	case genai.FinishReasonRecitation:
+		return "RECITATION"
+	case genai.FinishReasonOther:
+		return "OTHER"
+	default:
+		return r.String()
+	}
+}
+
+func NewGoogle() (*Google, error) {
 	apiKey, err := getClientKey("google")
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	clientOpts := []option.ClientOption{option.WithAPIKey(apiKey)}
+	settings, err := httpSettings("google")
+	if err != nil {
+		return nil, err
 	}
+	if !settings.IsZero() {
+		// The SDK ignores WithAPIKey once it's handed its own http.Client, so
+		// the key has to travel as a header instead
+		settings = settings.Merge(httpclient.Settings{
+			Headers: map[string]string{"x-goog-api-key": apiKey},
+		})
+		httpClient, err := httpclient.New(settings)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, option.WithHTTPClient(httpClient))
+	}
+
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
+	client, err := genai.NewClient(ctx, clientOpts...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	return &Google{APIKey: apiKey, Client: client, Context: ctx}
+	return &Google{APIKey: apiKey, Client: client, Context: ctx}, nil
 }
 
 func (cs *Google) SimpleChat(args ClientArgs) error {
@@ -91,6 +131,7 @@ func (cs *Google) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 
 	var resp_str string
 	var usage *genai.UsageMetadata
+	var finishReason genai.FinishReason
 	prompt := buildPrompt(args.Context, *args.Prompt)
 	myInputEstimate := EstimateTokens(prompt + *args.SystemPrompt)
 
@@ -104,6 +145,7 @@ func (cs *Google) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 			return ClientResponse{}, err
 		}
 
+		finishReason = resp.Candidates[0].FinishReason
 		r := fmt.Sprintf("%s", resp.Candidates[0].Content.Parts[0])
 		resp_str += r
 
@@ -118,9 +160,10 @@ func (cs *Google) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 
 	// TODO: do we need to check for errors?
 	stream <- StreamResponse{
-		Content: "",
-		Done:    true,
-		Error:   nil,
+		Content:      "",
+		Done:         true,
+		Error:        nil,
+		FinishReason: googleFinishReason(finishReason),
 	}
 
 	// I believe the stats object will be usable even if the response is empty
@@ -1,15 +1,16 @@
 package LLM
 
 import (
-	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"unicode"
 
 	"github.com/spf13/viper"
+
+	"github.com/duluk/ask-ai/pkg/credentials"
+	"github.com/duluk/ask-ai/pkg/httpclient"
 )
 
 // Gemini created this function, along with tokenizeWord. It's not perfect by
@@ -26,6 +27,18 @@ func EstimateTokens(text string) int32 {
 	return tokenCount
 }
 
+// SplitLastTurn returns the context's last prompt and the context before it,
+// which is what asking that prompt again needs. ok is false if the context
+// has no prompt.
+func SplitLastTurn(context []LLMConversations) (before []LLMConversations, prompt string, ok bool) {
+	for i := len(context) - 1; i >= 0; i-- {
+		if context[i].Role == "user" {
+			return context[:i:i], context[i].Content, true
+		}
+	}
+	return context, "", false
+}
+
 func tokenizeWord(word string) int32 {
 	var tokens int32
 	var currentToken string
@@ -60,50 +73,109 @@ func tokenizeWord(word string) int32 {
 	return tokens
 }
 
-// TODO: what should the precedence be? Which should be used first, env or config?
+// getClientKey looks up the API key for llm. If the provider has a
+// `credentials` list in the config, those sources are used as given;
+// otherwise the old behavior applies: environment, then api_key from the
+// config (run through the shell if it contains whitespace), then the key file.
+// A missing key comes back as a *credentials.MissingKeyError.
 func getClientKey(llm string) (string, error) {
-	// 1) First try environment variable
-	keyEnv := strings.ToUpper(llm) + "_API_KEY"
-	if key := os.Getenv(keyEnv); key != "" {
-		return key, nil
-	}
+	return credentials.Lookup(llm, clientKeySources(llm))
+}
 
-	// 2) Try API key from config file via viper
+func clientKeySources(llm string) []credentials.Source {
+	var sources []credentials.Source
+	cfgSources := fmt.Sprintf("models.%s.credentials", llm)
+	if err := viper.UnmarshalKey(cfgSources, &sources); err == nil && len(sources) > 0 {
+		return sources
+	}
 	cfgKey := fmt.Sprintf("models.%s.api_key", llm)
-	if key := viper.GetString(cfgKey); key != "" {
+	return DefaultKeySources(llm, viper.GetString(cfgKey))
+}
+
+// DefaultKeySources are the places llm's key is looked for when the config
+// doesn't list its credentials: the environment, the api_key from the config,
+// then the key file
+func DefaultKeySources(llm, apiKey string) []credentials.Source {
+	var sources []credentials.Source
+
+	// 1) Environment variable
+	sources = append(sources, credentials.Source{
+		Type: credentials.SourceEnv,
+		Env:  strings.ToUpper(llm) + "_API_KEY",
+	})
+
+	// 2) API key from config file
+	if apiKey != "" {
 		// If the api_key contains whitespace, treat it as a shell command to run
-		if strings.ContainsAny(key, " \t") {
-			out, err := exec.Command("sh", "-c", key).Output()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error executing API key command for %s: %v\n", llm, err)
-				os.Exit(1)
-			}
-			return strings.TrimSpace(string(out)), nil
+		if strings.ContainsAny(apiKey, " \t") {
+			sources = append(sources, credentials.Source{Type: credentials.SourceCommand, Command: apiKey})
+		} else {
+			sources = append(sources, credentials.Source{Type: credentials.SourceKey, Key: apiKey})
 		}
-		return key, nil
 	}
 
-	// 3) Fallback: read key from file under XDG or HOME config dir
+	// 3) Key file under XDG or HOME config dir
 	cfgHome := os.Getenv("XDG_CONFIG_HOME")
 	if cfgHome == "" {
 		cfgHome = filepath.Join(os.Getenv("HOME"), ".config")
 	}
 	keyFile := strings.ToLower(llm) + "-api-key"
-	filePath := filepath.Join(cfgHome, "ask-ai", keyFile)
-	file, err := os.Open(filePath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading API key for %s: %v\n", llm, err)
-		os.Exit(1)
+	sources = append(sources, credentials.Source{
+		Type: credentials.SourceFile,
+		File: filepath.Join(cfgHome, "ask-ai", keyFile),
+	})
+
+	return sources
+}
+
+// httpSettings returns the top-level `http` block with the provider's own
+// `models.<llm>.http` block layered on top.
+func httpSettings(llm string) (httpclient.Settings, error) {
+	var global, provider httpclient.Settings
+	if err := viper.UnmarshalKey("http", &global); err != nil {
+		return httpclient.Settings{}, fmt.Errorf("invalid http config: %w", err)
+	}
+	if err := viper.UnmarshalKey(fmt.Sprintf("models.%s.http", llm), &provider); err != nil {
+		return httpclient.Settings{}, fmt.Errorf("invalid http config for %s: %w", llm, err)
 	}
-	defer file.Close()
+	return global.Merge(provider), nil
+}
 
-	scanner := bufio.NewScanner(file)
-	if scanner.Scan() {
-		return scanner.Text(), nil
+// Providers are the names NewClient takes, some of them for the same
+// provider
+var Providers = []string{"openai", "anthropic", "claude", "google", "gemini", "ollama", "xai", "grok", "deepseek"}
+
+// NeedsKey says whether the provider needs an API key; ollama's models run
+// locally
+func NeedsKey(provider string) bool {
+	return provider != "ollama"
+}
+
+// NewClient returns the client for provider, accepting the same provider
+// aliases as the config (claude, gemini, grok).
+func NewClient(provider string) (Client, error) {
+	var client Client
+	var err error
+
+	switch provider {
+	case "openai":
+		client, err = NewOpenAI("openai", "https://api.openai.com/v1/")
+	case "claude", "anthropic":
+		client, err = NewAnthropic()
+	case "gemini", "google":
+		client, err = NewGoogle()
+	case "ollama":
+		client, err = NewOllama()
+	case "grok", "xai":
+		client, err = NewOpenAI("xai", "https://api.x.ai/v1/")
+	case "deepseek":
+		client, err = NewDeepSeek()
+	default:
+		return nil, fmt.Errorf("unknown provider: %q", provider)
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading API key for %s: %v\n", llm, err)
-		os.Exit(1)
+	if err != nil {
+		return nil, err
 	}
-	return "", fmt.Errorf("No API key found for %s", llm)
+
+	return client, nil
 }
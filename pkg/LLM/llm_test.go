@@ -1,13 +1,19 @@
 package LLM
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/generative-ai-go/genai"
 	"github.com/liushuangls/go-anthropic/v2"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/credentials"
 )
 
 // Tokenization tests
@@ -55,12 +61,36 @@ func TestEstimateTokens_Whitespace(t *testing.T) {
 }
 
 // getClientKey tests
+func TestSplitLastTurn(t *testing.T) {
+	context := []LLMConversations{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "one"},
+		{Role: "user", Content: "second"},
+		{Role: "assistant", Content: "two"},
+	}
+	before, prompt, ok := SplitLastTurn(context)
+	assert.True(t, ok)
+	assert.Equal(t, "second", prompt)
+	assert.Equal(t, context[:2], before)
+
+	// A prompt whose response never arrived is still the last turn
+	before, prompt, ok = SplitLastTurn(context[:3])
+	assert.True(t, ok)
+	assert.Equal(t, "second", prompt)
+	assert.Len(t, before, 2)
+
+	_, _, ok = SplitLastTurn(nil)
+	assert.False(t, ok)
+}
+
 func TestGetClientKey(t *testing.T) {
+	credentials.ResetCache()
 	os.Setenv("TEST_API_KEY", "test-key")
 	key, err := getClientKey("test")
 	assert.NoError(t, err)
 	assert.Equal(t, "test-key", key)
 	os.Unsetenv("TEST_API_KEY")
+	credentials.ResetCache()
 
 	home := os.Getenv("HOME")
 	cfgDir := filepath.Join(home, ".config", "ask-ai")
@@ -76,6 +106,7 @@ func TestGetClientKey(t *testing.T) {
 
 func TestGetClientKey_PriorityEnvViper(t *testing.T) {
 	viper.Reset()
+	credentials.ResetCache()
 	os.Unsetenv("TESTPRE_API_KEY")
 	defer os.Unsetenv("TESTPRE_API_KEY")
 	os.Setenv("TESTPRE_API_KEY", "envKey")
@@ -88,6 +119,7 @@ func TestGetClientKey_PriorityEnvViper(t *testing.T) {
 
 func TestGetClientKey_ViperNoWhitespace(t *testing.T) {
 	viper.Reset()
+	credentials.ResetCache()
 	os.Unsetenv("TEST_API_KEY")
 	cfgHome := t.TempDir()
 	os.Setenv("XDG_CONFIG_HOME", cfgHome)
@@ -100,6 +132,7 @@ func TestGetClientKey_ViperNoWhitespace(t *testing.T) {
 
 func TestGetClientKey_ViperShellCommand(t *testing.T) {
 	viper.Reset()
+	credentials.ResetCache()
 	os.Unsetenv("TEST_API_KEY")
 	cfgHome := t.TempDir()
 	os.Setenv("XDG_CONFIG_HOME", cfgHome)
@@ -112,6 +145,7 @@ func TestGetClientKey_ViperShellCommand(t *testing.T) {
 
 func TestGetClientKey_FileMultiline(t *testing.T) {
 	viper.Reset()
+	credentials.ResetCache()
 	os.Unsetenv("MULTI_API_KEY")
 	tmp := t.TempDir()
 	os.Setenv("XDG_CONFIG_HOME", tmp)
@@ -127,6 +161,64 @@ func TestGetClientKey_FileMultiline(t *testing.T) {
 	assert.Equal(t, "line1", key)
 }
 
+func TestGetClientKey_ConfiguredSources(t *testing.T) {
+	viper.Reset()
+	credentials.ResetCache()
+	os.Unsetenv("SRCS_API_KEY")
+
+	viper.Set("models.srcs.credentials", []map[string]any{
+		{"type": "env", "env": "SRCS_ALT_KEY"},
+		{"type": "command", "command": "echo from-command"},
+	})
+	key, err := getClientKey("srcs")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-command", key)
+}
+
+func TestGetClientKey_Missing(t *testing.T) {
+	viper.Reset()
+	credentials.ResetCache()
+	os.Unsetenv("NOKEY_API_KEY")
+	os.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	defer os.Unsetenv("XDG_CONFIG_HOME")
+
+	_, err := getClientKey("nokey")
+	assert.ErrorIs(t, err, credentials.ErrNoKey)
+	assert.Contains(t, err.Error(), "no key for nokey")
+}
+
+func TestNewClient_UnknownProvider(t *testing.T) {
+	_, err := NewClient("marklar")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown provider")
+}
+
+func TestHTTPSettings_ProviderOverridesGlobal(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("http", map[string]any{
+		"connect_timeout": "5s",
+		"proxy":           "http://global:3128",
+	})
+	viper.Set("models.anthropic.http", map[string]any{
+		"idle_timeout": "1m",
+		"proxy":        "http://corp:3128",
+		"headers":      map[string]any{"x-team": "platform"},
+	})
+
+	settings, err := httpSettings("anthropic")
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, settings.ConnectTimeout)
+	assert.Equal(t, time.Minute, settings.IdleTimeout)
+	assert.Equal(t, "http://corp:3128", settings.Proxy)
+	assert.Equal(t, "platform", settings.Headers["x-team"])
+
+	settings, err = httpSettings("openai")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://global:3128", settings.Proxy)
+	assert.Zero(t, settings.IdleTimeout)
+}
+
 // buildPrompt tests
 func TestBuildPrompt(t *testing.T) {
 	ctx := []LLMConversations{
@@ -144,10 +236,18 @@ func TestBuildPrompt_EmptyContext(t *testing.T) {
 }
 
 // client constructors tests
+func TestGoogleFinishReason(t *testing.T) {
+	assert.Equal(t, "", googleFinishReason(genai.FinishReasonUnspecified))
+	assert.Equal(t, "STOP", googleFinishReason(genai.FinishReasonStop))
+	assert.Equal(t, "MAX_TOKENS", googleFinishReason(genai.FinishReasonMaxTokens))
+	assert.Equal(t, "FinishReason(9)", googleFinishReason(genai.FinishReason(9)))
+}
+
 func TestNewOpenAI_SetsAPIKeyAndClient(t *testing.T) {
 	os.Setenv("OPENAI_API_KEY", "oapi")
 	defer os.Unsetenv("OPENAI_API_KEY")
-	cli := NewOpenAI("openai", "http://example.com")
+	cli, err := NewOpenAI("openai", "http://example.com")
+	assert.NoError(t, err)
 	assert.Equal(t, "oapi", cli.APIKey)
 	assert.NotNil(t, cli.Client)
 }
@@ -155,7 +255,8 @@ func TestNewOpenAI_SetsAPIKeyAndClient(t *testing.T) {
 func TestNewGoogle_SetsAPIKeyAndClient(t *testing.T) {
 	os.Setenv("GOOGLE_API_KEY", "gkey")
 	defer os.Unsetenv("GOOGLE_API_KEY")
-	g := NewGoogle()
+	g, err := NewGoogle()
+	assert.NoError(t, err)
 	assert.Equal(t, "gkey", g.APIKey)
 	assert.NotNil(t, g.Client)
 }
@@ -175,4 +276,110 @@ func TestConvertToAnthropicMessages(t *testing.T) {
 	assert.Equal(t, "a1", *msgs[1].Content[0].Text)
 	assert.Equal(t, anthropic.ChatRole(""), msgs[2].Role)
 	assert.Equal(t, "x", *msgs[2].Content[0].Text)
+
+	// A failed request's empty answer isn't sent
+	msgs = convertToAnthropicMessages([]LLMConversations{
+		{Role: "user", Content: "u1"},
+		{Role: "assistant", Content: ""},
+		{Role: "user", Content: "u2"},
+	})
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "u2", *msgs[1].Content[0].Text)
+}
+
+// stubClient streams a canned reply and records the args it was called with
+type stubClient struct {
+	chunks []StreamResponse
+	args   ClientArgs
+}
+
+func (c *stubClient) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, <-chan StreamResponse, error) {
+	c.args = args
+	stream := make(chan StreamResponse, len(c.chunks))
+	for _, chunk := range c.chunks {
+		stream <- chunk
+	}
+	close(stream)
+	return ClientResponse{}, stream, nil
+}
+
+func (c *stubClient) ChatStream(args ClientArgs, termWidth int, tabWidth int, stream chan<- StreamResponse) (ClientResponse, error) {
+	return ClientResponse{}, nil
+}
+
+func TestGenerateTitle(t *testing.T) {
+	client := &stubClient{chunks: []StreamResponse{{Content: "\"Chess Openings"}, {Content: " Compared\".\n"}, {Done: true}}}
+	model := "cheap"
+	sys := "the user's system prompt"
+	args := ClientArgs{Model: &model, SystemPrompt: &sys, Context: []LLMConversations{{Role: "user", Content: "old"}}}
+
+	title, err := GenerateTitle(client, args, "which chess opening?", "the Reti")
+	assert.NoError(t, err)
+	assert.Equal(t, "Chess Openings Compared", title)
+	assert.Equal(t, "cheap", *client.args.Model)
+	assert.Contains(t, *client.args.Prompt, "which chess opening?")
+	assert.Contains(t, *client.args.SystemPrompt, "short titles")
+	assert.Nil(t, client.args.Context)
+
+	client = &stubClient{chunks: []StreamResponse{{Error: assert.AnError, Done: true}}}
+	_, err = GenerateTitle(client, args, "p", "r")
+	assert.ErrorIs(t, err, assert.AnError)
+
+	client = &stubClient{chunks: []StreamResponse{{Content: "  \n"}, {Done: true}}}
+	_, err = GenerateTitle(client, args, "p", "r")
+	assert.Error(t, err)
+}
+
+// streamingClient sends its chunks on an unbuffered stream from a goroutine,
+// like the real clients, and closes sent when it's done
+type streamingClient struct {
+	chunks []StreamResponse
+	sent   chan struct{}
+}
+
+func (c *streamingClient) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, <-chan StreamResponse, error) {
+	stream := make(chan StreamResponse)
+	go func() {
+		defer close(c.sent)
+		defer close(stream)
+		for _, chunk := range c.chunks {
+			stream <- chunk
+		}
+	}()
+	return ClientResponse{}, stream, nil
+}
+
+func (c *streamingClient) ChatStream(args ClientArgs, termWidth int, tabWidth int, stream chan<- StreamResponse) (ClientResponse, error) {
+	return ClientResponse{}, nil
+}
+
+func TestGenerateTitle_DrainsStreamOnError(t *testing.T) {
+	client := &streamingClient{
+		chunks: []StreamResponse{{Error: assert.AnError}, {Error: errors.New("second")}, {Done: true}},
+		sent:   make(chan struct{}),
+	}
+	model := "cheap"
+	_, err := GenerateTitle(client, ClientArgs{Model: &model}, "p", "r")
+	assert.ErrorIs(t, err, assert.AnError)
+
+	select {
+	case <-client.sent:
+	case <-time.After(time.Second):
+		t.Fatal("the client was left blocked sending on the stream")
+	}
+}
+
+func TestCleanTitle(t *testing.T) {
+	tests := map[string]string{
+		"Plain title":                   "Plain title",
+		"  **Bold Title**  ":            "Bold Title",
+		"Title: Debugging Go Channels.": "Debugging Go Channels",
+		"\n\n'Quoted'\nsecond line":     "Quoted",
+		"# Heading":                     "Heading",
+		strings.Repeat("x", 100):        strings.Repeat("x", 80),
+		"":                              "",
+	}
+	for in, want := range tests {
+		assert.Equal(t, want, CleanTitle(in), in)
+	}
 }
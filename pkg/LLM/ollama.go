@@ -3,14 +3,24 @@ package LLM
 import (
 	"strings"
 
+	"github.com/duluk/ask-ai/pkg/httpclient"
 	"github.com/duluk/ask-ai/pkg/ollama"
 )
 
-func NewOllama() *Ollama {
+func NewOllama() (*Ollama, error) {
 	apiKey := ""
 	client := ollama.NewClient(apiKey, ollama.OllamaBaseURL)
 
-	return &Ollama{APIKey: apiKey, Client: client}
+	settings, err := httpSettings("ollama")
+	if err != nil {
+		return nil, err
+	}
+	client.HTTPClient, err = httpclient.New(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ollama{APIKey: apiKey, Client: client}, nil
 }
 
 func (cs *Ollama) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, <-chan StreamResponse, error) {
@@ -104,8 +114,12 @@ func (cs *Ollama) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 	}
 
 	// Use the streaming API from ollama client
+	var finishReason string
 	err := client.ChatCompletionStream(req, func(chunk ollama.ChatCompletionChunk) {
 		if len(chunk.Choices) > 0 {
+			if reason, ok := chunk.Choices[0].FinishReason.(string); ok {
+				finishReason = reason
+			}
 			content := chunk.Choices[0].Delta.Content
 
 			// Only send non-empty content
@@ -129,9 +143,10 @@ func (cs *Ollama) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 
 	// Signal completion
 	stream <- StreamResponse{
-		Content: "",
-		Done:    true,
-		Error:   nil,
+		Content:      "",
+		Done:         true,
+		Error:        nil,
+		FinishReason: finishReason,
 	}
 
 	// TODO: populate this at some point? Do we have this data?
@@ -8,19 +8,33 @@ import (
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/shared"
+
+	"github.com/duluk/ask-ai/pkg/httpclient"
 )
 
-func NewOpenAI(apiLLC string, apiURL string) *OpenAI {
+func NewOpenAI(apiLLC string, apiURL string) (*OpenAI, error) {
 	apiKey, err := getClientKey(apiLLC)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	client := openai.NewClient(
+	clientOpts := []option.RequestOption{
 		option.WithAPIKey(apiKey),
 		option.WithBaseURL(apiURL),
-	)
+	}
+	settings, err := httpSettings(apiLLC)
+	if err != nil {
+		return nil, err
+	}
+	if !settings.IsZero() {
+		httpClient, err := httpclient.New(settings)
+		if err != nil {
+			return nil, err
+		}
+		clientOpts = append(clientOpts, option.WithHTTPClient(httpClient))
+	}
+	client := openai.NewClient(clientOpts...)
 
-	return &OpenAI{APIKey: apiKey, Client: &client}
+	return &OpenAI{APIKey: apiKey, Client: &client}, nil
 }
 
 func (cs *OpenAI) Chat(args ClientArgs, termWidth int, tabWidth int) (ClientResponse, <-chan StreamResponse, error) {
@@ -92,9 +106,13 @@ func (cs *OpenAI) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 	)
 
 	// Process the stream in chunks
+	var finishReason string
 	for openaiStream.Next() {
 		evt := openaiStream.Current()
 		if len(evt.Choices) > 0 {
+			if evt.Choices[0].FinishReason != "" {
+				finishReason = evt.Choices[0].FinishReason
+			}
 			data := evt.Choices[0].Delta.Content
 
 			// Only send non-empty content
@@ -136,9 +154,10 @@ func (cs *OpenAI) ChatStream(args ClientArgs, termWidth int, tabWidth int, strea
 
 	// Signal completion
 	stream <- StreamResponse{
-		Content: "",
-		Done:    true,
-		Error:   nil,
+		Content:      "",
+		Done:         true,
+		Error:        nil,
+		FinishReason: finishReason,
 	}
 
 	// TODO: populate this?
@@ -0,0 +1,84 @@
+package LLM
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const titleSystemPrompt = "You write short titles for conversations. Reply with only the title: " +
+	"at most six words, no quotes and no trailing punctuation."
+
+// Only the start of the exchange is needed to name it
+const (
+	titleExcerptLen = 2000
+	maxTitleLen     = 80
+)
+
+// GenerateTitle asks the model in args for a short title for a conversation
+// that opened with prompt and response. The model, token and temperature
+// settings come from args; the prompts and context are replaced.
+func GenerateTitle(client Client, args ClientArgs, prompt, response string) (string, error) {
+	titlePrompt := "Write a title for this conversation.\n\nUser: " + excerpt(prompt, titleExcerptLen) +
+		"\n\nAssistant: " + excerpt(response, titleExcerptLen)
+	systemPrompt := titleSystemPrompt
+	args.Prompt = &titlePrompt
+	args.SystemPrompt = &systemPrompt
+	args.Context = nil
+	args.DisableOutput = true
+
+	// The response isn't displayed, so there's nothing to wrap
+	_, stream, err := client.Chat(args, 1<<16, 4)
+	if err != nil {
+		return "", err
+	}
+	// The stream is read to the end even after an error, as clients can send
+	// more than one and would block on a stream no one reads
+	var b strings.Builder
+	for chunk := range stream {
+		if chunk.Error != nil && err == nil {
+			err = chunk.Error
+		}
+		b.WriteString(chunk.Content)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	title := CleanTitle(b.String())
+	if title == "" {
+		return "", fmt.Errorf("model returned an empty title")
+	}
+	return title, nil
+}
+
+// decoration is what models wrap titles in
+const decoration = "\"'`*#_ "
+
+// CleanTitle reduces a model's reply, or a title typed by hand, to a single
+// short line without the decoration models like to add
+func CleanTitle(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) > 6 && strings.EqualFold(line[:6], "title:") {
+			line = strings.TrimSpace(line[6:])
+		}
+		// Quotes and a final period can come in either order
+		line = strings.Trim(line, decoration)
+		line = strings.TrimRight(line, ".")
+		line = strings.Trim(line, decoration)
+		return excerpt(line, maxTitleLen)
+	}
+	return ""
+}
+
+// excerpt cuts s to at most n runes
+func excerpt(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -32,11 +32,14 @@ type ClientResponse struct {
 	MyEstInput   int32 // May be used at some point
 }
 
-// StreamResponse represents a chunk of streaming response
+// StreamResponse represents a chunk of streaming response. The last chunk
+// has Done set, and FinishReason says why the model stopped, in the
+// provider's words (eg "stop", "end_turn", "max_tokens"), if it said.
 type StreamResponse struct {
-	Content string
-	Done    bool
-	Error   error
+	Content      string
+	Done         bool
+	Error        error
+	FinishReason string
 }
 
 type Client interface {
@@ -0,0 +1,675 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/duluk/ask-ai/pkg/LLM"
+	"github.com/duluk/ask-ai/pkg/credentials"
+	"github.com/duluk/ask-ai/pkg/database"
+	"github.com/duluk/ask-ai/pkg/httpclient"
+	"github.com/duluk/ask-ai/pkg/paths"
+)
+
+// Problem is something wrong with the config file
+type Problem struct {
+	Line    int // 0 when it isn't on any one line
+	Message string
+	Warning bool // worth knowing, but ask-ai runs regardless
+}
+
+// CheckConfig reads the config file at path and reports everything wrong
+// with it, by line, where Initialize would stop at the first problem, or not
+// notice it at all. The YAML is read as written rather than through viper,
+// so keys with a '.' in them are seen before viper splits them.
+func CheckConfig(path string) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return checkConfig(data), nil
+}
+
+// setting is what a key of the config holds: a block of settings with keys
+// of their own, or a value, which check checks if it isn't nil. A setting
+// with neither can hold anything.
+type setting struct {
+	keys  keys
+	check func(c *checker, v *yaml.Node, path string)
+}
+
+type keys map[string]setting
+
+var (
+	httpKeys = keys{
+		"connect_timeout": {check: (*checker).duration},
+		"idle_timeout":    {check: (*checker).duration},
+		"proxy":           {check: (*checker).proxy},
+		"ca_bundle":       {},
+		"headers":         {},
+	}
+	credentialKeys = keys{"type": {}, "env": {}, "key": {}, "file": {}, "command": {}, "helper": {}}
+	roleKeys       = keys{"description": {}, "model": {check: (*checker).modelRef}, "prompt": {check: (*checker).prompt}}
+	// The old-style model block, still read for its defaults
+	modelBlockKeys = keys{
+		"default":        {check: (*checker).modelRef},
+		"max_tokens":     {check: (*checker).positive},
+		"thinking":       {check: (*checker).thinking},
+		"context_length": {check: (*checker).positive},
+		"temperature":    {check: temperature(2)},
+		"system_prompt":  {},
+	}
+	topKeys = keys{
+		"roles":    {check: (*checker).roles},
+		"models":   {check: (*checker).models},
+		"model":    {keys: modelBlockKeys},
+		"defaults": {keys: defaultsKeys},
+		"http":     {keys: httpKeys},
+		"log":      {keys: logKeys},
+		"database": {keys: databaseKeys},
+		"profiles": {check: (*checker).profiles},
+	}
+	// What a profile can set, over the rest of the config
+	profileKeys = keys{
+		"defaults": {keys: defaultsKeys},
+		"models":   {check: (*checker).models},
+		"database": {keys: databaseKeys},
+		"log":      {keys: logKeys},
+		"http":     {keys: httpKeys},
+	}
+	defaultsKeys = keys{
+		"model":          {check: (*checker).modelRef},
+		"title_model":    {check: (*checker).modelRef},
+		"provider":       {check: (*checker).defaultProvider},
+		"role":           {check: (*checker).roleRef},
+		"max_tokens":     {check: (*checker).positive},
+		"context_length": {check: (*checker).positive},
+		"temperature":    {check: temperature(2)},
+		"thinking":       {check: (*checker).thinking},
+		"system_prompt":  {},
+	}
+	logKeys = keys{
+		"file":        {},
+		"level":       {},
+		"max_size":    {check: (*checker).count},
+		"max_backups": {check: (*checker).count},
+	}
+	databaseKeys = keys{
+		"file":    {},
+		"table":   {},
+		"backend": {check: (*checker).backend},
+		"retention": {keys: keys{
+			"max_age":           {check: (*checker).age},
+			"max_conversations": {check: (*checker).count},
+			"keep_starred":      {check: (*checker).boolean},
+		}},
+		"encryption": {keys: keys{
+			"enabled":     {check: (*checker).boolean},
+			"key":         {},
+			"credentials": {check: (*checker).keySources},
+		}},
+	}
+)
+
+// modelKeys are the settings of a model; a provider may take a lower
+// temperature than most
+func modelKeys(maxTemperature float64) keys {
+	return keys{
+		"aliases":     {check: (*checker).aliases},
+		"model_name":  {},
+		"temperature": {check: temperature(maxTemperature)},
+		"max_tokens":  {check: (*checker).positive},
+		"thinking":    {check: (*checker).thinking},
+	}
+}
+
+// maxTemperatures are the providers whose APIs take less than 2
+var maxTemperatures = map[string]float64{"anthropic": 1, "claude": 1}
+
+// reference is a model or role named somewhere in the config, looked up once
+// the whole file has been read
+type reference struct {
+	node *yaml.Node
+	path string
+}
+
+type checker struct {
+	problems    []Problem
+	cfg         Config // the models as written, to look references up in
+	provider    string // defaults.provider, for models named without one
+	names       map[string]modelName
+	modelRefs   []reference
+	roleRefs    []reference
+	profileRefs []reference // checked over the rest of the config, once it's read
+	inProfile   bool
+}
+
+// modelName is where a model key or alias was first given
+type modelName struct {
+	provider, model string
+	line            int
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func checkConfig(data []byte) []Problem {
+	c := &checker{
+		cfg:      Config{Models: make(map[string]Provider), Roles: make(map[string]RoleConfig)},
+		provider: "openai",
+		names:    make(map[string]modelName),
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return []Problem{{Line: line, Message: m[2]}}
+		}
+		return []Problem{{Message: err.Error()}}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	c.block(doc.Content[0], "", topKeys)
+	c.resolve()
+
+	for _, profile := range c.profileRefs {
+		pc := c.profile()
+		pc.block(profile.node, profile.path, profileKeys)
+		pc.resolve()
+		c.problems = append(c.problems, pc.problems...)
+	}
+
+	sort.SliceStable(c.problems, func(i, j int) bool { return c.problems[i].Line < c.problems[j].Line })
+	return c.problems
+}
+
+// resolve looks up the models and roles named in the config
+func (c *checker) resolve() {
+	for _, ref := range c.modelRefs {
+		if _, _, _, err := ResolveModel(&c.cfg, c.provider, ref.node.Value); err != nil {
+			c.errorf(ref.node, "%s: %v", ref.path, err)
+		}
+	}
+	for _, ref := range c.roleRefs {
+		if _, ok := c.cfg.Roles[ref.node.Value]; !ok {
+			c.errorf(ref.node, "%s: role %q isn't in roles", ref.path, ref.node.Value)
+		}
+	}
+}
+
+// profiles records each profile, to be checked once the config it goes over
+// has been read
+func (c *checker) profiles(n *yaml.Node, path string) {
+	for _, e := range c.entries(n, path) {
+		c.profileRefs = append(c.profileRefs, reference{node: e[1], path: join(path, e[0].Value)})
+	}
+}
+
+// profile is a checker for a profile, starting from the models and roles of
+// the rest of the config, as the profile's are merged into them
+func (c *checker) profile() *checker {
+	pc := &checker{
+		cfg:       Config{Models: make(map[string]Provider, len(c.cfg.Models)), Roles: c.cfg.Roles},
+		provider:  c.provider,
+		names:     maps.Clone(c.names),
+		inProfile: true,
+	}
+	for name, p := range c.cfg.Models {
+		pc.cfg.Models[name] = Provider{Models: maps.Clone(p.Models)}
+	}
+	return pc
+}
+
+func (c *checker) errorf(n *yaml.Node, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Line: n.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(n *yaml.Node, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Line: n.Line, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// entries are the keys and values of a block, following YAML aliases. A
+// block left empty has none; anything else that isn't a block is reported.
+func (c *checker) entries(n *yaml.Node, path string) [][2]*yaml.Node {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	if n.Tag == "!!null" {
+		return nil
+	}
+	if n.Kind != yaml.MappingNode {
+		c.errorf(n, "%s should be a block of settings", path)
+		return nil
+	}
+	var entries [][2]*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if k.Value == "<<" {
+			// A merged anchor's keys are checked where it's defined
+			continue
+		}
+		if v.Kind == yaml.AliasNode {
+			v = v.Alias
+		}
+		entries = append(entries, [2]*yaml.Node{k, v})
+	}
+	return entries
+}
+
+// block checks a block's keys against the ones it may have, and their
+// values
+func (c *checker) block(n *yaml.Node, path string, known keys) {
+	if path == "" {
+		path = "the config"
+	}
+	for _, e := range c.entries(n, path) {
+		k, v := e[0], e[1]
+		key := join(path, k.Value)
+		s, ok := known[k.Value]
+		if !ok {
+			c.unknown(k, key, known)
+			continue
+		}
+		switch {
+		case s.keys != nil:
+			c.block(v, key, s.keys)
+		case s.check != nil && v.Tag != "!!null":
+			s.check(c, v, key)
+		}
+	}
+}
+
+// join is the path to key in the block at path
+func join(path, key string) string {
+	if path == "the config" {
+		return key
+	}
+	return path + "." + key
+}
+
+// unknown reports a key that isn't a setting, and the one it may be a typo
+// of
+func (c *checker) unknown(k *yaml.Node, key string, known keys) {
+	if strings.Contains(k.Value, ".") {
+		c.dotted(k, key)
+		return
+	}
+	best, bestDist := "", 3
+	for name := range known {
+		if d := editDistance(k.Value, name); d < bestDist || d == bestDist && name < best {
+			best, bestDist = name, d
+		}
+	}
+	if best != "" {
+		c.errorf(k, "unknown setting %s; did you mean %s?", key, best)
+	} else {
+		c.errorf(k, "unknown setting %s", key)
+	}
+}
+
+// dotted reports a key with a '.' in it, which viper reads as a key within a
+// key, so the setting is never seen under the name it was given
+func (c *checker) dotted(k *yaml.Node, key string) {
+	c.errorf(k, "%s: keys can't have a '.' in them; use e.g. %q", key, strings.ReplaceAll(k.Value, ".", "-"))
+}
+
+// editDistance is the number of single letter edits that turn a into b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
+
+// models checks each provider's block: its key, credentials and http
+// settings, and every other key is a model
+func (c *checker) models(n *yaml.Node, path string) {
+	for _, e := range c.entries(n, path) {
+		k, v := e[0], e[1]
+		provider := k.Value
+		key := join(path, provider)
+		if !slices.Contains(LLM.Providers, provider) {
+			c.errorf(k, "%s: unknown provider; ask-ai knows %s", key, strings.Join(LLM.Providers, ", "))
+		}
+
+		maxTemperature, ok := maxTemperatures[provider]
+		if !ok {
+			maxTemperature = 2
+		}
+		var apiKey string
+		var sources []credentials.Source
+		listed := false // credentials given replace the usual places to look
+		p, ok := c.cfg.Models[provider]
+		if !ok {
+			p = Provider{Models: make(map[string]ModelConfig)}
+		}
+		for _, e := range c.entries(v, key) {
+			k, v := e[0], e[1]
+			switch k.Value {
+			case "api_key":
+				apiKey = c.str(v, join(key, k.Value))
+			case "credentials":
+				sources = c.credentialSources(v, join(key, k.Value))
+				listed = len(v.Content) > 0
+			case "http":
+				c.block(v, join(key, k.Value), httpKeys)
+			default:
+				if m, ok := c.model(provider, k, v, join(key, k.Value), maxTemperature); ok {
+					p.Models[k.Value] = m
+				}
+			}
+		}
+		c.cfg.Models[provider] = p
+
+		// A profile's provider may have its key in the rest of the config
+		if c.inProfile || !LLM.NeedsKey(provider) {
+			continue
+		}
+		if !listed {
+			sources = LLM.DefaultKeySources(provider, apiKey)
+		}
+		// Sources listed wrong have been reported already
+		if len(sources) > 0 && !slices.ContainsFunc(sources, mayHaveKey) {
+			looked := make([]string, len(sources))
+			for i, s := range sources {
+				looked[i] = s.String()
+			}
+			c.warnf(k, "%s: no API key found (looked in %s)", key, strings.Join(looked, ", "))
+		}
+	}
+}
+
+// model checks a model's settings and that its key and aliases don't name
+// another model too
+func (c *checker) model(provider string, k, v *yaml.Node, key string, maxTemperature float64) (ModelConfig, bool) {
+	if strings.Contains(k.Value, ".") {
+		c.dotted(k, key)
+	}
+	if v.Kind != yaml.MappingNode {
+		c.errorf(k, "%s should be a model's settings", key)
+		return ModelConfig{}, false
+	}
+	c.block(v, key, modelKeys(maxTemperature))
+
+	var raw struct {
+		Aliases   any    `yaml:"aliases"`
+		ModelName string `yaml:"model_name"`
+	}
+	// The values' types were checked along with the rest of the block
+	_ = v.Decode(&raw)
+	m := ModelConfig{ModelName: raw.ModelName}
+	switch a := raw.Aliases.(type) {
+	case string:
+		m.Aliases = []string{a}
+	case []any:
+		for _, alias := range a {
+			if s, ok := alias.(string); ok {
+				m.Aliases = append(m.Aliases, s)
+			}
+		}
+	}
+	if m.ModelName == "" {
+		c.errorf(k, "%s has no model_name, the model's name in the %s API", key, provider)
+	}
+
+	c.name(k, provider, k.Value)
+	for _, e := range c.entries(v, key) {
+		if e[0].Value != "aliases" {
+			continue
+		}
+		if e[1].Kind == yaml.ScalarNode {
+			c.name(e[1], provider, k.Value)
+		}
+		for _, alias := range e[1].Content {
+			c.name(alias, provider, k.Value)
+		}
+	}
+	return m, true
+}
+
+// name records a model key or alias, and reports it if it already names
+// another model
+func (c *checker) name(n *yaml.Node, provider, model string) {
+	first, ok := c.names[n.Value]
+	if !ok {
+		c.names[n.Value] = modelName{provider: provider, model: model, line: n.Line}
+		return
+	}
+	switch {
+	case first.provider != provider:
+		c.errorf(n, "%q names %s/%s and %s/%s (line %d); without a provider it's ambiguous",
+			n.Value, provider, model, first.provider, first.model, first.line)
+	case first.model != model:
+		c.errorf(n, "%q names both %s and %s (line %d) in %s", n.Value, model, first.model, first.line, provider)
+	}
+}
+
+// credentialSources checks a list of places to look for a key, and returns
+// them
+func (c *checker) credentialSources(n *yaml.Node, path string) []credentials.Source {
+	if n.Tag == "!!null" {
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		c.errorf(n, "%s should be a list of sources", path)
+		return nil
+	}
+	var sources []credentials.Source
+	for i, item := range n.Content {
+		key := fmt.Sprintf("%s[%d]", path, i)
+		c.block(item, key, credentialKeys)
+		var s struct {
+			Type    string `yaml:"type"`
+			Env     string `yaml:"env"`
+			Key     string `yaml:"key"`
+			File    string `yaml:"file"`
+			Command string `yaml:"command"`
+			Helper  string `yaml:"helper"`
+		}
+		if err := item.Decode(&s); err != nil {
+			continue
+		}
+		source := credentials.Source(s)
+		needs := map[string]string{
+			credentials.SourceEnv:     s.Env,
+			credentials.SourceKey:     s.Key,
+			credentials.SourceFile:    s.File,
+			credentials.SourceCommand: s.Command,
+			credentials.SourceHelper:  s.Helper,
+		}
+		value, ok := needs[s.Type]
+		switch {
+		case s.Type == "":
+			c.errorf(item, "%s has no type", key)
+		case !ok:
+			c.errorf(item, "%s: unknown type %q: must be env, key, file, command or helper", key, s.Type)
+		case value == "":
+			c.errorf(item, "%s: type %s needs %s set", key, s.Type, s.Type)
+		default:
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+func (c *checker) keySources(n *yaml.Node, path string) {
+	c.credentialSources(n, path)
+}
+
+// mayHaveKey says whether a source could produce a key, without running
+// anything: commands and helpers get the benefit of the doubt
+func mayHaveKey(s credentials.Source) bool {
+	switch s.Type {
+	case credentials.SourceEnv:
+		return os.Getenv(s.Env) != ""
+	case credentials.SourceKey:
+		return s.Key != ""
+	case credentials.SourceFile:
+		_, err := os.Stat(paths.ExpandHome(s.File))
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// roles checks each role's settings, and records its name for the
+// references to it
+func (c *checker) roles(n *yaml.Node, path string) {
+	for _, e := range c.entries(n, path) {
+		c.block(e[1], join(path, e[0].Value), roleKeys)
+		c.cfg.Roles[e[0].Value] = RoleConfig{}
+	}
+}
+
+func (c *checker) prompt(n *yaml.Node, path string) {
+	if n.Kind == yaml.ScalarNode {
+		return
+	}
+	if n.Kind == yaml.SequenceNode && !slices.ContainsFunc(n.Content, func(item *yaml.Node) bool { return item.Kind != yaml.ScalarNode }) {
+		return
+	}
+	c.errorf(n, "%s should be a string or a list of strings", path)
+}
+
+func (c *checker) aliases(n *yaml.Node, path string) {
+	c.prompt(n, path)
+}
+
+// str is the value of a setting that's a string
+func (c *checker) str(n *yaml.Node, path string) string {
+	if n.Kind != yaml.ScalarNode {
+		c.errorf(n, "%s should be a string", path)
+		return ""
+	}
+	return n.Value
+}
+
+func (c *checker) modelRef(n *yaml.Node, path string) {
+	if c.str(n, path) != "" {
+		c.modelRefs = append(c.modelRefs, reference{node: n, path: path})
+	}
+}
+
+func (c *checker) roleRef(n *yaml.Node, path string) {
+	if c.str(n, path) != "" {
+		c.roleRefs = append(c.roleRefs, reference{node: n, path: path})
+	}
+}
+
+func (c *checker) defaultProvider(n *yaml.Node, path string) {
+	provider := c.str(n, path)
+	if provider == "" {
+		return
+	}
+	if !slices.Contains(LLM.Providers, provider) {
+		c.errorf(n, "%s: unknown provider %q; ask-ai knows %s", path, provider, strings.Join(LLM.Providers, ", "))
+	}
+	c.provider = provider
+}
+
+func (c *checker) thinking(n *yaml.Node, path string) {
+	if err := validateThinking(c.str(n, path)); err != nil {
+		c.errorf(n, "%s: %v: must be low, medium or high", path, err)
+	}
+}
+
+// number is the value of a setting that's a number
+func (c *checker) number(n *yaml.Node, path string) (float64, bool) {
+	var f float64
+	if n.Kind != yaml.ScalarNode || (n.Tag != "!!int" && n.Tag != "!!float") || n.Decode(&f) != nil {
+		c.errorf(n, "%s should be a number, not %q", path, n.Value)
+		return 0, false
+	}
+	return f, true
+}
+
+// integer is the value of a setting that's a whole number
+func (c *checker) integer(n *yaml.Node, path string) (int, bool) {
+	var i int
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!int" || n.Decode(&i) != nil {
+		c.errorf(n, "%s should be a whole number, not %q", path, n.Value)
+		return 0, false
+	}
+	return i, true
+}
+
+// temperature checks a temperature is between 0 and max
+func temperature(max float64) func(c *checker, n *yaml.Node, path string) {
+	return func(c *checker, n *yaml.Node, path string) {
+		if t, ok := c.number(n, path); ok && (t < 0 || t > max) {
+			c.errorf(n, "%s: %v is out of range: must be from 0 to %v", path, t, max)
+		}
+	}
+}
+
+func (c *checker) positive(n *yaml.Node, path string) {
+	if i, ok := c.integer(n, path); ok && i <= 0 {
+		c.errorf(n, "%s must be more than 0", path)
+	}
+}
+
+func (c *checker) count(n *yaml.Node, path string) {
+	if i, ok := c.integer(n, path); ok && i < 0 {
+		c.errorf(n, "%s must not be negative", path)
+	}
+}
+
+func (c *checker) boolean(n *yaml.Node, path string) {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!bool" {
+		c.errorf(n, "%s should be true or false, not %q", path, n.Value)
+	}
+}
+
+func (c *checker) duration(n *yaml.Node, path string) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!int" {
+		return
+	}
+	if _, err := time.ParseDuration(c.str(n, path)); err != nil {
+		c.errorf(n, "%s: invalid duration %q: use e.g. 10s or 2m", path, n.Value)
+	}
+}
+
+func (c *checker) proxy(n *yaml.Node, path string) {
+	if proxy := c.str(n, path); proxy != "" {
+		if _, err := httpclient.ParseProxy(proxy); err != nil {
+			c.errorf(n, "%s: %v", path, err)
+		}
+	}
+}
+
+func (c *checker) age(n *yaml.Node, path string) {
+	if age := c.str(n, path); age != "" {
+		if _, err := parseAge(age); err != nil {
+			c.errorf(n, "%s: %v", path, err)
+		}
+	}
+}
+
+func (c *checker) backend(n *yaml.Node, path string) {
+	if backend := c.str(n, path); !slices.Contains(database.Backends, backend) {
+		c.errorf(n, "%s: unknown backend %q: must be one of %s", path, backend, strings.Join(database.Backends, ", "))
+	}
+}
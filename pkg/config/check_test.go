@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfig(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	config := `roles:
+    coder:
+        model: gpt
+        prompt: "You write code"
+    poet:
+        model: openai/gpt-5
+        prompt: {text: rhyme}
+models:
+    openai:
+        gpt-4o:
+            aliases: [gpt, claude]
+            model_name: gpt-4o
+            temperature: 2.5
+            thinking: lots
+        gpt-4.1:
+            model_name: gpt-4.1
+            max_tokens: many
+    anthropic:
+        credentials:
+            - type: env
+              env: CLAUDE_KEY
+            - type: env
+            - type: magic
+              magic: yes
+        sonnet:
+            aliases: [claude]
+            model_name: claude-sonnet-4
+            temperature: 1.5
+        opus:
+            temprature: 0.5
+    ollama:
+        llama:
+            model_name: llama3
+defaults:
+    model: sonnet
+    role: writer
+    provider: openai
+database:
+    backend: postgres
+    retention:
+        max_age: forever
+        keep_starred: yes please
+lgo:
+    file: ask-ai.log
+http:
+    proxy: proxy.corp:8080
+`
+	assert.Equal(t, []Problem{
+		{Line: 6, Message: `roles.poet.model: model gpt-5 not found for provider openai`},
+		{Line: 7, Message: `roles.poet.prompt should be a string or a list of strings`},
+		{Line: 13, Message: `models.openai.gpt-4o.temperature: 2.5 is out of range: must be from 0 to 2`},
+		{Line: 14, Message: `models.openai.gpt-4o.thinking: invalid Thinking value: lots: must be low, medium or high`},
+		{Line: 15, Message: `models.openai.gpt-4.1: keys can't have a '.' in them; use e.g. "gpt-4-1"`},
+		{Line: 17, Message: `models.openai.gpt-4.1.max_tokens should be a whole number, not "many"`},
+		{Line: 18, Message: `models.anthropic: no API key found (looked in env CLAUDE_KEY)`, Warning: true},
+		{Line: 22, Message: `models.anthropic.credentials[1]: type env needs env set`},
+		{Line: 23, Message: `models.anthropic.credentials[2]: unknown type "magic": must be env, key, file, command or helper`},
+		{Line: 24, Message: `unknown setting models.anthropic.credentials[2].magic`},
+		{Line: 26, Message: `"claude" names anthropic/sonnet and openai/gpt-4o (line 11); without a provider it's ambiguous`},
+		{Line: 28, Message: `models.anthropic.sonnet.temperature: 1.5 is out of range: must be from 0 to 1`},
+		{Line: 29, Message: `models.anthropic.opus has no model_name, the model's name in the anthropic API`},
+		{Line: 30, Message: `unknown setting models.anthropic.opus.temprature; did you mean temperature?`},
+		{Line: 36, Message: `defaults.role: role "writer" isn't in roles`},
+		{Line: 39, Message: `database.backend: unknown backend "postgres": must be one of sqlite, jsonl, memory`},
+		{Line: 41, Message: `database.retention.max_age: invalid age "forever": use e.g. 90d, 12w, 1y or 720h`},
+		{Line: 42, Message: `database.retention.keep_starred should be true or false, not "yes please"`},
+		{Line: 43, Message: `unknown setting lgo; did you mean log?`},
+		{Line: 46, Message: `http.proxy: invalid proxy URL "proxy.corp:8080": needs a scheme and host, e.g. http://proxy.corp:3128`},
+	}, checkConfig([]byte(config)))
+}
+
+func TestCheckConfigSyntax(t *testing.T) {
+	assert.Equal(t, []Problem{{Line: 3, Message: "mapping values are not allowed in this context"}},
+		checkConfig([]byte("defaults:\n    model: gpt\n    role: a: b\n")))
+	assert.Equal(t, []Problem{{Line: 1, Message: "the config should be a block of settings"}},
+		checkConfig([]byte("- model\n")))
+	assert.Empty(t, checkConfig(nil))
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test")
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("models:\n    openai:\n        gpt:\n            model_name: gpt-4o\ndefaults:\n    model: gpt\n"), 0o600))
+	problems, err := CheckConfig(path)
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	_, err = CheckConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestCheckConfigProfiles(t *testing.T) {
+	config := `profiles:
+    work:
+        defaults:
+            model: gpt
+            thinking: hard
+        models:
+            ollama:
+                llama:
+                    aliases: [local]
+                    model_name: llama3
+        roles:
+            poet:
+                prompt: rhyme
+    home:
+        defaults:
+            model: llama
+models:
+    ollama:
+        llama:
+            model_name: llama3
+defaults:
+    model: local
+`
+	assert.Equal(t, []Problem{
+		{Line: 4, Message: `profiles.work.defaults.model: provider openai not found`},
+		{Line: 5, Message: `profiles.work.defaults.thinking: invalid Thinking value: hard: must be low, medium or high`},
+		{Line: 11, Message: `unknown setting profiles.work.roles`},
+		{Line: 22, Message: `defaults.model: provider openai not found`},
+	}, checkConfig([]byte(config)))
+}
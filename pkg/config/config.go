@@ -2,26 +2,36 @@ package config
 
 import (
 	"fmt"
+	"io"
+	"maps"
 	"os"
-	"os/user"
 	"path/filepath"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	// "golang.org/x/term"
 	"github.com/charmbracelet/x/term"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 
+	"github.com/duluk/ask-ai/pkg/credentials"
 	"github.com/duluk/ask-ai/pkg/database"
+	"github.com/duluk/ask-ai/pkg/httpclient"
+	"github.com/duluk/ask-ai/pkg/paths"
 )
 
 // Provider holds configuration for an AI provider
 // The Models field captures all model entries under the provider block.
 type Provider struct {
-	APIKey string                 `mapstructure:"api_key"`
-	Models map[string]ModelConfig `mapstructure:",remain"`
+	APIKey      string                 `mapstructure:"api_key"`
+	Credentials []credentials.Source   `mapstructure:"credentials"`
+	HTTP        httpclient.Settings    `mapstructure:"http"`
+	Models      map[string]ModelConfig `mapstructure:",remain"`
 }
 
 // ModelConfig holds configuration for a specific model
@@ -45,8 +55,10 @@ type RoleConfig struct {
 // It is primarily used for reading provider/model settings; logging and database
 // options are read directly via viper for Options initialization.
 type Config struct {
-	Roles    map[string]RoleConfig
-	Models   map[string]Provider `mapstructure:"models"`
+	Roles  map[string]RoleConfig
+	Models map[string]Provider `mapstructure:"models"`
+	// Global HTTP settings; each provider's own http block overrides these
+	HTTP     httpclient.Settings `mapstructure:"http"`
 	Defaults struct {
 		Model    string `mapstructure:"model"`
 		Provider string `mapstructure:"provider"`
@@ -94,6 +106,9 @@ type Config struct {
 // Options holds runtime configuration options
 type Options struct {
 	ConfigDir      string
+	ConfigFile     string // The config file read, if any
+	CheckConfig    bool   // Report the mistakes in ConfigFile and exit
+	Profile        string // The config's profile in use, if any
 	DumpConfig     bool
 	ShowAPIKeys    bool
 	Model          string
@@ -105,16 +120,48 @@ type Options struct {
 	LogFileName    string
 	DBFileName     string
 	DBTable        string
+	DBBackend      string // sqlite, jsonl or memory
 	SystemPrompt   string
+	Role           string // name of the role the system prompt came from, if any
 	UseTUI         bool
 	NoOutput       bool
 	NoRecord       bool
 	Quiet          bool
 	ContinueChat   bool
 	ConversationID int
-
-	SearchKeyword     string // Keyword for searching previous conversations
-	ListConversations bool   // Flag to list all conversations interactively
+	ContextTurns   int // Recent turns of any conversations to start a new one with
+	Show           int // Conversation ID to show, through $PAGER
+
+	SearchKeyword     string     // Keyword for searching previous conversations
+	ListConversations bool       // Flag to list all conversations interactively
+	Filter            FilterSpec // Narrows --search and --list
+	TitleModel        string     // Model that names new conversations; none when empty
+	Retitle           bool       // Generate titles for conversations that have none
+	Export            string     // Conversation ID to export, or "all"
+	ExportFormat      string     // md, json, jsonl or html
+	ExportOutput      string     // File to export to; stdout when empty
+	Import            string     // Export file from another application to import
+	ImportFrom        string     // chatgpt, claude or jsonl; guessed when empty
+	Delete            int        // Conversation ID to delete
+	Fork              int        // Conversation ID to fork and continue
+	ForkAt            int        // Turn to fork after; the latest when 0
+
+	Stats       bool                 // Print usage statistics and exit
+	StatsFilter database.StatsFilter // --since, --until and --by for --stats
+	StatsFormat string               // table or json
+
+	Retention database.RetentionPolicy // database.retention, applied at startup
+	Prune     bool                     // Apply Retention, report and exit
+
+	DBMigrate bool   // Migrate the database schema and exit
+	DryRun    bool   // Show what --db-migrate would do without doing it
+	MigrateTo int    // Schema version to --db-migrate to; the latest when 0
+	DBMerge   string // Database to merge into this one, then exit
+
+	Encrypt       bool   // database.encryption.enabled
+	EncryptionKey []byte // database.encryption key; nil unless needed
+	DBEncrypt     bool   // Encrypt the messages already stored and exit
+	DBDecrypt     bool   // Decrypt the stored messages and exit
 
 	// Terminal dimensions and tab width for TUI or dumping
 	ScreenWidth     int // total terminal width
@@ -144,7 +191,7 @@ func Initialize() (*Options, error) {
 	// Note: can also just not use the '.' in the YAML key; the model_name
 	// string is what's passed to the API.
 	// NOTE: this function isn't working so just removing the '.' from the
-	// config key
+	// config key; --check-config points out keys that have one
 	// viper.KeyDelimiter("|")
 
 	configDir := filepath.Join(os.Getenv("HOME"), ".config", "ask-ai")
@@ -155,9 +202,8 @@ func Initialize() (*Options, error) {
 	// Figure out the config file and read it first if there
 	pflag.StringP("config", "C", "", "Configuration file")
 	viper.BindPFlags(pflag.CommandLine)
-	if err := setupConfigFile(); err != nil {
-		return nil, fmt.Errorf("error setting up config: %w", err)
-	}
+	// A config file that can't be read is for --check-config to explain
+	configErr := setupConfigFile()
 
 	width, height := determineScreenSize()
 	// Compute usable text width (terminal width minus pad, capped)
@@ -176,15 +222,46 @@ func Initialize() (*Options, error) {
 	pflag.StringP("thinking-effort", "e", "medium", "Reasoning effort for model responses")
 	pflag.BoolP("continue", "c", false, "Continue last conversation")
 	pflag.IntP("id", "i", 0, "Conversation ID to continue")
+	pflag.Int("context", 0, "Start a new conversation with the last n turns of any conversations as context")
+	pflag.Int("show", 0, "Show a conversation by ID")
 	pflag.String("search", "", "Search previous conversations for keyword")
 	pflag.BoolP("list", "l", false, "List all conversations interactively")
+	pflag.Bool("retitle", false, "Generate titles for conversations without one (uses defaults.title_model)")
+	pflag.String("export", "", "Export a conversation by ID, or all of them (ID|all)")
+	pflag.String("format", "md", "Export format (md|json|jsonl|html), or --stats format (table|json)")
+	pflag.StringP("output", "o", "", "File to export to (default stdout)")
+	pflag.String("import", "", "Import conversations from a ChatGPT, Claude or JSONL export file")
+	pflag.String("from", "", "Format of the --import file (chatgpt|claude|jsonl; default: detect)")
+	pflag.Bool("stats", false, "Show usage statistics: conversations, turns, tokens and latency (see --by, --since)")
+	pflag.String("by", "", "Break --stats down by model, provider, day or role")
+	pflag.Int("delete", 0, "Delete a conversation by ID")
+	pflag.Int("fork", 0, "Continue a copy of a conversation by ID (see --at)")
+	pflag.Int("at", 0, "Turn to --fork after (default: the latest)")
+	pflag.Bool("db-migrate", false, "Back up the database and migrate its schema to the latest version (see --to, --dry-run)")
+	pflag.Bool("dry-run", false, "With --db-migrate, show the migrations and their SQL without running them")
+	pflag.Int("to", 0, "Schema version to --db-migrate to, up or down (default: the latest)")
+	pflag.String("db-merge", "", "Merge another ask-ai database, such as one from another machine, into this one")
+	pflag.Bool("db-encrypt", false, "Encrypt the messages and titles already in the database with the database.encryption key")
+	pflag.Bool("db-decrypt", false, "Decrypt the messages and titles in the database and stop encrypting new ones")
+	pflag.Bool("prune", false, "Apply database.retention now, vacuum and report the space freed")
+	// Filters for --search and --list; --model and --provider also filter
+	// when combined with them
+	pflag.String("since", "", "Only conversations with messages on or after this date (YYYY-MM-DD)")
+	pflag.String("until", "", "Only conversations with messages on or before this date (YYYY-MM-DD)")
+	pflag.String("in", "", "Only match prompts or responses (prompt|response)")
+	pflag.String("regex", "", "Only conversations with a message matching this regular expression")
+	pflag.StringSlice("tag", []string{}, "Only conversations with this tag (repeat for several)")
+	pflag.Bool("starred", false, "Only starred conversations")
+	pflag.Bool("archived", false, "Include archived conversations in --list and --search")
 	pflag.BoolP("tui", "T", false, "Use TUI interface")
 	pflag.BoolP("no-output", "n", false, "Disable direct terminal output")
 	pflag.BoolP("quiet", "q", false, "Suppress non-essential output")
 	// Disable conversation recording
 	pflag.Bool("no-record", false, "Disable recording conversations to database")
 	pflag.BoolP("dump-config", "d", false, "Dump configuration and exit")
+	pflag.StringP("profile", "P", "", "Configuration profile to use, from the config's profiles (default $ASK_AI_PROFILE)")
 	pflag.BoolP("show-keys", "k", false, "Show API keys in config dump")
+	pflag.Bool("check-config", false, "Check the config file for mistakes, reporting each by line, and exit")
 	// Additional runtime flags
 	// Context window length for prompts (default 2048)
 	pflag.Int("context-length", 2048, "Context window length for model responses")
@@ -198,6 +275,15 @@ func Initialize() (*Options, error) {
 	// Parse CLI flags to populate values
 	pflag.Parse()
 
+	// --check-config reads the file itself, before anything below can stop
+	// at the first mistake in it
+	if viper.GetBool("check-config") {
+		return &Options{ConfigDir: configDir, CheckConfig: true, ConfigFile: viper.ConfigFileUsed()}, nil
+	}
+	if configErr != nil {
+		return nil, fmt.Errorf("error setting up config: %w", configErr)
+	}
+
 	// Set default configuration values
 	// Set default configuration values
 	viper.SetDefault("defaults.provider", "openai")
@@ -208,6 +294,8 @@ func Initialize() (*Options, error) {
 	// Default database file and table
 	viper.SetDefault("database.file", filepath.Join(configDir, "ask-ai.db"))
 	viper.SetDefault("database.table", "conversations")
+	viper.SetDefault("database.backend", database.BackendSQLite)
+	viper.SetDefault("database.retention.keep_starred", true)
 
 	// Read config file
 	if configFile := viper.GetString("config"); configFile != "" {
@@ -224,11 +312,28 @@ func Initialize() (*Options, error) {
 			return nil, fmt.Errorf("error reading config: %w", err)
 		}
 	}
+	// A profile's settings go over the rest of the config's
+	profile := viper.GetString("profile")
+	if profile == "" {
+		profile = os.Getenv("ASK_AI_PROFILE")
+	}
+	if profile != "" {
+		if err := applyProfile(profile); err != nil {
+			return nil, err
+		}
+	}
+	// The default history file depends on the backend keeping it
+	if viper.GetString("database.backend") == database.BackendJSONL {
+		viper.SetDefault("database.file", filepath.Join(configDir, "ask-ai.jsonl"))
+	}
 
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("error parsing config: %w", err)
 	}
+	if err := checkProxies(&config); err != nil {
+		return nil, err
+	}
 	// Parse roles section if present
 	if rawRoles := viper.Get("roles"); rawRoles != nil {
 		if rm, ok := rawRoles.(map[string]any); ok {
@@ -270,6 +375,8 @@ func Initialize() (*Options, error) {
 
 	// Basic flags/booleans
 	opts.ConfigDir = configDir
+	opts.ConfigFile = viper.ConfigFileUsed()
+	opts.Profile = profile
 	opts.DumpConfig = viper.GetBool("dump-config")
 	opts.ShowAPIKeys = viper.GetBool("show-keys")
 	opts.UseTUI = viper.GetBool("tui")
@@ -279,8 +386,25 @@ func Initialize() (*Options, error) {
 	opts.Quiet = viper.GetBool("quiet")
 	opts.ContinueChat = viper.GetBool("continue")
 	opts.ConversationID = viper.GetInt("id")
+	opts.ContextTurns = viper.GetInt("context")
+	opts.Show = viper.GetInt("show")
 	opts.SearchKeyword = viper.GetString("search")
 	opts.ListConversations = viper.GetBool("list")
+	opts.Retitle = viper.GetBool("retitle")
+	opts.Export = viper.GetString("export")
+	opts.ExportFormat = viper.GetString("format")
+	opts.ExportOutput = viper.GetString("output")
+	opts.Import = viper.GetString("import")
+	opts.ImportFrom = viper.GetString("from")
+	opts.Fork = viper.GetInt("fork")
+	opts.ForkAt = viper.GetInt("at")
+	if opts.ContextTurns < 0 {
+		return nil, fmt.Errorf("--context must not be negative")
+	}
+	if opts.ContextTurns > 0 && (opts.ContinueChat || opts.ConversationID != 0 || opts.Fork != 0) {
+		return nil, fmt.Errorf("--context starts a new conversation; it can't be used with --continue, --id or --fork")
+	}
+	opts.TitleModel = viper.GetString("defaults.title_model")
 	// Terminal size and tab width
 	opts.ScreenWidth = width
 	opts.ScreenTextWidth = textWidth
@@ -344,6 +468,7 @@ func Initialize() (*Options, error) {
 		opts.SystemPrompt = sp
 	} else if roleName := viper.GetString("role"); roleName != "" {
 		if rc, ok := config.Roles[roleName]; ok {
+			opts.Role = roleName
 			opts.SystemPrompt = strings.Join(rc.Prompt, "\n")
 			// Override model if specified for this role and not set via CLI
 			if rc.Model != "" && viper.GetString("model") == "" {
@@ -354,6 +479,7 @@ func Initialize() (*Options, error) {
 		}
 	} else if defaultRole := viper.GetString("defaults.role"); defaultRole != "" {
 		if rc, ok := config.Roles[defaultRole]; ok {
+			opts.Role = defaultRole
 			opts.SystemPrompt = strings.Join(rc.Prompt, "\n")
 			if rc.Model != "" && viper.GetString("model") == "" {
 				opts.Model = rc.Model
@@ -368,9 +494,71 @@ func Initialize() (*Options, error) {
 	}
 
 	// Log and database settings
+	// Filters for listing and searching. A filter-only flag with neither
+	// --search nor --list means list what matches.
+	opts.Filter = FilterSpec{
+		Since:    viper.GetString("since"),
+		Until:    viper.GetString("until"),
+		In:       viper.GetString("in"),
+		Regex:    viper.GetString("regex"),
+		Tags:     viper.GetStringSlice("tag"),
+		Starred:  viper.GetBool("starred"),
+		Archived: viper.GetBool("archived"),
+	}
+	opts.Stats = viper.GetBool("stats")
+	if opts.Stats {
+		if err := statsOptions(opts, &config); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Filter.IsZero() && opts.SearchKeyword == "" && !opts.Stats {
+		opts.ListConversations = true
+	}
+	if opts.ListConversations || opts.SearchKeyword != "" {
+		if pflag.CommandLine.Changed("model") {
+			opts.Filter.Model = viper.GetString("model")
+		}
+		if pflag.CommandLine.Changed("provider") {
+			opts.Filter.Provider = viper.GetString("provider")
+		}
+		if _, err := opts.Filter.Resolve(&config); err != nil {
+			return nil, err
+		}
+	}
+
 	opts.LogFileName = os.ExpandEnv(viper.GetString("log.file"))
 	opts.DBFileName = os.ExpandEnv(viper.GetString("database.file"))
 	opts.DBTable = viper.GetString("database.table")
+	opts.DBBackend = viper.GetString("database.backend")
+	if !slices.Contains(database.Backends, opts.DBBackend) {
+		return nil, fmt.Errorf("unknown database.backend %q: must be one of %s", opts.DBBackend, strings.Join(database.Backends, ", "))
+	}
+	opts.Delete = viper.GetInt("delete")
+	opts.Prune = viper.GetBool("prune")
+	opts.DBMigrate = viper.GetBool("db-migrate")
+	opts.DryRun = viper.GetBool("dry-run")
+	opts.MigrateTo = viper.GetInt("to")
+	opts.DBMerge = os.ExpandEnv(viper.GetString("db-merge"))
+	retention, err := retentionPolicy()
+	if err != nil {
+		return nil, err
+	}
+	opts.Retention = retention
+	opts.DBEncrypt = viper.GetBool("db-encrypt")
+	opts.DBDecrypt = viper.GetBool("db-decrypt")
+	if opts.DBEncrypt && opts.DBDecrypt {
+		return nil, fmt.Errorf("--db-encrypt and --db-decrypt can't be used together")
+	}
+	opts.Encrypt = viper.GetBool("database.encryption.enabled")
+	if opts.Encrypt && opts.DBBackend != database.BackendSQLite {
+		return nil, fmt.Errorf("database.encryption: %w", database.NeedsSQLite("encryption"))
+	}
+	if opts.Encrypt || opts.DBEncrypt || opts.DBDecrypt {
+		opts.EncryptionKey, err = encryptionKey()
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Validations
 	for _, provider := range config.Models {
@@ -415,48 +603,75 @@ func GetModelConfig(config *Config, provider, model string) (*ModelConfig, error
 	return nil, fmt.Errorf("model %s not found for provider %s", model, provider)
 }
 
-// Maybe this shouldn't be in config...
-func searchForConversation(search string) {
-	if viper.GetString("database.file") == "" {
-		fmt.Println("Database file not set")
-		os.Exit(1)
-	}
-	if viper.GetString("database.table") == "" {
-		fmt.Println("Database table not set")
-		os.Exit(1)
+// ResolveModel finds the provider and configuration for a model spec, either
+// "provider/model" or a model key or alias. Without a provider prefix the
+// provider is inferred when only one has the model, else defaultProvider is
+// used. The returned key is the spec without its provider.
+func ResolveModel(config *Config, defaultProvider, spec string) (string, string, *ModelConfig, error) {
+	provider := defaultProvider
+	modelKey := spec
+	if idx := strings.Index(spec, "/"); idx >= 0 {
+		provider = spec[:idx]
+		modelKey = spec[idx+1:]
+	} else {
+		var matches []string
+		for provName, prov := range config.Models {
+			if _, ok := prov.Models[modelKey]; ok {
+				matches = append(matches, provName)
+				continue
+			}
+			for _, mConf := range prov.Models {
+				if slices.Contains(mConf.Aliases, modelKey) {
+					matches = append(matches, provName)
+					break
+				}
+			}
+		}
+		sort.Strings(matches)
+		if len(matches) == 1 {
+			provider = matches[0]
+		} else if len(matches) > 1 {
+			return "", "", nil, fmt.Errorf("model %q is ambiguous across providers: %v", modelKey, matches)
+		}
 	}
 
-	db, err := database.InitializeDB(os.ExpandEnv(viper.GetString("database.file")), viper.GetString("database.table"))
+	modelConf, err := GetModelConfig(config, provider, modelKey)
 	if err != nil {
-		fmt.Printf("Error opening database: %s", err)
-		os.Exit(1)
+		return "", "", nil, err
 	}
-	defer db.Close()
+	return provider, modelKey, modelConf, nil
+}
 
-	ids, err := db.SearchForConversation(search)
-	if err != nil {
-		fmt.Printf("Error searching for conversation: %s", err)
-	}
+// profileSections are the parts of the config a profile can set
+var profileSections = []string{"defaults", "models", "database", "log", "http"}
 
-	uniqIDs := make([]int, 0)
-	unique := make(map[int]bool)
-	for _, id := range ids {
-		if !unique[id] {
-			unique[id] = true
-			uniqIDs = append(uniqIDs, id)
+// applyProfile merges the named entry of the config's profiles over the rest
+// of the config. Blocks are merged key by key; anything else, lists
+// included, is replaced.
+func applyProfile(name string) error {
+	profiles := viper.GetStringMap("profiles")
+	// viper has lowercased the names
+	entry, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		if len(profiles) == 0 {
+			return fmt.Errorf("profile %q: the config has no profiles", name)
 		}
+		names := slices.Sorted(maps.Keys(profiles))
+		return fmt.Errorf("profile %q isn't one of the config's profiles: %s", name, strings.Join(names, ", "))
 	}
-
-	fmt.Printf("Found %d conversations: ", len(uniqIDs))
-	for i, id := range uniqIDs {
-		fmt.Printf("%d", id)
-		if i < len(uniqIDs)-1 {
-			fmt.Printf(", ")
+	if entry == nil {
+		return nil
+	}
+	settings, ok := entry.(map[string]any)
+	if !ok {
+		return fmt.Errorf("profiles.%s should be a block of settings", name)
+	}
+	for key := range settings {
+		if !slices.Contains(profileSections, key) {
+			return fmt.Errorf("profiles.%s.%s: a profile can only set %s", name, key, strings.Join(profileSections, ", "))
 		}
 	}
-	fmt.Println()
-
-	os.Exit(0)
+	return viper.MergeConfigMap(settings)
 }
 
 func validateThinking(thinking string) error {
@@ -467,34 +682,121 @@ func validateThinking(thinking string) error {
 	return fmt.Errorf("invalid Thinking value: %s", thinking)
 }
 
-func determineScreenSize() (int, int) {
-	width, height, err := term.GetSize(0)
+// checkProxies makes sure the http blocks' proxies are URLs a client can
+// use, naming the key of the first that isn't
+func checkProxies(cfg *Config) error {
+	if cfg.HTTP.Proxy != "" {
+		if _, err := httpclient.ParseProxy(cfg.HTTP.Proxy); err != nil {
+			return fmt.Errorf("http.proxy: %w", err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Models)) {
+		if proxy := cfg.Models[name].HTTP.Proxy; proxy != "" {
+			if _, err := httpclient.ParseProxy(proxy); err != nil {
+				return fmt.Errorf("models.%s.http.proxy: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// retentionPolicy reads database.retention
+func retentionPolicy() (database.RetentionPolicy, error) {
+	policy := database.RetentionPolicy{
+		MaxConversations: viper.GetInt("database.retention.max_conversations"),
+		KeepStarred:      viper.GetBool("database.retention.keep_starred"),
+	}
+	if policy.MaxConversations < 0 {
+		return policy, fmt.Errorf("database.retention.max_conversations must not be negative")
+	}
+	if age := viper.GetString("database.retention.max_age"); age != "" {
+		d, err := parseAge(age)
+		if err != nil {
+			return policy, fmt.Errorf("database.retention.max_age: %w", err)
+		}
+		policy.MaxAge = d
+	}
+	return policy, nil
+}
+
+// statsOptions reads the flags --stats takes. It uses the filter's dates;
+// the rest of the filter is for --list and --search.
+func statsOptions(opts *Options, cfg *Config) error {
+	by := viper.GetString("by")
+	if !database.ValidStatsGrouping(by) {
+		return fmt.Errorf("invalid --by %q: must be one of %s", by, strings.Join(database.StatsGroupings, ", "))
+	}
+	filter, err := FilterSpec{Since: opts.Filter.Since, Until: opts.Filter.Until}.Resolve(cfg)
 	if err != nil {
-		return 80, 24
+		return err
 	}
+	opts.StatsFilter = database.StatsFilter{Since: filter.Since, Until: filter.Until, By: by}
 
-	return width, height
+	opts.StatsFormat = "table"
+	if pflag.CommandLine.Changed("format") {
+		opts.StatsFormat = viper.GetString("format")
+	}
+	if opts.StatsFormat != "table" && opts.StatsFormat != "json" {
+		return fmt.Errorf("invalid --stats format %q: must be table or json", opts.StatsFormat)
+	}
+	return nil
 }
 
-func showConversation(convID int) {
-	if viper.GetString("database.file") == "" {
-		fmt.Println("Database file not set")
-		os.Exit(1)
+// encryptionKey looks the database key up the way API keys are: from the
+// sources in database.encryption.credentials if there are any, otherwise
+// from $ASK_AI_DB_KEY, then database.encryption.key, which is run through
+// the shell if it contains whitespace.
+func encryptionKey() ([]byte, error) {
+	var sources []credentials.Source
+	if err := viper.UnmarshalKey("database.encryption.credentials", &sources); err != nil {
+		return nil, fmt.Errorf("database.encryption.credentials: %w", err)
+	}
+	if len(sources) == 0 {
+		sources = append(sources, credentials.Source{Type: credentials.SourceEnv, Env: "ASK_AI_DB_KEY"})
+		if key := viper.GetString("database.encryption.key"); key != "" {
+			if strings.ContainsAny(key, " \t") {
+				sources = append(sources, credentials.Source{Type: credentials.SourceCommand, Command: key})
+			} else {
+				sources = append(sources, credentials.Source{Type: credentials.SourceKey, Key: key})
+			}
+		}
+	}
+
+	key, err := credentials.Lookup("database encryption", sources)
+	if err != nil {
+		return nil, err
 	}
-	if viper.GetString("database.table") == "" {
-		fmt.Println("Database table not set")
-		os.Exit(1)
+	parsed, err := database.ParseKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("database.encryption: %w", err)
 	}
+	return parsed, nil
+}
 
-	db, err := database.InitializeDB(os.ExpandEnv(viper.GetString("database.file")), viper.GetString("database.table"))
+// parseAge reads a duration in days (90d), weeks (12w) or years (1y), or
+// anything time.ParseDuration takes
+func parseAge(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err == nil && n > 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid age %q: use e.g. 90d, 12w, 1y or 720h", s)
+	}
+	return d, nil
+}
+
+func determineScreenSize() (int, int) {
+	width, height, err := term.GetSize(0)
 	if err != nil {
-		fmt.Printf("Error opening database: %s", err)
-		os.Exit(1)
+		return 80, 24
 	}
-	defer db.Close()
 
-	db.ShowConversation(convID)
-	os.Exit(0)
+	return width, height
 }
 
 func handleVersionFlags() bool {
@@ -528,27 +830,13 @@ func checkConfigFlag() string {
 	return ""
 }
 
-// If there's an error getting the user, just returning the path unmodified
-func expandHomePath(path string) string {
-	if strings.HasPrefix(path, "~") {
-		currentUser, err := user.Current()
-		if err != nil {
-			// I'm always trying to sneak a goto in just to trigger :)
-			goto oopsies
-		}
-		return filepath.Join(currentUser.HomeDir, path[1:])
-	}
-oopsies:
-	return path
-}
-
 func setupConfigFile() error {
 	cfgFile := checkConfigFlag()
 	// fmt.Printf("checkConfigFlag return: %s\n", cfgFile)
 
 	if cfgFile != "" {
 		fmt.Printf("Setting config file: %s\n", cfgFile)
-		viper.SetConfigFile(expandHomePath(cfgFile))
+		viper.SetConfigFile(paths.ExpandHome(cfgFile))
 	} else {
 		viper.SetConfigName("config")
 		viper.SetConfigType("yml")
@@ -564,18 +852,67 @@ func setupConfigFile() error {
 	return nil
 }
 
+// DumpConfig prints the options in effect, then the config they came from,
+// with the profile's settings merged in
 func DumpConfig(cfg *Options) {
-	fmt.Printf("Model: %s\n", cfg.Model)
-	fmt.Printf("ContextLength: %d\n", cfg.ContextLength)
-	fmt.Printf("ContinueChat: %t\n", cfg.ContinueChat)
-	fmt.Printf("LogFileName: %s\n", cfg.LogFileName)
-	fmt.Printf("DBFileName: %s\n", cfg.DBFileName)
-	fmt.Printf("DBTable: %s\n", cfg.DBTable)
-	fmt.Printf("SystemPrompt: %s\n", cfg.SystemPrompt)
-	fmt.Printf("MaxTokens: %d\n", cfg.MaxTokens)
-	fmt.Printf("Temperature: %f\n", cfg.Temperature)
-	fmt.Printf("ConversationID: %d\n", cfg.ConversationID)
-	fmt.Printf("ScreenWidth: %d\n", cfg.ScreenWidth)
-	fmt.Printf("ScreenHeight: %d\n", cfg.ScreenHeight)
-	fmt.Printf("TabWidth: %d\n", cfg.TabWidth)
+	dumpConfig(os.Stdout, cfg)
+}
+
+func dumpConfig(w io.Writer, cfg *Options) {
+	fmt.Fprintf(w, "ConfigFile: %s\n", cfg.ConfigFile)
+	fmt.Fprintf(w, "Profile: %s\n", cfg.Profile)
+	fmt.Fprintf(w, "Model: %s\n", cfg.Model)
+	fmt.Fprintf(w, "Provider: %s\n", cfg.Provider)
+	fmt.Fprintf(w, "ContextLength: %d\n", cfg.ContextLength)
+	fmt.Fprintf(w, "ContinueChat: %t\n", cfg.ContinueChat)
+	fmt.Fprintf(w, "LogFileName: %s\n", cfg.LogFileName)
+	fmt.Fprintf(w, "DBFileName: %s\n", cfg.DBFileName)
+	fmt.Fprintf(w, "DBTable: %s\n", cfg.DBTable)
+	fmt.Fprintf(w, "DBBackend: %s\n", cfg.DBBackend)
+	fmt.Fprintf(w, "SystemPrompt: %s\n", cfg.SystemPrompt)
+	fmt.Fprintf(w, "MaxTokens: %d\n", cfg.MaxTokens)
+	fmt.Fprintf(w, "Temperature: %f\n", cfg.Temperature)
+	fmt.Fprintf(w, "ConversationID: %d\n", cfg.ConversationID)
+	fmt.Fprintf(w, "ScreenWidth: %d\n", cfg.ScreenWidth)
+	fmt.Fprintf(w, "ScreenHeight: %d\n", cfg.ScreenHeight)
+	fmt.Fprintf(w, "TabWidth: %d\n", cfg.TabWidth)
+
+	// The config itself, as the sections ask-ai reads; profiles are left
+	// out, the one in use being part of the rest
+	settings := make(map[string]any)
+	for _, section := range []string{"roles", "models", "defaults", "http", "log", "database"} {
+		if v := viper.Get(section); v != nil {
+			settings[section] = maskKeys(v, cfg.ShowAPIKeys)
+		}
+	}
+	out, err := yaml.Marshal(settings)
+	if err != nil {
+		fmt.Fprintf(w, "Error dumping config: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "\n%s", out)
+}
+
+// maskKeys copies v with the API and encryption keys in it masked, unless
+// show is set. A key that's a command to run is shown: it isn't the key.
+func maskKeys(v any, show bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		masked := make(map[string]any, len(v))
+		for k, val := range v {
+			if s, ok := val.(string); ok && (k == "api_key" || k == "key") && s != "" && !show && !strings.ContainsAny(s, " \t") {
+				val = "********"
+			}
+			masked[k] = maskKeys(val, show)
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(v))
+		for i, val := range v {
+			masked[i] = maskKeys(val, show)
+		}
+		return masked
+	default:
+		return v
+	}
 }
@@ -3,8 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/duluk/ask-ai/pkg/credentials"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -146,6 +149,116 @@ model:
 				assert.Nil(t, opts)
 			},
 		},
+		{
+			name: "jsonl backend defaults to a jsonl file",
+			args: []string{},
+			config: `
+database:
+  backend: jsonl
+`,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "jsonl", opts.DBBackend)
+				assert.Equal(t, filepath.Join(tmpHome, ".config", "ask-ai", "ask-ai.jsonl"), opts.DBFileName)
+			},
+		},
+		{
+			name: "unknown backend",
+			args: []string{},
+			config: `
+database:
+  backend: postgres
+`,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.ErrorContains(t, err, `unknown database.backend "postgres"`)
+			},
+		},
+		{
+			name: "proxy without a scheme",
+			args: []string{},
+			config: `
+models:
+  openai:
+    http:
+      proxy: proxy.corp:8080
+`,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.ErrorContains(t, err, `models.openai.http.proxy: invalid proxy URL "proxy.corp:8080": needs a scheme and host`)
+			},
+		},
+		{
+			name: "check a config that doesn't parse",
+			args: []string{"--check-config"},
+			config: `
+defaults:
+  role: a: b
+`,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.NoError(t, err)
+				assert.True(t, opts.CheckConfig)
+				assert.NotEmpty(t, opts.ConfigFile)
+			},
+		},
+		{
+			name:   "profile goes over the config",
+			args:   []string{"--profile", "work"},
+			config: profileConfig,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "work", opts.Profile)
+				assert.Equal(t, "openai/gpt", opts.Model)
+				assert.Equal(t, 1024, opts.MaxTokens, "kept from defaults")
+				assert.Equal(t, "/work/ask-ai.db", opts.DBFileName)
+				assert.Equal(t, 10, opts.Retention.MaxConversations)
+				assert.Equal(t, "gpt-4o", opts.Config.Models["openai"].Models["gpt"].ModelName)
+				assert.Equal(t, "llama3", opts.Config.Models["ollama"].Models["llama"].ModelName, "models are merged")
+				assert.Equal(t, "http://proxy:3128", opts.Config.HTTP.Proxy)
+			},
+		},
+		{
+			name:   "no profile",
+			args:   []string{},
+			config: profileConfig,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.NoError(t, err)
+				assert.Empty(t, opts.Profile)
+				assert.Equal(t, "ollama/llama", opts.Model)
+				assert.Equal(t, 0, opts.Retention.MaxConversations)
+				assert.NotContains(t, opts.Config.Models, "openai")
+			},
+		},
+		{
+			name:   "unknown profile",
+			args:   []string{"--profile", "play"},
+			config: profileConfig,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.ErrorContains(t, err, `profile "play" isn't one of the config's profiles: home, work`)
+			},
+		},
+		{
+			name:   "profile setting roles",
+			args:   []string{"--profile", "home"},
+			config: profileConfig,
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.ErrorContains(t, err, "profiles.home.roles: a profile can only set defaults, models, database, log, http")
+			},
+		},
+		{
+			name: "show and context",
+			args: []string{"--show", "3", "--context", "5"},
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, 3, opts.Show)
+				assert.Equal(t, 5, opts.ContextTurns)
+			},
+		},
+		{
+			name: "context with continue",
+			args: []string{"--context", "5", "--continue"},
+			validate: func(t *testing.T, opts *Options, err error) {
+				assert.ErrorContains(t, err, "--context starts a new conversation")
+			},
+		},
 		{
 			name: "system prompt",
 			args: []string{"--system-prompt", "You are a helpful assistant"},
@@ -182,6 +295,76 @@ model:
 	}
 }
 
+const profileConfig = `
+defaults:
+  model: ollama/llama
+  max_tokens: 1024
+models:
+  ollama:
+    llama:
+      model_name: llama3
+profiles:
+  work:
+    defaults:
+      model: openai/gpt
+    models:
+      openai:
+        api_key: sk-work
+        gpt:
+          model_name: gpt-4o
+    database:
+      file: /work/ask-ai.db
+      retention:
+        max_conversations: 10
+    http:
+      proxy: http://proxy:3128
+  home:
+    roles:
+      poet:
+        prompt: rhyme
+`
+
+func TestProfileFromEnvironment(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("ASK_AI_PROFILE", "work")
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(profileConfig), 0o600))
+
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	viper.Reset()
+	defer func() { os.Args = originalArgs }()
+	os.Args = []string{"test-program", "--config", path}
+	opts, err := Initialize()
+	assert.NoError(t, err)
+	assert.Equal(t, "work", opts.Profile)
+	assert.Equal(t, "openai/gpt", opts.Model)
+
+	// The flag wins over the environment
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	viper.Reset()
+	os.Args = []string{"test-program", "--config", path, "--profile", "home"}
+	_, err = Initialize()
+	assert.ErrorContains(t, err, "profiles.home.roles")
+
+	var b strings.Builder
+	pflag.CommandLine = pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError)
+	viper.Reset()
+	os.Args = []string{"test-program", "--config", path, "--dump-config"}
+	opts, err = Initialize()
+	assert.NoError(t, err)
+	dumpConfig(&b, opts)
+	assert.Contains(t, b.String(), "Profile: work\n")
+	assert.Contains(t, b.String(), "file: /work/ask-ai.db")
+	assert.Contains(t, b.String(), "api_key: '********'")
+	assert.NotContains(t, b.String(), "sk-work")
+	assert.NotContains(t, b.String(), "profiles:")
+
+	b.Reset()
+	opts.ShowAPIKeys = true
+	dumpConfig(&b, opts)
+	assert.Contains(t, b.String(), "api_key: sk-work")
+}
+
 func HandleVersionFlags(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -417,3 +600,58 @@ func TestGetModelConfig(t *testing.T) {
 	_, err = GetModelConfig(cfg, "unknown", "m1")
 	assert.Error(t, err)
 }
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"90d", 90 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseAge(tt.in)
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	for _, bad := range []string{"d", "-5d", "0d", "soon", "-1h"} {
+		_, err := parseAge(bad)
+		assert.ErrorContains(t, err, "invalid age", bad)
+	}
+}
+
+func TestEncryptionKey(t *testing.T) {
+	defer viper.Reset()
+	defer credentials.ResetCache()
+	key := strings.Repeat("ab", 32)
+
+	viper.Reset()
+	credentials.ResetCache()
+	t.Setenv("ASK_AI_DB_KEY", key)
+	got, err := encryptionKey()
+	assert.Nil(t, err)
+	assert.Len(t, got, 32)
+
+	// A key with whitespace is a command
+	viper.Reset()
+	credentials.ResetCache()
+	t.Setenv("ASK_AI_DB_KEY", "")
+	viper.Set("database.encryption.key", "echo "+key)
+	got2, err := encryptionKey()
+	assert.Nil(t, err)
+	assert.Equal(t, got, got2)
+
+	viper.Reset()
+	credentials.ResetCache()
+	viper.Set("database.encryption.key", "short")
+	_, err = encryptionKey()
+	assert.ErrorContains(t, err, "32 bytes")
+
+	viper.Reset()
+	credentials.ResetCache()
+	_, err = encryptionKey()
+	assert.ErrorIs(t, err, credentials.ErrNoKey)
+}
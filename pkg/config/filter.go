@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+// FilterSpec is a conversation filter as the user writes it, either as
+// --model/--provider/--since/--until/--in/--regex/--tag/--starred/--archived
+// or as key:value terms in the TUI list's filter input. Resolve turns it into
+// a database.SearchFilter.
+type FilterSpec struct {
+	Model    string
+	Provider string
+	Since    string
+	Until    string
+	In       string // prompt or response
+	Regex    string
+	Tags     []string
+	Starred  bool
+	Archived bool // include archived conversations
+}
+
+// filterKeys are the keys ParseFilterSpec recognizes
+var filterKeys = []string{"model", "provider", "since", "until", "in", "regex", "tag"}
+
+func (s FilterSpec) IsZero() bool {
+	return s.Model == "" && s.Provider == "" && s.Since == "" && s.Until == "" &&
+		s.In == "" && s.Regex == "" && len(s.Tags) == 0 && !s.Starred && !s.Archived
+}
+
+// Merge returns s with the fields set in over replacing its own; tags and
+// flags add to its own
+func (s FilterSpec) Merge(over FilterSpec) FilterSpec {
+	if over.Model != "" {
+		s.Model = over.Model
+	}
+	if over.Provider != "" {
+		s.Provider = over.Provider
+	}
+	if over.Since != "" {
+		s.Since = over.Since
+	}
+	if over.Until != "" {
+		s.Until = over.Until
+	}
+	if over.In != "" {
+		s.In = over.In
+	}
+	if over.Regex != "" {
+		s.Regex = over.Regex
+	}
+	s.Tags = slices.Clone(s.Tags)
+	for _, tag := range over.Tags {
+		if !slices.Contains(s.Tags, tag) {
+			s.Tags = append(s.Tags, tag)
+		}
+	}
+	s.Starred = s.Starred || over.Starred
+	s.Archived = s.Archived || over.Archived
+	return s
+}
+
+// ParseFilterSpec splits a filter input like "model:gpt-4o since:2024-05-01
+// chess" into its key:value terms and the remaining free text. Words with an
+// unknown key are kept as text.
+func ParseFilterSpec(input string) (FilterSpec, string) {
+	var spec FilterSpec
+	var text []string
+	for _, word := range strings.Fields(input) {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok || value == "" || !slices.Contains(filterKeys, key) {
+			text = append(text, word)
+			continue
+		}
+		switch key {
+		case "model":
+			spec.Model = value
+		case "provider":
+			spec.Provider = value
+		case "since":
+			spec.Since = value
+		case "until":
+			spec.Until = value
+		case "in":
+			spec.In = value
+		case "regex":
+			spec.Regex = value
+		case "tag":
+			spec.Tags = append(spec.Tags, value)
+		}
+	}
+	return spec, strings.Join(text, " ")
+}
+
+// Resolve validates the spec and converts it for the database. A model
+// matches under its config key, its aliases and its API name, since any of
+// them may have been recorded. A provider is matched as recorded, so
+// conversations with providers or models no longer in the config still do.
+func (s FilterSpec) Resolve(cfg *Config) (database.SearchFilter, error) {
+	var filter database.SearchFilter
+	var err error
+
+	if s.Model != "" {
+		filter.Models = ModelNames(cfg, s.Provider, s.Model)
+	}
+	filter.Provider = s.Provider
+
+	if s.Since != "" {
+		filter.Since, err = parseFilterDate(s.Since, false)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since date: %w", err)
+		}
+	}
+	if s.Until != "" {
+		filter.Until, err = parseFilterDate(s.Until, true)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until date: %w", err)
+		}
+	}
+
+	switch s.In {
+	case "":
+	case "prompt":
+		filter.Role = "user"
+	case "response":
+		filter.Role = "assistant"
+	default:
+		return filter, fmt.Errorf("invalid in value %q: must be prompt or response", s.In)
+	}
+
+	if s.Regex != "" {
+		if _, err := regexp.Compile(s.Regex); err != nil {
+			return filter, fmt.Errorf("invalid regex: %w", err)
+		}
+		filter.Regex = s.Regex
+	}
+
+	for _, tag := range s.Tags {
+		norm := database.NormalizeTag(tag)
+		if norm == "" {
+			return filter, fmt.Errorf("invalid tag %q", tag)
+		}
+		filter.Tags = append(filter.Tags, norm)
+	}
+	filter.Starred = s.Starred
+	filter.IncludeArchived = s.Archived
+
+	return filter, nil
+}
+
+// ModelNames returns every name model may have been recorded under: itself
+// plus the key, aliases and API name of any configured model it refers to,
+// limited to provider when that's set.
+func ModelNames(cfg *Config, provider, model string) []string {
+	names := []string{model}
+	if cfg == nil {
+		return names
+	}
+	for provName, prov := range cfg.Models {
+		if provider != "" && provName != provider {
+			continue
+		}
+		for key, m := range prov.Models {
+			if key == model || m.ModelName == model || slices.Contains(m.Aliases, model) {
+				names = appendModelNames(names, key, m)
+			}
+		}
+	}
+	return names
+}
+
+func appendModelNames(names []string, key string, m ModelConfig) []string {
+	for _, name := range append([]string{key, m.ModelName}, m.Aliases...) {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parseFilterDate accepts a date, a date and time, or RFC 3339, in local time
+// unless a zone is given. A bare date used as an end bound covers that whole
+// day.
+func parseFilterDate(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
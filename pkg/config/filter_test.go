@@ -0,0 +1,104 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func filterTestConfig() *Config {
+	return &Config{
+		Models: map[string]Provider{
+			"openai": {Models: map[string]ModelConfig{
+				"gpt4o": {ModelName: "gpt-4o", Aliases: []string{"4o"}},
+				"mini":  {ModelName: "gpt-4o-mini"},
+			}},
+			"anthropic": {Models: map[string]ModelConfig{
+				"sonnet": {ModelName: "claude-sonnet-4-0"},
+			}},
+		},
+	}
+}
+
+func TestParseFilterSpec(t *testing.T) {
+	spec, text := ParseFilterSpec("model:gpt4o chess since:2024-05-01 in:response http://x.y openings regex:E[0-9]+ model:")
+	assert.Equal(t, FilterSpec{Model: "gpt4o", Since: "2024-05-01", In: "response", Regex: "E[0-9]+"}, spec)
+	assert.Equal(t, "chess http://x.y openings model:", text)
+
+	spec, text = ParseFilterSpec("tag:work tag:ideas notes")
+	assert.Equal(t, FilterSpec{Tags: []string{"work", "ideas"}}, spec)
+	assert.Equal(t, "notes", text)
+
+	spec, text = ParseFilterSpec("just words")
+	assert.True(t, spec.IsZero())
+	assert.Equal(t, "just words", text)
+}
+
+func TestFilterSpecMerge(t *testing.T) {
+	base := FilterSpec{Model: "a", Since: "2024-01-01"}
+	merged := base.Merge(FilterSpec{Model: "b", In: "prompt"})
+	assert.Equal(t, FilterSpec{Model: "b", Since: "2024-01-01", In: "prompt"}, merged)
+
+	// Tags and flags add up
+	base = FilterSpec{Tags: []string{"work"}, Archived: true}
+	merged = base.Merge(FilterSpec{Tags: []string{"work", "ideas"}, Starred: true})
+	assert.Equal(t, FilterSpec{Tags: []string{"work", "ideas"}, Starred: true, Archived: true}, merged)
+	assert.Equal(t, []string{"work"}, base.Tags)
+	assert.False(t, FilterSpec{Archived: true}.IsZero())
+}
+
+func TestFilterSpecResolve(t *testing.T) {
+	cfg := filterTestConfig()
+
+	f, err := FilterSpec{Model: "4o"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"4o", "gpt4o", "gpt-4o"}, f.Models)
+
+	// Models not in the config are still matched by name
+	f, err = FilterSpec{Model: "llama3"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"llama3"}, f.Models)
+
+	// A provider is matched as recorded, not through its models
+	f, err = FilterSpec{Provider: "openai"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, "openai", f.Provider)
+	assert.Empty(t, f.Models)
+	f, err = FilterSpec{Provider: "nope"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, "nope", f.Provider)
+
+	// A model is only expanded within the given provider
+	f, err = FilterSpec{Provider: "anthropic", Model: "gpt4o"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gpt4o"}, f.Models)
+	assert.Equal(t, "anthropic", f.Provider)
+
+	f, err = FilterSpec{Since: "2024-05-01", Until: "2024-05-31", In: "prompt"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), f.Since)
+	// A bare until date includes the whole day
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), f.Until)
+	assert.Equal(t, "user", f.Role)
+
+	f, err = FilterSpec{Since: "2024-05-01T10:00:00Z", In: "response"}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), f.Since.UTC())
+	assert.Equal(t, "assistant", f.Role)
+
+	_, err = FilterSpec{Since: "May 1"}.Resolve(cfg)
+	assert.ErrorContains(t, err, "invalid since date")
+	_, err = FilterSpec{In: "both"}.Resolve(cfg)
+	assert.ErrorContains(t, err, "must be prompt or response")
+	f, err = FilterSpec{Tags: []string{"#Work"}, Starred: true, Archived: true}.Resolve(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"work"}, f.Tags)
+	assert.True(t, f.Starred)
+	assert.True(t, f.IncludeArchived)
+	_, err = FilterSpec{Tags: []string{"two words"}}.Resolve(cfg)
+	assert.ErrorContains(t, err, "invalid tag")
+
+	_, err = FilterSpec{Regex: "("}.Resolve(cfg)
+	assert.ErrorContains(t, err, "invalid regex")
+}
@@ -0,0 +1,253 @@
+package credentials
+
+// Credential lookup for API keys, modelled loosely on git's credential
+// helpers. Each provider has an ordered list of sources; the first one that
+// produces a key wins and the result is cached for the life of the process.
+//
+// Example config:
+// models:
+//   anthropic:
+//     credentials:
+//       - type: env
+//         env: CLAUDE_KEY
+//       - type: helper
+//         helper: "ask-ai-credential-pass"
+//       - type: file
+//         file: "~/.config/ask-ai/anthropic-api-key"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/duluk/ask-ai/pkg/paths"
+)
+
+const (
+	SourceEnv     = "env"     // read the key from an environment variable
+	SourceKey     = "key"     // key given literally in the config
+	SourceFile    = "file"    // first line of a file
+	SourceCommand = "command" // stdout of a shell command
+	SourceHelper  = "helper"  // external helper speaking JSON on stdin/stdout
+)
+
+// Source is one place to look for an API key. Only the field matching Type
+// is used.
+type Source struct {
+	Type    string `mapstructure:"type"`
+	Env     string `mapstructure:"env"`
+	Key     string `mapstructure:"key"`
+	File    string `mapstructure:"file"`
+	Command string `mapstructure:"command"`
+	Helper  string `mapstructure:"helper"`
+}
+
+func (s Source) String() string {
+	switch s.Type {
+	case SourceEnv:
+		return "env " + s.Env
+	case SourceFile:
+		return "file " + s.File
+	case SourceCommand:
+		return "command"
+	case SourceHelper:
+		return "helper " + s.Helper
+	default:
+		return s.Type
+	}
+}
+
+// ErrNoKey is matched (via errors.Is) by every MissingKeyError
+var ErrNoKey = errors.New("no API key")
+
+// MissingKeyError means none of the sources for a provider produced a key
+type MissingKeyError struct {
+	Provider string
+	Tried    []string
+}
+
+func (e *MissingKeyError) Error() string {
+	if len(e.Tried) == 0 {
+		return fmt.Sprintf("no key for %s", e.Provider)
+	}
+	return fmt.Sprintf("no key for %s (tried %s)", e.Provider, strings.Join(e.Tried, ", "))
+}
+
+func (e *MissingKeyError) Is(target error) bool {
+	return target == ErrNoKey
+}
+
+// SourceError means a source was configured but failed, eg the command exited
+// non-zero or the helper returned garbage. Lookup stops at the first one so a
+// broken setup doesn't silently fall through to a different key.
+type SourceError struct {
+	Provider string
+	Source   string
+	Err      error
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("error getting key for %s from %s: %v", e.Provider, e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// HelperRequest is written to a helper's stdin as JSON
+type HelperRequest struct {
+	Action   string `json:"action"`
+	Provider string `json:"provider"`
+}
+
+// HelperResponse is read from a helper's stdout. An empty APIKey with no
+// Error means the helper doesn't have a key and the next source is tried.
+type HelperResponse struct {
+	APIKey string `json:"api_key"`
+	Error  string `json:"error,omitempty"`
+}
+
+// cacheEntry is the key found for one provider and list of sources. Its mu
+// is held while the sources are tried, so they run once however many ask.
+type cacheEntry struct {
+	mu  sync.Mutex
+	key string
+}
+
+var (
+	cacheMu sync.Mutex // guards the map only, not the lookups
+	cache   = make(map[string]*cacheEntry)
+)
+
+// cacheKey names the provider's sources as well as the provider, since two
+// profiles can get the same provider's key from different places
+func cacheKey(provider string, sources []Source) string {
+	data, _ := json.Marshal(sources)
+	return provider + "\n" + string(data)
+}
+
+// Lookup returns the key for provider from the first source that has one.
+// Successful lookups are cached, so helpers and commands run at most once
+// per process for the same sources. Lookups with other sources don't wait
+// on each other.
+func Lookup(provider string, sources []Source) (string, error) {
+	id := cacheKey(provider, sources)
+	cacheMu.Lock()
+	entry, ok := cache[id]
+	if !ok {
+		entry = &cacheEntry{}
+		cache[id] = entry
+	}
+	cacheMu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.key != "" {
+		return entry.key, nil
+	}
+
+	var tried []string
+	for _, src := range sources {
+		key, err := src.lookup(provider)
+		if err != nil {
+			return "", &SourceError{Provider: provider, Source: src.String(), Err: err}
+		}
+		if key != "" {
+			entry.key = key
+			return key, nil
+		}
+		tried = append(tried, src.String())
+	}
+
+	return "", &MissingKeyError{Provider: provider, Tried: tried}
+}
+
+// ResetCache forgets all cached keys
+func ResetCache() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache = make(map[string]*cacheEntry)
+}
+
+// An empty key with a nil error means "not here, keep looking"
+func (s Source) lookup(provider string) (string, error) {
+	switch s.Type {
+	case SourceEnv:
+		return os.Getenv(s.Env), nil
+	case SourceKey:
+		return s.Key, nil
+	case SourceFile:
+		return readKeyFile(s.File)
+	case SourceCommand:
+		return runCommand(s.Command)
+	case SourceHelper:
+		return runHelper(s.Helper, provider)
+	default:
+		return "", fmt.Errorf("unknown credential source type %q", s.Type)
+	}
+}
+
+func readKeyFile(path string) (string, error) {
+	file, err := os.Open(paths.ExpandHome(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if scanner.Scan() {
+		return strings.TrimSpace(scanner.Text()), nil
+	}
+	return "", scanner.Err()
+}
+
+func runCommand(command string) (string, error) {
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Helpers are invoked as `<helper> get` with a HelperRequest on stdin, the
+// same way git runs `git credential-<name> get`.
+func runHelper(helper string, provider string) (string, error) {
+	req, err := json.Marshal(HelperRequest{Action: "get", Provider: provider})
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", helper+" get")
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return "", nil
+	}
+
+	var resp HelperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return "", fmt.Errorf("invalid helper response: %v", err)
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.APIKey, nil
+}
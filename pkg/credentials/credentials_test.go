@@ -0,0 +1,185 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup_Env(t *testing.T) {
+	ResetCache()
+	t.Setenv("CREDS_TEST_KEY", "env-key")
+
+	key, err := Lookup("envtest", []Source{{Type: SourceEnv, Env: "CREDS_TEST_KEY"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "env-key", key)
+}
+
+func TestLookup_OrderAndFallthrough(t *testing.T) {
+	ResetCache()
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key")
+	os.WriteFile(keyPath, []byte("file-key\nignored"), 0o600)
+
+	sources := []Source{
+		{Type: SourceEnv, Env: "CREDS_UNSET_KEY"},
+		{Type: SourceFile, File: filepath.Join(dir, "missing")},
+		{Type: SourceFile, File: keyPath},
+		{Type: SourceKey, Key: "literal-key"},
+	}
+	key, err := Lookup("order", sources)
+	assert.NoError(t, err)
+	assert.Equal(t, "file-key", key)
+}
+
+func TestLookup_Command(t *testing.T) {
+	ResetCache()
+	key, err := Lookup("cmd", []Source{{Type: SourceCommand, Command: "echo '  cmd-key  '"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd-key", key)
+}
+
+func TestLookup_CommandFailure(t *testing.T) {
+	ResetCache()
+	_, err := Lookup("cmdfail", []Source{
+		{Type: SourceCommand, Command: "exit 3"},
+		{Type: SourceKey, Key: "never-reached"},
+	})
+	var srcErr *SourceError
+	assert.True(t, errors.As(err, &srcErr))
+	assert.Equal(t, "cmdfail", srcErr.Provider)
+	assert.Equal(t, "command", srcErr.Source)
+}
+
+func writeHelper(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "helper.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup_Helper(t *testing.T) {
+	ResetCache()
+	// Echo the provider back so we know the request made it to the helper
+	helper := writeHelper(t, `[ "$1" = "get" ] || exit 1
+provider=$(sed -e 's/.*"provider":"\([^"]*\)".*/\1/')
+printf '{"api_key":"key-for-%s"}' "$provider"
+`)
+
+	key, err := Lookup("anthropic", []Source{{Type: SourceHelper, Helper: helper}})
+	assert.NoError(t, err)
+	assert.Equal(t, "key-for-anthropic", key)
+}
+
+func TestLookup_HelperEmptyFallsThrough(t *testing.T) {
+	ResetCache()
+	helper := writeHelper(t, `echo '{}'`)
+
+	key, err := Lookup("empty", []Source{
+		{Type: SourceHelper, Helper: helper},
+		{Type: SourceKey, Key: "fallback"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback", key)
+}
+
+func TestLookup_HelperError(t *testing.T) {
+	ResetCache()
+	helper := writeHelper(t, `echo '{"error":"vault is locked"}'`)
+
+	_, err := Lookup("locked", []Source{{Type: SourceHelper, Helper: helper}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vault is locked")
+}
+
+func TestLookup_Missing(t *testing.T) {
+	ResetCache()
+	_, err := Lookup("anthropic", []Source{{Type: SourceEnv, Env: "CREDS_UNSET_KEY"}})
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	var missing *MissingKeyError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, "anthropic", missing.Provider)
+	assert.Contains(t, err.Error(), "no key for anthropic")
+}
+
+func TestLookup_Cached(t *testing.T) {
+	ResetCache()
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "count")
+
+	// Each run appends to the counter file; a cached lookup must not run it again
+	src := Source{Type: SourceCommand, Command: "echo x >> " + counter + "; echo cached-key"}
+	for i := 0; i < 3; i++ {
+		key, err := Lookup("cached", []Source{src})
+		assert.NoError(t, err)
+		assert.Equal(t, "cached-key", key)
+	}
+
+	data, err := os.ReadFile(counter)
+	assert.NoError(t, err)
+	assert.Equal(t, "x\n", string(data))
+}
+
+func TestLookup_CachedPerSources(t *testing.T) {
+	ResetCache()
+	// Two profiles with their own key for the same provider
+	key, err := Lookup("openai", []Source{{Type: SourceKey, Key: "work-key"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "work-key", key)
+	key, err = Lookup("openai", []Source{{Type: SourceKey, Key: "home-key"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "home-key", key)
+}
+
+func TestLookup_SlowSourceDoesNotBlockOthers(t *testing.T) {
+	ResetCache()
+	dir := t.TempDir()
+	started, release := filepath.Join(dir, "started"), filepath.Join(dir, "release")
+	slow := Source{Type: SourceCommand, Command: "touch " + started + "; while [ ! -f " + release + " ]; do sleep 0.01; done; echo slow-key"}
+	// Let the command finish even if the test fails
+	t.Cleanup(func() { os.WriteFile(release, nil, 0o600) })
+
+	done := make(chan string)
+	go func() {
+		key, _ := Lookup("slow", []Source{slow})
+		done <- key
+	}()
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(started)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	fast := make(chan string)
+	go func() {
+		key, _ := Lookup("fast", []Source{{Type: SourceKey, Key: "fast-key"}})
+		fast <- key
+	}()
+	select {
+	case key := <-fast:
+		assert.Equal(t, "fast-key", key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup waited on another provider's command")
+	}
+
+	assert.NoError(t, os.WriteFile(release, nil, 0o600))
+	select {
+	case key := <-done:
+		assert.Equal(t, "slow-key", key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow lookup never finished")
+	}
+}
+
+func TestLookup_UnknownType(t *testing.T) {
+	ResetCache()
+	_, err := Lookup("bogus", []Source{{Type: "carrier-pigeon"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown credential source type")
+}
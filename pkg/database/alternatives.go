@@ -0,0 +1,206 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Alternative is one version of a turn that was retried or edited
+type Alternative struct {
+	ID        int
+	Prompt    string
+	Response  string
+	Model     string
+	Timestamp string
+	Chosen    bool // this version is the one in the conversation's history
+}
+
+// lastTurnSeq is the seq of the conversation's last prompt
+func (sqlDB *ChatDB) lastTurnSeq(tx *sql.Tx, convID int) (int, error) {
+	var seq sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT MAX(seq) FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND role = 'user';
+	`, convID).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	if !seq.Valid {
+		return 0, fmt.Errorf("conversation %d has no turns", convID)
+	}
+	return int(seq.Int64), nil
+}
+
+// ReplaceLastTurn replaces the conversation's last turn with a new version of
+// it, as retrying it or editing its prompt does. The version it replaces is
+// kept as an alternative of the turn, and the new one is chosen.
+func (sqlDB *ChatDB) ReplaceLastTurn(turn Turn) error {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	seq, err := sqlDB.lastTurnSeq(tx, turn.ConvID)
+	if err != nil {
+		return err
+	}
+
+	// The first time a turn is replaced, the original becomes its first
+	// alternative
+	var n int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM `+sqlDB.altTable+` WHERE conversation_id = ? AND seq = ?;
+	`, turn.ConvID, seq).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n == 0 {
+		_, err = tx.Exec(`
+			INSERT INTO `+sqlDB.altTable+` (conversation_id, seq, prompt, response, model, temperature, input_tokens, output_tokens, timestamp,
+				provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms)
+			SELECT p.conversation_id, p.seq, p.content, COALESCE(r.content, ''), COALESCE(r.model, p.model),
+				COALESCE(r.temperature, p.temperature), p.tokens, r.tokens, COALESCE(r.timestamp, p.timestamp),
+				COALESCE(r.provider, ''), COALESCE(r.system_prompt, ''), COALESCE(r.role_name, ''), COALESCE(r.thinking, ''),
+				r.max_tokens, COALESCE(r.finish_reason, ''), COALESCE(r.error, ''), r.latency_ms
+			FROM `+sqlDB.msgTable+` p LEFT JOIN `+sqlDB.msgTable+` r
+				ON r.conversation_id = p.conversation_id AND r.seq = p.seq + 1
+			WHERE p.conversation_id = ? AND p.seq = ?;
+		`, turn.ConvID, seq)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	}
+
+	prompt, err := sqlDB.encrypt(turn.Prompt)
+	if err != nil {
+		return err
+	}
+	response, err := sqlDB.encrypt(turn.Response)
+	if err != nil {
+		return err
+	}
+	systemPrompt, err := sqlDB.encryptField(turn.SystemPrompt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE `+sqlDB.altTable+` SET chosen = 0 WHERE conversation_id = ? AND seq = ?;`, turn.ConvID, seq)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.altTable+` (conversation_id, seq, prompt, response, model, temperature, input_tokens, output_tokens,
+			provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms, chosen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1);
+	`, turn.ConvID, seq, prompt, response, turn.Model, turn.Temperature, turn.InputTokens, turn.OutputTokens,
+		turn.Provider, systemPrompt, turn.Role, turn.Thinking, nullInt(turn.MaxTokens), turn.FinishReason, turn.Error,
+		nullInt(int(turn.Latency.Milliseconds())))
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND seq >= ?;`, turn.ConvID, seq)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if err := sqlDB.insertTurnMessages(tx, turn, seq); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE `+sqlDB.dbTable+` SET model = ? WHERE id = ?;`, turn.Model, turn.ConvID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	return tx.Commit()
+}
+
+// Alternatives returns the versions of the conversation's last turn, oldest
+// first, or none if it was never retried or edited
+func (sqlDB *ChatDB) Alternatives(convID int) ([]Alternative, error) {
+	rows, err := sqlDB.db.Query(`
+		SELECT id, prompt, response, model, timestamp, chosen FROM `+sqlDB.altTable+`
+		WHERE conversation_id = ? AND seq = (
+			SELECT MAX(seq) FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND role = 'user'
+		)
+		ORDER BY id;
+	`, convID, convID)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var alts []Alternative
+	for rows.Next() {
+		var a Alternative
+		if err := rows.Scan(&a.ID, &a.Prompt, &a.Response, &a.Model, &a.Timestamp, &a.Chosen); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		if a.Prompt, err = sqlDB.decrypt(a.Prompt); err != nil {
+			return nil, err
+		}
+		if a.Response, err = sqlDB.decrypt(a.Response); err != nil {
+			return nil, err
+		}
+		alts = append(alts, a)
+	}
+	return alts, rows.Err()
+}
+
+// ChooseAlternative makes an alternative of the conversation's last turn the
+// one in its history
+func (sqlDB *ChatDB) ChooseAlternative(convID, altID int) error {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	seq, err := sqlDB.lastTurnSeq(tx, convID)
+	if err != nil {
+		return err
+	}
+	var altSeq int
+	var model string
+	err = tx.QueryRow(`
+		SELECT seq, model FROM `+sqlDB.altTable+` WHERE id = ? AND conversation_id = ?;
+	`, altID, convID).Scan(&altSeq, &model)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("alternative %d %w in conversation %d", altID, ErrNotFound, convID)
+	}
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if altSeq != seq {
+		return fmt.Errorf("only the last turn's alternatives can be chosen")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE `+sqlDB.altTable+` SET chosen = (id = ?) WHERE conversation_id = ? AND seq = ?;
+	`, altID, convID, seq)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`DELETE FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND seq >= ?;`, convID, seq)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.msgTable+` (conversation_id, seq, role, content, tokens, model, temperature, timestamp,
+			provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms)
+		SELECT conversation_id, seq, 'user', prompt, input_tokens, model, temperature, timestamp,
+			'', '', '', '', NULL, '', '', NULL
+		FROM `+sqlDB.altTable+` WHERE id = ?
+		UNION ALL
+		SELECT conversation_id, seq + 1, 'assistant', response, output_tokens, model, temperature, timestamp,
+			provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms
+		FROM `+sqlDB.altTable+` WHERE id = ?;
+	`, altID, altID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`UPDATE `+sqlDB.dbTable+` SET model = ? WHERE id = ?;`, model, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceLastTurn(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	assert.ErrorContains(t, db.ReplaceLastTurn(Turn{ConvID: 1, Prompt: "q", Response: "r"}), "conversation 1 has no turns")
+
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "first", Response: "one", Model: "a"}))
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "secnod", Response: "huh?", Model: "a", OutputTokens: 2}))
+	alts, err := db.Alternatives(1)
+	assert.Nil(t, err)
+	assert.Empty(t, alts)
+
+	// Retrying with another model, then editing the prompt
+	assert.Nil(t, db.ReplaceLastTurn(Turn{ConvID: 1, Prompt: "secnod", Response: "second?", Model: "b"}))
+	assert.Nil(t, db.ReplaceLastTurn(Turn{ConvID: 1, Prompt: "second", Response: "two", Model: "b", OutputTokens: 1, FinishReason: "stop"}))
+
+	msgs, err := db.Messages(1)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "second", msgs[2].Content)
+	assert.Equal(t, "two", msgs[3].Content)
+	assert.Equal(t, int32(1), msgs[3].Tokens)
+
+	alts, err = db.Alternatives(1)
+	assert.Nil(t, err)
+	assert.Len(t, alts, 3)
+	assert.Equal(t, []string{"huh?", "second?", "two"}, []string{alts[0].Response, alts[1].Response, alts[2].Response})
+	assert.Equal(t, "a", alts[0].Model)
+	assert.Equal(t, []bool{false, false, true}, []bool{alts[0].Chosen, alts[1].Chosen, alts[2].Chosen})
+
+	// Going back to the original restores it as it was
+	assert.Nil(t, db.ChooseAlternative(1, alts[0].ID))
+	msgs, err = db.Messages(1)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "secnod", msgs[2].Content)
+	assert.Equal(t, "huh?", msgs[3].Content)
+	assert.Equal(t, int32(2), msgs[3].Tokens)
+	assert.Equal(t, alts[0].Timestamp, msgs[3].Timestamp)
+	conv, err := db.GetConversation(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", conv.Model)
+	alts, err = db.Alternatives(1)
+	assert.Nil(t, err)
+	assert.True(t, alts[0].Chosen)
+	assert.False(t, alts[2].Chosen)
+
+	// and the newer one comes back with its metadata
+	assert.Nil(t, db.ChooseAlternative(1, alts[2].ID))
+	msgs, err = db.Messages(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "stop", msgs[3].FinishReason)
+	assert.Equal(t, "", msgs[2].FinishReason)
+
+	// Once the conversation moves on, the older turn's versions are kept but
+	// can't be chosen
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "third", Response: "three", Model: "a"}))
+	alts2, err := db.Alternatives(1)
+	assert.Nil(t, err)
+	assert.Empty(t, alts2)
+	assert.ErrorContains(t, db.ChooseAlternative(1, alts[1].ID), "only the last turn's")
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 2, Prompt: "other", Response: "chat", Model: "a"}))
+	assert.ErrorIs(t, db.ChooseAlternative(2, alts[1].ID), ErrNotFound)
+
+	assert.Nil(t, db.DeleteConversation(1))
+	var n int
+	assert.Nil(t, db.db.QueryRow(`SELECT COUNT(*) FROM `+db.altTable).Scan(&n))
+	assert.Equal(t, 0, n)
+}
@@ -1,26 +1,35 @@
 package database
 
-import (
-	"database/sql"
-	"strconv"
-)
+// SchemaVersion is the latest schema. It has one row per conversation, one
+// row per message ordered by seq within the conversation, and the
+// conversations' tags. Conversations imported from another application record
+// where they came from so importing again doesn't duplicate them, and forks
+// record the conversation and turn they branched from. A turn that was
+// retried or edited keeps every version in the alternatives table, keyed by
+// the seq of its prompt, with the chosen one copied into the messages.
+// Responses, and alternatives, record how they were asked for: the provider,
+// system prompt, role, thinking effort and max tokens, why the answer ended
+// and how long it took. Settings such as encryption that have to travel with
+// the database are kept in it. Conversations also have a UUID that identifies
+// them across databases.
+const SchemaVersion = 12
 
-const SchemaVersion = 3
+// Messages live in their own table next to the conversations table, named
+// after it so test tables don't collide with the real ones.
+func messagesTable(dbTable string) string {
+	return dbTable + "_messages"
+}
 
-func DBSchema(dbTable string) string {
-	return `
-	CREATE TABLE IF NOT EXISTS ` + dbTable + ` (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		prompt TEXT NOT NULL,
-		response TEXT NOT NULL,
-		model_name TEXT NOT NULL,
-		temperature REAL NOT NULL,
-		input_tokens INTEGER,
-		output_tokens INTEGER,
-		conv_id INTEGER
-	);
-	`
+func tagsTable(dbTable string) string {
+	return dbTable + "_tags"
+}
+
+func alternativesTable(dbTable string) string {
+	return dbTable + "_alternatives"
+}
+
+func settingsTable(dbTable string) string {
+	return dbTable + "_settings"
 }
 
 func SchemaQueryV1(dbTable string) string {
@@ -55,74 +64,216 @@ func SchemaQueryV3(dbTable string) string {
 	`
 }
 
-// There's got to be a better way to do this
-func getSchemaSQL(schemaVersion int, dbTable string) string {
-	switch schemaVersion {
-	case 1:
-		return SchemaQueryV1(dbTable)
-	case 2:
-		return SchemaQueryV2(dbTable)
-	case 3:
-		return SchemaQueryV3(dbTable)
-	default:
-		return ""
-	}
+// SchemaQueryV4 splits the single prompt/response table into conversations
+// and messages. Each v3 row becomes a user message followed by an assistant
+// message; the prompt's token count is the input tokens and the response's
+// the output tokens, which is all LoadConversationFromDB ever reported. Rows
+// written before conv_id existed were one-off questions, so each gets its own
+// conversation numbered after the highest existing ID.
+func SchemaQueryV4(dbTable string) string {
+	oldTable := dbTable + "_v3"
+	msgTable := messagesTable(dbTable)
+	return `
+	ALTER TABLE ` + dbTable + ` RENAME TO ` + oldTable + `;
+
+	CREATE TABLE ` + dbTable + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT NOT NULL DEFAULT '',
+		created DATETIME DEFAULT CURRENT_TIMESTAMP,
+		model TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL DEFAULT '',
+		system_prompt TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE ` + msgTable + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id INTEGER NOT NULL REFERENCES ` + dbTable + `(id),
+		seq INTEGER NOT NULL,
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		tokens INTEGER,
+		model TEXT NOT NULL DEFAULT '',
+		temperature REAL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (conversation_id, seq)
+	);
+
+	CREATE TEMP TABLE v4_rows AS
+	SELECT *, ROW_NUMBER() OVER (PARTITION BY new_conv_id ORDER BY id) AS turn
+	FROM (
+		SELECT id, timestamp, prompt, response, model_name, temperature, input_tokens, output_tokens,
+			COALESCE(conv_id, (SELECT COALESCE(MAX(conv_id), 0) FROM ` + oldTable + `)
+				+ ROW_NUMBER() OVER (PARTITION BY conv_id IS NULL ORDER BY id)) AS new_conv_id
+		FROM ` + oldTable + `
+	);
+
+	INSERT INTO ` + dbTable + ` (id, created, model)
+	SELECT new_conv_id, MIN(timestamp),
+		(SELECT l.model_name FROM v4_rows l WHERE l.new_conv_id = v.new_conv_id ORDER BY l.id DESC LIMIT 1)
+	FROM v4_rows v
+	GROUP BY new_conv_id;
+
+	INSERT INTO ` + msgTable + ` (conversation_id, seq, role, content, tokens, model, temperature, timestamp)
+	SELECT new_conv_id, seq, role, content, tokens, model_name, temperature, timestamp FROM (
+		SELECT new_conv_id, 2 * (turn - 1) AS seq, 'user' AS role, prompt AS content, input_tokens AS tokens,
+			model_name, temperature, timestamp
+		FROM v4_rows
+		UNION ALL
+		SELECT new_conv_id, 2 * (turn - 1) + 1, 'assistant', response, output_tokens,
+			model_name, temperature, timestamp
+		FROM v4_rows
+	)
+	ORDER BY new_conv_id, seq;
+
+	DROP TABLE v4_rows;
+	DROP TABLE ` + oldTable + `;
+
+	PRAGMA user_version = 4;
+	`
 }
 
-func applySchema(db *sql.DB, dbTable string, schemaVersion int) error {
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
+// SchemaQueryV5 adds starred and archived flags and tags to conversations
+func SchemaQueryV5(dbTable string) string {
+	tagTable := tagsTable(dbTable)
+	return `
+	ALTER TABLE ` + dbTable + ` ADD COLUMN starred INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE ` + dbTable + ` ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
 
-	_, err = tx.Exec(getSchemaSQL(schemaVersion, dbTable))
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	CREATE TABLE IF NOT EXISTS ` + tagTable + ` (
+		conversation_id INTEGER NOT NULL REFERENCES ` + dbTable + `(id),
+		tag TEXT NOT NULL,
+		PRIMARY KEY (conversation_id, tag)
+	);
+	CREATE INDEX IF NOT EXISTS ` + tagTable + `_tag ON ` + tagTable + ` (tag);
 
-	err = tx.Commit()
-	if err != nil {
-		return err
-	}
+	PRAGMA user_version = 5;
+	`
+}
 
-	return nil
+// SchemaQueryV6 records where imported conversations came from
+func SchemaQueryV6(dbTable string) string {
+	return `
+	ALTER TABLE ` + dbTable + ` ADD COLUMN source TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + dbTable + ` ADD COLUMN source_id TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX IF NOT EXISTS ` + dbTable + `_source ON ` + dbTable + ` (source, source_id) WHERE source_id != '';
+
+	PRAGMA user_version = 6;
+	`
+}
+
+// SchemaQueryV7 lets a conversation remember the one it was forked from
+func SchemaQueryV7(dbTable string) string {
+	return `
+	ALTER TABLE ` + dbTable + ` ADD COLUMN parent_id INTEGER REFERENCES ` + dbTable + `(id);
+	ALTER TABLE ` + dbTable + ` ADD COLUMN parent_turn INTEGER;
+	CREATE INDEX IF NOT EXISTS ` + dbTable + `_parent ON ` + dbTable + ` (parent_id);
+
+	PRAGMA user_version = 7;
+	`
+}
+
+// SchemaQueryV8 keeps the alternatives of retried and edited turns
+func SchemaQueryV8(dbTable string) string {
+	altTable := alternativesTable(dbTable)
+	return `
+	CREATE TABLE IF NOT EXISTS ` + altTable + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id INTEGER NOT NULL REFERENCES ` + dbTable + `(id),
+		seq INTEGER NOT NULL,
+		prompt TEXT NOT NULL,
+		response TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		temperature REAL,
+		input_tokens INTEGER,
+		output_tokens INTEGER,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		chosen INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS ` + altTable + `_turn ON ` + altTable + ` (conversation_id, seq);
+
+	PRAGMA user_version = 8;
+	`
+}
+
+// SchemaQueryV9 records each response's request metadata, and any error
+func SchemaQueryV9(dbTable string) string {
+	msgTable := messagesTable(dbTable)
+	altTable := alternativesTable(dbTable)
+	return `
+	ALTER TABLE ` + msgTable + ` ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + msgTable + ` ADD COLUMN system_prompt TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + msgTable + ` ADD COLUMN role_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + msgTable + ` ADD COLUMN thinking TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + msgTable + ` ADD COLUMN max_tokens INTEGER;
+	ALTER TABLE ` + msgTable + ` ADD COLUMN finish_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + msgTable + ` ADD COLUMN error TEXT NOT NULL DEFAULT '';
+
+	ALTER TABLE ` + altTable + ` ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + altTable + ` ADD COLUMN system_prompt TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + altTable + ` ADD COLUMN role_name TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + altTable + ` ADD COLUMN thinking TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + altTable + ` ADD COLUMN max_tokens INTEGER;
+	ALTER TABLE ` + altTable + ` ADD COLUMN finish_reason TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + altTable + ` ADD COLUMN error TEXT NOT NULL DEFAULT '';
+
+	PRAGMA user_version = 9;
+	`
 }
 
-func setSchemaVersion(db *sql.DB, schemaVersion int) error {
-	verStr := strconv.Itoa(schemaVersion)
-	_, err := db.Exec(`PRAGMA user_version = ` + verStr)
-	return err
+// SchemaQueryV10 keeps settings that belong to the database rather than the
+// config, such as whether its messages are encrypted
+func SchemaQueryV10(dbTable string) string {
+	return `
+	CREATE TABLE IF NOT EXISTS ` + settingsTable(dbTable) + ` (
+		name TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+
+	PRAGMA user_version = 10;
+	`
 }
 
+// SchemaQueryV11 records how long each response took
+func SchemaQueryV11(dbTable string) string {
+	return `
+	ALTER TABLE ` + messagesTable(dbTable) + ` ADD COLUMN latency_ms INTEGER;
+	ALTER TABLE ` + alternativesTable(dbTable) + ` ADD COLUMN latency_ms INTEGER;
+
+	PRAGMA user_version = 11;
+	`
+}
+
+// SchemaQueryV12 gives every conversation a UUID, so it can be recognized
+// in another machine's database whatever its ID is there. Existing
+// conversations get random (version 4) ones.
+func SchemaQueryV12(dbTable string) string {
+	return `
+	ALTER TABLE ` + dbTable + ` ADD COLUMN uuid TEXT;
+	UPDATE ` + dbTable + ` SET uuid = lower(
+		hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' ||
+		substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS ` + dbTable + `_uuid ON ` + dbTable + ` (uuid);
+
+	PRAGMA user_version = 12;
+	`
+}
+
+// InitializeDB opens the database, creating it if it doesn't exist, and
+// brings its schema up to date, backing it up first if it had one.
 func InitializeDB(dbPath string, dbTable string) (*ChatDB, error) {
-	// DB created only if it doesn't exist
 	chatDB, err := NewDB(dbPath, dbTable)
 	if err != nil {
 		return chatDB, err
 	}
 
-	var currentVersion int
-	err = chatDB.db.QueryRow("PRAGMA user_version").Scan(&currentVersion)
+	version, err := chatDB.Version()
 	if err != nil {
 		return chatDB, err
 	}
-
-	if currentVersion == 0 {
-		// This should mean it's the first time we've created this database,
-		// which means it should be using the latest schema, which should mean
-		// the latest schema version. So just set that.
-		setSchemaVersion(chatDB.db, SchemaVersion)
-	} else if currentVersion < SchemaVersion {
-		// If the current schema exists but is less than the latest schema,
-		// apply each schema that was missed.
-		for i := currentVersion + 1; i <= SchemaVersion; i++ {
-			err = applySchema(chatDB.db, dbTable, i)
-			if err != nil {
-				return chatDB, err
-			}
-		}
+	// A database written by a newer ask-ai is left as it is
+	if version < SchemaVersion {
+		_, _, err = chatDB.Migrate(SchemaVersion, false)
 	}
-
 	return chatDB, err
 }
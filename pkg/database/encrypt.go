@@ -0,0 +1,314 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Encrypted prompts, responses, titles and system prompts are stored as this
+// prefix followed by the base64 of the GCM nonce and ciphertext. Anything
+// without it is plaintext, so a database can hold both while it's being
+// converted.
+const encryptedPrefix = "enc1:"
+
+// The settings row that marks a database as encrypted holds a known value
+// sealed with the key, so a wrong key is caught before it's used
+const (
+	encryptionSetting = "encryption"
+	keyCheck          = "ask-ai"
+)
+
+// ErrEncrypted means the database has encrypted messages but no key was given
+var ErrEncrypted = errors.New("the database is encrypted; configure database.encryption with its key")
+
+// ParseKey decodes an encryption key: 32 random bytes, hex or base64
+// encoded, as `openssl rand -base64 32` makes
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, hex or base64 encoded")
+	}
+	return key, nil
+}
+
+var aeadCache sync.Map // string(key) -> cipher.AEAD
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if aead, ok := aeadCache.Load(string(key)); ok {
+		return aead.(cipher.AEAD), nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	aeadCache.Store(string(key), aead)
+	return aead, nil
+}
+
+func seal(aead cipher.AEAD, plaintext string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func open(aead cipher.AEAD, value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted message")
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt message: wrong key?")
+	}
+	return string(plaintext), nil
+}
+
+// decryptValue implements decrypt(key, value), which lets queries filter on
+// the plaintext of encrypted columns
+func decryptValue(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	return open(aead, value)
+}
+
+// encrypt is what a prompt or response is stored as
+func (sqlDB *ChatDB) encrypt(plaintext string) (string, error) {
+	if sqlDB.aead == nil {
+		return plaintext, nil
+	}
+	value, err := seal(sqlDB.aead, plaintext)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting message: %v", err)
+	}
+	return value, nil
+}
+
+// encryptField is what a title or system prompt is stored as. An empty one
+// stays empty, since queries look for conversations without a title.
+func (sqlDB *ChatDB) encryptField(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	return sqlDB.encrypt(plaintext)
+}
+
+// decrypt is the plaintext of a stored prompt, response, title or system
+// prompt
+func (sqlDB *ChatDB) decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if sqlDB.aead == nil {
+		return "", ErrEncrypted
+	}
+	return open(sqlDB.aead, value)
+}
+
+// plaintext is the SQL for the plaintext of an encrypted column, with its
+// arguments
+func (sqlDB *ChatDB) plaintext(col string) (string, []any) {
+	if sqlDB.aead == nil {
+		return col, nil
+	}
+	return `decrypt(?, ` + col + `)`, []any{sqlDB.key}
+}
+
+// Encrypted reports whether the database is marked as encrypted
+func (sqlDB *ChatDB) Encrypted() (bool, error) {
+	// Schemas before v10 have nowhere to mark it
+	var n int
+	err := sqlDB.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;
+	`, settingsTable(sqlDB.dbTable)).Scan(&n)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	err = sqlDB.db.QueryRow(`
+		SELECT COUNT(*) FROM `+settingsTable(sqlDB.dbTable)+` WHERE name = ?;
+	`, encryptionSetting).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("%v", err)
+	}
+	return n > 0, nil
+}
+
+// SetEncryptionKey encrypts prompts, responses, titles and system prompts
+// stored from now on and decrypts them on the way out. A database that's
+// already encrypted must have been encrypted with the same key; one that
+// isn't is marked as encrypted, though what it already holds stays as it is
+// until EncryptAll.
+// The search index is dropped, since it would hold the plaintext.
+func (sqlDB *ChatDB) SetEncryptionKey(key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return fmt.Errorf("invalid encryption key: %v", err)
+	}
+
+	var check string
+	err = sqlDB.db.QueryRow(`
+		SELECT value FROM `+settingsTable(sqlDB.dbTable)+` WHERE name = ?;
+	`, encryptionSetting).Scan(&check)
+	switch {
+	case err == sql.ErrNoRows:
+		check, err = seal(aead, keyCheck)
+		if err != nil {
+			return fmt.Errorf("error encrypting key check: %v", err)
+		}
+		_, err = sqlDB.db.Exec(`
+			INSERT INTO `+settingsTable(sqlDB.dbTable)+` (name, value) VALUES (?, ?);
+		`, encryptionSetting, check)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	case err != nil:
+		return fmt.Errorf("%v", err)
+	default:
+		if plain, err := open(aead, check); err != nil || plain != keyCheck {
+			return fmt.Errorf("the encryption key doesn't match the one the database was encrypted with")
+		}
+	}
+
+	sqlDB.key = key
+	sqlDB.aead = aead
+	return sqlDB.dropSearchIndex()
+}
+
+// EncryptAll encrypts every stored prompt, response, title and system prompt
+// that isn't already, and returns how many it encrypted. The old plaintext
+// may linger in free pages until the database is vacuumed.
+func (sqlDB *ChatDB) EncryptAll() (int, error) {
+	if sqlDB.aead == nil {
+		return 0, fmt.Errorf("no encryption key set")
+	}
+	return sqlDB.convertAll(func(value string) (string, bool, error) {
+		if value == "" || strings.HasPrefix(value, encryptedPrefix) {
+			return value, false, nil
+		}
+		sealed, err := sqlDB.encrypt(value)
+		return sealed, true, err
+	})
+}
+
+// DecryptAll decrypts everything EncryptAll encrypts, returns how many it
+// decrypted and stops encrypting new ones. The database is no longer marked
+// as encrypted and the search index is rebuilt.
+func (sqlDB *ChatDB) DecryptAll() (int, error) {
+	if sqlDB.aead == nil {
+		return 0, fmt.Errorf("no encryption key set")
+	}
+	n, err := sqlDB.convertAll(func(value string) (string, bool, error) {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			return value, false, nil
+		}
+		plain, err := sqlDB.decrypt(value)
+		return plain, true, err
+	})
+	if err != nil {
+		return n, err
+	}
+
+	_, err = sqlDB.db.Exec(`DELETE FROM `+settingsTable(sqlDB.dbTable)+` WHERE name = ?;`, encryptionSetting)
+	if err != nil {
+		return n, fmt.Errorf("%v", err)
+	}
+	sqlDB.key = nil
+	sqlDB.aead = nil
+	return n, sqlDB.ensureSearchIndex()
+}
+
+// convertAll rewrites every prompt, response, title and system prompt in one
+// transaction, returning how many convert changed
+func (sqlDB *ChatDB) convertAll(convert func(string) (string, bool, error)) (int, error) {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	total := 0
+	columns := []struct{ table, column string }{
+		{sqlDB.msgTable, "content"},
+		{sqlDB.msgTable, "system_prompt"},
+		{sqlDB.altTable, "prompt"},
+		{sqlDB.altTable, "response"},
+		{sqlDB.altTable, "system_prompt"},
+		{sqlDB.dbTable, "title"},
+		{sqlDB.dbTable, "system_prompt"},
+	}
+	for _, c := range columns {
+		n, err := convertColumn(tx, c.table, c.column, convert)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return total, nil
+}
+
+func convertColumn(tx *sql.Tx, table, column string, convert func(string) (string, bool, error)) (int, error) {
+	rows, err := tx.Query(`SELECT id, ` + column + ` FROM ` + table + `;`)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	type row struct {
+		id    int
+		value string
+	}
+	var changed []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.value); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%v", err)
+		}
+		value, ok, err := convert(r.value)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%s %d: %v", table, r.id, err)
+		}
+		if ok {
+			changed = append(changed, row{r.id, value})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+
+	for _, r := range changed {
+		_, err := tx.Exec(`UPDATE `+table+` SET `+column+` = ? WHERE id = ?;`, r.value, r.id)
+		if err != nil {
+			return 0, fmt.Errorf("%v", err)
+		}
+	}
+	return len(changed), nil
+}
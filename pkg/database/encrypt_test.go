@@ -0,0 +1,201 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey(hex.EncodeToString(testKey))
+	assert.Nil(t, err)
+	assert.Equal(t, testKey, key)
+
+	key, err = ParseKey(base64.StdEncoding.EncodeToString(testKey) + "\n")
+	assert.Nil(t, err)
+	assert.Equal(t, testKey, key)
+
+	for _, bad := range []string{"", "hunter2", hex.EncodeToString(testKey[:16])} {
+		_, err = ParseKey(bad)
+		assert.ErrorContains(t, err, "32 bytes", bad)
+	}
+}
+
+func TestEncryptedMessages(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	assert.Nil(t, db.SetEncryptionKey(testKey))
+	assert.False(t, db.HasFullTextSearch())
+	insertSearchFixtures(t, db)
+
+	// Nothing readable is stored
+	var content string
+	assert.Nil(t, db.db.QueryRow(`SELECT content FROM `+db.msgTable+` WHERE seq = 0 AND conversation_id = 1`).Scan(&content))
+	assert.Contains(t, content, encryptedPrefix)
+	assert.NotContains(t, content, "fox")
+
+	msgs, err := db.Messages(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "tell me about the quick brown fox", msgs[0].Content)
+	assert.Equal(t, "it jumps over things", msgs[1].Content)
+
+	// Search decrypts as it reads
+	results, err := db.Search("fox")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, resultIDs(results))
+	assert.Contains(t, results[0].Snippet, HighlightStart+"fox"+HighlightEnd)
+	results, err = db.FindConversations(SearchFilter{Regex: `^sleeps`})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, resultIDs(results))
+	ids, err := db.SearchForConversation("lazy")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, ids)
+
+	// And so do alternatives
+	assert.Nil(t, db.ReplaceLastTurn(Turn{ConvID: 2, Prompt: "what does a lazy cat do", Response: "naps", Model: "m"}))
+	alts, err := db.Alternatives(2)
+	assert.Nil(t, err)
+	assert.Len(t, alts, 2)
+	assert.Equal(t, "sleeps mostly", alts[0].Response)
+	assert.Equal(t, "what does a lazy cat do", alts[1].Prompt)
+}
+
+func TestEncryptionKeyChecked(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer RemoveDB()
+	assert.Nil(t, db.SetEncryptionKey(testKey))
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "secret", Response: "safe", Model: "m"}))
+	db.Close()
+
+	db, err = InitializeDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer db.Close()
+	encrypted, err := db.Encrypted()
+	assert.Nil(t, err)
+	assert.True(t, encrypted)
+	assert.False(t, db.HasFullTextSearch())
+
+	// Without the key nothing can be read
+	_, err = db.Messages(1)
+	assert.ErrorIs(t, err, ErrEncrypted)
+
+	wrong := bytes.Repeat([]byte{8}, 32)
+	assert.ErrorContains(t, db.SetEncryptionKey(wrong), "doesn't match")
+	assert.Nil(t, db.SetEncryptionKey(testKey))
+	msgs, err := db.Messages(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", msgs[0].Content)
+}
+
+func TestEncryptAndDecryptAll(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	insertSearchFixtures(t, db)
+	assert.Nil(t, db.ReplaceLastTurn(Turn{ConvID: 2, Prompt: "again", Response: "still sleeps", Model: "m"}))
+	fts := db.HasFullTextSearch()
+
+	_, err = db.EncryptAll()
+	assert.ErrorContains(t, err, "no encryption key")
+
+	assert.Nil(t, db.SetEncryptionKey(testKey))
+	n, err := db.EncryptAll()
+	assert.Nil(t, err)
+	// 8 messages, and 2 alternatives with a prompt and a response each
+	assert.Equal(t, 12, n)
+	n, err = db.EncryptAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "already encrypted")
+
+	var plain int
+	assert.Nil(t, db.db.QueryRow(`SELECT COUNT(*) FROM `+db.msgTable+` WHERE content NOT LIKE 'enc1:%'`).Scan(&plain))
+	assert.Equal(t, 0, plain)
+	results, err := db.Search("fox")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, resultIDs(results))
+
+	n, err = db.DecryptAll()
+	assert.Nil(t, err)
+	assert.Equal(t, 12, n)
+	encrypted, err := db.Encrypted()
+	assert.Nil(t, err)
+	assert.False(t, encrypted)
+	assert.Equal(t, fts, db.HasFullTextSearch(), "the search index is back")
+
+	msgs, err := db.Messages(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "still sleeps", msgs[1].Content)
+	results, err = db.Search("fox")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, resultIDs(results))
+}
+
+func TestEncryptedTitlesAndSystemPrompts(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m", SystemPrompt: "be a pirate"}))
+	assert.Nil(t, db.SetTitle(1, "Plain Title"))
+	assert.Nil(t, db.SetEncryptionKey(testKey))
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 2, Prompt: "p", Response: "r", Model: "m", SystemPrompt: "be a parrot"}))
+	assert.Nil(t, db.SetTitle(2, "Secret Title"))
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 3, Prompt: "p", Response: "r", Model: "m"}))
+
+	stored := func(convID int) (title, convSystem, msgSystem string) {
+		assert.Nil(t, db.db.QueryRow(`SELECT title, system_prompt FROM `+db.dbTable+` WHERE id = ?`, convID).Scan(&title, &convSystem))
+		assert.Nil(t, db.db.QueryRow(`SELECT system_prompt FROM `+db.msgTable+` WHERE conversation_id = ? AND seq = 1`, convID).Scan(&msgSystem))
+		return
+	}
+	title, convSystem, msgSystem := stored(2)
+	for _, value := range []string{title, convSystem, msgSystem} {
+		assert.Contains(t, value, encryptedPrefix)
+	}
+
+	// Read back in plaintext, whichever way they were stored
+	for id, want := range map[int][2]string{1: {"Plain Title", "be a pirate"}, 2: {"Secret Title", "be a parrot"}} {
+		conv, err := db.GetConversation(id)
+		assert.Nil(t, err)
+		assert.Equal(t, want[0], conv.Title)
+		assert.Equal(t, want[1], conv.SystemPrompt)
+		title, err := db.GetTitle(id)
+		assert.Nil(t, err)
+		assert.Equal(t, want[0], title)
+		msgs, err := db.Messages(id)
+		assert.Nil(t, err)
+		assert.Equal(t, want[1], msgs[1].SystemPrompt)
+	}
+	summaries, err := db.ListSummaries(SearchFilter{}, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, summaries, 3)
+	for _, s := range summaries {
+		assert.NotContains(t, s.Title, encryptedPrefix)
+	}
+
+	// The untitled conversation is still untitled
+	ids, err := db.UntitledConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3}, ids)
+
+	_, err = db.EncryptAll()
+	assert.Nil(t, err)
+	title, convSystem, msgSystem = stored(1)
+	for _, value := range []string{title, convSystem, msgSystem} {
+		assert.Contains(t, value, encryptedPrefix)
+	}
+	title, _, _ = stored(3)
+	assert.Equal(t, "", title)
+
+	_, err = db.DecryptAll()
+	assert.Nil(t, err)
+	title, convSystem, msgSystem = stored(2)
+	assert.Equal(t, "Secret Title", title)
+	assert.Equal(t, "be a parrot", convSystem)
+	assert.Equal(t, "be a parrot", msgSystem)
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Fork is a conversation forked from another one after the given turn
+type Fork struct {
+	ConvID int
+	Turn   int
+}
+
+// ForkConversation copies the conversation's history up to and including the
+// given turn, counting from 1, into a new conversation that remembers where it
+// came from; turn 0 copies all of it. The fork keeps the messages'
+// timestamps, models and token counts, and the conversation's role, system
+// prompt and tags. It returns the new conversation's ID.
+func (sqlDB *ChatDB) ForkConversation(convID, turn int) (int, error) {
+	if turn < 0 {
+		return 0, fmt.Errorf("invalid turn %d", turn)
+	}
+
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	var title string
+	err = tx.QueryRow(`SELECT title FROM `+sqlDB.dbTable+` WHERE id = ?;`, convID).Scan(&title)
+	if err == sql.ErrNoRows {
+		return 0, notFound(convID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+
+	// A turn starts with a prompt; the fork ends before the prompt that
+	// starts the turn after the one asked for
+	rows, err := tx.Query(`
+		SELECT seq FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND role = 'user' ORDER BY seq;
+	`, convID)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	var starts []int
+	for rows.Next() {
+		var seq int
+		if err := rows.Scan(&seq); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%v", err)
+		}
+		starts = append(starts, seq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+
+	if len(starts) == 0 {
+		return 0, fmt.Errorf("conversation %d has no turns to fork", convID)
+	}
+	if turn == 0 {
+		turn = len(starts)
+	}
+	if turn > len(starts) {
+		return 0, fmt.Errorf("conversation %d has only %d turns", convID, len(starts))
+	}
+	end := -1 // copy every message
+	if turn < len(starts) {
+		end = starts[turn]
+	}
+	below := `(? < 0 OR seq < ?)`
+
+	var model string
+	err = tx.QueryRow(`
+		SELECT model FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND `+below+` ORDER BY seq DESC LIMIT 1;
+	`, convID, end, end).Scan(&model)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+
+	if title, err = sqlDB.decrypt(title); err != nil {
+		return 0, err
+	}
+	if title != "" {
+		title += " (fork)"
+	}
+	if title, err = sqlDB.encryptField(title); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO `+sqlDB.dbTable+` (uuid, title, model, role, system_prompt, parent_id, parent_turn)
+		SELECT ?, ?, ?, role, system_prompt, id, ? FROM `+sqlDB.dbTable+` WHERE id = ?;
+	`, uuid.NewString(), title, model, turn, convID)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	forkID := int(lastID)
+
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.msgTable+` (conversation_id, seq, role, content, tokens, model, temperature, timestamp,
+			provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms)
+		SELECT ?, seq, role, content, tokens, model, temperature, timestamp,
+			provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms
+		FROM `+sqlDB.msgTable+` WHERE conversation_id = ? AND `+below+` ORDER BY seq;
+	`, forkID, convID, end, end)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.tagTable+` (conversation_id, tag)
+		SELECT ?, tag FROM `+sqlDB.tagTable+` WHERE conversation_id = ?;
+	`, forkID, convID)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return forkID, nil
+}
+
+// Forks returns the conversations forked from this one, by turn
+func (sqlDB *ChatDB) Forks(convID int) ([]Fork, error) {
+	rows, err := sqlDB.db.Query(`
+		SELECT id, parent_turn FROM `+sqlDB.dbTable+` WHERE parent_id = ? ORDER BY parent_turn, id;
+	`, convID)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var forks []Fork
+	for rows.Next() {
+		var f Fork
+		if err := rows.Scan(&f.ConvID, &f.Turn); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		forks = append(forks, f)
+	}
+	return forks, rows.Err()
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForkConversation(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	turns := []Turn{
+		{ConvID: 1, Prompt: "first", Response: "one", Model: "a", Temperature: 0.5, InputTokens: 3, OutputTokens: 4, Role: "coder", SystemPrompt: "be brief"},
+		{ConvID: 1, Prompt: "second", Response: "two", Model: "b"},
+		{ConvID: 1, Prompt: "third", Response: "three", Model: "c"},
+	}
+	for _, turn := range turns {
+		assert.Nil(t, db.InsertTurn(turn))
+	}
+	assert.Nil(t, db.SetTitle(1, "Counting"))
+	assert.Nil(t, db.AddTags(1, "numbers"))
+
+	forkID, err := db.ForkConversation(1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, forkID)
+
+	fork, err := db.GetConversation(forkID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Counting (fork)", fork.Title)
+	assert.Equal(t, "b", fork.Model)
+	assert.Equal(t, "coder", fork.Role)
+	assert.Equal(t, "be brief", fork.SystemPrompt)
+	assert.Equal(t, []string{"numbers"}, fork.Tags)
+	assert.Equal(t, 1, fork.ParentID)
+	assert.Equal(t, 2, fork.ParentTurn)
+
+	orig, err := db.Messages(1)
+	assert.Nil(t, err)
+	msgs, err := db.Messages(forkID)
+	assert.Nil(t, err)
+	assert.Equal(t, orig[:4], msgs)
+
+	// The fork carries on by itself
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: forkID, Prompt: "other", Response: "way", Model: "d"}))
+	msgs, err = db.Messages(1)
+	assert.Nil(t, err)
+	assert.Equal(t, orig, msgs)
+
+	// Turn 0 forks all of it
+	allID, err := db.ForkConversation(1, 0)
+	assert.Nil(t, err)
+	msgs, err = db.Messages(allID)
+	assert.Nil(t, err)
+	assert.Equal(t, orig, msgs)
+	all, err := db.GetConversation(allID)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, all.ParentTurn)
+
+	forks, err := db.Forks(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []Fork{{ConvID: forkID, Turn: 2}, {ConvID: allID, Turn: 3}}, forks)
+
+	// Forks outlive their parent
+	assert.Nil(t, db.DeleteConversation(1))
+	fork, err = db.GetConversation(forkID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fork.ParentID)
+
+	_, err = db.ForkConversation(1, 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = db.ForkConversation(forkID, 4)
+	assert.ErrorContains(t, err, "has only 3 turns")
+	_, err = db.ForkConversation(forkID, -1)
+	assert.ErrorContains(t, err, "invalid turn")
+	empty, err := db.NewConversationID()
+	assert.Nil(t, err)
+	_, err = db.ForkConversation(empty, 0)
+	assert.ErrorContains(t, err, "no turns to fork")
+}
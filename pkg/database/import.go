@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImportedMessage is a message of a conversation from another application
+type ImportedMessage struct {
+	Role      string // "user" or "assistant"
+	Content   string
+	Model     string
+	Timestamp time.Time
+	Tokens    int32
+}
+
+// ImportedConversation is a conversation from another application. Source
+// names the application and SourceID identifies the conversation there; the
+// pair is what makes importing the same export again a no-op.
+type ImportedConversation struct {
+	Source   string
+	SourceID string
+	Title    string
+	Created  time.Time
+	Messages []ImportedMessage
+}
+
+// ImportConversation stores the conversation under a new ID, keeping its
+// timestamps and models. It returns the conversation's ID and whether it was
+// stored; a conversation already imported from the same source is left as
+// is and its existing ID returned.
+func (sqlDB *ChatDB) ImportConversation(conv ImportedConversation) (int, bool, error) {
+	if conv.Source == "" || conv.SourceID == "" {
+		return 0, false, fmt.Errorf("imported conversation needs a source and source ID")
+	}
+
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return 0, false, fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		SELECT id FROM `+sqlDB.dbTable+` WHERE source = ? AND source_id = ?;
+	`, conv.Source, conv.SourceID).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, fmt.Errorf("%v", err)
+	}
+
+	// The conversation's model is its latest one, as InsertTurn keeps it
+	var model string
+	for _, m := range conv.Messages {
+		if m.Model != "" {
+			model = m.Model
+		}
+	}
+	created := conv.Created
+	if created.IsZero() && len(conv.Messages) > 0 {
+		created = conv.Messages[0].Timestamp
+	}
+
+	title, err := sqlDB.encryptField(conv.Title)
+	if err != nil {
+		return 0, false, err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO `+sqlDB.dbTable+` (uuid, title, created, model, source, source_id)
+		VALUES (?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?);
+	`, uuid.NewString(), title, formatTimestamp(created), model, conv.Source, conv.SourceID)
+	if err != nil {
+		return 0, false, fmt.Errorf("%v", err)
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, false, fmt.Errorf("%v", err)
+	}
+	id = int(lastID)
+
+	for seq, m := range conv.Messages {
+		ts := m.Timestamp
+		if ts.IsZero() {
+			ts = created
+		}
+		content, err := sqlDB.encrypt(m.Content)
+		if err != nil {
+			return 0, false, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO `+sqlDB.msgTable+` (conversation_id, seq, role, content, tokens, model, timestamp)
+			VALUES (?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP));
+		`, id, seq, m.Role, content, nullTokens(m.Tokens), m.Model, formatTimestamp(ts))
+		if err != nil {
+			return 0, false, fmt.Errorf("%v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("%v", err)
+	}
+	return id, true, nil
+}
+
+// formatTimestamp writes t the way CURRENT_TIMESTAMP does, or NULL for the
+// zero time
+func formatTimestamp(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(timestampLayout)
+}
+
+// nullTokens stores an unknown token count as NULL, as old rows have it
+func nullTokens(n int32) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// nullInt stores an unknown count as NULL
+func nullInt(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
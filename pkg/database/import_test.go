@@ -0,0 +1,60 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportConversation(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+
+	ts := time.Date(2023, 11, 5, 14, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	conv := ImportedConversation{
+		Source:   "chatgpt",
+		SourceID: "abc",
+		Title:    "Imported",
+		Created:  ts,
+		Messages: []ImportedMessage{
+			{Role: "user", Content: "old question", Timestamp: ts},
+			{Role: "assistant", Content: "old answer", Model: "gpt-4", Timestamp: ts.Add(time.Minute), Tokens: 42},
+		},
+	}
+
+	id, added, err := db.ImportConversation(conv)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	assert.Equal(t, 2, id)
+
+	// The same conversation again is skipped
+	again, added, err := db.ImportConversation(conv)
+	assert.Nil(t, err)
+	assert.False(t, added)
+	assert.Equal(t, id, again)
+
+	got, err := db.GetConversation(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Imported", got.Title)
+	assert.Equal(t, "gpt-4", got.Model)
+	assert.Contains(t, got.Created, "2023-11-05T19:30:00")
+
+	msgs, err := db.Messages(id)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 2)
+	assert.Contains(t, msgs[1].Timestamp, "2023-11-05T19:31:00")
+	assert.Equal(t, int32(42), msgs[1].Tokens)
+	assert.Equal(t, int32(0), msgs[0].Tokens)
+
+	// Same ID from another source is a different conversation
+	conv.Source = "claude"
+	_, added, err = db.ImportConversation(conv)
+	assert.Nil(t, err)
+	assert.True(t, added)
+
+	_, _, err = db.ImportConversation(ImportedConversation{Source: "claude"})
+	assert.ErrorContains(t, err, "source ID")
+}
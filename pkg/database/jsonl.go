@@ -0,0 +1,270 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Operations a JSONL record can be
+const (
+	jsonlTurn        = "turn"
+	jsonlReplace     = "replace"
+	jsonlTitle       = "title"
+	jsonlTitleFailed = "title_failed"
+)
+
+// jsonlRecord is one line of a JSONL history: a turn recorded, a last turn
+// replaced, a title set, or titling failed. Replaying them in order rebuilds
+// the history.
+type jsonlRecord struct {
+	Op     string          `json:"op"`
+	ConvID int             `json:"conversation_id"`
+	UUID   string          `json:"uuid,omitempty"`
+	Time   time.Time       `json:"time"`
+	Title  string          `json:"title,omitempty"`
+	Turn   *jsonlTurnEntry `json:"turn,omitempty"`
+}
+
+type jsonlTurnEntry struct {
+	Prompt       string  `json:"prompt"`
+	Response     string  `json:"response"`
+	Model        string  `json:"model,omitempty"`
+	Temperature  float32 `json:"temperature,omitempty"`
+	InputTokens  int32   `json:"input_tokens,omitempty"`
+	OutputTokens int32   `json:"output_tokens,omitempty"`
+	Role         string  `json:"role,omitempty"`
+	SystemPrompt string  `json:"system_prompt,omitempty"`
+	Provider     string  `json:"provider,omitempty"`
+	Thinking     string  `json:"thinking,omitempty"`
+	MaxTokens    int     `json:"max_tokens,omitempty"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	LatencyMS    int64   `json:"latency_ms,omitempty"`
+}
+
+func newJSONLTurn(turn Turn) *jsonlTurnEntry {
+	return &jsonlTurnEntry{
+		Prompt:       turn.Prompt,
+		Response:     turn.Response,
+		Model:        turn.Model,
+		Temperature:  turn.Temperature,
+		InputTokens:  turn.InputTokens,
+		OutputTokens: turn.OutputTokens,
+		Role:         turn.Role,
+		SystemPrompt: turn.SystemPrompt,
+		Provider:     turn.Provider,
+		Thinking:     turn.Thinking,
+		MaxTokens:    turn.MaxTokens,
+		FinishReason: turn.FinishReason,
+		Error:        turn.Error,
+		LatencyMS:    turn.Latency.Milliseconds(),
+	}
+}
+
+func (e *jsonlTurnEntry) turn(convID int) Turn {
+	return Turn{
+		ConvID:       convID,
+		Prompt:       e.Prompt,
+		Response:     e.Response,
+		Model:        e.Model,
+		Temperature:  e.Temperature,
+		InputTokens:  e.InputTokens,
+		OutputTokens: e.OutputTokens,
+		Role:         e.Role,
+		SystemPrompt: e.SystemPrompt,
+		Provider:     e.Provider,
+		Thinking:     e.Thinking,
+		MaxTokens:    e.MaxTokens,
+		FinishReason: e.FinishReason,
+		Error:        e.Error,
+		Latency:      time.Duration(e.LatencyMS) * time.Millisecond,
+	}
+}
+
+// JSONLStore keeps the history in a JSON Lines file that is only ever
+// appended to, so it diffs and merges well kept in git or with dotfiles.
+// The file is read into memory when opened and answers queries from there;
+// changes made by another process after that aren't seen. Records name their
+// conversation by UUID as well as ID, since another process, or another
+// machine's copy of the file, can give a different conversation the same ID.
+type JSONLStore struct {
+	*MemoryStore
+	mu   sync.Mutex // orders appends with the changes they record
+	file *os.File
+}
+
+// OpenJSONL opens the history at path, creating the file if need be, and
+// replays it
+func OpenJSONL(path string) (*JSONLStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := &JSONLStore{MemoryStore: NewMemoryStore(), file: f}
+	if err := s.replay(f, path); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay applies every record in r to the store
+func (s *JSONLStore) replay(r io.Reader, path string) error {
+	ids := make(map[string]int)
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var rec jsonlRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			s.replayID(ids, &rec)
+			if err := s.apply(rec); err != nil {
+				return fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// legacyNamespace derives UUIDs for records written before they had one
+var legacyNamespace = uuid.NewSHA1(uuid.NameSpaceOID, []byte("ask-ai jsonl"))
+
+// replayID points the record at the conversation its UUID was first seen
+// with, in ids. A UUID seen for the first time keeps the ID it was recorded
+// with unless an earlier conversation has that ID, when it gets the next
+// one. Records from before UUIDs are given one made from their ID.
+func (s *JSONLStore) replayID(ids map[string]int, rec *jsonlRecord) {
+	if rec.UUID == "" {
+		rec.UUID = uuid.NewSHA1(legacyNamespace, []byte(strconv.Itoa(rec.ConvID))).String()
+	}
+	if id, ok := ids[rec.UUID]; ok {
+		rec.ConvID = id
+		return
+	}
+
+	m := s.MemoryStore
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, taken := m.convs[rec.ConvID]; taken || rec.ConvID <= 0 {
+		rec.ConvID = m.lastID + 1
+	}
+	ids[rec.UUID] = rec.ConvID
+}
+
+// apply makes the record's change to the conversations in memory
+func (s *JSONLStore) apply(rec jsonlRecord) error {
+	m := s.MemoryStore
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The UUID recorded is kept; the conversation is given one otherwise
+	if c := m.conversation(rec.ConvID, rec.UUID != "", rec.Time); c != nil && rec.UUID != "" {
+		c.conv.UUID = rec.UUID
+	}
+
+	switch rec.Op {
+	case jsonlTurn, jsonlReplace:
+		if rec.Turn == nil {
+			return fmt.Errorf("%s record without a turn", rec.Op)
+		}
+		if rec.Op == jsonlReplace {
+			return m.replaceLastTurn(rec.Turn.turn(rec.ConvID), rec.Time)
+		}
+		m.insertTurn(rec.Turn.turn(rec.ConvID), rec.Time)
+	case jsonlTitle:
+		// A conversation can be named before its first turn
+		m.conversation(rec.ConvID, true, rec.Time).conv.Title = rec.Title
+	case jsonlTitleFailed:
+		m.conversation(rec.ConvID, true, rec.Time).titleFailed = true
+	default:
+		return fmt.Errorf("unknown record %q", rec.Op)
+	}
+	return nil
+}
+
+// record applies the record and appends it to the file. The caller holds mu.
+func (s *JSONLStore) record(rec jsonlRecord) error {
+	rec.Time = time.Now().UTC().Truncate(time.Second)
+	if err := s.apply(rec); err != nil {
+		return err
+	}
+	conv, _ := s.GetConversation(rec.ConvID)
+	rec.UUID = conv.UUID
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	// One write per line, so processes appending at once don't interleave
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+func (s *JSONLStore) InsertTurn(turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record(jsonlRecord{Op: jsonlTurn, ConvID: turn.ConvID, Turn: newJSONLTurn(turn)})
+}
+
+// ReplaceLastTurn replaces the conversation's last turn. The file keeps the
+// version it replaces, but the store doesn't offer it as an alternative.
+func (s *JSONLStore) ReplaceLastTurn(turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record(jsonlRecord{Op: jsonlReplace, ConvID: turn.ConvID, Turn: newJSONLTurn(turn)})
+}
+
+func (s *JSONLStore) SetTitle(convID int, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.GetConversation(convID); err != nil {
+		return err
+	}
+	return s.record(jsonlRecord{Op: jsonlTitle, ConvID: convID, Title: title})
+}
+
+// SetTitleIfEmpty sets the title unless the conversation already has one,
+// and reports whether it did
+func (s *JSONLStore) SetTitleIfEmpty(convID int, title string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, err := s.GetConversation(convID); err != nil || current.Title != "" {
+		return false, nil
+	}
+	return true, s.record(jsonlRecord{Op: jsonlTitle, ConvID: convID, Title: title})
+}
+
+func (s *JSONLStore) MarkTitleFailed(convID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.GetConversation(convID); err != nil {
+		return err
+	}
+	return s.record(jsonlRecord{Op: jsonlTitleFailed, ConvID: convID})
+}
+
+// Close closes the file
+func (s *JSONLStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Close(); err != nil {
+		log.Fatalf("error closing database: %v", err)
+	}
+}
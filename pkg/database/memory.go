@@ -0,0 +1,470 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duluk/ask-ai/pkg/LLM"
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps conversations in memory only, for --no-record sessions
+// and tests; everything is gone once the process exits. It has no tags,
+// stars or forks, and searches by substring like SQLite without FTS5.
+type MemoryStore struct {
+	mu     sync.Mutex
+	convs  map[int]*memoryConversation
+	lastID int // highest ID handed out or recorded
+}
+
+type memoryConversation struct {
+	conv        Conversation
+	msgs        []Message
+	titleFailed bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{convs: make(map[int]*memoryConversation)}
+}
+
+// memoryTimestamp writes t as the sqlite3 driver returns timestamps
+func memoryTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// conversation returns the conversation, creating it if create is set.
+// The caller holds mu.
+func (s *MemoryStore) conversation(convID int, create bool, at time.Time) *memoryConversation {
+	c, ok := s.convs[convID]
+	if !ok && create {
+		c = &memoryConversation{conv: Conversation{ID: convID, UUID: uuid.NewString(), Created: memoryTimestamp(at)}}
+		s.convs[convID] = c
+		s.lastID = max(s.lastID, convID)
+	}
+	return c
+}
+
+// CopyConversation copies a conversation from another store, so a session
+// kept in memory can carry on from it under the same ID
+func (s *MemoryStore) CopyConversation(from Store, convID int) error {
+	conv, err := from.GetConversation(convID)
+	if err != nil {
+		return err
+	}
+	msgs, err := from.Messages(convID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.convs[convID] = &memoryConversation{conv: conv, msgs: msgs}
+	s.lastID = max(s.lastID, convID)
+	return nil
+}
+
+func (s *MemoryStore) NewConversationID() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	s.conversation(s.lastID, true, time.Now())
+	return s.lastID, nil
+}
+
+func (s *MemoryStore) InsertTurn(turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertTurn(turn, time.Now())
+	return nil
+}
+
+func (s *MemoryStore) insertTurn(turn Turn, at time.Time) {
+	c := s.conversation(turn.ConvID, true, at)
+	c.conv.Model = turn.Model
+	if c.conv.Role == "" {
+		c.conv.Role = turn.Role
+	}
+	if c.conv.SystemPrompt == "" {
+		c.conv.SystemPrompt = turn.SystemPrompt
+	}
+
+	seq := 0
+	if n := len(c.msgs); n > 0 {
+		seq = c.msgs[n-1].Seq + 1
+	}
+	ts := memoryTimestamp(at)
+	c.msgs = append(c.msgs,
+		Message{
+			Seq: seq, Role: "user", Content: turn.Prompt, Model: turn.Model, Temperature: turn.Temperature,
+			Timestamp: ts, Tokens: turn.InputTokens,
+		},
+		Message{
+			Seq: seq + 1, Role: "assistant", Content: turn.Response, Model: turn.Model, Temperature: turn.Temperature,
+			Timestamp: ts, Tokens: turn.OutputTokens, Provider: turn.Provider, SystemPrompt: turn.SystemPrompt,
+			RoleName: turn.Role, Thinking: turn.Thinking, MaxTokens: turn.MaxTokens,
+			FinishReason: turn.FinishReason, Error: turn.Error, Latency: turn.Latency.Truncate(time.Millisecond),
+		})
+}
+
+// ReplaceLastTurn replaces the conversation's last turn. Unlike SQLite, the
+// version it replaces isn't kept.
+func (s *MemoryStore) ReplaceLastTurn(turn Turn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceLastTurn(turn, time.Now())
+}
+
+func (s *MemoryStore) replaceLastTurn(turn Turn, at time.Time) error {
+	c := s.conversation(turn.ConvID, false, at)
+	last := -1
+	if c != nil {
+		for i := len(c.msgs) - 1; i >= 0 && last < 0; i-- {
+			if c.msgs[i].Role == "user" {
+				last = i
+			}
+		}
+	}
+	if last < 0 {
+		return fmt.Errorf("conversation %d has no turns", turn.ConvID)
+	}
+	c.msgs = c.msgs[:last]
+	s.insertTurn(turn, at)
+	return nil
+}
+
+func (s *MemoryStore) GetConversation(convID int) (Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.convs[convID]
+	if !ok {
+		return Conversation{ID: convID}, notFound(convID)
+	}
+	return c.conv, nil
+}
+
+// Messages returns the conversation's messages in order
+func (s *MemoryStore) Messages(convID int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.convs[convID]; ok {
+		return slices.Clone(c.msgs), nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) LoadConversationFromDB(convID int) ([]LLM.LLMConversations, error) {
+	msgs, err := s.Messages(convID)
+	if err != nil {
+		return nil, err
+	}
+	return conversationContext(convID, msgs), nil
+}
+
+// LoadRecentTurns returns the last n turns recorded in any conversation,
+// oldest first, as context for a new conversation
+func (s *MemoryStore) LoadRecentTurns(n int) ([]LLM.LLMConversations, error) {
+	type recent struct {
+		convID int
+		msgs   []Message
+	}
+	s.mu.Lock()
+	var turns []recent
+	for id, c := range s.convs {
+		for i, m := range c.msgs {
+			if m.Role != "user" {
+				continue
+			}
+			turn := recent{convID: id, msgs: c.msgs[i : i+1 : i+1]}
+			if i+1 < len(c.msgs) && c.msgs[i+1].Role == "assistant" {
+				turn.msgs = c.msgs[i : i+2 : i+2]
+			}
+			turns = append(turns, turn)
+		}
+	}
+	s.mu.Unlock()
+
+	// Oldest first; turns recorded the same second go by conversation
+	slices.SortStableFunc(turns, func(a, b recent) int {
+		if c := strings.Compare(a.msgs[0].Timestamp, b.msgs[0].Timestamp); c != 0 {
+			return c
+		}
+		if a.convID != b.convID {
+			return a.convID - b.convID
+		}
+		return a.msgs[0].Seq - b.msgs[0].Seq
+	})
+	var context []LLM.LLMConversations
+	for _, turn := range turns[max(len(turns)-n, 0):] {
+		context = append(context, conversationContext(turn.convID, turn.msgs)...)
+	}
+	return context, nil
+}
+
+// GetModel returns the model of the latest message in the conversation, or ""
+func (s *MemoryStore) GetModel(convID int) (string, error) {
+	msgs, _ := s.Messages(convID)
+	if len(msgs) == 0 {
+		return "", nil
+	}
+	return msgs[len(msgs)-1].Model, nil
+}
+
+func (s *MemoryStore) ShowConversation(convID int) {
+	err := s.WriteConversation(os.Stdout, convID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Fatalf("error showing conversation: %v", err)
+	}
+}
+
+// WriteConversation writes the conversation as ShowConversation prints it
+func (s *MemoryStore) WriteConversation(w io.Writer, convID int) error {
+	conv, err := s.GetConversation(convID)
+	if err != nil {
+		return err
+	}
+	msgs, _ := s.Messages(convID)
+	return writeConversation(w, convID, conv, msgs, nil)
+}
+
+// ids returns the IDs of the conversations with messages that keep says
+// to, sorted. The caller holds mu.
+func (s *MemoryStore) ids(keep func(*memoryConversation) bool) []int {
+	var ids []int
+	for id, c := range s.convs {
+		if len(c.msgs) > 0 && keep(c) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func (s *MemoryStore) ListConversationIDs() ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids(func(*memoryConversation) bool { return true }), nil
+}
+
+// GetLastConversationID returns the highest ID of a conversation with at
+// least one message, or 0 if none exist
+func (s *MemoryStore) GetLastConversationID() (int, error) {
+	ids, _ := s.ListConversationIDs()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[len(ids)-1], nil
+}
+
+func (s *MemoryStore) Search(query string) ([]SearchResult, error) {
+	return s.FindConversations(SearchFilter{Query: query})
+}
+
+// FindConversations returns one result per conversation matching the
+// filter, by ID, as SQLite does without FTS5. None have tags or stars, so
+// filtering on those matches nothing.
+func (s *MemoryStore) FindConversations(filter SearchFilter) ([]SearchResult, error) {
+	var re *regexp.Regexp
+	if filter.Regex != "" {
+		var err error
+		re, err = regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+	if len(filter.Tags) > 0 || filter.Starred {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []SearchResult
+	for _, id := range s.ids(func(*memoryConversation) bool { return true }) {
+		c := s.convs[id]
+		for i, m := range c.msgs {
+			start, end, ok := matchMessage(c.msgs, i, filter, re)
+			if !ok {
+				continue
+			}
+			results = append(results, SearchResult{
+				ConvID:  id,
+				Title:   c.conv.Title,
+				Seq:     m.Seq,
+				Role:    m.Role,
+				Snippet: markedSnippet(m.Content, start, end, snippetTokens),
+			})
+			break
+		}
+	}
+	return results, nil
+}
+
+// ListSummaries returns a page of the conversations that match the filter,
+// the most recently active first. As with FindConversations, filtering on
+// tags or stars matches nothing, and the filter's Query isn't supported.
+func (s *MemoryStore) ListSummaries(filter SearchFilter, offset, limit int) ([]ConversationSummary, error) {
+	if filter.Query != "" {
+		return nil, fmt.Errorf("conversation summaries can't be searched")
+	}
+	var re *regexp.Regexp
+	if filter.Regex != "" {
+		var err error
+		re, err = regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+	if len(filter.Tags) > 0 || filter.Starred {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	var summaries []ConversationSummary
+	for _, id := range s.ids(func(*memoryConversation) bool { return true }) {
+		c := s.convs[id]
+		matched := false
+		for i := range c.msgs {
+			if _, _, matched = matchMessage(c.msgs, i, filter, re); matched {
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		summary := ConversationSummary{ID: id, Title: c.conv.Title, Model: c.conv.Model}
+		for _, m := range c.msgs {
+			if m.Role != "user" {
+				continue
+			}
+			if summary.Turns == 0 && summary.Title == "" {
+				summary.FirstPrompt = m.Content
+			}
+			summary.Turns++
+		}
+		summary.LastActivity, _ = time.Parse(time.RFC3339, c.msgs[len(c.msgs)-1].Timestamp)
+		summaries = append(summaries, summary)
+	}
+	s.mu.Unlock()
+
+	slices.SortStableFunc(summaries, func(a, b ConversationSummary) int {
+		if c := b.LastActivity.Compare(a.LastActivity); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	})
+	if offset >= len(summaries) {
+		return nil, nil
+	}
+	return summaries[offset:min(offset+limit, len(summaries))], nil
+}
+
+// matchMessage reports whether message i of msgs satisfies the filter's
+// message fields, and the span of the match to mark, if there's one
+func matchMessage(msgs []Message, i int, filter SearchFilter, re *regexp.Regexp) (int, int, bool) {
+	m := msgs[i]
+	start, end := -1, -1
+	if filter.Query != "" {
+		if start, end = matchSpan(m.Content, filter.Query); start < 0 {
+			return 0, 0, false
+		}
+	}
+	if len(filter.Models) > 0 && !slices.Contains(filter.Models, m.Model) {
+		return 0, 0, false
+	}
+	if filter.Role != "" && m.Role != filter.Role {
+		return 0, 0, false
+	}
+	if filter.Provider != "" {
+		// Only responses record their provider
+		provider := m.Provider
+		if m.Role == "user" && i+1 < len(msgs) {
+			provider = msgs[i+1].Provider
+		}
+		if provider != filter.Provider {
+			return 0, 0, false
+		}
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		ts, err := time.Parse(time.RFC3339, m.Timestamp)
+		if err != nil || ts.Before(filter.Since) || (!filter.Until.IsZero() && !ts.Before(filter.Until)) {
+			return 0, 0, false
+		}
+	}
+	if re != nil {
+		loc := re.FindStringIndex(m.Content)
+		if loc == nil {
+			return 0, 0, false
+		}
+		if start < 0 {
+			start, end = loc[0], loc[1]
+		}
+	}
+	return start, end, true
+}
+
+// GetTitle returns the conversation's title, or "" if it has none
+func (s *MemoryStore) GetTitle(convID int) (string, error) {
+	conv, _ := s.GetConversation(convID)
+	return conv.Title, nil
+}
+
+func (s *MemoryStore) SetTitle(convID int, title string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.convs[convID]
+	if !ok {
+		return notFound(convID)
+	}
+	c.conv.Title = title
+	return nil
+}
+
+// SetTitleIfEmpty sets the title unless the conversation already has one,
+// and reports whether it did
+func (s *MemoryStore) SetTitleIfEmpty(convID int, title string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.convs[convID]
+	if !ok || c.conv.Title != "" {
+		return false, nil
+	}
+	c.conv.Title = title
+	return true, nil
+}
+
+func (s *MemoryStore) UntitledConversationIDs() ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids(func(c *memoryConversation) bool { return c.conv.Title == "" }), nil
+}
+
+func (s *MemoryStore) MarkTitleFailed(convID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.conversation(convID, false, time.Time{})
+	if c == nil {
+		return notFound(convID)
+	}
+	c.titleFailed = true
+	return nil
+}
+
+func (s *MemoryStore) TitleFailedIDs() ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids(func(c *memoryConversation) bool { return c.titleFailed }), nil
+}
+
+// Close drops the conversations
+func (s *MemoryStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.convs = make(map[int]*memoryConversation)
+}
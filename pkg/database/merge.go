@@ -0,0 +1,316 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MergeResult is what merging another database brought in
+type MergeResult struct {
+	Added    int         // conversations that weren't here
+	Remapped map[int]int // of those, the ones whose ID was taken: their ID there to the one here
+	Updated  int         // conversations continued there, brought up to date here
+	Messages int         // messages added, to new conversations and updated ones
+	Skipped  int         // conversations already here in full
+	Diverged []int       // IDs there of conversations continued differently on both sides, left alone
+}
+
+// messageHash identifies a message by its content and when it was sent, for
+// conversations that have no UUID in common
+func messageHash(m Message) [sha256.Size]byte {
+	ts := parseTimestamp(m.Timestamp).UTC().Format(timestampLayout)
+	return sha256.Sum256([]byte(m.Role + "\x00" + m.Content + "\x00" + ts))
+}
+
+// parseTimestamp reads a timestamp as the sqlite3 driver returns it, or as
+// it's stored; anything else is the zero time
+func parseTimestamp(s string) time.Time {
+	for _, layout := range []string{time.RFC3339, timestampLayout} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// MergeFile merges the database at path into this one, see Merge. The other
+// database is left untouched: it's copied, and the copy brought up to date.
+// The key decrypts it if it's encrypted.
+func (sqlDB *ChatDB) MergeFile(path string, key []byte) (MergeResult, error) {
+	if _, err := os.Stat(path); err != nil {
+		return MergeResult{}, err
+	}
+	src, err := OpenDB(path, sqlDB.dbTable)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	dir, err := os.MkdirTemp("", "ask-ai-merge")
+	if err != nil {
+		src.Close()
+		return MergeResult{}, err
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "merge.db")
+	_, err = src.db.Exec(`VACUUM INTO ?;`, snapshot)
+	src.Close()
+	if err != nil {
+		return MergeResult{}, fmt.Errorf("%v", err)
+	}
+
+	other, err := InitializeDB(snapshot, sqlDB.dbTable)
+	if err != nil {
+		return MergeResult{}, err
+	}
+	defer other.Close()
+	encrypted, err := other.Encrypted()
+	if err != nil {
+		return MergeResult{}, err
+	}
+	if encrypted {
+		if key == nil {
+			return MergeResult{}, fmt.Errorf("%s: %w", path, ErrEncrypted)
+		}
+		if err := other.SetEncryptionKey(key); err != nil {
+			return MergeResult{}, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return sqlDB.Merge(other)
+}
+
+// Merge copies the conversations of the other database that this one doesn't
+// have, under new IDs where theirs are taken, and adds the turns of
+// conversations that were continued there. A conversation is the same one
+// when it has the same UUID or, failing that, the same first message. One
+// that both databases continued differently is left alone, as are the
+// alternatives of retried turns. Each conversation is merged in its own
+// transaction, so merging the same database again only adds what's new.
+func (sqlDB *ChatDB) Merge(other *ChatDB) (MergeResult, error) {
+	res := MergeResult{Remapped: make(map[int]int)}
+
+	byUUID, byHash, err := sqlDB.mergeIndex()
+	if err != nil {
+		return res, err
+	}
+
+	ids, err := other.ListConversationIDs()
+	if err != nil {
+		return res, err
+	}
+	// Forks are merged after their parents, which have lower IDs
+	merged := make(map[int]int)
+	for _, id := range ids {
+		conv, err := other.GetConversation(id)
+		if err != nil {
+			return res, err
+		}
+		msgs, err := other.Messages(id)
+		if err != nil {
+			return res, err
+		}
+
+		localID, found := byUUID[conv.UUID]
+		if !found {
+			localID, found = byHash[messageHash(msgs[0])]
+		}
+		if !found {
+			localID, err = sqlDB.insertMerged(conv, msgs, merged)
+			if err != nil {
+				return res, err
+			}
+			res.Added++
+			res.Messages += len(msgs)
+			if localID != id {
+				res.Remapped[id] = localID
+			}
+			merged[id] = localID
+			continue
+		}
+		merged[id] = localID
+
+		local, err := sqlDB.Messages(localID)
+		if err != nil {
+			return res, err
+		}
+		n := min(len(local), len(msgs))
+		diverged := false
+		for i := 0; i < n && !diverged; i++ {
+			diverged = messageHash(local[i]) != messageHash(msgs[i])
+		}
+		switch {
+		case diverged:
+			res.Diverged = append(res.Diverged, id)
+		case len(msgs) > len(local):
+			if err := sqlDB.appendMerged(localID, conv, msgs[len(local):]); err != nil {
+				return res, err
+			}
+			res.Updated++
+			res.Messages += len(msgs) - len(local)
+		default:
+			res.Skipped++
+		}
+	}
+	return res, nil
+}
+
+// mergeIndex maps the UUID and first message of every conversation here to
+// its ID
+func (sqlDB *ChatDB) mergeIndex() (map[string]int, map[[sha256.Size]byte]int, error) {
+	byUUID := make(map[string]int)
+	byHash := make(map[[sha256.Size]byte]int)
+
+	rows, err := sqlDB.db.Query(`
+		SELECT c.id, COALESCE(c.uuid, ''), m.role, m.content, m.timestamp
+		FROM ` + sqlDB.dbTable + ` c JOIN ` + sqlDB.msgTable + ` m ON m.conversation_id = c.id
+		WHERE m.seq = (SELECT MIN(seq) FROM ` + sqlDB.msgTable + ` f WHERE f.conversation_id = c.id);
+	`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var convUUID string
+		var m Message
+		if err := rows.Scan(&id, &convUUID, &m.Role, &m.Content, &m.Timestamp); err != nil {
+			return nil, nil, fmt.Errorf("%v", err)
+		}
+		if m.Content, err = sqlDB.decrypt(m.Content); err != nil {
+			return nil, nil, err
+		}
+		if convUUID != "" {
+			byUUID[convUUID] = id
+		}
+		byHash[messageHash(m)] = id
+	}
+	return byUUID, byHash, rows.Err()
+}
+
+// insertMerged adds the conversation, under its own ID unless that's taken
+// here, and returns the ID it got. Its fork parent is kept if that was merged
+// too; merged maps the other database's IDs to these.
+func (sqlDB *ChatDB) insertMerged(conv Conversation, msgs []Message, merged map[int]int) (int, error) {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	if conv.UUID == "" {
+		conv.UUID = uuid.NewString()
+	}
+	title, err := sqlDB.encryptField(conv.Title)
+	if err != nil {
+		return 0, err
+	}
+	systemPrompt, err := sqlDB.encryptField(conv.SystemPrompt)
+	if err != nil {
+		return 0, err
+	}
+	var taken bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+sqlDB.dbTable+` WHERE id = ?);`, conv.ID).Scan(&taken)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	var newID any
+	if !taken {
+		newID = conv.ID
+	}
+	var parentID, parentTurn any
+	if id, ok := merged[conv.ParentID]; ok {
+		parentID, parentTurn = id, conv.ParentTurn
+	}
+	res, err := tx.Exec(`
+		INSERT INTO `+sqlDB.dbTable+` (id, uuid, title, created, model, role, system_prompt, starred, archived, parent_id, parent_turn)
+		VALUES (?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?, ?, ?, ?, ?);
+	`, newID, conv.UUID, title, formatTimestamp(parseTimestamp(conv.Created)), conv.Model, conv.Role, systemPrompt,
+		conv.Starred, conv.Archived, parentID, parentTurn)
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	id := int(lastID)
+
+	if err := sqlDB.insertMergedMessages(tx, id, 0, msgs); err != nil {
+		return 0, err
+	}
+	for _, tag := range conv.Tags {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO `+sqlDB.tagTable+` (conversation_id, tag) VALUES (?, ?);`, id, tag)
+		if err != nil {
+			return 0, fmt.Errorf("%v", err)
+		}
+	}
+	return id, tx.Commit()
+}
+
+// appendMerged adds the messages a conversation here is missing, and the
+// title and tags it was given there
+func (sqlDB *ChatDB) appendMerged(convID int, conv Conversation, msgs []Message) error {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	var seq int
+	err = tx.QueryRow(`
+		SELECT COALESCE(MAX(seq) + 1, 0) FROM `+sqlDB.msgTable+` WHERE conversation_id = ?;
+	`, convID).Scan(&seq)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if err := sqlDB.insertMergedMessages(tx, convID, seq, msgs); err != nil {
+		return err
+	}
+	title, err := sqlDB.encryptField(conv.Title)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE `+sqlDB.dbTable+` SET model = ?, title = CASE WHEN title = '' THEN ? ELSE title END WHERE id = ?;
+	`, conv.Model, title, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	for _, tag := range conv.Tags {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO `+sqlDB.tagTable+` (conversation_id, tag) VALUES (?, ?);`, convID, tag)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// insertMergedMessages stores the messages from seq on, keeping their
+// timestamps and metadata
+func (sqlDB *ChatDB) insertMergedMessages(tx *sql.Tx, convID, seq int, msgs []Message) error {
+	for i, m := range msgs {
+		content, err := sqlDB.encrypt(m.Content)
+		if err != nil {
+			return err
+		}
+		systemPrompt, err := sqlDB.encryptField(m.SystemPrompt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO `+sqlDB.msgTable+` (conversation_id, seq, role, content, tokens, model, temperature, timestamp,
+				provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms)
+			VALUES (?, ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?, ?, ?, ?, ?, ?);
+		`, convID, seq+i, m.Role, content, nullTokens(m.Tokens), m.Model, m.Temperature, formatTimestamp(parseTimestamp(m.Timestamp)),
+			m.Provider, systemPrompt, m.RoleName, m.Thinking, nullInt(m.MaxTokens), m.FinishReason, m.Error,
+			nullInt(int(m.Latency.Milliseconds())))
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	}
+	return nil
+}
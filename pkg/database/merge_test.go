@@ -0,0 +1,121 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	laptop, err := InitializeDB(filepath.Join(dir, "laptop.db"), "conversations")
+	assert.Nil(t, err)
+	defer laptop.Close()
+	desktop, err := InitializeDB(filepath.Join(dir, "desktop.db"), "conversations")
+	assert.Nil(t, err)
+	defer desktop.Close()
+
+	// Conversation 1 on the laptop, copied to the desktop and continued there
+	assert.Nil(t, laptop.InsertTurn(Turn{ConvID: 1, Prompt: "p1", Response: "r1", Model: "m"}))
+	_, err = desktop.Merge(laptop)
+	assert.Nil(t, err)
+	assert.Nil(t, desktop.InsertTurn(Turn{ConvID: 1, Prompt: "p2", Response: "r2", Model: "n"}))
+	assert.Nil(t, desktop.SetTitle(1, "Shared"))
+	assert.Nil(t, desktop.AddTags(1, "work"))
+	// Conversation 2 on each, different ones
+	assert.Nil(t, laptop.InsertTurn(Turn{ConvID: 2, Prompt: "laptop", Response: "r", Model: "m"}))
+	assert.Nil(t, desktop.InsertTurn(Turn{ConvID: 2, Prompt: "desktop", Response: "r", Model: "m"}))
+
+	res, err := laptop.Merge(desktop)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, res.Added)
+	assert.Equal(t, map[int]int{2: 3}, res.Remapped)
+	assert.Equal(t, 1, res.Updated)
+	assert.Equal(t, 4, res.Messages)
+	assert.Empty(t, res.Diverged)
+
+	msgs, err := laptop.Messages(1)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "r2", msgs[3].Content)
+	conv, err := laptop.GetConversation(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Shared", conv.Title)
+	assert.Equal(t, "n", conv.Model)
+	assert.Equal(t, []string{"work"}, conv.Tags)
+	msgs, _ = laptop.Messages(3)
+	assert.Equal(t, "desktop", msgs[0].Content)
+	theirs, _ := desktop.GetConversation(2)
+	ours, _ := laptop.GetConversation(3)
+	assert.Equal(t, theirs.UUID, ours.UUID)
+	assert.Equal(t, theirs.Created, ours.Created)
+
+	// Merging again adds nothing
+	res, err = laptop.Merge(desktop)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, res.Added)
+	assert.Equal(t, 0, res.Messages)
+	assert.Equal(t, 2, res.Skipped)
+
+	// Continued differently on both sides
+	assert.Nil(t, laptop.InsertTurn(Turn{ConvID: 1, Prompt: "p3", Response: "on the laptop", Model: "m"}))
+	assert.Nil(t, desktop.InsertTurn(Turn{ConvID: 1, Prompt: "p3", Response: "on the desktop", Model: "m"}))
+	res, err = laptop.Merge(desktop)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, res.Diverged)
+	msgs, _ = laptop.Messages(1)
+	assert.Equal(t, "on the laptop", msgs[5].Content)
+}
+
+func TestMergeByContent(t *testing.T) {
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	other, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer other.Close()
+
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+	msgs, _ := db.Messages(1)
+	// The same conversation with another UUID, as a copy of the file from
+	// before UUIDs would have after both were upgraded
+	_, err = other.db.Exec(`INSERT INTO conversations (id, uuid) VALUES (1, 'elsewhere');`)
+	assert.Nil(t, err)
+	assert.Nil(t, other.appendMerged(1, Conversation{Model: "m"}, msgs))
+	assert.Nil(t, other.InsertTurn(Turn{ConvID: 1, Prompt: "p2", Response: "r2", Model: "m"}))
+
+	res, err := db.Merge(other)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, res.Added)
+	assert.Equal(t, 1, res.Updated)
+	assert.Equal(t, 2, res.Messages)
+	msgs, _ = db.Messages(1)
+	assert.Len(t, msgs, 4)
+}
+
+func TestMergeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "other.db")
+	other, err := InitializeDB(path, "conversations")
+	assert.Nil(t, err)
+	assert.Nil(t, other.InsertTurn(Turn{ConvID: 1, Prompt: "secret", Response: "r", Model: "m"}))
+	assert.Nil(t, other.SetEncryptionKey(testKey))
+	_, err = other.EncryptAll()
+	assert.Nil(t, err)
+	other.Close()
+
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	_, err = db.MergeFile(path, nil)
+	assert.ErrorIs(t, err, ErrEncrypted)
+	_, err = db.MergeFile(filepath.Join(dir, "missing.db"), nil)
+	assert.Error(t, err)
+
+	res, err := db.MergeFile(path, testKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, res.Added)
+	msgs, _ := db.Messages(1)
+	assert.Equal(t, "secret", msgs[0].Content)
+}
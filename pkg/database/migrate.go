@@ -0,0 +1,323 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Migration is one version of the schema. Up takes the schema from the
+// version before it to this one; Down, where there is one, takes it back.
+// Both return literal SQL that ends by setting user_version, so a migration
+// keeps doing the same thing however the latest schema changes.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(dbTable string) string
+	Down    func(dbTable string) string // nil if it can't be undone
+}
+
+// migrations are the versions of the schema in order, version v at v-1
+var migrations []Migration
+
+func registerMigration(m Migration) {
+	if m.Version != len(migrations)+1 {
+		panic(fmt.Sprintf("migration %d registered out of order", m.Version))
+	}
+	migrations = append(migrations, m)
+}
+
+func init() {
+	registerMigration(Migration{Version: 1, Name: "prompts and responses", Up: SchemaQueryV1, Down: schemaDownV1})
+	registerMigration(Migration{Version: 2, Name: "token counts", Up: SchemaQueryV2, Down: schemaDownV2})
+	registerMigration(Migration{Version: 3, Name: "conversation IDs", Up: SchemaQueryV3, Down: schemaDownV3})
+	registerMigration(Migration{Version: 4, Name: "conversations and messages", Up: SchemaQueryV4})
+	registerMigration(Migration{Version: 5, Name: "stars, archive and tags", Up: SchemaQueryV5, Down: schemaDownV5})
+	registerMigration(Migration{Version: 6, Name: "import sources", Up: SchemaQueryV6, Down: schemaDownV6})
+	// SQLite can't drop a column with a foreign key
+	registerMigration(Migration{Version: 7, Name: "forks", Up: SchemaQueryV7})
+	registerMigration(Migration{Version: 8, Name: "alternatives", Up: SchemaQueryV8, Down: schemaDownV8})
+	registerMigration(Migration{Version: 9, Name: "response metadata", Up: SchemaQueryV9, Down: schemaDownV9})
+	registerMigration(Migration{Version: 10, Name: "settings", Up: SchemaQueryV10, Down: schemaDownV10})
+	registerMigration(Migration{Version: 11, Name: "latency", Up: SchemaQueryV11, Down: schemaDownV11})
+	registerMigration(Migration{Version: 12, Name: "conversation UUIDs", Up: SchemaQueryV12, Down: schemaDownV12})
+
+	if len(migrations) != SchemaVersion {
+		panic(fmt.Sprintf("schema version %d has %d migrations", SchemaVersion, len(migrations)))
+	}
+}
+
+func schemaDownV1(dbTable string) string {
+	return `
+	DROP TABLE ` + dbTable + `;
+
+	PRAGMA user_version = 0;
+	`
+}
+
+func schemaDownV2(dbTable string) string {
+	return `
+	ALTER TABLE ` + dbTable + ` DROP COLUMN input_tokens;
+	ALTER TABLE ` + dbTable + ` DROP COLUMN output_tokens;
+
+	PRAGMA user_version = 1;
+	`
+}
+
+func schemaDownV3(dbTable string) string {
+	return `
+	ALTER TABLE ` + dbTable + ` DROP COLUMN conv_id;
+
+	PRAGMA user_version = 2;
+	`
+}
+
+func schemaDownV5(dbTable string) string {
+	return `
+	DROP TABLE ` + tagsTable(dbTable) + `;
+	ALTER TABLE ` + dbTable + ` DROP COLUMN starred;
+	ALTER TABLE ` + dbTable + ` DROP COLUMN archived;
+
+	PRAGMA user_version = 4;
+	`
+}
+
+func schemaDownV6(dbTable string) string {
+	return `
+	DROP INDEX ` + dbTable + `_source;
+	ALTER TABLE ` + dbTable + ` DROP COLUMN source;
+	ALTER TABLE ` + dbTable + ` DROP COLUMN source_id;
+
+	PRAGMA user_version = 5;
+	`
+}
+
+func schemaDownV8(dbTable string) string {
+	return `
+	DROP TABLE ` + alternativesTable(dbTable) + `;
+
+	PRAGMA user_version = 7;
+	`
+}
+
+func schemaDownV9(dbTable string) string {
+	msgTable := messagesTable(dbTable)
+	altTable := alternativesTable(dbTable)
+	return `
+	ALTER TABLE ` + msgTable + ` DROP COLUMN provider;
+	ALTER TABLE ` + msgTable + ` DROP COLUMN system_prompt;
+	ALTER TABLE ` + msgTable + ` DROP COLUMN role_name;
+	ALTER TABLE ` + msgTable + ` DROP COLUMN thinking;
+	ALTER TABLE ` + msgTable + ` DROP COLUMN max_tokens;
+	ALTER TABLE ` + msgTable + ` DROP COLUMN finish_reason;
+	ALTER TABLE ` + msgTable + ` DROP COLUMN error;
+
+	ALTER TABLE ` + altTable + ` DROP COLUMN provider;
+	ALTER TABLE ` + altTable + ` DROP COLUMN system_prompt;
+	ALTER TABLE ` + altTable + ` DROP COLUMN role_name;
+	ALTER TABLE ` + altTable + ` DROP COLUMN thinking;
+	ALTER TABLE ` + altTable + ` DROP COLUMN max_tokens;
+	ALTER TABLE ` + altTable + ` DROP COLUMN finish_reason;
+	ALTER TABLE ` + altTable + ` DROP COLUMN error;
+
+	PRAGMA user_version = 8;
+	`
+}
+
+func schemaDownV10(dbTable string) string {
+	return `
+	DROP TABLE ` + settingsTable(dbTable) + `;
+
+	PRAGMA user_version = 9;
+	`
+}
+
+func schemaDownV11(dbTable string) string {
+	return `
+	ALTER TABLE ` + messagesTable(dbTable) + ` DROP COLUMN latency_ms;
+	ALTER TABLE ` + alternativesTable(dbTable) + ` DROP COLUMN latency_ms;
+
+	PRAGMA user_version = 10;
+	`
+}
+
+func schemaDownV12(dbTable string) string {
+	return `
+	DROP INDEX ` + dbTable + `_uuid;
+	ALTER TABLE ` + dbTable + ` DROP COLUMN uuid;
+
+	PRAGMA user_version = 11;
+	`
+}
+
+// MigrationStep is a migration applied, or undone, with the SQL it runs
+type MigrationStep struct {
+	Migration
+	Down bool
+	SQL  string
+}
+
+var tableNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validTableName keeps the table name, which ends up in every query, from
+// being anything but an identifier
+func validTableName(name string) error {
+	if !tableNameRe.MatchString(name) {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	return nil
+}
+
+// Version is the schema version the database is at
+func (sqlDB *ChatDB) Version() (int, error) {
+	var version int
+	err := sqlDB.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// planMigration lists the steps from one schema version to another
+func (sqlDB *ChatDB) planMigration(from, to int) ([]MigrationStep, error) {
+	if to < 1 || to > SchemaVersion {
+		return nil, fmt.Errorf("invalid schema version %d, expected 1 to %d", to, SchemaVersion)
+	}
+	if from > SchemaVersion {
+		return nil, fmt.Errorf("schema version %d is newer than this ask-ai knows (%d)", from, SchemaVersion)
+	}
+
+	var steps []MigrationStep
+	for v := from + 1; v <= to; v++ {
+		m := migrations[v-1]
+		steps = append(steps, MigrationStep{Migration: m, SQL: m.Up(sqlDB.dbTable)})
+	}
+	for v := from; v > to; v-- {
+		m := migrations[v-1]
+		if m.Down == nil {
+			return nil, fmt.Errorf("migration %d (%s) can't be undone", m.Version, m.Name)
+		}
+		steps = append(steps, MigrationStep{Migration: m, Down: true, SQL: m.Down(sqlDB.dbTable)})
+	}
+	return steps, nil
+}
+
+// Migrate takes the schema to the given version, one transaction per step,
+// and returns the steps it took. An existing database file is backed up
+// first, and the backup's path returned. With dryRun it only returns the
+// steps it would take.
+func (sqlDB *ChatDB) Migrate(to int, dryRun bool) ([]MigrationStep, string, error) {
+	from, err := sqlDB.Version()
+	if err != nil {
+		return nil, "", err
+	}
+	steps, err := sqlDB.planMigration(from, to)
+	if err != nil || dryRun || len(steps) == 0 {
+		return steps, "", err
+	}
+
+	var backup string
+	if from > 0 {
+		backup, err = sqlDB.backup(from)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, step := range steps {
+		if err := sqlDB.applyStep(step); err != nil {
+			return nil, backup, err
+		}
+	}
+
+	// Older schemas have no messages table to index
+	if to >= 4 {
+		if err := sqlDB.ensureSearchIndex(); err != nil {
+			return steps, backup, err
+		}
+	}
+	return steps, backup, nil
+}
+
+// applyStep runs a step in its own transaction. The version is checked again
+// inside it since another ask-ai may have migrated the database meanwhile.
+func (sqlDB *ChatDB) applyStep(step MigrationStep) error {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	from := step.Version - 1
+	if step.Down {
+		from = step.Version
+	}
+	if version != from {
+		if !step.Down && version >= step.Version || step.Down && version < step.Version {
+			return nil
+		}
+		return fmt.Errorf("schema version changed to %d while migrating", version)
+	}
+
+	if _, err := tx.Exec(step.SQL); err != nil {
+		if step.Down {
+			return fmt.Errorf("error undoing migration %d (%s): %v", step.Version, step.Name, err)
+		}
+		return fmt.Errorf("error applying migration %d (%s): %v", step.Version, step.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	return nil
+}
+
+// backup copies the database next to itself, named after the schema version
+// it's at, and returns the copy's path. An in-memory database has nothing to
+// back up.
+func (sqlDB *ChatDB) backup(version int) (string, error) {
+	path, _, _ := strings.Cut(sqlDB.path, "?")
+	if path == "" || path == ":memory:" {
+		return "", nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backup); err == nil {
+		return "", fmt.Errorf("backup %s already exists", backup)
+	}
+	if _, err := sqlDB.db.Exec(`VACUUM INTO ?;`, backup); err != nil {
+		return "", fmt.Errorf("error backing up database: %v", err)
+	}
+	return backup, nil
+}
+
+// OpenDB opens the database without touching its schema, for inspecting or
+// migrating it
+func OpenDB(dbPath string, dbTable string) (*ChatDB, error) {
+	if err := validTableName(dbTable); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName, dsn(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	if dbPath == ":memory:" {
+		// Every connection to :memory: is a separate, empty database
+		db.SetMaxOpenConns(1)
+	}
+
+	return &ChatDB{
+		db:       db,
+		path:     dbPath,
+		dbTable:  dbTable,
+		msgTable: messagesTable(dbTable),
+		tagTable: tagsTable(dbTable),
+		altTable: alternativesTable(dbTable),
+	}, nil
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsRegistered(t *testing.T) {
+	assert.Len(t, migrations, SchemaVersion)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+	}
+}
+
+func TestNewDBRunsEveryMigration(t *testing.T) {
+	RemoveDB()
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	version, err := db.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion, version)
+
+	// Nothing to migrate, so nothing is backed up
+	steps, backup, err := db.Migrate(SchemaVersion, false)
+	assert.Nil(t, err)
+	assert.Empty(t, steps)
+	assert.Empty(t, backup)
+}
+
+func TestMigrateDryRunAndBackup(t *testing.T) {
+	RemoveDB()
+	createV3DB(t)
+	defer RemoveDB()
+
+	db, err := OpenDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	steps, backup, err := db.Migrate(SchemaVersion, true)
+	assert.Nil(t, err)
+	assert.Empty(t, backup)
+	assert.Len(t, steps, SchemaVersion-3)
+	assert.Equal(t, 4, steps[0].Version)
+	assert.False(t, steps[0].Down)
+	assert.Contains(t, steps[0].SQL, "PRAGMA user_version = 4")
+	version, err := db.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version, "a dry run changes nothing")
+
+	steps, backup, err = db.Migrate(SchemaVersion, false)
+	assert.Nil(t, err)
+	assert.Len(t, steps, SchemaVersion-3)
+	assert.FileExists(t, backup)
+	version, err = db.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, SchemaVersion, version)
+
+	// The backup is the database as it was
+	old, err := OpenDB(backup, dbTable)
+	assert.Nil(t, err)
+	defer old.Close()
+	version, err = old.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+}
+
+func TestMigrateDown(t *testing.T) {
+	RemoveDB()
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	assert.Nil(t, db.InsertConversation("p", "r", "m", 0.5, 1, 1, 1))
+
+	steps, backup, err := db.Migrate(7, false)
+	assert.Nil(t, err)
+	assert.Len(t, steps, SchemaVersion-7)
+	assert.True(t, steps[0].Down)
+	assert.Equal(t, SchemaVersion, steps[0].Version)
+	assert.FileExists(t, backup)
+
+	version, err := db.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, version)
+	var n int
+	assert.Nil(t, db.db.QueryRow(`SELECT COUNT(*) FROM `+db.msgTable).Scan(&n))
+	assert.Equal(t, 2, n)
+
+	_, _, err = db.Migrate(6, false)
+	assert.ErrorContains(t, err, "migration 7 (forks) can't be undone")
+
+	// And back up again
+	_, _, err = db.Migrate(SchemaVersion, false)
+	assert.Nil(t, err)
+	msgs, err := db.Messages(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "r", msgs[1].Content)
+	// Conversations from before UUIDs are given one
+	conv, err := db.GetConversation(1)
+	assert.Nil(t, err)
+	assert.Len(t, conv.UUID, 36)
+}
+
+// Every migration that can be undone leaves the schema as it was before it,
+// so it can be applied again
+func TestMigrateDownAndUp(t *testing.T) {
+	for _, m := range migrations {
+		if m.Down == nil {
+			continue
+		}
+		db, err := OpenDB(":memory:", "conversations")
+		assert.Nil(t, err)
+
+		_, _, err = db.Migrate(m.Version, false)
+		assert.Nil(t, err)
+		assert.Nil(t, db.applyStep(MigrationStep{Migration: m, Down: true, SQL: m.Down(db.dbTable)}),
+			"undoing migration %d (%s)", m.Version, m.Name)
+		version, err := db.Version()
+		assert.Nil(t, err)
+		assert.Equal(t, m.Version-1, version)
+
+		_, _, err = db.Migrate(SchemaVersion, false)
+		assert.Nil(t, err, "applying migration %d (%s) again", m.Version, m.Name)
+		db.Close()
+	}
+}
+
+func TestMigrateInvalidVersion(t *testing.T) {
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	_, _, err = db.Migrate(0, false)
+	assert.ErrorContains(t, err, "invalid schema version 0")
+	_, _, err = db.Migrate(SchemaVersion+1, false)
+	assert.ErrorContains(t, err, "invalid schema version")
+}
+
+func TestInvalidTableName(t *testing.T) {
+	for _, name := range []string{"", "1conversations", "conversations; DROP TABLE x", "conv-ersations", "a b"} {
+		_, err := InitializeDB(":memory:", name)
+		assert.ErrorContains(t, err, "invalid table name", name)
+	}
+	_, err := os.Stat("conversations; DROP TABLE x")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetentionPolicy says which conversations Prune deletes. Zero fields don't
+// delete anything.
+type RetentionPolicy struct {
+	MaxAge           time.Duration // delete conversations inactive for longer
+	MaxConversations int           // keep only this many, most recently active first
+	KeepStarred      bool          // never delete starred conversations, nor count them
+	Keep             []int         // never delete these, such as a conversation about to be opened
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAge == 0 && p.MaxConversations == 0
+}
+
+// DeleteConversation deletes the conversation with its messages, tags and
+// alternatives
+func (sqlDB *ChatDB) DeleteConversation(convID int) error {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	if err := sqlDB.deleteConversation(tx, convID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (sqlDB *ChatDB) deleteConversation(tx *sql.Tx, convID int) error {
+	_, err := tx.Exec(`DELETE FROM `+sqlDB.tagTable+` WHERE conversation_id = ?;`, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`DELETE FROM `+sqlDB.altTable+` WHERE conversation_id = ?;`, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`DELETE FROM `+sqlDB.msgTable+` WHERE conversation_id = ?;`, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`DELETE FROM `+settingsTable(sqlDB.dbTable)+` WHERE name = ?;`, titleFailedSetting+strconv.Itoa(convID))
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	// Forks outlive the conversation they came from
+	_, err = tx.Exec(`UPDATE `+sqlDB.dbTable+` SET parent_id = NULL, parent_turn = NULL WHERE parent_id = ?;`, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	res, err := tx.Exec(`DELETE FROM `+sqlDB.dbTable+` WHERE id = ?;`, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFound(convID)
+	}
+	return nil
+}
+
+// emptyGrace is how long a conversation without messages is left alone, as
+// the session that reserved its ID may be about to record its first turn
+const emptyGrace = 24 * time.Hour
+
+// Prune deletes the conversations the policy doesn't keep and returns their
+// IDs. A conversation's age is the time since its latest message.
+// Conversations without messages, IDs reserved by sessions that never
+// recorded a turn, neither count nor are returned; once they're older than
+// emptyGrace they're deleted whatever the policy.
+func (sqlDB *ChatDB) Prune(policy RetentionPolicy, now time.Time) ([]int, error) {
+	tx, err := sqlDB.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer tx.Rollback()
+
+	if err := sqlDB.deleteEmpty(tx, now.Add(-emptyGrace), policy.Keep); err != nil {
+		return nil, err
+	}
+	if policy.IsZero() {
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		return nil, nil
+	}
+
+	// Timestamps are stored as UTC text, so they compare as strings; nothing
+	// is before the empty string
+	cutoff := ""
+	if policy.MaxAge > 0 {
+		cutoff = now.Add(-policy.MaxAge).UTC().Format(timestampLayout)
+	}
+	rows, err := tx.Query(`
+		SELECT c.id, c.starred, MAX(m.timestamp) < ? AS expired
+		FROM `+sqlDB.dbTable+` c JOIN `+sqlDB.msgTable+` m ON m.conversation_id = c.id
+		GROUP BY c.id
+		ORDER BY MAX(m.timestamp) DESC, c.id DESC;
+	`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+
+	var doomed []int
+	kept := 0
+	for rows.Next() {
+		var id int
+		var starred, expired bool
+		if err := rows.Scan(&id, &starred, &expired); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("%v", err)
+		}
+		if starred && policy.KeepStarred {
+			continue
+		}
+		if !slices.Contains(policy.Keep, id) &&
+			(expired || (policy.MaxConversations > 0 && kept >= policy.MaxConversations)) {
+			doomed = append(doomed, id)
+			continue
+		}
+		kept++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+
+	for _, id := range doomed {
+		if err := sqlDB.deleteConversation(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	return doomed, nil
+}
+
+// deleteEmpty deletes the conversations without messages created before
+// cutoff, other than those in keep
+func (sqlDB *ChatDB) deleteEmpty(tx *sql.Tx, cutoff time.Time, keep []int) error {
+	rows, err := tx.Query(`
+		SELECT id FROM `+sqlDB.dbTable+` c
+		WHERE created < ?
+			AND NOT EXISTS (SELECT 1 FROM `+sqlDB.msgTable+` m WHERE m.conversation_id = c.id);
+	`, cutoff.UTC().Format(timestampLayout))
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	var empty []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("%v", err)
+		}
+		if !slices.Contains(keep, id) {
+			empty = append(empty, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	for _, id := range empty {
+		if err := sqlDB.deleteConversation(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Vacuum rewrites the database file without the space deleted rows left
+// behind, so deleted text is gone from the disk too, and returns how many
+// bytes that freed. The search index is merged first since it keeps deleted
+// entries until then.
+func (sqlDB *ChatDB) Vacuum() (int64, error) {
+	before, err := sqlDB.fileSize()
+	if err != nil {
+		return 0, err
+	}
+
+	if sqlDB.fts {
+		fts := ftsTable(sqlDB.dbTable)
+		_, err = sqlDB.db.Exec(`INSERT INTO ` + fts + `(` + fts + `) VALUES ('optimize');`)
+		if err != nil {
+			return 0, fmt.Errorf("error optimizing search index: %v", err)
+		}
+	}
+	if _, err = sqlDB.db.Exec(`VACUUM;`); err != nil {
+		return 0, fmt.Errorf("error vacuuming database: %v", err)
+	}
+	// Old pages can linger in the write-ahead log until it's checkpointed
+	if _, err = sqlDB.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE);`); err != nil {
+		return 0, fmt.Errorf("error checkpointing database: %v", err)
+	}
+
+	after, err := sqlDB.fileSize()
+	if err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// FormatSize formats a size like Vacuum's result for people
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
+
+// fileSize is the size of the database in bytes
+func (sqlDB *ChatDB) fileSize() (int64, error) {
+	var pages, pageSize int64
+	if err := sqlDB.db.QueryRow(`PRAGMA page_count;`).Scan(&pages); err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	if err := sqlDB.db.QueryRow(`PRAGMA page_size;`).Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return pages * pageSize, nil
+}
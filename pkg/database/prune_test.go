@@ -0,0 +1,142 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteConversation(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	insertSearchFixtures(t, db)
+	assert.Nil(t, db.AddTags(1, "work"))
+
+	assert.Nil(t, db.DeleteConversation(1))
+	ids, err := db.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 3}, ids)
+	tags, err := db.Tags(1)
+	assert.Nil(t, err)
+	assert.Empty(t, tags)
+	results, err := db.Search("brown")
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+
+	assert.ErrorIs(t, db.DeleteConversation(1), ErrNotFound)
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	setup := func() *ChatDB {
+		RemoveDB()
+		db, err := NewDB(dbPath, dbTable)
+		assert.Nil(t, err)
+		// Conversation n was last active n*10 days ago
+		for id := 1; id <= 4; id++ {
+			assert.Nil(t, db.InsertTurn(Turn{ConvID: id, Prompt: "p", Response: "r", Model: "m"}))
+			ts := now.AddDate(0, 0, -10*id).Format(timestampLayout)
+			_, err = db.db.Exec(`UPDATE `+db.msgTable+` SET timestamp = ? WHERE conversation_id = ?`, ts, id)
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, db.SetStarred(4, true))
+		return db
+	}
+	defer RemoveDB()
+
+	tests := []struct {
+		name    string
+		policy  RetentionPolicy
+		deleted []int
+	}{
+		{"no policy", RetentionPolicy{KeepStarred: true}, nil},
+		{"max age", RetentionPolicy{MaxAge: 25 * 24 * time.Hour}, []int{3, 4}},
+		{"max age keeping starred", RetentionPolicy{MaxAge: 25 * 24 * time.Hour, KeepStarred: true}, []int{3}},
+		{"max conversations", RetentionPolicy{MaxConversations: 1}, []int{2, 3, 4}},
+		// Starred conversations don't count towards the limit
+		{"max conversations keeping starred", RetentionPolicy{MaxConversations: 2, KeepStarred: true}, []int{3}},
+		{"both", RetentionPolicy{MaxAge: 35 * 24 * time.Hour, MaxConversations: 3}, []int{4}},
+		// A conversation being opened is kept whatever its age
+		{"max age keeping one", RetentionPolicy{MaxAge: 25 * 24 * time.Hour, Keep: []int{4}}, []int{3}},
+		{"max conversations keeping one", RetentionPolicy{MaxConversations: 1, Keep: []int{4}}, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		db := setup()
+		deleted, err := db.Prune(tt.policy, now)
+		assert.Nil(t, err, tt.name)
+		assert.ElementsMatch(t, tt.deleted, deleted, tt.name)
+
+		ids, err := db.ListConversationIDs()
+		assert.Nil(t, err)
+		assert.Len(t, ids, 4-len(tt.deleted), tt.name)
+		db.Close()
+	}
+
+	// Conversations without messages don't count towards the limit, and are
+	// only deleted once they've been left empty for a while
+	db := setup()
+	defer db.Close()
+	fresh, err := db.NewConversationID()
+	assert.Nil(t, err)
+	stale, err := db.NewConversationID()
+	assert.Nil(t, err)
+	_, err = db.db.Exec(`UPDATE `+db.dbTable+` SET created = ? WHERE id = ?`, now.AddDate(0, 0, -2).Format(timestampLayout), stale)
+	assert.Nil(t, err)
+	_, err = db.db.Exec(`UPDATE `+db.dbTable+` SET created = ? WHERE id = ?`, now.Add(-time.Hour).Format(timestampLayout), fresh)
+	assert.Nil(t, err)
+
+	deleted, err := db.Prune(RetentionPolicy{MaxConversations: 4}, now)
+	assert.Nil(t, err)
+	assert.Empty(t, deleted)
+	_, err = db.GetConversation(fresh)
+	assert.Nil(t, err)
+	_, err = db.GetConversation(stale)
+	assert.Error(t, err)
+
+	// Even without a policy
+	deleted, err = db.Prune(RetentionPolicy{}, now.Add(emptyGrace))
+	assert.Nil(t, err)
+	assert.Empty(t, deleted)
+	_, err = db.GetConversation(fresh)
+	assert.Error(t, err)
+	ids, err := db.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Len(t, ids, 4)
+}
+
+func TestVacuum(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	secret := "sk-secret-"
+	big := make([]byte, 0, 200_000)
+	for len(big) < 200_000 {
+		big = append(big, "filler text "...)
+	}
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: secret + "1234567890", Response: string(big), Model: "m"}))
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 2, Prompt: "keep", Response: "me", Model: "m"}))
+
+	assert.Nil(t, db.DeleteConversation(1))
+	freed, err := db.Vacuum()
+	assert.Nil(t, err)
+	assert.Greater(t, freed, int64(100_000))
+
+	// The deleted text is gone from the file, not just unreachable
+	data, err := os.ReadFile(dbPath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), secret+"1234567890")
+
+	results, err := db.Search("keep")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, resultIDs(results))
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 bytes", FormatSize(512))
+	assert.Equal(t, "1.5 KiB", FormatSize(1536))
+	assert.Equal(t, "2.0 MiB", FormatSize(2<<20))
+}
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Snippets mark the matched terms with these so the caller can highlight
+// them however it likes
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// timestampLayout is how SQLite's CURRENT_TIMESTAMP writes message times,
+// always in UTC
+const timestampLayout = "2006-01-02 15:04:05"
+
+// snippetTokens is roughly how many words of context a snippet shows
+const snippetTokens = 16
+
+// SearchResult is the best matching message of a conversation
+type SearchResult struct {
+	ConvID   int
+	Title    string
+	Seq      int
+	Role     string
+	Snippet  string  // text around the match with the terms marked
+	Rank     float64 // bm25 score; lower is a better match, 0 without FTS5
+	Starred  bool
+	Archived bool
+	Tags     []string
+}
+
+func ftsTable(dbTable string) string {
+	return dbTable + "_fts"
+}
+
+// ensureSearchIndex keeps an FTS5 index of message content in sync through
+// triggers. It isn't part of the versioned schema because it depends on how
+// the binary was built: without FTS5 the triggers are dropped, since they
+// would make every insert fail, and search falls back to LIKE. The index is
+// rebuilt whenever the triggers have to be (re)created, which covers both a
+// new index and one that went stale while a build without FTS5 was writing.
+func (sqlDB *ChatDB) ensureSearchIndex() error {
+	fts := ftsTable(sqlDB.dbTable)
+
+	// An index of encrypted messages would give away what they say
+	encrypted, err := sqlDB.Encrypted()
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return sqlDB.dropSearchIndex()
+	}
+
+	_, err = sqlDB.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS ` + fts + ` USING fts5(
+			content, content='` + sqlDB.msgTable + `', content_rowid='id'
+		);
+	`)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module") {
+			return fmt.Errorf("error creating search index: %v", err)
+		}
+		sqlDB.fts = false
+		return sqlDB.dropSearchTriggers()
+	}
+	sqlDB.fts = true
+
+	var n int
+	err = sqlDB.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = ?;
+	`, fts+"_ai").Scan(&n)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = sqlDB.db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS ` + fts + `_ai AFTER INSERT ON ` + sqlDB.msgTable + ` BEGIN
+			INSERT INTO ` + fts + `(rowid, content) VALUES (new.id, new.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS ` + fts + `_ad AFTER DELETE ON ` + sqlDB.msgTable + ` BEGIN
+			INSERT INTO ` + fts + `(` + fts + `, rowid, content) VALUES ('delete', old.id, old.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS ` + fts + `_au AFTER UPDATE OF content ON ` + sqlDB.msgTable + ` BEGIN
+			INSERT INTO ` + fts + `(` + fts + `, rowid, content) VALUES ('delete', old.id, old.content);
+			INSERT INTO ` + fts + `(rowid, content) VALUES (new.id, new.content);
+		END;
+		INSERT INTO ` + fts + `(` + fts + `) VALUES ('rebuild');
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating search triggers: %v", err)
+	}
+	return nil
+}
+
+func (sqlDB *ChatDB) dropSearchTriggers() error {
+	fts := ftsTable(sqlDB.dbTable)
+	_, err := sqlDB.db.Exec(`
+		DROP TRIGGER IF EXISTS ` + fts + `_ai;
+		DROP TRIGGER IF EXISTS ` + fts + `_ad;
+		DROP TRIGGER IF EXISTS ` + fts + `_au;
+	`)
+	if err != nil {
+		return fmt.Errorf("error dropping search triggers: %v", err)
+	}
+	return nil
+}
+
+// dropSearchIndex stops indexing messages and drops the index. Without FTS5
+// the index can't be dropped, but nothing reads or updates it either.
+func (sqlDB *ChatDB) dropSearchIndex() error {
+	sqlDB.fts = false
+	if err := sqlDB.dropSearchTriggers(); err != nil {
+		return err
+	}
+	_, err := sqlDB.db.Exec(`DROP TABLE IF EXISTS ` + ftsTable(sqlDB.dbTable) + `;`)
+	if err != nil && !strings.Contains(err.Error(), "no such module") {
+		return fmt.Errorf("error dropping search index: %v", err)
+	}
+	return nil
+}
+
+// HasFullTextSearch reports whether Search uses the FTS5 index
+func (sqlDB *ChatDB) HasFullTextSearch() bool {
+	return sqlDB.fts
+}
+
+// SearchFilter selects the conversations FindConversations returns. A
+// conversation matches when one of its messages satisfies every message field
+// that is set and the conversation itself satisfies the rest; zero fields
+// don't filter, except that archived conversations are left out unless
+// IncludeArchived is set.
+type SearchFilter struct {
+	Query    string    // text query, see Search
+	Models   []string  // model the message was recorded with
+	Provider string    // provider that answered; a prompt goes by its answer
+	Since    time.Time // sent at or after
+	Until    time.Time // sent before
+	Role     string    // "user" for prompts, "assistant" for responses
+	Regex    string    // RE2 pattern the content must match
+
+	Tags            []string // the conversation has every one of these tags
+	Starred         bool     // only starred conversations
+	IncludeArchived bool     // archived conversations too
+}
+
+// Search returns one result per matching conversation. With FTS5 the query
+// uses its syntax (phrases, AND/OR/NOT, prefix*) and results are ordered by
+// bm25; a query that isn't valid FTS5 syntax is searched for as a phrase.
+// Without FTS5 the query is a plain substring and results are ordered by
+// conversation ID.
+func (sqlDB *ChatDB) Search(query string) ([]SearchResult, error) {
+	return sqlDB.FindConversations(SearchFilter{Query: query})
+}
+
+// FindConversations returns one result per conversation matching the filter,
+// with the snippet taken from its first matching message. Results are ordered
+// as for Search; with an empty filter that is every conversation by ID.
+func (sqlDB *ChatDB) FindConversations(filter SearchFilter) ([]SearchResult, error) {
+	var re *regexp.Regexp
+	if filter.Regex != "" {
+		var err error
+		re, err = regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+
+	results, err := sqlDB.findConversations(filter, re)
+	if sqlDB.fts && isQueryError(err) {
+		filter.Query = `"` + strings.ReplaceAll(filter.Query, `"`, `""`) + `"`
+		results, err = sqlDB.findConversations(filter, re)
+	}
+	return results, err
+}
+
+// isQueryError reports whether FTS5 couldn't parse the query, e.g. an
+// unbalanced quote. "no such column" isn't one: a schema missing a column
+// says the same, and quoting the query wouldn't hide that.
+func isQueryError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "fts5: ") || msg == "unterminated string"
+}
+
+func (sqlDB *ChatDB) findConversations(filter SearchFilter, re *regexp.Regexp) ([]SearchResult, error) {
+	useFTS := sqlDB.fts && filter.Query != ""
+	fts := ftsTable(sqlDB.dbTable)
+
+	var args []any
+	var where []string
+	tagList := `(SELECT group_concat(tag, ',') FROM (SELECT tag FROM ` + sqlDB.tagTable + ` t WHERE t.conversation_id = c.id ORDER BY tag))`
+	flags := `, c.starred, c.archived, COALESCE(` + tagList + `, '')`
+	cols := `m.conversation_id, c.title, m.seq, m.role, m.content, 0`
+	from := sqlDB.msgTable + ` m JOIN ` + sqlDB.dbTable + ` c ON c.id = m.conversation_id`
+	order := `m.conversation_id, m.seq`
+	if useFTS {
+		cols = `m.conversation_id, c.title, m.seq, m.role, snippet(` + fts + `, 0, ?, ?, '...', ?), bm25(` + fts + `)`
+		args = append(args, HighlightStart, HighlightEnd, snippetTokens)
+		from += ` JOIN ` + fts + ` ON ` + fts + `.rowid = m.id`
+		where = append(where, fts+` MATCH ?`)
+		args = append(args, filter.Query)
+		order = `bm25(` + fts + `), m.conversation_id, m.seq`
+	} else if filter.Query != "" {
+		content, contentArgs := sqlDB.plaintext(`m.content`)
+		where = append(where, content+` LIKE ?`)
+		args = append(append(args, contentArgs...), "%"+filter.Query+"%")
+	}
+	msgWhere, msgArgs := sqlDB.messageConditions(filter, re, "m")
+	convWhere, convArgs := sqlDB.conversationConditions(filter, "c")
+	where = append(append(where, msgWhere...), convWhere...)
+	args = append(append(args, msgArgs...), convArgs...)
+
+	query := `SELECT ` + cols + flags + ` FROM ` + from
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY ` + order + `;`
+
+	rows, err := sqlDB.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	results, err := collectResults(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if results[i].Title, err = sqlDB.decrypt(results[i].Title); err != nil {
+			return nil, err
+		}
+	}
+	if useFTS {
+		return results, nil
+	}
+	// The full content was selected; cut it down around whatever matched
+	for i := range results {
+		content, err := sqlDB.decrypt(results[i].Snippet)
+		if err != nil {
+			return nil, err
+		}
+		start, end := -1, -1
+		if filter.Query != "" {
+			start, end = matchSpan(content, filter.Query)
+		} else if re != nil {
+			if loc := re.FindStringIndex(content); loc != nil {
+				start, end = loc[0], loc[1]
+			}
+		}
+		results[i].Snippet = markedSnippet(content, start, end, snippetTokens)
+	}
+	return results, nil
+}
+
+// messageConditions are the SQL conditions on the messages aliased m that
+// the filter's message fields other than Query make, and their arguments
+func (sqlDB *ChatDB) messageConditions(filter SearchFilter, re *regexp.Regexp, m string) ([]string, []any) {
+	var where []string
+	var args []any
+	if len(filter.Models) > 0 {
+		where = append(where, m+`.model IN (?`+strings.Repeat(`, ?`, len(filter.Models)-1)+`)`)
+		for _, model := range filter.Models {
+			args = append(args, model)
+		}
+	}
+	if filter.Provider != "" {
+		where = append(where, `(`+m+`.provider = ? OR `+m+`.role = 'user' AND EXISTS (
+			SELECT 1 FROM `+sqlDB.msgTable+` r
+			WHERE r.conversation_id = `+m+`.conversation_id AND r.seq = `+m+`.seq + 1 AND r.provider = ?))`)
+		args = append(args, filter.Provider, filter.Provider)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, m+`.timestamp >= ?`)
+		args = append(args, filter.Since.UTC().Format(timestampLayout))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, m+`.timestamp < ?`)
+		args = append(args, filter.Until.UTC().Format(timestampLayout))
+	}
+	if filter.Role != "" {
+		where = append(where, m+`.role = ?`)
+		args = append(args, filter.Role)
+	}
+	if re != nil {
+		content, contentArgs := sqlDB.plaintext(m + `.content`)
+		where = append(where, content+` REGEXP ?`)
+		args = append(append(args, contentArgs...), filter.Regex)
+	}
+	return where, args
+}
+
+// conversationConditions are the SQL conditions on the conversations aliased
+// c that the filter's conversation fields make, and their arguments
+func (sqlDB *ChatDB) conversationConditions(filter SearchFilter, c string) ([]string, []any) {
+	var where []string
+	var args []any
+	for _, tag := range filter.Tags {
+		where = append(where, `EXISTS (SELECT 1 FROM `+sqlDB.tagTable+` t WHERE t.conversation_id = `+c+`.id AND t.tag = ?)`)
+		args = append(args, NormalizeTag(tag))
+	}
+	if filter.Starred {
+		where = append(where, c+`.starred = 1`)
+	}
+	if !filter.IncludeArchived {
+		where = append(where, c+`.archived = 0`)
+	}
+	return where, args
+}
+
+// collectResults keeps the first, and so best, row of each conversation
+func collectResults(rows *sql.Rows) ([]SearchResult, error) {
+	seen := make(map[int]bool)
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var tags string
+		if err := rows.Scan(&r.ConvID, &r.Title, &r.Seq, &r.Role, &r.Snippet, &r.Rank, &r.Starred, &r.Archived, &tags); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		if seen[r.ConvID] {
+			continue
+		}
+		seen[r.ConvID] = true
+		if tags != "" {
+			r.Tags = strings.Split(tags, ",")
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// matchSpan returns the byte span of the first case-insensitive occurrence
+// of query, or -1, -1. It's found in content itself: lowercasing changes the
+// length of some runes, so offsets into a lowercased copy don't fit it.
+func matchSpan(content, query string) (int, int) {
+	if query == "" {
+		return -1, -1
+	}
+	loc := regexp.MustCompile("(?i)" + regexp.QuoteMeta(query)).FindStringIndex(content)
+	if loc == nil {
+		return -1, -1
+	}
+	return loc[0], loc[1]
+}
+
+// markedSnippet returns about n words of content around content[start:end],
+// which is marked, or the first n words when start is negative
+func markedSnippet(content string, start, end, n int) string {
+	if start < 0 {
+		words := strings.Fields(content)
+		if len(words) > n {
+			return strings.Join(words[:n], " ") + "..."
+		}
+		return strings.Join(words, " ")
+	}
+	idx := start
+
+	before := strings.Fields(content[:idx])
+	after := strings.Fields(content[end:])
+	keepBefore := n / 2
+	if len(before) < keepBefore {
+		keepBefore = len(before)
+	}
+	keepAfter := n - keepBefore
+	if len(after) < keepAfter {
+		keepAfter = len(after)
+	}
+
+	var b strings.Builder
+	if keepBefore < len(before) {
+		b.WriteString("...")
+	}
+	b.WriteString(strings.Join(before[len(before)-keepBefore:], " "))
+	// Keep the match attached to its word when it's inside one
+	if keepBefore > 0 && startsWord(content, idx) {
+		b.WriteString(" ")
+	}
+	b.WriteString(HighlightStart + content[idx:end] + HighlightEnd)
+	if keepAfter > 0 && endsWord(content, end) {
+		b.WriteString(" ")
+	}
+	b.WriteString(strings.Join(after[:keepAfter], " "))
+	if keepAfter < len(after) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+func startsWord(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return i == 0 || strings.ContainsRune(" \t\r\n", r)
+}
+
+func endsWord(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return i == len(s) || strings.ContainsRune(" \t\r\n", r)
+}
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func insertSearchFixtures(t *testing.T, db *ChatDB) {
+	turns := []Turn{
+		{ConvID: 1, Prompt: "tell me about the quick brown fox", Response: "it jumps over things", Model: "m"},
+		{ConvID: 2, Prompt: "what does a lazy dog do", Response: "sleeps mostly", Model: "m"},
+		{ConvID: 3, Prompt: "first question", Response: "first answer", Model: "m"},
+		{ConvID: 3, Prompt: "second question", Response: "a fox and another fox and a third fox", Model: "m"},
+	}
+	for _, turn := range turns {
+		assert.Nil(t, db.InsertTurn(turn))
+	}
+}
+
+func resultIDs(results []SearchResult) []int {
+	var ids []int
+	for _, r := range results {
+		ids = append(ids, r.ConvID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	insertSearchFixtures(t, db)
+
+	results, err := db.Search("fox")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, resultIDs(results))
+
+	// The snippet comes from the turn that matched, not the first one
+	for _, r := range results {
+		if r.ConvID == 3 {
+			assert.Equal(t, 3, r.Seq)
+			assert.Equal(t, "assistant", r.Role)
+			assert.Contains(t, r.Snippet, HighlightStart+"fox"+HighlightEnd)
+		}
+	}
+
+	results, err = db.Search("marklar")
+	assert.Nil(t, err)
+	assert.Len(t, results, 0)
+}
+
+func TestSearchFTS(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	if !db.HasFullTextSearch() {
+		t.Skip("sqlite3 built without FTS5; build with -tags sqlite_fts5")
+	}
+	insertSearchFixtures(t, db)
+
+	// More occurrences rank higher under bm25
+	results, err := db.Search("fox")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 1}, resultIDs(results))
+	assert.Less(t, results[0].Rank, 0.0)
+
+	results, err = db.Search(`"brown fox"`)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, resultIDs(results))
+
+	results, err = db.Search("lazy OR brown")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{1, 2}, resultIDs(results))
+
+	results, err = db.Search("fox AND third")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3}, resultIDs(results))
+
+	results, err = db.Search("sle*")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, resultIDs(results))
+
+	// Invalid syntax is searched for as a phrase
+	results, err = db.Search(`lazy dog"`)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, resultIDs(results))
+	results, err = db.Search("(lazy")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2}, resultIDs(results))
+	// but a column that doesn't exist isn't taken for bad syntax
+	_, err = db.Search("dog:")
+	assert.ErrorContains(t, err, "no such column")
+
+	// The index follows updates and deletes
+	_, err = db.db.Exec(`UPDATE ` + db.msgTable + ` SET content = 'a cat' WHERE conversation_id = 1 AND seq = 0`)
+	assert.Nil(t, err)
+	_, err = db.db.Exec(`DELETE FROM ` + db.msgTable + ` WHERE conversation_id = 3`)
+	assert.Nil(t, err)
+	results, err = db.Search("fox")
+	assert.Nil(t, err)
+	assert.Len(t, results, 0)
+	results, err = db.Search("cat")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, resultIDs(results))
+}
+
+func TestIsQueryError(t *testing.T) {
+	for _, msg := range []string{`fts5: syntax error near "AND"`, "unterminated string"} {
+		assert.True(t, isQueryError(errors.New(msg)), msg)
+	}
+	for _, msg := range []string{"no such column: foo", "no such table: conversations_fts", "database is locked"} {
+		assert.False(t, isQueryError(errors.New(msg)), msg)
+	}
+	assert.False(t, isQueryError(nil))
+}
+
+func TestSearchIndexRebuiltForExistingMessages(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer RemoveDB()
+	if !db.HasFullTextSearch() {
+		db.Close()
+		t.Skip("sqlite3 built without FTS5; build with -tags sqlite_fts5")
+	}
+	insertSearchFixtures(t, db)
+
+	// Messages written while the triggers were missing, as by a build
+	// without FTS5, are indexed the next time the database is opened
+	fts := ftsTable(dbTable)
+	_, err = db.db.Exec(`DROP TRIGGER ` + fts + `_ai; DROP TRIGGER ` + fts + `_ad; DROP TRIGGER ` + fts + `_au;`)
+	assert.Nil(t, err)
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 4, Prompt: "unindexed platypus", Response: "r", Model: "m"}))
+	db.Close()
+
+	db, err = NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer db.Close()
+	results, err := db.Search("platypus")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4}, resultIDs(results))
+}
+
+func TestMatchSpan(t *testing.T) {
+	tests := []struct {
+		content    string
+		query      string
+		start, end int
+	}{
+		{"one two three", "two", 4, 7},
+		{"Upper Case", "case", 6, 10},
+		{"no match", "zzz", -1, -1},
+		{"anything", "", -1, -1},
+		// Ⱥ is 2 bytes and lowercases to ⱥ, 3 bytes
+		{"ȺȺȺȺȺȺ foo", "foo", 13, 16},
+		{"ȺȺ", "ⱥ", 0, 2},
+		// İ is 2 bytes and lowercases to 3
+		{"İİİ foo", "FOO", 7, 10},
+		{"x İ y", "İ", 2, 4},
+		{"a.b", ".", 1, 2},
+	}
+
+	for _, tt := range tests {
+		start, end := matchSpan(tt.content, tt.query)
+		assert.Equal(t, []int{tt.start, tt.end}, []int{start, end}, tt.content)
+	}
+}
+
+func TestMarkedSnippet(t *testing.T) {
+	tests := []struct {
+		content string
+		query   string
+		n       int
+		want    string
+	}{
+		{"one two three", "two", 4, "one \x02two\x03 three"},
+		{"one two three four five six", "four", 2, "...three \x02four\x03 five..."},
+		{"the responses", "response", 4, "the \x02response\x03s"},
+		{"Upper Case", "case", 4, "Upper \x02Case\x03"},
+		{"no match here at all", "zzz", 3, "no match here..."},
+		{"ȺȺȺȺȺȺ foo", "foo", 4, "ȺȺȺȺȺȺ \x02foo\x03"},
+		{"İstanbul foo bar", "FOO", 4, "İstanbul \x02foo\x03 bar"},
+	}
+
+	for _, tt := range tests {
+		start, end := matchSpan(tt.content, tt.query)
+		assert.Equal(t, tt.want, markedSnippet(tt.content, start, end, tt.n), tt.content)
+	}
+}
+
+func TestFindConversations(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	turns := []Turn{
+		{ConvID: 1, Prompt: "chess openings", Response: "try the Reti", Model: "gpt-4o", Provider: "openai"},
+		{ConvID: 2, Prompt: "best chess engine", Response: "stockfish 16", Model: "sonnet", Provider: "anthropic"},
+		{ConvID: 3, Prompt: "error code E1234", Response: "look up E1234 in the manual", Model: "gpt-4o", Provider: "openai"},
+		{ConvID: 4, Prompt: "hello", Response: "hi there", Model: "llama"},
+	}
+	for _, turn := range turns {
+		assert.Nil(t, db.InsertTurn(turn))
+	}
+	_, err = db.db.Exec(`UPDATE ` + db.msgTable + ` SET timestamp = CASE conversation_id
+		WHEN 1 THEN '2024-01-10 12:00:00'
+		WHEN 2 THEN '2024-02-10 12:00:00'
+		WHEN 3 THEN '2024-03-10 12:00:00'
+		ELSE '2024-04-10 12:00:00' END`)
+	assert.Nil(t, err)
+
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		assert.Nil(t, err)
+		return d
+	}
+
+	tests := []struct {
+		name   string
+		filter SearchFilter
+		want   []int
+	}{
+		{"everything", SearchFilter{}, []int{1, 2, 3, 4}},
+		{"model", SearchFilter{Models: []string{"gpt-4o"}}, []int{1, 3}},
+		{"models", SearchFilter{Models: []string{"sonnet", "llama"}}, []int{2, 4}},
+		{"provider", SearchFilter{Provider: "openai"}, []int{1, 3}},
+		{"provider of a prompt's answer", SearchFilter{Query: "chess", Role: "user", Provider: "anthropic"}, []int{2}},
+		{"provider nothing recorded", SearchFilter{Provider: "xai"}, nil},
+		{"since", SearchFilter{Since: date("2024-02-01")}, []int{2, 3, 4}},
+		{"until", SearchFilter{Until: date("2024-03-01")}, []int{1, 2}},
+		{"range", SearchFilter{Since: date("2024-02-01"), Until: date("2024-04-01")}, []int{2, 3}},
+		{"query and model", SearchFilter{Query: "chess", Models: []string{"sonnet"}}, []int{2}},
+		{"in prompts", SearchFilter{Query: "stockfish", Role: "user"}, nil},
+		{"in responses", SearchFilter{Query: "stockfish", Role: "assistant"}, []int{2}},
+		{"regex", SearchFilter{Regex: `E\d{4}`}, []int{3}},
+		{"regex in responses", SearchFilter{Regex: `^look`, Role: "assistant"}, []int{3}},
+		{"regex and query", SearchFilter{Query: "stockfish", Regex: `[0-9]+`}, []int{2}},
+		// Every field applies to the same message
+		{"regex and query in different messages", SearchFilter{Query: "chess", Regex: `[0-9]+`}, nil},
+	}
+
+	for _, tt := range tests {
+		results, err := db.FindConversations(tt.filter)
+		assert.Nil(t, err, tt.name)
+		assert.ElementsMatch(t, tt.want, resultIDs(results), tt.name)
+	}
+
+	// The snippet marks what the regex matched
+	results, err := db.FindConversations(SearchFilter{Regex: `E\d{4}`, Role: "assistant"})
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Contains(t, results[0].Snippet, HighlightStart+"E1234"+HighlightEnd)
+
+	_, err = db.FindConversations(SearchFilter{Regex: `(`})
+	assert.ErrorContains(t, err, "invalid regex")
+}
+
+func TestFindConversationsTagsAndFlags(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	insertSearchFixtures(t, db)
+
+	assert.Nil(t, db.AddTags(1, "animals", "work"))
+	assert.Nil(t, db.AddTags(3, "animals"))
+	assert.Nil(t, db.SetStarred(3, true))
+	assert.Nil(t, db.SetArchived(2, true))
+
+	tests := []struct {
+		name   string
+		filter SearchFilter
+		want   []int
+	}{
+		{"archived hidden", SearchFilter{}, []int{1, 3}},
+		{"archived included", SearchFilter{IncludeArchived: true}, []int{1, 2, 3}},
+		{"tag", SearchFilter{Tags: []string{"animals"}}, []int{1, 3}},
+		{"every tag", SearchFilter{Tags: []string{"animals", "#Work"}}, []int{1}},
+		{"starred", SearchFilter{Starred: true}, []int{3}},
+		{"tag and query", SearchFilter{Query: "brown", Tags: []string{"animals"}}, []int{1}},
+		{"archived by query", SearchFilter{Query: "lazy"}, nil},
+	}
+	for _, tt := range tests {
+		results, err := db.FindConversations(tt.filter)
+		assert.Nil(t, err, tt.name)
+		assert.ElementsMatch(t, tt.want, resultIDs(results), tt.name)
+	}
+
+	results, err := db.FindConversations(SearchFilter{IncludeArchived: true})
+	assert.Nil(t, err)
+	for _, r := range results {
+		switch r.ConvID {
+		case 1:
+			assert.Equal(t, []string{"animals", "work"}, r.Tags)
+		case 2:
+			assert.True(t, r.Archived)
+			assert.Nil(t, r.Tags)
+		case 3:
+			assert.True(t, r.Starred)
+		}
+	}
+}
@@ -5,37 +5,171 @@ package database
 // InsertConversation(db, "prompt", "response", "model_name", model.temp)
 
 import (
+	"crypto/cipher"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/duluk/ask-ai/pkg/LLM"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
 )
 
+// driverName is sqlite3 with the extra SQL functions ask-ai's queries use
+const driverName = "sqlite3_ask_ai"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// SQLite parses "x REGEXP y" but leaves the function to the
+			// application
+			if err := conn.RegisterFunc("regexp", regexpMatch, true); err != nil {
+				return err
+			}
+			return conn.RegisterFunc("decrypt", decryptValue, true)
+		},
+	})
+}
+
+var regexCache sync.Map // pattern -> *regexp.Regexp
+
+// regexpMatch implements regexp(pattern, text) with Go's RE2 syntax
+func regexpMatch(pattern, text string) (bool, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp).MatchString(text), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	regexCache.Store(pattern, re)
+	return re.MatchString(text), nil
+}
+
 type ChatDB struct {
-	db      *sql.DB
-	dbTable string
+	db       *sql.DB
+	path     string
+	dbTable  string
+	msgTable string
+	tagTable string
+	altTable string
+	fts      bool // messages are indexed with FTS5
+
+	// Prompts and responses are encrypted with this key when it's set
+	key  []byte
+	aead cipher.AEAD
+}
+
+// Conversation is a conversation's metadata, without its messages
+type Conversation struct {
+	ID           int
+	UUID         string // the same in every database the conversation is merged into
+	Title        string
+	Created      string
+	Model        string // model of the latest turn
+	Role         string
+	SystemPrompt string
+	Starred      bool
+	Archived     bool
+	Tags         []string
+	ParentID     int // conversation this was forked from, or 0
+	ParentTurn   int // turn of the parent it was forked after
+}
+
+// Turn is one prompt and the response to it, which is what both the CLI and
+// the TUI record after each exchange.
+type Turn struct {
+	ConvID       int
+	Prompt       string
+	Response     string
+	Model        string
+	Temperature  float32
+	InputTokens  int32
+	OutputTokens int32
+	// Role and SystemPrompt are recorded on the conversation by its first
+	// turn that has them, and on the response like the rest of these
+	Role         string
+	SystemPrompt string
+	Provider     string
+	Thinking     string
+	MaxTokens    int
+	FinishReason string        // why the model stopped, as the provider put it
+	Error        string        // why the request failed; Response is what arrived first
+	Latency      time.Duration // from sending the request to the end of the response
 }
 
 // Retun errors to the caller in case we want to ignore them. That is, just
 // because we can't store the conversations in the database doesn't mean we
 // should stop the program.
 func NewDB(dbPath string, dbTable string) (*ChatDB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	sqlDB, err := OpenDB(dbPath, dbTable)
 	if err != nil {
-		return nil, fmt.Errorf("%v", err)
+		return nil, err
 	}
 
-	_, err = db.Exec(DBSchema(dbTable))
+	// Only a new database gets the latest schema here, by running every
+	// migration; an older one keeps its tables until InitializeDB migrates
+	// them.
+	version, err := sqlDB.Version()
 	if err != nil {
-		return nil, fmt.Errorf("error creating %s table: %v", dbTable, err)
+		return nil, err
+	}
+	if version == 0 {
+		_, _, err = sqlDB.Migrate(SchemaVersion, false)
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s table: %v", dbTable, err)
+		}
+		return sqlDB, nil
+	}
+
+	// Older schemas have no messages table to index until they're migrated
+	if version >= 4 {
+		err = sqlDB.ensureSearchIndex()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sqlDB, nil
+}
+
+// dsn adds the connection settings that let several ask-ai processes share
+// the history file: WAL so readers don't block the writer, a busy timeout so
+// writers wait for each other instead of failing with SQLITE_BUSY, and
+// immediate transactions so a read-then-write transaction can't deadlock on
+// the upgrade to a write lock.
+func dsn(dbPath string) string {
+	if dbPath == ":memory:" {
+		return dbPath
 	}
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return dbPath + sep + "_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+}
 
-	sqlDB := ChatDB{}
-	sqlDB.db = db
-	sqlDB.dbTable = dbTable
-	return &sqlDB, nil
+// NewConversationID reserves the next conversation ID by inserting an empty
+// conversation, so concurrent processes can't hand out the same ID. Sessions
+// only call it, through StartConversation, as they record their first turn.
+func (sqlDB *ChatDB) NewConversationID() (int, error) {
+	res, err := sqlDB.db.Exec(`INSERT INTO `+sqlDB.dbTable+` (uuid) VALUES (?);`, uuid.NewString())
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%v", err)
+	}
+	return int(id), nil
 }
 
 func (sqlDB *ChatDB) InsertConversation(
@@ -47,14 +181,90 @@ func (sqlDB *ChatDB) InsertConversation(
 	outputTokens int32,
 	convID int,
 ) error {
-	_, err := sqlDB.db.Exec(`
-		INSERT INTO `+sqlDB.dbTable+` (prompt, response, model_name, temperature, input_tokens, output_tokens, conv_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?);
-	`, prompt, response, modelName, temperature, inputTokens, outputTokens, convID)
+	return sqlDB.InsertTurn(Turn{
+		ConvID:       convID,
+		Prompt:       prompt,
+		Response:     response,
+		Model:        modelName,
+		Temperature:  temperature,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+	})
+}
+
+// InsertTurn appends the prompt and response as the next two messages of the
+// conversation, creating the conversation on its first turn.
+func (sqlDB *ChatDB) InsertTurn(turn Turn) error {
+	systemPrompt, err := sqlDB.encryptField(turn.SystemPrompt)
+	if err != nil {
+		return err
+	}
+	tx, err := sqlDB.db.Begin()
 	if err != nil {
 		return fmt.Errorf("%v", err)
 	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.dbTable+` (id, uuid, model, role, system_prompt)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			model = excluded.model,
+			role = CASE WHEN role = '' THEN excluded.role ELSE role END,
+			system_prompt = CASE WHEN system_prompt = '' THEN excluded.system_prompt ELSE system_prompt END;
+	`, turn.ConvID, uuid.NewString(), turn.Model, turn.Role, systemPrompt)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	var seq int
+	err = tx.QueryRow(`
+		SELECT COALESCE(MAX(seq) + 1, 0) FROM `+sqlDB.msgTable+` WHERE conversation_id = ?;
+	`, turn.ConvID).Scan(&seq)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	if err := sqlDB.insertTurnMessages(tx, turn, seq); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
+// insertTurnMessages stores the turn's prompt at seq and its response, with
+// the request's metadata, after it
+func (sqlDB *ChatDB) insertTurnMessages(tx *sql.Tx, turn Turn, seq int) error {
+	prompt, err := sqlDB.encrypt(turn.Prompt)
+	if err != nil {
+		return err
+	}
+	response, err := sqlDB.encrypt(turn.Response)
+	if err != nil {
+		return err
+	}
+	systemPrompt, err := sqlDB.encryptField(turn.SystemPrompt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.msgTable+` (conversation_id, seq, role, content, tokens, model, temperature)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, turn.ConvID, seq, "user", prompt, turn.InputTokens, turn.Model, turn.Temperature)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO `+sqlDB.msgTable+` (conversation_id, seq, role, content, tokens, model, temperature,
+			provider, system_prompt, role_name, thinking, max_tokens, finish_reason, error, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`, turn.ConvID, seq+1, "assistant", response, turn.OutputTokens, turn.Model, turn.Temperature,
+		turn.Provider, systemPrompt, turn.Role, turn.Thinking, nullInt(turn.MaxTokens), turn.FinishReason, turn.Error,
+		nullInt(int(turn.Latency.Milliseconds())))
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
 	return nil
 }
 
@@ -65,66 +275,128 @@ func (sqlDB *ChatDB) Close() {
 	}
 }
 
-// Return LLMConversations for a given conv_id. This will require generating
-// the LLMConversations from a single row in the DB as the LLMConversations
-// structure has one etnry for the user role with prompt and another with the
-// assistant role and response.
-func (sqlDB *ChatDB) LoadConversationFromDB(convID int) ([]LLM.LLMConversations, error) {
+// Message is a row of the messages table. Responses also record how they
+// were asked for, as their Turn had it.
+type Message struct {
+	Seq          int
+	Role         string
+	Content      string
+	Model        string
+	Temperature  float32
+	Timestamp    string
+	Tokens       int32
+	Provider     string
+	SystemPrompt string
+	RoleName     string
+	Thinking     string
+	MaxTokens    int
+	FinishReason string
+	Error        string
+	Latency      time.Duration
+}
+
+// Messages returns the conversation's messages in order
+func (sqlDB *ChatDB) Messages(convID int) ([]Message, error) {
 	rows, err := sqlDB.db.Query(`
-		SELECT prompt, response, model_name, timestamp, temperature, input_tokens, output_tokens, conv_id
-		FROM `+sqlDB.dbTable+` WHERE conv_id = ?;
+		SELECT seq, role, content, model, COALESCE(temperature, 0), timestamp, COALESCE(tokens, 0),
+			provider, system_prompt, role_name, thinking, COALESCE(max_tokens, 0), finish_reason, error,
+			COALESCE(latency_ms, 0)
+		FROM `+sqlDB.msgTable+` WHERE conversation_id = ? ORDER BY seq;
 	`, convID)
 	if err != nil {
 		return nil, fmt.Errorf("%v", err)
 	}
 	defer rows.Close()
 
-	var row struct {
-		prompt       string
-		response     string
-		modelName    string
-		timestamp    string
-		temperature  float32
-		inputTokens  int32
-		outputTokens int32
-		convID       int
-	}
-	var conversations []LLM.LLMConversations
+	var msgs []Message
 	for rows.Next() {
-		err := rows.Scan(&row.prompt, &row.response, &row.modelName, &row.timestamp, &row.temperature, &row.inputTokens, &row.outputTokens, &row.convID)
+		var m Message
+		var latency int64
+		err := rows.Scan(&m.Seq, &m.Role, &m.Content, &m.Model, &m.Temperature, &m.Timestamp, &m.Tokens,
+			&m.Provider, &m.SystemPrompt, &m.RoleName, &m.Thinking, &m.MaxTokens, &m.FinishReason, &m.Error, &latency)
 		if err != nil {
 			return nil, fmt.Errorf("%v", err)
 		}
-
-		userTurn := LLM.LLMConversations{
-			Role:         "user",
-			Content:      row.prompt,
-			Model:        row.modelName,
-			Timestamp:    row.timestamp,
-			InputTokens:  row.inputTokens,
-			OutputTokens: 0,
-			ConvID:       row.convID,
+		if m.Content, err = sqlDB.decrypt(m.Content); err != nil {
+			return nil, err
+		}
+		if m.SystemPrompt, err = sqlDB.decrypt(m.SystemPrompt); err != nil {
+			return nil, err
 		}
-		conversations = append(conversations, userTurn)
+		m.Latency = time.Duration(latency) * time.Millisecond
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
 
-		assistantTurn := LLM.LLMConversations{
-			Role:         "assistant",
-			Content:      row.response,
-			Model:        row.modelName,
-			Timestamp:    row.timestamp,
-			InputTokens:  row.inputTokens,
-			OutputTokens: row.outputTokens,
-			ConvID:       row.convID,
+// Return LLMConversations for a given conversation ID, one per message
+func (sqlDB *ChatDB) LoadConversationFromDB(convID int) ([]LLM.LLMConversations, error) {
+	msgs, err := sqlDB.Messages(convID)
+	if err != nil {
+		return nil, err
+	}
+	return conversationContext(convID, msgs), nil
+}
+
+// LoadRecentTurns returns the last n turns recorded in any conversation but
+// archived ones, oldest first, as context for a new conversation. Turns
+// recorded the same second go by conversation.
+func (sqlDB *ChatDB) LoadRecentTurns(n int) ([]LLM.LLMConversations, error) {
+	rows, err := sqlDB.db.Query(`
+		SELECT u.conversation_id, u.content, u.model, u.timestamp, COALESCE(u.tokens, 0),
+			a.content, COALESCE(a.model, ''), COALESCE(a.timestamp, ''), COALESCE(a.tokens, 0)
+		FROM `+sqlDB.msgTable+` u
+		JOIN `+sqlDB.dbTable+` c ON c.id = u.conversation_id
+		LEFT JOIN `+sqlDB.msgTable+` a
+			ON a.conversation_id = u.conversation_id AND a.seq = u.seq + 1 AND a.role = 'assistant'
+		WHERE u.role = 'user' AND NOT c.archived
+		ORDER BY u.timestamp DESC, u.conversation_id DESC, u.seq DESC
+		LIMIT ?;
+	`, n)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var turns [][]LLM.LLMConversations
+	for rows.Next() {
+		var convID int
+		var response sql.NullString
+		prompt := Message{Role: "user"}
+		answer := Message{Role: "assistant"}
+		err := rows.Scan(&convID, &prompt.Content, &prompt.Model, &prompt.Timestamp, &prompt.Tokens,
+			&response, &answer.Model, &answer.Timestamp, &answer.Tokens)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		if prompt.Content, err = sqlDB.decrypt(prompt.Content); err != nil {
+			return nil, err
+		}
+		msgs := []Message{prompt}
+		if response.Valid {
+			if answer.Content, err = sqlDB.decrypt(response.String); err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, answer)
 		}
-		conversations = append(conversations, assistantTurn)
+		turns = append(turns, conversationContext(convID, msgs))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%v", err)
 	}
 
-	return conversations, nil
+	var context []LLM.LLMConversations
+	for _, turn := range slices.Backward(turns) {
+		context = append(context, turn...)
+	}
+	return context, nil
 }
 
-// GetLastConversationID returns the highest conversation ID, or 0 if none exist
+// GetLastConversationID returns the highest ID of a conversation with at
+// least one message, or 0 if none exist. IDs reserved by NewConversationID
+// that haven't been used yet are skipped.
 func (sqlDB *ChatDB) GetLastConversationID() (int, error) {
-	query := `SELECT MAX(conv_id) FROM ` + sqlDB.dbTable + `;`
+	query := `SELECT MAX(conversation_id) FROM ` + sqlDB.msgTable + `;`
 	row := sqlDB.db.QueryRow(query)
 	// Use sql.NullInt64 to handle NULL when no rows
 	var maxID sql.NullInt64
@@ -137,11 +409,14 @@ func (sqlDB *ChatDB) GetLastConversationID() (int, error) {
 	return int(maxID.Int64), nil
 }
 
-// ListConversationIDs returns all distinct conversation IDs, sorted ascending
+// ListConversationIDs returns the IDs of all conversations with at least one
+// message, sorted ascending
 func (sqlDB *ChatDB) ListConversationIDs() ([]int, error) {
-	rows, err := sqlDB.db.Query(
-		`SELECT DISTINCT conv_id FROM ` + sqlDB.dbTable + ` ORDER BY conv_id;`,
-	)
+	rows, err := sqlDB.db.Query(`
+		SELECT c.id FROM ` + sqlDB.dbTable + ` c
+		WHERE EXISTS (SELECT 1 FROM ` + sqlDB.msgTable + ` m WHERE m.conversation_id = c.id)
+		ORDER BY c.id;
+	`)
 	if err != nil {
 		return nil, fmt.Errorf("%v", err)
 	}
@@ -149,13 +424,11 @@ func (sqlDB *ChatDB) ListConversationIDs() ([]int, error) {
 
 	var ids []int
 	for rows.Next() {
-		var id sql.NullInt64
+		var id int
 		if err := rows.Scan(&id); err != nil {
 			return nil, fmt.Errorf("%v", err)
 		}
-		if id.Valid {
-			ids = append(ids, int(id.Int64))
-		}
+		ids = append(ids, id)
 	}
 	return ids, nil
 }
@@ -164,10 +437,11 @@ func (sqlDB *ChatDB) ListConversationIDs() ([]int, error) {
 // response at the minimum can be returned. But may want prompt too. May want
 // everything.
 func (sqlDB *ChatDB) SearchForConversation(keyword string) ([]int, error) {
-	// Search both prompt and response for the keyword, return distinct conversation IDs
+	// Search every message for the keyword, return distinct conversation IDs
+	content, args := sqlDB.plaintext(`content`)
 	rows, err := sqlDB.db.Query(`
-       SELECT DISTINCT conv_id FROM `+sqlDB.dbTable+` WHERE prompt LIKE ? OR response LIKE ?;
-   `, "%"+keyword+"%", "%"+keyword+"%")
+		SELECT DISTINCT conversation_id FROM `+sqlDB.msgTable+` WHERE `+content+` LIKE ? ORDER BY conversation_id;
+	`, append(args, "%"+keyword+"%")...)
 	if err != nil {
 		return nil, fmt.Errorf("%v", err)
 	}
@@ -175,67 +449,293 @@ func (sqlDB *ChatDB) SearchForConversation(keyword string) ([]int, error) {
 
 	var convIDs []int
 	for rows.Next() {
-		var id sql.NullInt64
+		var id int
 		if err := rows.Scan(&id); err != nil {
 			return nil, fmt.Errorf("%v", err)
 		}
-		if id.Valid {
-			convIDs = append(convIDs, int(id.Int64))
-		}
+		convIDs = append(convIDs, id)
 	}
 	return convIDs, nil
 }
 
+// GetModel returns the model of the latest message in the conversation, or ""
+// if the conversation doesn't exist
 func (sqlDB *ChatDB) GetModel(convID int) (string, error) {
-	rows, err := sqlDB.db.Query(`
-		SELECT model_name FROM `+sqlDB.dbTable+` WHERE conv_id = ?;
-	`, convID)
+	var model string
+	err := sqlDB.db.QueryRow(`
+		SELECT model FROM `+sqlDB.msgTable+` WHERE conversation_id = ? ORDER BY seq DESC LIMIT 1;
+	`, convID).Scan(&model)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v", err)
+	}
+
+	return model, nil
+}
+
+// GetTitle returns the conversation's title, or "" if it has none
+func (sqlDB *ChatDB) GetTitle(convID int) (string, error) {
+	var title string
+	err := sqlDB.db.QueryRow(`SELECT title FROM `+sqlDB.dbTable+` WHERE id = ?;`, convID).Scan(&title)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
 	if err != nil {
 		return "", fmt.Errorf("%v", err)
 	}
+	return sqlDB.decrypt(title)
+}
+
+// SetTitle sets the conversation's title
+func (sqlDB *ChatDB) SetTitle(convID int, title string) error {
+	title, err := sqlDB.encryptField(title)
+	if err != nil {
+		return err
+	}
+	res, err := sqlDB.db.Exec(`UPDATE `+sqlDB.dbTable+` SET title = ? WHERE id = ?;`, title, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFound(convID)
+	}
+	return nil
+}
+
+// SetTitleIfEmpty sets the title unless the conversation already has one,
+// so a generated title never replaces one set by hand in the meantime. It
+// reports whether the title was set.
+func (sqlDB *ChatDB) SetTitleIfEmpty(convID int, title string) (bool, error) {
+	title, err := sqlDB.encryptField(title)
+	if err != nil {
+		return false, err
+	}
+	res, err := sqlDB.db.Exec(`UPDATE `+sqlDB.dbTable+` SET title = ? WHERE id = ? AND title = '';`, title, convID)
+	if err != nil {
+		return false, fmt.Errorf("%v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%v", err)
+	}
+	return n > 0, nil
+}
+
+// titleFailedSetting, followed by a conversation ID, names the settings row
+// that marks titling the conversation as having failed
+const titleFailedSetting = "title_failed:"
+
+// MarkTitleFailed records that the title model couldn't name the conversation
+func (sqlDB *ChatDB) MarkTitleFailed(convID int) error {
+	res, err := sqlDB.db.Exec(`INSERT OR REPLACE INTO `+settingsTable(sqlDB.dbTable)+` (name, value)
+		SELECT ?, '1' WHERE EXISTS (SELECT 1 FROM `+sqlDB.dbTable+` WHERE id = ?);`,
+		titleFailedSetting+strconv.Itoa(convID), convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFound(convID)
+	}
+	return nil
+}
+
+// TitleFailedIDs returns the conversations MarkTitleFailed marked, sorted
+// ascending
+func (sqlDB *ChatDB) TitleFailedIDs() ([]int, error) {
+	rows, err := sqlDB.db.Query(`SELECT name FROM `+settingsTable(sqlDB.dbTable)+` WHERE name LIKE ? || '%';`, titleFailedSetting)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
 	defer rows.Close()
 
-	var model string
+	var ids []int
 	for rows.Next() {
-		err := rows.Scan(&model)
-		if err != nil {
-			return "", fmt.Errorf("%v", err)
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		if id, err := strconv.Atoi(strings.TrimPrefix(name, titleFailedSetting)); err == nil {
+			ids = append(ids, id)
 		}
 	}
-
-	return model, nil
+	slices.Sort(ids)
+	return ids, rows.Err()
 }
 
-func (sqlDB *ChatDB) ShowConversation(convID int) {
+// UntitledConversationIDs returns the conversations with messages but no
+// title, sorted ascending
+func (sqlDB *ChatDB) UntitledConversationIDs() ([]int, error) {
 	rows, err := sqlDB.db.Query(`
-		SELECT prompt, response, model_name, temperature, input_tokens, output_tokens, conv_id
-		FROM `+sqlDB.dbTable+` WHERE conv_id = ?;
-	`, convID)
+		SELECT c.id FROM ` + sqlDB.dbTable + ` c
+		WHERE c.title = ''
+			AND EXISTS (SELECT 1 FROM ` + sqlDB.msgTable + ` m WHERE m.conversation_id = c.id)
+		ORDER BY c.id;
+	`)
 	if err != nil {
-		log.Fatalf("error showing conversation: %v", err)
+		return nil, fmt.Errorf("%v", err)
 	}
 	defer rows.Close()
 
-	var row struct {
-		prompt       string
-		response     string
-		modelName    string
-		temperature  float32
-		inputTokens  int32
-		outputTokens int32
-		convID       int
-	}
+	var ids []int
 	for rows.Next() {
-		err := rows.Scan(&row.prompt, &row.response, &row.modelName, &row.temperature, &row.inputTokens, &row.outputTokens, &row.convID)
-		if err != nil {
-			log.Fatalf("error showing conversation: %v", err)
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%v", err)
 		}
-		fmt.Printf("Prompt: %s\n", row.prompt)
-		fmt.Printf("Response: %s\n", row.response)
-		fmt.Printf("Model: %s\n", row.modelName)
-		fmt.Printf("Temperature: %f\n", row.temperature)
-		fmt.Printf("Input tokens: %d\n", row.inputTokens)
-		fmt.Printf("Output tokens: %d\n", row.outputTokens)
-		fmt.Printf("Conversation ID: %d\n", row.convID)
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetConversation returns the conversation's metadata
+func (sqlDB *ChatDB) GetConversation(convID int) (Conversation, error) {
+	conv := Conversation{ID: convID}
+	err := sqlDB.db.QueryRow(`
+		SELECT COALESCE(uuid, ''), title, created, model, role, system_prompt, starred, archived,
+			COALESCE(parent_id, 0), COALESCE(parent_turn, 0)
+		FROM `+sqlDB.dbTable+` WHERE id = ?;
+	`, convID).Scan(&conv.UUID, &conv.Title, &conv.Created, &conv.Model, &conv.Role, &conv.SystemPrompt, &conv.Starred, &conv.Archived,
+		&conv.ParentID, &conv.ParentTurn)
+	if err == sql.ErrNoRows {
+		return conv, notFound(convID)
+	}
+	if err != nil {
+		return conv, fmt.Errorf("%v", err)
+	}
+	if conv.Title, err = sqlDB.decrypt(conv.Title); err != nil {
+		return conv, err
+	}
+	if conv.SystemPrompt, err = sqlDB.decrypt(conv.SystemPrompt); err != nil {
+		return conv, err
+	}
+	conv.Tags, err = sqlDB.Tags(convID)
+	return conv, err
+}
+
+func (sqlDB *ChatDB) ShowConversation(convID int) {
+	err := sqlDB.WriteConversation(os.Stdout, convID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Fatalf("error showing conversation: %v", err)
+	}
+}
+
+// WriteConversation writes the conversation as ShowConversation prints it
+func (sqlDB *ChatDB) WriteConversation(w io.Writer, convID int) error {
+	conv, err := sqlDB.GetConversation(convID)
+	if err != nil {
+		return err
+	}
+	msgs, err := sqlDB.Messages(convID)
+	if err != nil {
+		return err
+	}
+	forks, err := sqlDB.Forks(convID)
+	if err != nil {
+		return err
+	}
+	return writeConversation(w, convID, conv, msgs, forks)
+}
+
+// writeConversation writes the conversation's metadata and its turns, with
+// the forks of each after it
+func writeConversation(w io.Writer, convID int, conv Conversation, msgs []Message, forks []Fork) error {
+	var b strings.Builder
+	if conv.Title != "" {
+		fmt.Fprintf(&b, "Title: %s\n", conv.Title)
+	}
+	if len(conv.Tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s\n", strings.Join(conv.Tags, ", "))
+	}
+	if conv.Starred {
+		fmt.Fprintln(&b, "Starred")
+	}
+	if conv.Archived {
+		fmt.Fprintln(&b, "Archived")
+	}
+	if conv.Role != "" {
+		fmt.Fprintf(&b, "Role: %s\n", conv.Role)
+	}
+	if conv.SystemPrompt != "" {
+		fmt.Fprintf(&b, "System prompt: %s\n", conv.SystemPrompt)
+	}
+	if conv.ParentID != 0 {
+		fmt.Fprintf(&b, "Forked from conversation %d at turn %d\n", conv.ParentID, conv.ParentTurn)
+	}
+
+	// Forks are listed after the turn they branched at
+	showForks := func(turn int) {
+		for _, f := range forks {
+			if f.Turn == turn {
+				fmt.Fprintf(&b, "Forked here: conversation %d\n", f.ConvID)
+			}
+		}
+	}
+
+	var prompt *Message
+	turn := 0
+	for i := range msgs {
+		m := &msgs[i]
+		if m.Role == "user" {
+			if prompt != nil {
+				// The previous prompt got no response
+				fmt.Fprintf(&b, "Prompt: %s\n", prompt.Content)
+				fmt.Fprintf(&b, "Conversation ID: %d\n", convID)
+				showForks(turn)
+			}
+			prompt = m
+			turn++
+			continue
+		}
+		if prompt != nil {
+			fmt.Fprintf(&b, "Prompt: %s\n", prompt.Content)
+		}
+		fmt.Fprintf(&b, "Response: %s\n", m.Content)
+		if m.Provider != "" {
+			fmt.Fprintf(&b, "Provider: %s\n", m.Provider)
+		}
+		fmt.Fprintf(&b, "Model: %s\n", m.Model)
+		fmt.Fprintf(&b, "Temperature: %f\n", m.Temperature)
+		if m.MaxTokens != 0 {
+			fmt.Fprintf(&b, "Max tokens: %d\n", m.MaxTokens)
+		}
+		if m.Thinking != "" {
+			fmt.Fprintf(&b, "Thinking: %s\n", m.Thinking)
+		}
+		// The conversation's role and system prompt are shown above; only
+		// turns asked differently need theirs
+		if m.RoleName != "" && m.RoleName != conv.Role {
+			fmt.Fprintf(&b, "Role: %s\n", m.RoleName)
+		}
+		if m.SystemPrompt != "" && m.SystemPrompt != conv.SystemPrompt {
+			fmt.Fprintf(&b, "System prompt: %s\n", m.SystemPrompt)
+		}
+		if m.FinishReason != "" {
+			fmt.Fprintf(&b, "Finish reason: %s\n", m.FinishReason)
+		}
+		if m.Error != "" {
+			fmt.Fprintf(&b, "Error: %s\n", m.Error)
+		}
+		if m.Latency != 0 {
+			fmt.Fprintf(&b, "Latency: %s\n", m.Latency.Round(time.Millisecond))
+		}
+		if prompt != nil {
+			fmt.Fprintf(&b, "Input tokens: %d\n", prompt.Tokens)
+		}
+		fmt.Fprintf(&b, "Output tokens: %d\n", m.Tokens)
+		fmt.Fprintf(&b, "Conversation ID: %d\n", convID)
+		if prompt != nil {
+			showForks(turn)
+		}
+		prompt = nil
+	}
+	if prompt != nil {
+		// A prompt whose response was never recorded
+		fmt.Fprintf(&b, "Prompt: %s\n", prompt.Content)
+		fmt.Fprintf(&b, "Conversation ID: %d\n", convID)
+		showForks(turn)
 	}
+	_, err := io.WriteString(w, b.String())
+	return err
 }
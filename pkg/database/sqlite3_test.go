@@ -1,9 +1,15 @@
 package database
 
 import (
+	"database/sql"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
@@ -17,7 +23,7 @@ const (
 func TestMain(m *testing.M) {
 	code := m.Run()
 
-	os.Remove(dbPath)
+	RemoveDB()
 
 	os.Exit(code)
 }
@@ -81,6 +87,9 @@ func TestShowConversation(t *testing.T) {
 	assert.Nil(t, err)
 	os.Stdout = w
 
+	assert.Nil(t, db.SetTitle(7, "A title"))
+	assert.Nil(t, db.AddTags(7, "work", "ideas"))
+	assert.Nil(t, db.SetStarred(7, true))
 	db.ShowConversation(7)
 
 	w.Close()
@@ -89,6 +98,9 @@ func TestShowConversation(t *testing.T) {
 	output, err := io.ReadAll(r)
 	assert.Nil(t, err)
 	outStr := string(output)
+	assert.Contains(t, outStr, "Title: A title")
+	assert.Contains(t, outStr, "Tags: ideas, work")
+	assert.Contains(t, outStr, "Starred")
 	assert.Contains(t, outStr, "Prompt: prompt")
 	assert.Contains(t, outStr, "Response: response")
 	assert.Contains(t, outStr, "Model: model_name")
@@ -98,6 +110,57 @@ func TestShowConversation(t *testing.T) {
 	assert.Contains(t, outStr, "Conversation ID: 7")
 }
 
+// TestShowConversationMetadata verifies that ShowConversation prints how each
+// response was asked for and how it ended
+func TestShowConversationMetadata(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	turns := []Turn{
+		{ConvID: 1, Prompt: "first", Response: "one", Model: "m", Provider: "openai", Role: "coder", SystemPrompt: "be brief",
+			Thinking: "high", MaxTokens: 512, FinishReason: "stop", Latency: 1500 * time.Millisecond},
+		{ConvID: 1, Prompt: "second", Response: "tw", Model: "m", Provider: "openai", Role: "poet", SystemPrompt: "rhyme",
+			MaxTokens: 2, FinishReason: "length"},
+		{ConvID: 1, Prompt: "third", Model: "m", Provider: "openai", Error: "connection reset"},
+	}
+	for _, turn := range turns {
+		assert.Nil(t, db.InsertTurn(turn))
+	}
+	msgs, err := db.Messages(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "", msgs[0].Provider)
+	assert.Equal(t, "openai", msgs[1].Provider)
+	assert.Equal(t, "coder", msgs[1].RoleName)
+	assert.Equal(t, 512, msgs[1].MaxTokens)
+	assert.Equal(t, 1500*time.Millisecond, msgs[1].Latency)
+	assert.Zero(t, msgs[3].Latency)
+	assert.Equal(t, "connection reset", msgs[5].Error)
+
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	os.Stdout = w
+	db.ShowConversation(1)
+	w.Close()
+	os.Stdout = oldStdout
+
+	output, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	outStr := string(output)
+	assert.Contains(t, outStr, "Provider: openai")
+	assert.Contains(t, outStr, "Max tokens: 512")
+	assert.Contains(t, outStr, "Thinking: high")
+	assert.Contains(t, outStr, "Finish reason: stop")
+	assert.Contains(t, outStr, "Finish reason: length")
+	assert.Contains(t, outStr, "Error: connection reset")
+	assert.Equal(t, 1, strings.Count(outStr, "Latency: 1.5s"))
+	// The conversation's role is shown once; the turn that differs shows its own
+	assert.Equal(t, 1, strings.Count(outStr, "Role: coder"))
+	assert.Contains(t, outStr, "Role: poet")
+	assert.Contains(t, outStr, "System prompt: rhyme")
+}
+
 func TestNewDB(t *testing.T) {
 	db, err := NewDB(dbPath, dbTable)
 	assert.Nil(t, err)
@@ -189,6 +252,324 @@ func TestSearchForConversation(t *testing.T) {
 	RemoveDB()
 }
 
+func TestInsertTurnRecordsConversation(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	err = db.InsertTurn(Turn{ConvID: 1, Prompt: "p1", Response: "r1", Model: "m1", InputTokens: 3, OutputTokens: 4, Role: "coder", SystemPrompt: "be brief"})
+	assert.Nil(t, err)
+	err = db.InsertTurn(Turn{ConvID: 1, Prompt: "p2", Response: "r2", Model: "m2", InputTokens: 5, OutputTokens: 6})
+	assert.Nil(t, err)
+
+	var role, systemPrompt, model string
+	err = db.db.QueryRow(`SELECT role, system_prompt, model FROM `+dbTable+` WHERE id = 1`).Scan(&role, &systemPrompt, &model)
+	assert.Nil(t, err)
+	assert.Equal(t, "coder", role)
+	assert.Equal(t, "be brief", systemPrompt)
+	assert.Equal(t, "m2", model)
+
+	conv, err := db.LoadConversationFromDB(1)
+	assert.Nil(t, err)
+	assert.Len(t, conv, 4)
+	assert.Equal(t, "p2", conv[2].Content)
+	assert.Equal(t, "user", conv[2].Role)
+	assert.Equal(t, int32(5), conv[3].InputTokens)
+	assert.Equal(t, int32(6), conv[3].OutputTokens)
+}
+
+// createV3DB builds a database the way v3 of the schema left it
+func createV3DB(t *testing.T) {
+	raw, err := sql.Open("sqlite3", dbPath)
+	assert.Nil(t, err)
+	defer raw.Close()
+
+	for v := 1; v <= 3; v++ {
+		_, err = raw.Exec(migrations[v-1].Up(dbTable))
+		assert.Nil(t, err)
+	}
+	_, err = raw.Exec(`
+		INSERT INTO ` + dbTable + ` (timestamp, prompt, response, model_name, temperature, input_tokens, output_tokens, conv_id) VALUES
+			('2024-01-01 10:00:00', 'old q', 'old a', 'gpt-3', 0.7, NULL, NULL, NULL),
+			('2024-02-01 10:00:00', 'p1', 'r1', 'm1', 0.5, 10, 20, 2),
+			('2024-02-01 10:01:00', 'p2', 'r2', 'm2', 0.5, 11, 21, 2),
+			('2024-03-01 10:00:00', 'other q', 'other a', 'gpt-3', 0.7, NULL, NULL, NULL),
+			('2024-03-02 10:00:00', 'p3', 'r3', 'm3', 0.2, 12, 22, 5);
+	`)
+	assert.Nil(t, err)
+}
+
+func TestMigrateV3ToV4(t *testing.T) {
+	RemoveDB()
+	createV3DB(t)
+	defer RemoveDB()
+
+	db, err := InitializeDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	var version int
+	assert.Nil(t, db.db.QueryRow("PRAGMA user_version").Scan(&version))
+	assert.Equal(t, SchemaVersion, version)
+
+	// Rows without a conv_id become conversations after the highest ID
+	ids, err := db.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{2, 5, 6, 7}, ids)
+
+	conv, err := db.LoadConversationFromDB(2)
+	assert.Nil(t, err)
+	assert.Len(t, conv, 4)
+	assert.Equal(t, []string{"p1", "r1", "p2", "r2"}, []string{conv[0].Content, conv[1].Content, conv[2].Content, conv[3].Content})
+	assert.Contains(t, conv[3].Timestamp, "10:01:00")
+	assert.Equal(t, int32(11), conv[3].InputTokens)
+	assert.Equal(t, int32(21), conv[3].OutputTokens)
+
+	conv, err = db.LoadConversationFromDB(7)
+	assert.Nil(t, err)
+	assert.Len(t, conv, 2)
+	assert.Equal(t, "other q", conv[0].Content)
+
+	model, err := db.GetModel(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "m2", model)
+
+	var created, convModel string
+	err = db.db.QueryRow(`SELECT created, model FROM `+dbTable+` WHERE id = 2`).Scan(&created, &convModel)
+	assert.Nil(t, err)
+	assert.Contains(t, created, "2024-02-01")
+	assert.Equal(t, "m2", convModel)
+
+	last, err := db.GetLastConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, 7, last)
+
+	// The old table is gone
+	var n int
+	err = db.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = ?`, dbTable+"_v3").Scan(&n)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// v5 adds tags and flags to the migrated conversations
+	assert.Nil(t, db.AddTags(2, "old"))
+	assert.Nil(t, db.SetStarred(2, true))
+	c, err := db.GetConversation(2)
+	assert.Nil(t, err)
+	assert.True(t, c.Starred)
+	assert.False(t, c.Archived)
+	assert.Equal(t, []string{"old"}, c.Tags)
+
+	// v6 records where imports came from
+	id, added, err := db.ImportConversation(ImportedConversation{
+		Source: "claude", SourceID: "x", Messages: []ImportedMessage{{Role: "user", Content: "q"}},
+	})
+	assert.Nil(t, err)
+	assert.True(t, added)
+	assert.Equal(t, 8, id)
+
+	// v7 remembers forks' parents
+	forkID, err := db.ForkConversation(2, 1)
+	assert.Nil(t, err)
+	c, err = db.GetConversation(forkID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, c.ParentID)
+	assert.Equal(t, 1, c.ParentTurn)
+
+	// v8 keeps the alternatives of retried turns
+	assert.Nil(t, db.ReplaceLastTurn(Turn{ConvID: 2, Prompt: "q", Response: "again", Model: "m"}))
+	alts, err := db.Alternatives(2)
+	assert.Nil(t, err)
+	assert.Len(t, alts, 2)
+
+	// v9 records request metadata on responses
+	assert.Nil(t, db.ReplaceLastTurn(Turn{ConvID: 2, Prompt: "q", Response: "cut", Model: "m", Provider: "p", FinishReason: "length"}))
+	msgs, err := db.Messages(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "p", msgs[len(msgs)-1].Provider)
+	assert.Equal(t, "length", msgs[len(msgs)-1].FinishReason)
+}
+
+func TestMigrateFromV1(t *testing.T) {
+	RemoveDB()
+	defer RemoveDB()
+
+	raw, err := sql.Open("sqlite3", dbPath)
+	assert.Nil(t, err)
+	_, err = raw.Exec(SchemaQueryV1(dbTable))
+	assert.Nil(t, err)
+	_, err = raw.Exec(`INSERT INTO ` + dbTable + ` (prompt, response, model_name, temperature) VALUES ('q', 'a', 'm', 0.1)`)
+	assert.Nil(t, err)
+	raw.Close()
+
+	db, err := InitializeDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	conv, err := db.LoadConversationFromDB(1)
+	assert.Nil(t, err)
+	assert.Len(t, conv, 2)
+	assert.Equal(t, "a", conv[1].Content)
+}
+
+func TestNewConversationID(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	id1, err := db.NewConversationID()
+	assert.Nil(t, err)
+	id2, err := db.NewConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, id1+1, id2)
+
+	// Reserved but unused IDs are neither listed nor continued
+	last, err := db.GetLastConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, last)
+	ids, err := db.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+
+	err = db.InsertTurn(Turn{ConvID: id1, Prompt: "p", Response: "r", Model: "m", Role: "coder"})
+	assert.Nil(t, err)
+	last, err = db.GetLastConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, id1, last)
+
+	var role string
+	assert.Nil(t, db.db.QueryRow(`SELECT role FROM `+dbTable+` WHERE id = ?`, id1).Scan(&role))
+	assert.Equal(t, "coder", role)
+}
+
+// TestParallelWriters runs several writers, each with its own connection
+// like separate ask-ai processes would have, against one database file
+func TestParallelWriters(t *testing.T) {
+	RemoveDB()
+	defer RemoveDB()
+
+	const writers = 8
+	const convsPerWriter = 10
+	const turnsPerConv = 3
+
+	dbs := make([]*ChatDB, writers)
+	for i := range dbs {
+		db, err := InitializeDB(dbPath, dbTable)
+		assert.Nil(t, err)
+		dbs[i] = db
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	allocated := make(chan int, writers*convsPerWriter)
+	for w, db := range dbs {
+		wg.Add(1)
+		go func(w int, db *ChatDB) {
+			defer wg.Done()
+			for c := 0; c < convsPerWriter; c++ {
+				id, err := db.NewConversationID()
+				if err != nil {
+					errs <- err
+					return
+				}
+				allocated <- id
+				for turn := 0; turn < turnsPerConv; turn++ {
+					err = db.InsertTurn(Turn{
+						ConvID:   id,
+						Prompt:   fmt.Sprintf("w%d c%d t%d", w, c, turn),
+						Response: fmt.Sprintf("w%d c%d r%d", w, c, turn),
+						Model:    "m",
+					})
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w, db)
+	}
+	wg.Wait()
+	close(errs)
+	close(allocated)
+	for err := range errs {
+		t.Fatalf("writer failed: %v", err)
+	}
+
+	seen := map[int]bool{}
+	for id := range allocated {
+		assert.False(t, seen[id], "conversation ID %d allocated twice", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, writers*convsPerWriter)
+
+	// Every conversation holds only its own writer's turns, in order
+	for id := range seen {
+		conv, err := dbs[0].LoadConversationFromDB(id)
+		assert.Nil(t, err)
+		assert.Len(t, conv, 2*turnsPerConv)
+		var w, c int
+		_, err = fmt.Sscanf(conv[0].Content, "w%d c%d", &w, &c)
+		assert.Nil(t, err)
+		for turn := 0; turn < turnsPerConv; turn++ {
+			assert.Equal(t, fmt.Sprintf("w%d c%d t%d", w, c, turn), conv[2*turn].Content)
+		}
+	}
+
+	var mode string
+	assert.Nil(t, dbs[0].db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	for _, db := range dbs {
+		db.Close()
+	}
+}
+
 func RemoveDB() {
+	// WAL mode leaves the log and shared-memory files next to the database
 	os.Remove(dbPath)
+	os.Remove(dbPath + "-wal")
+	os.Remove(dbPath + "-shm")
+	backups, _ := filepath.Glob(dbPath + ".v*.bak")
+	for _, backup := range backups {
+		os.Remove(backup)
+	}
+}
+
+func TestTitles(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+
+	assert.Nil(t, db.InsertConversation("p1", "r1", "m", 0.5, 1, 1, 1))
+	assert.Nil(t, db.InsertConversation("p2", "r2", "m", 0.5, 1, 1, 2))
+
+	ids, err := db.UntitledConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+
+	ok, err := db.SetTitleIfEmpty(1, "Generated")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// A title that's already there is kept
+	ok, err = db.SetTitleIfEmpty(1, "Again")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, db.SetTitle(2, "By hand"))
+	assert.ErrorIs(t, db.SetTitle(99, "x"), ErrNotFound)
+
+	title, err := db.GetTitle(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Generated", title)
+	title, err = db.GetTitle(99)
+	assert.Nil(t, err)
+	assert.Equal(t, "", title)
+
+	ids, err = db.UntitledConversationIDs()
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+
+	results, err := db.FindConversations(SearchFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, "Generated", results[0].Title)
+	assert.Equal(t, "By hand", results[1].Title)
 }
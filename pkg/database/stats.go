@@ -0,0 +1,143 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Groupings Stats can break its totals down by
+const (
+	StatsByModel    = "model"
+	StatsByProvider = "provider"
+	StatsByDay      = "day"
+	StatsByRole     = "role"
+)
+
+// StatsGroupings are the values StatsFilter.By takes, besides none
+var StatsGroupings = []string{StatsByModel, StatsByProvider, StatsByDay, StatsByRole}
+
+// StatsFilter selects the turns Stats summarizes; zero fields don't filter
+type StatsFilter struct {
+	Since time.Time // asked at or after
+	Until time.Time // asked before
+	By    string    // one of StatsGroupings, or "" for the totals alone
+}
+
+// StatsRow sums up a group of turns. A turn is a prompt and its response, if
+// it got one.
+type StatsRow struct {
+	Key           string // the model, provider, day (local YYYY-MM-DD) or role
+	Conversations int
+	Turns         int
+	InputTokens   int64
+	OutputTokens  int64
+	// AvgLatency is the mean response time over the turns that recorded
+	// one, which turns from before ask-ai did don't
+	AvgLatency time.Duration
+	Timed      int // turns AvgLatency is taken over
+}
+
+// Stats is a usage summary of the history
+type Stats struct {
+	Groups []StatsRow // by Key; empty without a grouping
+	Total  StatsRow
+}
+
+// Stats summarizes the turns asked in the filter's period, in total and by
+// the filter's grouping. Turns from before provider and role were recorded
+// are grouped under "unknown" and "none".
+func (sqlDB *ChatDB) Stats(filter StatsFilter) (Stats, error) {
+	var stats Stats
+
+	var key string
+	switch filter.By {
+	case "":
+		key = `''`
+	case StatsByModel:
+		key = `COALESCE(NULLIF(r.model, ''), NULLIF(p.model, ''), 'unknown')`
+	case StatsByProvider:
+		key = `COALESCE(NULLIF(r.provider, ''), 'unknown')`
+	case StatsByDay:
+		key = `date(p.timestamp, 'localtime')`
+	case StatsByRole:
+		key = `COALESCE(NULLIF(r.role_name, ''), NULLIF(c.role, ''), 'none')`
+	default:
+		return stats, fmt.Errorf("invalid stats grouping %q: must be one of %s", filter.By, strings.Join(StatsGroupings, ", "))
+	}
+
+	var where []string
+	var args []any
+	where = append(where, `p.role = 'user'`)
+	if !filter.Since.IsZero() {
+		where = append(where, `p.timestamp >= ?`)
+		args = append(args, filter.Since.UTC().Format(timestampLayout))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, `p.timestamp < ?`)
+		args = append(args, filter.Until.UTC().Format(timestampLayout))
+	}
+
+	// Each prompt joined with the response after it
+	query := `
+		SELECT ` + key + ` AS k, COUNT(DISTINCT p.conversation_id), COUNT(*),
+			COALESCE(SUM(p.tokens), 0), COALESCE(SUM(r.tokens), 0),
+			COALESCE(AVG(r.latency_ms), 0), COUNT(r.latency_ms)
+		FROM ` + sqlDB.msgTable + ` p
+		JOIN ` + sqlDB.dbTable + ` c ON c.id = p.conversation_id
+		LEFT JOIN ` + sqlDB.msgTable + ` r
+			ON r.conversation_id = p.conversation_id AND r.seq = p.seq + 1 AND r.role = 'assistant'
+		WHERE ` + strings.Join(where, ` AND `)
+
+	rows, err := sqlDB.db.Query(query+` GROUP BY k ORDER BY k;`, args...)
+	if err != nil {
+		return stats, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row StatsRow
+		var latency float64
+		err := rows.Scan(&row.Key, &row.Conversations, &row.Turns, &row.InputTokens, &row.OutputTokens, &latency, &row.Timed)
+		if err != nil {
+			return stats, fmt.Errorf("%v", err)
+		}
+		row.AvgLatency = time.Duration(latency * float64(time.Millisecond))
+		stats.Groups = append(stats.Groups, row)
+	}
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("%v", err)
+	}
+
+	// A conversation can be in several groups, so the total is counted
+	// rather than added up
+	stats.Total = StatsRow{Key: "total"}
+	for _, row := range stats.Groups {
+		stats.Total.Turns += row.Turns
+		stats.Total.InputTokens += row.InputTokens
+		stats.Total.OutputTokens += row.OutputTokens
+		stats.Total.AvgLatency += row.AvgLatency * time.Duration(row.Timed)
+		stats.Total.Timed += row.Timed
+	}
+	if stats.Total.Timed > 0 {
+		stats.Total.AvgLatency /= time.Duration(stats.Total.Timed)
+	}
+	err = sqlDB.db.QueryRow(`
+		SELECT COUNT(DISTINCT p.conversation_id) FROM `+sqlDB.msgTable+` p
+		WHERE `+strings.Join(where, ` AND `)+`;
+	`, args...).Scan(&stats.Total.Conversations)
+	if err != nil {
+		return stats, fmt.Errorf("%v", err)
+	}
+
+	if filter.By == "" {
+		stats.Groups = nil
+	}
+	return stats, nil
+}
+
+// ValidStatsGrouping reports whether by is one of StatsGroupings or empty
+func ValidStatsGrouping(by string) bool {
+	return by == "" || slices.Contains(StatsGroupings, by)
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func insertStatsFixtures(t *testing.T, db *ChatDB) {
+	turns := []Turn{
+		{ConvID: 1, Prompt: "p", Response: "r", Model: "gpt-4o", Provider: "openai", InputTokens: 10, OutputTokens: 100, Latency: time.Second},
+		{ConvID: 1, Prompt: "p", Response: "r", Model: "sonnet", Provider: "anthropic", InputTokens: 20, OutputTokens: 200, Latency: 3 * time.Second},
+		{ConvID: 2, Prompt: "p", Response: "r", Model: "gpt-4o", Provider: "openai", Role: "coder", InputTokens: 30, OutputTokens: 300},
+	}
+	for _, turn := range turns {
+		assert.Nil(t, db.InsertTurn(turn))
+	}
+	// A turn from before provider and latency were recorded
+	assert.Nil(t, db.InsertConversation("p", "r", "gpt-4o", 0.5, 40, 400, 3))
+
+	_, err := db.db.Exec(`UPDATE ` + db.msgTable + ` SET timestamp = '2024-05-01 12:00:00' WHERE conversation_id = 1`)
+	assert.Nil(t, err)
+	_, err = db.db.Exec(`UPDATE ` + db.msgTable + ` SET timestamp = '2024-05-03 12:00:00' WHERE conversation_id != 1`)
+	assert.Nil(t, err)
+}
+
+func TestStats(t *testing.T) {
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	insertStatsFixtures(t, db)
+
+	stats, err := db.Stats(StatsFilter{})
+	assert.Nil(t, err)
+	assert.Nil(t, stats.Groups)
+	assert.Equal(t, StatsRow{
+		Key: "total", Conversations: 3, Turns: 4, InputTokens: 100, OutputTokens: 1000,
+		AvgLatency: 2 * time.Second, Timed: 2,
+	}, stats.Total)
+
+	stats, err = db.Stats(StatsFilter{By: StatsByModel})
+	assert.Nil(t, err)
+	assert.Len(t, stats.Groups, 2)
+	assert.Equal(t, "gpt-4o", stats.Groups[0].Key)
+	assert.Equal(t, 3, stats.Groups[0].Conversations)
+	assert.Equal(t, 3, stats.Groups[0].Turns)
+	assert.Equal(t, int64(800), stats.Groups[0].OutputTokens)
+	assert.Equal(t, time.Second, stats.Groups[0].AvgLatency)
+	assert.Equal(t, "sonnet", stats.Groups[1].Key)
+	// Conversation 1 used both models but is only counted once in total
+	assert.Equal(t, 3, stats.Total.Conversations)
+	assert.Equal(t, 4, stats.Total.Turns)
+
+	stats, err = db.Stats(StatsFilter{By: StatsByProvider})
+	assert.Nil(t, err)
+	var keys []string
+	for _, g := range stats.Groups {
+		keys = append(keys, g.Key)
+	}
+	assert.Equal(t, []string{"anthropic", "openai", "unknown"}, keys)
+
+	stats, err = db.Stats(StatsFilter{By: StatsByRole})
+	assert.Nil(t, err)
+	assert.Equal(t, "coder", stats.Groups[0].Key)
+	assert.Equal(t, "none", stats.Groups[1].Key)
+	assert.Equal(t, 3, stats.Groups[1].Turns)
+
+	stats, err = db.Stats(StatsFilter{By: StatsByDay})
+	assert.Nil(t, err)
+	assert.Len(t, stats.Groups, 2)
+	assert.Equal(t, 2, stats.Groups[0].Turns)
+}
+
+func TestStatsSince(t *testing.T) {
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	insertStatsFixtures(t, db)
+
+	stats, err := db.Stats(StatsFilter{Since: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stats.Total.Conversations)
+	assert.Equal(t, 2, stats.Total.Turns)
+	assert.Equal(t, int64(70), stats.Total.InputTokens)
+	assert.Equal(t, 0, stats.Total.Timed)
+
+	stats, err = db.Stats(StatsFilter{Until: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stats.Total.Turns)
+
+	_, err = db.Stats(StatsFilter{By: "week"})
+	assert.ErrorContains(t, err, "invalid stats grouping")
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/duluk/ask-ai/pkg/LLM"
+)
+
+// Backends database.backend chooses between
+const (
+	BackendSQLite = "sqlite"
+	BackendJSONL  = "jsonl"
+	BackendMemory = "memory"
+)
+
+// Backends are the values database.backend takes
+var Backends = []string{BackendSQLite, BackendJSONL, BackendMemory}
+
+// Store keeps the conversation history: it records turns and titles, loads
+// conversations back, lists and searches them. ChatDB, the SQLite database,
+// is the default and the only store with tags, stars, forks, alternatives,
+// imports, statistics, encryption and retention; callers that need those
+// type-assert to *ChatDB.
+type Store interface {
+	// NewConversationID reserves the ID of a conversation about to record
+	// its first turn
+	NewConversationID() (int, error)
+	// InsertTurn appends the turn to its conversation, creating that on
+	// its first turn
+	InsertTurn(turn Turn) error
+	// ReplaceLastTurn replaces the conversation's last turn, as /retry and
+	// /edit do
+	ReplaceLastTurn(turn Turn) error
+
+	GetConversation(convID int) (Conversation, error)
+	Messages(convID int) ([]Message, error)
+	LoadConversationFromDB(convID int) ([]LLM.LLMConversations, error)
+	// LoadRecentTurns is the last n turns of any conversations, for --context
+	LoadRecentTurns(n int) ([]LLM.LLMConversations, error)
+	GetModel(convID int) (string, error)
+	ShowConversation(convID int)
+	WriteConversation(w io.Writer, convID int) error
+
+	ListConversationIDs() ([]int, error)
+	GetLastConversationID() (int, error)
+	Search(query string) ([]SearchResult, error)
+	FindConversations(filter SearchFilter) ([]SearchResult, error)
+	// ListSummaries is a page of the conversation list, most recent first
+	ListSummaries(filter SearchFilter, offset, limit int) ([]ConversationSummary, error)
+
+	GetTitle(convID int) (string, error)
+	SetTitle(convID int, title string) error
+	SetTitleIfEmpty(convID int, title string) (bool, error)
+	UntitledConversationIDs() ([]int, error)
+	// MarkTitleFailed records that the title model couldn't name the
+	// conversation, so catching up on titles passes over it
+	MarkTitleFailed(convID int) error
+	TitleFailedIDs() ([]int, error)
+
+	Close()
+}
+
+var (
+	_ Store = (*ChatDB)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*JSONLStore)(nil)
+)
+
+// OpenStore opens the backend's store at path, creating it if it doesn't
+// exist. The table is only used by SQLite, and the memory backend keeps
+// nothing once closed.
+func OpenStore(backend, path, table string) (Store, error) {
+	switch backend {
+	case "", BackendSQLite:
+		db, err := InitializeDB(path, table)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case BackendJSONL:
+		return OpenJSONL(path)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q: must be one of %s", backend, strings.Join(Backends, ", "))
+	}
+}
+
+// NeedsSQLite is the error for a feature only the SQLite backend has
+func NeedsSQLite(feature string) error {
+	return fmt.Errorf("%s needs the %s database backend", feature, BackendSQLite)
+}
+
+// ErrNotFound means the conversation, or alternative, asked for doesn't
+// exist
+var ErrNotFound = errors.New("not found")
+
+// notFound is the error for a conversation that doesn't exist
+func notFound(convID int) error {
+	return fmt.Errorf("conversation %d %w", convID, ErrNotFound)
+}
+
+// StartConversation gives a new conversation, whose ID is still 0, an ID as
+// its first turn is recorded. Sessions don't reserve one before then, so a
+// session quit before its first prompt leaves nothing behind.
+func StartConversation(s Store, convID *int) error {
+	if *convID != 0 {
+		return nil
+	}
+	id, err := s.NewConversationID()
+	if err != nil {
+		return err
+	}
+	*convID = id
+	return nil
+}
+
+// NotStarted is the error for a chat command that acts on a conversation
+// given before its first prompt, when there's nothing to act on yet
+func NotStarted(command string) error {
+	return fmt.Errorf("nothing to %s yet: the conversation starts with its first prompt", strings.TrimPrefix(command, "/"))
+}
+
+// conversationContext turns the messages into the context a client sends.
+// Each message stores a single token count, so the assistant's input tokens
+// are taken from the prompt before it.
+func conversationContext(convID int, msgs []Message) []LLM.LLMConversations {
+	var conversations []LLM.LLMConversations
+	var inputTokens int32
+	for _, m := range msgs {
+		turn := LLM.LLMConversations{
+			Role:      m.Role,
+			Content:   m.Content,
+			Model:     m.Model,
+			Timestamp: m.Timestamp,
+			ConvID:    convID,
+		}
+		if m.Role == "user" {
+			inputTokens = m.Tokens
+			turn.InputTokens = m.Tokens
+		} else {
+			turn.InputTokens = inputTokens
+			turn.OutputTokens = m.Tokens
+		}
+		conversations = append(conversations, turn)
+	}
+	return conversations
+}
@@ -0,0 +1,436 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stores opens one store of each backend
+func stores(t *testing.T) map[string]Store {
+	dir := t.TempDir()
+	all := make(map[string]Store)
+	for _, backend := range Backends {
+		path := ":memory:"
+		if backend == BackendJSONL {
+			path = filepath.Join(dir, "history.jsonl")
+		}
+		s, err := OpenStore(backend, path, "conversations")
+		assert.Nil(t, err)
+		t.Cleanup(s.Close)
+		all[backend] = s
+	}
+	return all
+}
+
+func TestStores(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			id, err := s.NewConversationID()
+			assert.Nil(t, err)
+			assert.Equal(t, 1, id)
+			// A reserved ID isn't a conversation yet
+			last, err := s.GetLastConversationID()
+			assert.Nil(t, err)
+			assert.Equal(t, 0, last)
+
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: id, Prompt: "What's a fianchetto?", Response: "A bishop developed to the long diagonal.",
+				Model: "gpt-4o", InputTokens: 5, OutputTokens: 9, Role: "coach", Latency: 1500 * time.Millisecond}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: id, Prompt: "And the Reti?", Response: "1. Nf3 d5 2. c4", Model: "sonnet"}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 2, Prompt: "Hello", Response: "Hi", Model: "gpt-4o"}))
+
+			ids, err := s.ListConversationIDs()
+			assert.Nil(t, err)
+			assert.Equal(t, []int{1, 2}, ids)
+			last, err = s.GetLastConversationID()
+			assert.Nil(t, err)
+			assert.Equal(t, 2, last)
+			next, err := s.NewConversationID()
+			assert.Nil(t, err)
+			assert.Equal(t, 3, next)
+
+			conv, err := s.GetConversation(id)
+			assert.Nil(t, err)
+			assert.Equal(t, "sonnet", conv.Model)
+			assert.Equal(t, "coach", conv.Role)
+			_, err = s.GetConversation(42)
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.EqualError(t, err, "conversation 42 not found")
+
+			msgs, err := s.Messages(id)
+			assert.Nil(t, err)
+			assert.Len(t, msgs, 4)
+			assert.Equal(t, "user", msgs[0].Role)
+			assert.Equal(t, 1500*time.Millisecond, msgs[1].Latency)
+			assert.Equal(t, 3, msgs[3].Seq)
+
+			context, err := s.LoadConversationFromDB(id)
+			assert.Nil(t, err)
+			assert.Len(t, context, 4)
+			assert.Equal(t, int32(5), context[1].InputTokens)
+			assert.Equal(t, int32(9), context[1].OutputTokens)
+
+			model, err := s.GetModel(id)
+			assert.Nil(t, err)
+			assert.Equal(t, "sonnet", model)
+
+			assert.Nil(t, s.ReplaceLastTurn(Turn{ConvID: id, Prompt: "And the English?", Response: "1. c4", Model: "gpt-4o"}))
+			msgs, _ = s.Messages(id)
+			assert.Len(t, msgs, 4)
+			assert.Equal(t, "And the English?", msgs[2].Content)
+			assert.ErrorContains(t, s.ReplaceLastTurn(Turn{ConvID: 42}), "has no turns")
+
+			results, err := s.Search("fianchetto")
+			assert.Nil(t, err)
+			assert.Len(t, results, 1)
+			assert.Equal(t, id, results[0].ConvID)
+			assert.Contains(t, results[0].Snippet, HighlightStart+"fianchetto"+HighlightEnd)
+
+			results, err = s.FindConversations(SearchFilter{Models: []string{"gpt-4o"}, Role: "assistant"})
+			assert.Nil(t, err)
+			assert.Len(t, results, 2)
+			results, err = s.FindConversations(SearchFilter{Regex: `Nf3|c4`})
+			assert.Nil(t, err)
+			assert.Len(t, results, 1)
+			_, err = s.FindConversations(SearchFilter{Regex: `(`})
+			assert.ErrorContains(t, err, "invalid regex")
+
+			untitled, err := s.UntitledConversationIDs()
+			assert.Nil(t, err)
+			assert.Equal(t, []int{1, 2}, untitled)
+			assert.Nil(t, s.SetTitle(id, "Openings"))
+			ok, err := s.SetTitleIfEmpty(id, "Something else")
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			title, err := s.GetTitle(id)
+			assert.Nil(t, err)
+			assert.Equal(t, "Openings", title)
+			assert.ErrorIs(t, s.SetTitle(42, "Nothing"), ErrNotFound)
+		})
+	}
+}
+
+func TestStoresSearchUnicode(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			// Runes that change length when lowercased come before the match
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "ȺȺȺȺȺȺ İİ foo", Response: "r", Model: "m"}))
+			results, err := s.Search("foo")
+			assert.Nil(t, err)
+			assert.Len(t, results, 1)
+			assert.Contains(t, results[0].Snippet, HighlightStart+"foo"+HighlightEnd)
+		})
+	}
+}
+
+func TestStoresFilterByProvider(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "retired-model", Provider: "openai"}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 2, Prompt: "p", Response: "r", Model: "sonnet", Provider: "anthropic"}))
+
+			results, err := s.FindConversations(SearchFilter{Provider: "openai", Role: "user"})
+			assert.Nil(t, err)
+			assert.Equal(t, []int{1}, resultIDs(results))
+			summaries, err := s.ListSummaries(SearchFilter{Provider: "anthropic"}, 0, 10)
+			assert.Nil(t, err)
+			assert.Len(t, summaries, 1)
+			assert.Equal(t, 2, summaries[0].ID)
+		})
+	}
+}
+
+func TestStartConversation(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			// A continued conversation keeps its ID
+			convID := 7
+			assert.Nil(t, StartConversation(s, &convID))
+			assert.Equal(t, 7, convID)
+
+			convID = 0
+			assert.Nil(t, StartConversation(s, &convID))
+			assert.Equal(t, 1, convID)
+		})
+	}
+}
+
+func TestLoadRecentTurns(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			context, err := s.LoadRecentTurns(3)
+			assert.Nil(t, err)
+			assert.Empty(t, context)
+
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p1", Response: "r1", Model: "m", InputTokens: 3}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p2", Response: "r2", Model: "m"}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 2, Prompt: "p3", Response: "r3", Model: "m"}))
+
+			context, err = s.LoadRecentTurns(2)
+			assert.Nil(t, err)
+			assert.Len(t, context, 4)
+			assert.Equal(t, "p2", context[0].Content)
+			assert.Equal(t, "r2", context[1].Content)
+			assert.Equal(t, 1, context[1].ConvID)
+			assert.Equal(t, "r3", context[3].Content)
+			assert.Equal(t, 2, context[3].ConvID)
+
+			context, err = s.LoadRecentTurns(10)
+			assert.Nil(t, err)
+			assert.Len(t, context, 6)
+			assert.Equal(t, int32(3), context[1].InputTokens)
+
+			if db, ok := s.(*ChatDB); ok {
+				// Archived conversations are left out
+				assert.Nil(t, db.SetArchived(2, true))
+				context, err = db.LoadRecentTurns(1)
+				assert.Nil(t, err)
+				assert.Equal(t, "p2", context[0].Content)
+			}
+		})
+	}
+}
+
+func TestListSummaries(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "first\nprompt", Response: "r1", Model: "m"}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "second", Response: "r2", Model: "n"}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 2, Prompt: "other", Response: "r", Model: "m"}))
+			assert.Nil(t, s.SetTitle(2, "Titled"))
+
+			summaries, err := s.ListSummaries(SearchFilter{}, 0, 10)
+			assert.Nil(t, err)
+			assert.Len(t, summaries, 2)
+			// Recorded the same second, so the higher ID first
+			assert.Equal(t, 2, summaries[0].ID)
+			assert.Equal(t, "Titled", summaries[0].Title)
+			assert.Empty(t, summaries[0].FirstPrompt)
+			assert.Equal(t, 1, summaries[1].ID)
+			assert.Equal(t, "first\nprompt", summaries[1].FirstPrompt)
+			assert.Equal(t, 2, summaries[1].Turns)
+			assert.Equal(t, "n", summaries[1].Model)
+			assert.WithinDuration(t, time.Now(), summaries[1].LastActivity, time.Minute)
+
+			page, err := s.ListSummaries(SearchFilter{}, 1, 1)
+			assert.Nil(t, err)
+			assert.Equal(t, summaries[1:], page)
+			page, err = s.ListSummaries(SearchFilter{}, 2, 1)
+			assert.Nil(t, err)
+			assert.Empty(t, page)
+
+			summaries, err = s.ListSummaries(SearchFilter{Models: []string{"n"}}, 0, 10)
+			assert.Nil(t, err)
+			assert.Len(t, summaries, 1)
+			assert.Equal(t, 1, summaries[0].ID)
+			_, err = s.ListSummaries(SearchFilter{Query: "other"}, 0, 10)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestListSummariesByActivity(t *testing.T) {
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+	old := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, _, err = db.ImportConversation(ImportedConversation{Source: "chatgpt", SourceID: "old", Created: old,
+		Messages: []ImportedMessage{{Role: "user", Content: "old", Timestamp: old}}})
+	assert.Nil(t, err)
+	assert.Nil(t, db.SetArchived(1, true))
+
+	summaries, err := db.ListSummaries(SearchFilter{IncludeArchived: true}, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, summaries, 2)
+	assert.Equal(t, 1, summaries[0].ID)
+	assert.True(t, summaries[0].Archived)
+	assert.Equal(t, old, summaries[1].LastActivity)
+
+	summaries, err = db.ListSummaries(SearchFilter{}, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, 2, summaries[0].ID)
+}
+
+func TestWriteConversation(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+			var b strings.Builder
+			assert.Nil(t, s.WriteConversation(&b, 1))
+			assert.Contains(t, b.String(), "Prompt: p\nResponse: r\n")
+			assert.ErrorIs(t, s.WriteConversation(&b, 42), ErrNotFound)
+			// Shows nothing, rather than exiting
+			s.ShowConversation(42)
+		})
+	}
+}
+
+func TestOpenStoreUnknownBackend(t *testing.T) {
+	_, err := OpenStore("postgres", "", "conversations")
+	assert.ErrorContains(t, err, `unknown database backend "postgres"`)
+}
+
+func TestStoresTitleFailed(t *testing.T) {
+	for backend, s := range stores(t) {
+		t.Run(backend, func(t *testing.T) {
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r"}))
+			assert.Nil(t, s.InsertTurn(Turn{ConvID: 2, Prompt: "p", Response: "r"}))
+			failed, err := s.TitleFailedIDs()
+			assert.Nil(t, err)
+			assert.Empty(t, failed)
+
+			assert.Nil(t, s.MarkTitleFailed(2))
+			assert.Nil(t, s.MarkTitleFailed(2))
+			failed, err = s.TitleFailedIDs()
+			assert.Nil(t, err)
+			assert.Equal(t, []int{2}, failed)
+			assert.ErrorIs(t, s.MarkTitleFailed(42), ErrNotFound)
+		})
+	}
+}
+
+func TestJSONLReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := OpenJSONL(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p1", Response: "r1", Model: "m", Latency: time.Second}))
+	assert.Nil(t, s.InsertTurn(Turn{ConvID: 1, Prompt: "p2", Response: "r2", Model: "m"}))
+	assert.Nil(t, s.ReplaceLastTurn(Turn{ConvID: 1, Prompt: "p2", Response: "better", Model: "n"}))
+	id, err := s.NewConversationID()
+	assert.Nil(t, err)
+	// Named before its first turn
+	assert.Nil(t, s.SetTitle(id, "Second"))
+	assert.Nil(t, s.InsertTurn(Turn{ConvID: id, Prompt: "p", Response: "r"}))
+	assert.Nil(t, s.MarkTitleFailed(1))
+	first, _ := s.GetConversation(1)
+	s.Close()
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"op":"replace"`)
+
+	s, err = OpenJSONL(path)
+	assert.Nil(t, err)
+	defer s.Close()
+	msgs, err := s.Messages(1)
+	assert.Nil(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "better", msgs[3].Content)
+	assert.Equal(t, time.Second, msgs[1].Latency)
+	conv, _ := s.GetConversation(1)
+	assert.Equal(t, first.UUID, conv.UUID)
+	title, _ := s.GetTitle(2)
+	assert.Equal(t, "Second", title)
+	failed, err := s.TitleFailedIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, failed)
+	next, err := s.NewConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, next)
+}
+
+// Two processes appending to one file, or copies of it merged, can give two
+// conversations the same ID; they're told apart by UUID
+func TestJSONLSameID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	a, err := OpenJSONL(path)
+	assert.Nil(t, err)
+	b, err := OpenJSONL(path)
+	assert.Nil(t, err)
+
+	idA, err := a.NewConversationID()
+	assert.Nil(t, err)
+	idB, err := b.NewConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, idA, idB)
+	assert.Nil(t, a.InsertTurn(Turn{ConvID: idA, Prompt: "a1", Response: "r"}))
+	assert.Nil(t, b.InsertTurn(Turn{ConvID: idB, Prompt: "b1", Response: "r"}))
+	assert.Nil(t, a.InsertTurn(Turn{ConvID: idA, Prompt: "a2", Response: "r"}))
+	assert.Nil(t, b.SetTitle(idB, "B"))
+	convA, _ := a.GetConversation(idA)
+	convB, _ := b.GetConversation(idB)
+	a.Close()
+	b.Close()
+
+	s, err := OpenJSONL(path)
+	assert.Nil(t, err)
+	defer s.Close()
+	ids, err := s.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+
+	conv, _ := s.GetConversation(1)
+	assert.Equal(t, convA.UUID, conv.UUID)
+	msgs, _ := s.Messages(1)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "a2", msgs[2].Content)
+
+	conv, _ = s.GetConversation(2)
+	assert.Equal(t, convB.UUID, conv.UUID)
+	assert.Equal(t, "B", conv.Title)
+	msgs, _ = s.Messages(2)
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "b1", msgs[0].Content)
+
+	// The next one doesn't reuse either
+	next, err := s.NewConversationID()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, next)
+}
+
+// Records from before UUIDs keep their IDs, and a conversation carried on
+// since is still the same one
+func TestJSONLWithoutUUIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	assert.Nil(t, os.WriteFile(path, []byte(
+		`{"op":"turn","conversation_id":3,"turn":{"prompt":"p1","response":"r1"}}`+"\n"+
+			`{"op":"turn","conversation_id":5,"turn":{"prompt":"p2","response":"r2"}}`+"\n"), 0o600))
+	s, err := OpenJSONL(path)
+	assert.Nil(t, err)
+	assert.Nil(t, s.InsertTurn(Turn{ConvID: 3, Prompt: "p3", Response: "r3"}))
+	s.Close()
+
+	s, err = OpenJSONL(path)
+	assert.Nil(t, err)
+	defer s.Close()
+	ids, err := s.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 5}, ids)
+	msgs, _ := s.Messages(3)
+	assert.Len(t, msgs, 4)
+}
+
+func TestJSONLBadRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"op":"turn","conversation_id":1,"turn":{"prompt":"p","response":"r"}}`+"\nnot json\n"), 0o600))
+	_, err := OpenJSONL(path)
+	assert.ErrorContains(t, err, "history.jsonl:2:")
+}
+
+func TestMemoryCopyConversation(t *testing.T) {
+	db, err := InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 7, Prompt: "p", Response: "r", Model: "m"}))
+	assert.Nil(t, db.SetTitle(7, "Kept"))
+
+	mem := NewMemoryStore()
+	assert.Nil(t, mem.CopyConversation(db, 7))
+	assert.Nil(t, mem.InsertTurn(Turn{ConvID: 7, Prompt: "p2", Response: "r2", Model: "m"}))
+	msgs, _ := mem.Messages(7)
+	assert.Len(t, msgs, 4)
+	title, _ := mem.GetTitle(7)
+	assert.Equal(t, "Kept", title)
+	next, _ := mem.NewConversationID()
+	assert.Equal(t, 8, next)
+
+	// The original is left alone
+	msgs, _ = db.Messages(7)
+	assert.Len(t, msgs, 2)
+}
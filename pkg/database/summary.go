@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConversationSummary is what the conversation list shows of a conversation,
+// without loading its messages
+type ConversationSummary struct {
+	ID           int
+	Title        string
+	FirstPrompt  string // only when it has no title
+	Turns        int
+	Model        string // model of the latest turn
+	LastActivity time.Time
+	Starred      bool
+	Archived     bool
+	Tags         []string
+}
+
+// ListSummaries returns a page of the conversations that match the filter,
+// the most recently active first. The filter's Query isn't supported: search
+// with FindConversations.
+func (sqlDB *ChatDB) ListSummaries(filter SearchFilter, offset, limit int) ([]ConversationSummary, error) {
+	if filter.Query != "" {
+		return nil, fmt.Errorf("conversation summaries can't be searched")
+	}
+	var re *regexp.Regexp
+	if filter.Regex != "" {
+		var err error
+		re, err = regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+
+	where, args := sqlDB.conversationConditions(filter, "c")
+	if msgWhere, msgArgs := sqlDB.messageConditions(filter, re, "f"); len(msgWhere) > 0 {
+		where = append(where, `EXISTS (SELECT 1 FROM `+sqlDB.msgTable+` f WHERE f.conversation_id = c.id AND `+
+			strings.Join(msgWhere, ` AND `)+`)`)
+		args = append(args, msgArgs...)
+	}
+	// The page is picked first, so only its conversations have their tags
+	// and first prompt looked up
+	page := `
+		SELECT c.id, c.title, c.model, c.starred, c.archived,
+			COUNT(CASE WHEN m.role = 'user' THEN 1 END) AS turns, MAX(m.timestamp) AS last
+		FROM ` + sqlDB.dbTable + ` c JOIN ` + sqlDB.msgTable + ` m ON m.conversation_id = c.id`
+	if len(where) > 0 {
+		page += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	page += ` GROUP BY c.id ORDER BY last DESC, c.id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+	query := `
+		SELECT s.id, s.title, s.model, s.starred, s.archived,
+			COALESCE((SELECT group_concat(tag, ',') FROM (SELECT tag FROM ` + sqlDB.tagTable + ` t WHERE t.conversation_id = s.id ORDER BY tag)), ''),
+			s.turns, s.last,
+			CASE WHEN s.title = '' THEN COALESCE((
+				SELECT p.content FROM ` + sqlDB.msgTable + ` p WHERE p.conversation_id = s.id AND p.role = 'user' ORDER BY p.seq LIMIT 1
+			), '') ELSE '' END
+		FROM (` + page + `) s
+		ORDER BY s.last DESC, s.id DESC;
+	`
+
+	rows, err := sqlDB.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var summaries []ConversationSummary
+	for rows.Next() {
+		var s ConversationSummary
+		var tags, last string
+		err := rows.Scan(&s.ID, &s.Title, &s.Model, &s.Starred, &s.Archived, &tags, &s.Turns, &last, &s.FirstPrompt)
+		if err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		if s.Title, err = sqlDB.decrypt(s.Title); err != nil {
+			return nil, err
+		}
+		if s.FirstPrompt, err = sqlDB.decrypt(s.FirstPrompt); err != nil {
+			return nil, err
+		}
+		if tags != "" {
+			s.Tags = strings.Split(tags, ",")
+		}
+		// An aggregate's timestamp comes back as stored, not parsed
+		s.LastActivity = parseTimestamp(last)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// NormalizeTag returns the stored form of a tag: lowercase, without a leading
+// '#'. It returns "" for something that can't be a tag.
+func NormalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	if tag == "" || strings.ContainsAny(tag, " \t\r\n,") {
+		return ""
+	}
+	return tag
+}
+
+// ParseTagEdits splits "/tag" arguments like "work -draft urgent" into the
+// tags to add and, prefixed with '-', the tags to remove
+func ParseTagEdits(args string) (add, remove []string, err error) {
+	for _, word := range strings.Fields(args) {
+		list := &add
+		if strings.HasPrefix(word, "-") {
+			list = &remove
+			word = word[1:]
+		}
+		tag := NormalizeTag(word)
+		if tag == "" {
+			return nil, nil, fmt.Errorf("invalid tag %q", word)
+		}
+		*list = append(*list, tag)
+	}
+	return add, remove, nil
+}
+
+// EditTags applies "/tag" arguments (see ParseTagEdits) to the conversation
+// and returns its tags afterwards
+func (sqlDB *ChatDB) EditTags(convID int, args string) ([]string, error) {
+	add, remove, err := ParseTagEdits(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqlDB.AddTags(convID, add...); err != nil {
+		return nil, err
+	}
+	if err := sqlDB.RemoveTags(convID, remove...); err != nil {
+		return nil, err
+	}
+	return sqlDB.Tags(convID)
+}
+
+func (sqlDB *ChatDB) conversationExists(convID int) error {
+	var n int
+	err := sqlDB.db.QueryRow(`SELECT COUNT(*) FROM `+sqlDB.dbTable+` WHERE id = ?;`, convID).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n == 0 {
+		return notFound(convID)
+	}
+	return nil
+}
+
+// AddTags tags the conversation; tags it already has are ignored
+func (sqlDB *ChatDB) AddTags(convID int, tags ...string) error {
+	if err := sqlDB.conversationExists(convID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		norm := NormalizeTag(tag)
+		if norm == "" {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+		_, err := sqlDB.db.Exec(`
+			INSERT OR IGNORE INTO `+sqlDB.tagTable+` (conversation_id, tag) VALUES (?, ?);
+		`, convID, norm)
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	}
+	return nil
+}
+
+// RemoveTags removes the tags from the conversation
+func (sqlDB *ChatDB) RemoveTags(convID int, tags ...string) error {
+	for _, tag := range tags {
+		_, err := sqlDB.db.Exec(`
+			DELETE FROM `+sqlDB.tagTable+` WHERE conversation_id = ? AND tag = ?;
+		`, convID, NormalizeTag(tag))
+		if err != nil {
+			return fmt.Errorf("%v", err)
+		}
+	}
+	return nil
+}
+
+// Tags returns the conversation's tags, sorted
+func (sqlDB *ChatDB) Tags(convID int) ([]string, error) {
+	rows, err := sqlDB.db.Query(`
+		SELECT tag FROM `+sqlDB.tagTable+` WHERE conversation_id = ? ORDER BY tag;
+	`, convID)
+	if err != nil {
+		return nil, fmt.Errorf("%v", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("%v", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// setFlag sets one of the conversation's boolean columns
+func (sqlDB *ChatDB) setFlag(convID int, column string, on bool) error {
+	res, err := sqlDB.db.Exec(`UPDATE `+sqlDB.dbTable+` SET `+column+` = ? WHERE id = ?;`, on, convID)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return notFound(convID)
+	}
+	return nil
+}
+
+// toggleFlag flips one of the conversation's boolean columns and returns
+// the new value
+func (sqlDB *ChatDB) toggleFlag(convID int, column string) (bool, error) {
+	var on bool
+	err := sqlDB.db.QueryRow(`
+		UPDATE `+sqlDB.dbTable+` SET `+column+` = NOT `+column+` WHERE id = ? RETURNING `+column+`;
+	`, convID).Scan(&on)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, notFound(convID)
+		}
+		return false, fmt.Errorf("%v", err)
+	}
+	return on, nil
+}
+
+func (sqlDB *ChatDB) SetStarred(convID int, starred bool) error {
+	return sqlDB.setFlag(convID, "starred", starred)
+}
+
+func (sqlDB *ChatDB) SetArchived(convID int, archived bool) error {
+	return sqlDB.setFlag(convID, "archived", archived)
+}
+
+// ToggleStarred stars or unstars the conversation and reports whether it's
+// now starred
+func (sqlDB *ChatDB) ToggleStarred(convID int) (bool, error) {
+	return sqlDB.toggleFlag(convID, "starred")
+}
+
+// ToggleArchived archives or unarchives the conversation and reports whether
+// it's now archived
+func (sqlDB *ChatDB) ToggleArchived(convID int) (bool, error) {
+	return sqlDB.toggleFlag(convID, "archived")
+}
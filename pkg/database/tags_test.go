@@ -0,0 +1,76 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTagEdits(t *testing.T) {
+	add, remove, err := ParseTagEdits("work #Ideas -draft  -#Old")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"work", "ideas"}, add)
+	assert.Equal(t, []string{"draft", "old"}, remove)
+
+	add, remove, err = ParseTagEdits("")
+	assert.Nil(t, err)
+	assert.Nil(t, add)
+	assert.Nil(t, remove)
+
+	_, _, err = ParseTagEdits("ok -")
+	assert.ErrorContains(t, err, "invalid tag")
+	_, _, err = ParseTagEdits("a,b")
+	assert.ErrorContains(t, err, "invalid tag")
+}
+
+func TestTags(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+
+	assert.Nil(t, db.AddTags(1, "work", "Ideas", "work"))
+	tags, err := db.Tags(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ideas", "work"}, tags)
+
+	tags, err = db.EditTags(1, "-work #later")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ideas", "later"}, tags)
+
+	assert.ErrorIs(t, db.AddTags(2, "work"), ErrNotFound)
+	assert.ErrorContains(t, db.AddTags(1, " "), "invalid tag")
+}
+
+func TestStarredAndArchived(t *testing.T) {
+	db, err := NewDB(dbPath, dbTable)
+	assert.Nil(t, err)
+	defer func() { db.Close(); RemoveDB() }()
+	assert.Nil(t, db.InsertTurn(Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+
+	starred, err := db.ToggleStarred(1)
+	assert.Nil(t, err)
+	assert.True(t, starred)
+	starred, err = db.ToggleStarred(1)
+	assert.Nil(t, err)
+	assert.False(t, starred)
+
+	assert.Nil(t, db.SetArchived(1, true))
+	archived, err := db.ToggleArchived(1)
+	assert.Nil(t, err)
+	assert.False(t, archived)
+
+	assert.Nil(t, db.SetStarred(1, true))
+	assert.Nil(t, db.AddTags(1, "work"))
+	conv, err := db.GetConversation(1)
+	assert.Nil(t, err)
+	assert.True(t, conv.Starred)
+	assert.False(t, conv.Archived)
+	assert.Equal(t, []string{"work"}, conv.Tags)
+
+	_, err = db.ToggleStarred(9)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, db.SetArchived(9, true), ErrNotFound)
+	_, err = db.GetConversation(9)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/duluk/ask-ai/pkg/sse"
 )
 
 const BaseURL = "https://api.deepseek.com/v1/chat/completions"
@@ -17,11 +19,17 @@ type Message struct {
 }
 
 type ChatCompletionRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Stream      bool      `json:"stream,omitempty"`
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Temperature   float64        `json:"temperature,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions asks for a final chunk carrying token usage
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type ChatCompletionResponse struct {
@@ -44,6 +52,29 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+// ChatCompletionChunk is one event of a streamed completion. Usage is only
+// set on the last chunk, and only if StreamOptions.IncludeUsage was requested.
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+type ChunkChoice struct {
+	Index int `json:"index"`
+	Delta struct {
+		Role             string `json:"role,omitempty"`
+		Content          string `json:"content,omitempty"`
+		ReasoningContent string `json:"reasoning_content,omitempty"`
+	} `json:"delta"`
+	FinishReason string `json:"finish_reason"`
+}
+
+type StreamHandler func(ChatCompletionChunk)
+
 type Client struct {
 	APIKey     string
 	HTTPClient *http.Client
@@ -88,3 +119,42 @@ func (c *Client) CreateChatCompletion(ctx context.Context, req ChatCompletionReq
 
 	return &result, nil
 }
+
+func (c *Client) CreateChatCompletionStream(ctx context.Context, req ChatCompletionRequest, handler StreamHandler) error {
+	req.Stream = true
+
+	jsonReq, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", BaseURL, bytes.NewBuffer(jsonReq))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return sse.Each(resp.Body, func(evt sse.Event) error {
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(evt.Data), &chunk); err != nil {
+			return fmt.Errorf("error unmarshaling chunk: %v", err)
+		}
+
+		handler(chunk)
+		return nil
+	})
+}
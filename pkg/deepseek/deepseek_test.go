@@ -7,9 +7,13 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/sse"
 )
 
 // stubTransport implements http.RoundTripper for testing
@@ -109,3 +113,75 @@ func TestChatCompletionRequest_MarshalUnmarshal(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, req, other)
 }
+
+func recordedStreamClient(t *testing.T, recording string, gotReq *ChatCompletionRequest) *Client {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("key")
+	client.HTTPClient = &http.Client{Transport: &stubTransport{fn: func(req *http.Request) (*http.Response, error) {
+		if gotReq != nil {
+			json.NewDecoder(req.Body).Decode(gotReq)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewReader(data)),
+			Header:     make(http.Header),
+		}, nil
+	}}}
+	return client
+}
+
+func TestCreateChatCompletionStream_Recorded(t *testing.T) {
+	var sent ChatCompletionRequest
+	client := recordedStreamClient(t, "chat_stream.txt", &sent)
+
+	var content, finish string
+	var usage *Usage
+	err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{
+		Model:         "deepseek-chat",
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+	}, func(chunk ChatCompletionChunk) {
+		if len(chunk.Choices) > 0 {
+			content += chunk.Choices[0].Delta.Content
+			if chunk.Choices[0].FinishReason != "" {
+				finish = chunk.Choices[0].FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	})
+	assert.NoError(t, err)
+	assert.True(t, sent.Stream)
+	assert.Equal(t, "42", content)
+	assert.Equal(t, "stop", finish)
+	assert.Equal(t, 9, usage.PromptTokens)
+	assert.Equal(t, 1, usage.CompletionTokens)
+}
+
+func TestCreateChatCompletionStream_ErrorEvent(t *testing.T) {
+	client := recordedStreamClient(t, "chat_stream_error.txt", nil)
+
+	err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{}, func(chunk ChatCompletionChunk) {})
+	var streamErr *sse.StreamError
+	assert.True(t, errors.As(err, &streamErr))
+	assert.Equal(t, "Insufficient Balance", streamErr.Message)
+}
+
+func TestCreateChatCompletionStream_HTTPError(t *testing.T) {
+	client := NewClient("key")
+	client.HTTPClient = &http.Client{Transport: &stubTransport{fn: func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Body:       io.NopCloser(bytes.NewReader([]byte("bad key"))),
+			Header:     make(http.Header),
+		}, nil
+	}}}
+
+	err := client.CreateChatCompletionStream(context.Background(), ChatCompletionRequest{}, func(chunk ChatCompletionChunk) {})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "API request failed with status 401: bad key")
+}
@@ -0,0 +1,262 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+// Formats are the export formats, as given to --format
+var Formats = []string{"md", "json", "jsonl", "html"}
+
+// Message is one message of an exported conversation
+type Message struct {
+	Role        string    `json:"role"`
+	Content     string    `json:"content"`
+	Model       string    `json:"model,omitempty"`
+	Temperature float32   `json:"temperature,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Tokens      int32     `json:"tokens,omitempty"`
+}
+
+// Conversation is a full transcript: the conversation's metadata and every
+// message in order
+type Conversation struct {
+	ID           int       `json:"id"`
+	Title        string    `json:"title,omitempty"`
+	Created      time.Time `json:"created"`
+	Model        string    `json:"model,omitempty"`
+	Role         string    `json:"role,omitempty"`
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Starred      bool      `json:"starred,omitempty"`
+	Archived     bool      `json:"archived,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	Messages     []Message `json:"messages"`
+}
+
+// Load reads one conversation's transcript from the database
+func Load(db database.Store, convID int) (Conversation, error) {
+	meta, err := db.GetConversation(convID)
+	if err != nil {
+		return Conversation{}, err
+	}
+	msgs, err := db.Messages(convID)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	conv := Conversation{
+		ID:           meta.ID,
+		Title:        meta.Title,
+		Created:      parseTimestamp(meta.Created),
+		Model:        meta.Model,
+		Role:         meta.Role,
+		SystemPrompt: meta.SystemPrompt,
+		Starred:      meta.Starred,
+		Archived:     meta.Archived,
+		Tags:         meta.Tags,
+		Messages:     make([]Message, 0, len(msgs)),
+	}
+	for _, m := range msgs {
+		conv.Messages = append(conv.Messages, Message{
+			Role:        m.Role,
+			Content:     m.Content,
+			Model:       m.Model,
+			Temperature: m.Temperature,
+			Timestamp:   parseTimestamp(m.Timestamp),
+			Tokens:      m.Tokens,
+		})
+	}
+	return conv, nil
+}
+
+// LoadAll reads every conversation that has messages, archived ones included
+func LoadAll(db database.Store) ([]Conversation, error) {
+	ids, err := db.ListConversationIDs()
+	if err != nil {
+		return nil, err
+	}
+	convs := make([]Conversation, 0, len(ids))
+	for _, id := range ids {
+		conv, err := Load(db, id)
+		if err != nil {
+			return nil, err
+		}
+		convs = append(convs, conv)
+	}
+	return convs, nil
+}
+
+// Export writes the conversations named by target, a conversation ID or
+// "all", to w in the given format. A single conversation exported as json is
+// an object; "all" is an array of them.
+func Export(db database.Store, target, format string, w io.Writer) error {
+	if !slices.Contains(Formats, format) {
+		return fmt.Errorf("unknown export format %q: must be one of %s", format, strings.Join(Formats, ", "))
+	}
+
+	if target == "all" {
+		convs, err := LoadAll(db)
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			return writeJSON(w, convs)
+		}
+		return Write(w, format, convs)
+	}
+
+	convID, err := strconv.Atoi(target)
+	if err != nil || convID <= 0 {
+		return fmt.Errorf("invalid export target %q: must be a conversation ID or all", target)
+	}
+	conv, err := Load(db, convID)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return writeJSON(w, conv)
+	}
+	return Write(w, format, []Conversation{conv})
+}
+
+// Write writes the conversations to w in the given format; json is written
+// as an array
+func Write(w io.Writer, format string, convs []Conversation) error {
+	switch format {
+	case "md":
+		return writeMarkdown(w, convs)
+	case "json":
+		return writeJSON(w, convs)
+	case "jsonl":
+		return writeJSONL(w, convs)
+	case "html":
+		return writeHTML(w, convs)
+	default:
+		return fmt.Errorf("unknown export format %q: must be one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeJSONL writes one conversation per line
+func writeJSONL(w io.Writer, convs []Conversation) error {
+	enc := json.NewEncoder(w)
+	for _, conv := range convs {
+		if err := enc.Encode(conv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMarkdown(w io.Writer, convs []Conversation) error {
+	var b strings.Builder
+	for i, conv := range convs {
+		if i > 0 {
+			b.WriteString("\n---\n\n")
+		}
+		fmt.Fprintf(&b, "# %s\n\n", heading(conv))
+		for _, field := range metadata(conv) {
+			fmt.Fprintf(&b, "- **%s:** %s\n", field[0], field[1])
+		}
+		if conv.SystemPrompt != "" {
+			fmt.Fprintf(&b, "\n## System prompt\n\n%s\n", conv.SystemPrompt)
+		}
+		for _, m := range conv.Messages {
+			fmt.Fprintf(&b, "\n## %s\n\n", speaker(m))
+			if details := messageDetails(m); details != "" {
+				fmt.Fprintf(&b, "_%s_\n\n", details)
+			}
+			b.WriteString(strings.TrimRight(m.Content, "\n"))
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// heading is the conversation's title, or its ID when it has none
+func heading(conv Conversation) string {
+	if conv.Title != "" {
+		return conv.Title
+	}
+	return fmt.Sprintf("Conversation %d", conv.ID)
+}
+
+// metadata lists the conversation's fields that are set, as name/value pairs
+func metadata(conv Conversation) [][2]string {
+	fields := [][2]string{{"Conversation ID", strconv.Itoa(conv.ID)}}
+	if !conv.Created.IsZero() {
+		fields = append(fields, [2]string{"Created", formatTime(conv.Created)})
+	}
+	if conv.Model != "" {
+		fields = append(fields, [2]string{"Model", conv.Model})
+	}
+	if conv.Role != "" {
+		fields = append(fields, [2]string{"Role", conv.Role})
+	}
+	if len(conv.Tags) > 0 {
+		fields = append(fields, [2]string{"Tags", strings.Join(conv.Tags, ", ")})
+	}
+	if conv.Starred {
+		fields = append(fields, [2]string{"Starred", "yes"})
+	}
+	if conv.Archived {
+		fields = append(fields, [2]string{"Archived", "yes"})
+	}
+	return fields
+}
+
+func speaker(m Message) string {
+	switch m.Role {
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	default:
+		return m.Role
+	}
+}
+
+// messageDetails is the message's time, model and token count, whichever are
+// known
+func messageDetails(m Message) string {
+	var parts []string
+	if !m.Timestamp.IsZero() {
+		parts = append(parts, formatTime(m.Timestamp))
+	}
+	if m.Model != "" {
+		parts = append(parts, m.Model)
+	}
+	if m.Tokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens", m.Tokens))
+	}
+	return strings.Join(parts, " · ")
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05 UTC")
+}
+
+// parseTimestamp reads a timestamp as the sqlite3 driver returns it, or as
+// stored; anything else is the zero time
+func parseTimestamp(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return t
+	}
+	return time.Time{}
+}
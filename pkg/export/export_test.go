@@ -0,0 +1,126 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+func exportTestDB(t *testing.T) *database.ChatDB {
+	db, err := database.InitializeDB(":memory:", "export_test")
+	assert.Nil(t, err)
+
+	turns := []database.Turn{
+		{ConvID: 1, Prompt: "How do I reverse a slice?", Response: "Use `slices.Reverse`:\n\n```go\nslices.Reverse(s)\n```", Model: "gpt-4o", Temperature: 0.5, InputTokens: 12, OutputTokens: 34, Role: "coder", SystemPrompt: "You write Go."},
+		{ConvID: 1, Prompt: "And a <string>?", Response: "Convert it to runes first.", Model: "sonnet", InputTokens: 50, OutputTokens: 8},
+		{ConvID: 2, Prompt: "hello", Response: "hi", Model: "llama"},
+	}
+	for _, turn := range turns {
+		assert.Nil(t, db.InsertTurn(turn))
+	}
+	assert.Nil(t, db.SetTitle(1, "Reversing slices"))
+	assert.Nil(t, db.AddTags(1, "go"))
+	return db
+}
+
+func TestLoad(t *testing.T) {
+	db := exportTestDB(t)
+	defer db.Close()
+
+	conv, err := Load(db, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "Reversing slices", conv.Title)
+	assert.Equal(t, "coder", conv.Role)
+	assert.Equal(t, "You write Go.", conv.SystemPrompt)
+	assert.Equal(t, []string{"go"}, conv.Tags)
+	assert.False(t, conv.Created.IsZero())
+	assert.Len(t, conv.Messages, 4)
+	assert.Equal(t, "user", conv.Messages[0].Role)
+	assert.Equal(t, int32(12), conv.Messages[0].Tokens)
+	assert.Equal(t, "gpt-4o", conv.Messages[1].Model)
+	assert.Equal(t, int32(34), conv.Messages[1].Tokens)
+	assert.Equal(t, "sonnet", conv.Messages[3].Model)
+	assert.False(t, conv.Messages[3].Timestamp.IsZero())
+
+	_, err = Load(db, 9)
+	assert.ErrorContains(t, err, "conversation 9 not found")
+}
+
+func TestExportMarkdown(t *testing.T) {
+	db := exportTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	assert.Nil(t, Export(db, "1", "md", &buf))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "# Reversing slices\n"))
+	assert.Contains(t, out, "- **Conversation ID:** 1\n")
+	assert.Contains(t, out, "- **Tags:** go\n")
+	assert.Contains(t, out, "## System prompt\n\nYou write Go.\n")
+	assert.Contains(t, out, "## Assistant\n\n_")
+	assert.Contains(t, out, "gpt-4o · 34 tokens_")
+	assert.Contains(t, out, "```go\nslices.Reverse(s)\n```\n")
+	assert.NotContains(t, out, "hello")
+}
+
+func TestExportJSON(t *testing.T) {
+	db := exportTestDB(t)
+	defer db.Close()
+
+	// One conversation is an object
+	var buf bytes.Buffer
+	assert.Nil(t, Export(db, "1", "json", &buf))
+	var conv Conversation
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &conv))
+	assert.Equal(t, 1, conv.ID)
+	assert.Len(t, conv.Messages, 4)
+	assert.Equal(t, "And a <string>?", conv.Messages[2].Content)
+
+	// All of them an array
+	buf.Reset()
+	assert.Nil(t, Export(db, "all", "json", &buf))
+	var convs []Conversation
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &convs))
+	assert.Len(t, convs, 2)
+
+	buf.Reset()
+	assert.Nil(t, Export(db, "all", "jsonl", &buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &conv))
+	assert.Equal(t, 2, conv.ID)
+	assert.Equal(t, "hi", conv.Messages[1].Content)
+}
+
+func TestExportHTML(t *testing.T) {
+	db := exportTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	assert.Nil(t, Export(db, "1", "html", &buf))
+	out := buf.String()
+	assert.Contains(t, out, "<title>Reversing slices</title>")
+	assert.Contains(t, out, "<style>")
+	assert.NotContains(t, out, "<link")
+	assert.NotContains(t, out, "<script")
+	assert.Contains(t, out, `<pre data-lang="go"><code class="language-go">slices.Reverse(s)</code></pre>`)
+	assert.Contains(t, out, "<p>And a &lt;string&gt;?</p>")
+	assert.Contains(t, out, `<section class="message assistant">`)
+}
+
+func TestExportErrors(t *testing.T) {
+	db := exportTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	assert.ErrorContains(t, Export(db, "1", "pdf", &buf), "unknown export format")
+	assert.ErrorContains(t, Export(db, "latest", "md", &buf), "invalid export target")
+	assert.ErrorContains(t, Export(db, "0", "md", &buf), "invalid export target")
+	assert.ErrorContains(t, Export(db, "9", "md", &buf), "not found")
+	assert.Equal(t, 0, buf.Len())
+}
@@ -0,0 +1,197 @@
+package export
+
+import (
+	"html"
+	"html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The page has no external references so it can be saved or pasted into a
+// wiki as is
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"heading":  heading,
+	"metadata": metadata,
+	"speaker":  speaker,
+	"details":  messageDetails,
+	"markdown": func(s string) template.HTML { return template.HTML(renderMarkdown(s)) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if eq (len .) 1}}{{heading (index . 0)}}{{else}}Conversations{{end}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.5; color: #1f2328; max-width: 52rem; margin: 2rem auto; padding: 0 1rem; }
+article + article { border-top: 2px solid #d0d7de; margin-top: 3rem; padding-top: 1rem; }
+h1 { font-size: 1.6rem; margin-bottom: 0.5rem; }
+dl.meta { display: grid; grid-template-columns: max-content auto; gap: 0.1rem 1rem; color: #57606a; font-size: 0.9rem; }
+dl.meta dt { font-weight: 600; }
+dl.meta dd { margin: 0; }
+.message { border: 1px solid #d0d7de; border-radius: 6px; margin: 1rem 0; padding: 0 1rem; }
+.message.user { background: #f6f8fa; }
+.message.system { background: #fff8c5; }
+.message header { display: flex; justify-content: space-between; gap: 1rem; border-bottom: 1px solid #d0d7de; padding: 0.4rem 0; font-size: 0.85rem; color: #57606a; }
+.message header strong { color: #1f2328; }
+pre { background: #161b22; color: #e6edf3; border-radius: 6px; padding: 0.75rem 1rem; overflow-x: auto; }
+pre code { background: none; padding: 0; color: inherit; }
+code { font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; font-size: 0.875em; background: #eff1f3; border-radius: 4px; padding: 0.1em 0.3em; }
+pre[data-lang]::before { content: attr(data-lang); display: block; font-size: 0.75rem; color: #8b949e; margin-bottom: 0.4rem; }
+blockquote { border-left: 4px solid #d0d7de; margin: 0; padding-left: 1rem; color: #57606a; }
+</style>
+</head>
+<body>
+{{range .}}<article>
+<h1>{{heading .}}</h1>
+<dl class="meta">
+{{range metadata .}}<dt>{{index . 0}}</dt><dd>{{index . 1}}</dd>
+{{end}}</dl>
+{{if .SystemPrompt}}<section class="message system">
+<header><strong>System prompt</strong></header>
+{{markdown .SystemPrompt}}
+</section>
+{{end}}{{range .Messages}}<section class="message {{.Role}}">
+<header><strong>{{speaker .}}</strong><span>{{details .}}</span></header>
+{{markdown .Content}}
+</section>
+{{end}}</article>
+{{end}}</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, convs []Conversation) error {
+	return pageTemplate.Execute(w, convs)
+}
+
+var (
+	headingLine = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletLine  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	orderedLine = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	inlineCode  = regexp.MustCompile("`([^`]+)`")
+	boldText    = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	italicText  = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*)\*`)
+	linkText    = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+)
+
+// renderMarkdown renders the Markdown that models commonly answer with:
+// fenced code blocks, headings, lists, block quotes and paragraphs, with
+// inline code, bold, italics and links. Everything is escaped first, so
+// anything it doesn't recognize comes out as text.
+func renderMarkdown(src string) string {
+	var b strings.Builder
+	var para []string
+	var list string // "ul" or "ol" while in a list
+
+	flushPara := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(kind string) {
+		if list != kind {
+			closeList()
+			b.WriteString("<" + kind + ">\n")
+			list = kind
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if fence, ok := codeFence(trimmed); ok {
+			flushPara()
+			closeList()
+			lang := strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1]))
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			if lang != "" {
+				lang = strings.Fields(lang)[0]
+				b.WriteString(`<pre data-lang="` + html.EscapeString(lang) + `"><code class="language-` + html.EscapeString(lang) + `">`)
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+			continue
+		}
+
+		switch {
+		case trimmed == "":
+			flushPara()
+			closeList()
+		case headingLine.MatchString(trimmed):
+			flushPara()
+			closeList()
+			m := headingLine.FindStringSubmatch(trimmed)
+			// The conversation title is the page's h1
+			level := min(len(m[1])+1, 6)
+			tag := "h" + strconv.Itoa(level)
+			b.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+		case bulletLine.MatchString(line):
+			flushPara()
+			openList("ul")
+			b.WriteString("<li>" + renderInline(bulletLine.FindStringSubmatch(line)[1]) + "</li>\n")
+		case orderedLine.MatchString(line):
+			flushPara()
+			openList("ol")
+			b.WriteString("<li>" + renderInline(orderedLine.FindStringSubmatch(line)[1]) + "</li>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flushPara()
+			closeList()
+			b.WriteString("<blockquote>" + renderInline(strings.TrimSpace(trimmed[1:])) + "</blockquote>\n")
+		default:
+			closeList()
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+	closeList()
+	return b.String()
+}
+
+// codeFence returns the fence a line opens a code block with
+func codeFence(line string) (string, bool) {
+	for _, c := range []string{"`", "~"} {
+		if strings.HasPrefix(line, strings.Repeat(c, 3)) {
+			n := len(line) - len(strings.TrimLeft(line, c))
+			return strings.Repeat(c, n), true
+		}
+	}
+	return "", false
+}
+
+// renderInline escapes text and renders inline code, bold, italics and links.
+// Code spans are rendered first and kept out of the other rules.
+func renderInline(text string) string {
+	parts := inlineCode.Split(text, -1)
+	codes := inlineCode.FindAllStringSubmatch(text, -1)
+
+	var b strings.Builder
+	for i, part := range parts {
+		s := html.EscapeString(part)
+		s = linkText.ReplaceAllString(s, `<a href="$2">$1</a>`)
+		s = boldText.ReplaceAllString(s, `<strong>$1</strong>`)
+		s = italicText.ReplaceAllString(s, `$1<em>$2</em>`)
+		b.WriteString(strings.ReplaceAll(s, "\n", "<br>\n"))
+		if i < len(codes) {
+			b.WriteString("<code>" + html.EscapeString(codes[i][1]) + "</code>")
+		}
+	}
+	return b.String()
+}
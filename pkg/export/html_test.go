@@ -0,0 +1,31 @@
+package export
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one<br>\ntwo</p>\n<p>three</p>\n"},
+		{"escaping", "a <b> & c", "<p>a &lt;b&gt; &amp; c</p>\n"},
+		{"code block", "```python\nif a < b:\n    pass\n```", "<pre data-lang=\"python\"><code class=\"language-python\">if a &lt; b:\n    pass</code></pre>\n"},
+		{"code block without language", "~~~\n**not bold**\n~~~", "<pre><code>**not bold**</code></pre>\n"},
+		{"unclosed code block", "```\nx", "<pre><code>x</code></pre>\n"},
+		{"heading", "## Steps", "<h3>Steps</h3>\n"},
+		{"lists", "- a\n- b\n1. c", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol>\n<li>c</li>\n</ol>\n"},
+		{"quote", "> said", "<blockquote>said</blockquote>\n"},
+		{"inline", "use `a*b*c` and **bold** and *it*", "<p>use <code>a*b*c</code> and <strong>bold</strong> and <em>it</em></p>\n"},
+		{"link", "[docs](https://go.dev/?a=1&b=2)", "<p><a href=\"https://go.dev/?a=1&amp;b=2\">docs</a></p>\n"},
+		{"no script links", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>\n"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, renderMarkdown(tt.src), tt.name)
+	}
+}
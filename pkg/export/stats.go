@@ -0,0 +1,97 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+// StatsFormats are the formats --stats prints in
+var StatsFormats = []string{"table", "json"}
+
+// StatsRow is one line of the summary
+type StatsRow struct {
+	Key           string `json:"key,omitempty"`
+	Conversations int    `json:"conversations"`
+	Turns         int    `json:"turns"`
+	InputTokens   int64  `json:"input_tokens"`
+	OutputTokens  int64  `json:"output_tokens"`
+	AvgLatencyMS  *int64 `json:"avg_latency_ms"` // null when no turn recorded one
+}
+
+// StatsReport is the summary as JSON has it
+type StatsReport struct {
+	By     string     `json:"by,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+	Groups []StatsRow `json:"groups,omitempty"`
+	Total  StatsRow   `json:"total"`
+}
+
+func statsRow(row database.StatsRow) StatsRow {
+	r := StatsRow{
+		Key:           row.Key,
+		Conversations: row.Conversations,
+		Turns:         row.Turns,
+		InputTokens:   row.InputTokens,
+		OutputTokens:  row.OutputTokens,
+	}
+	if row.Timed > 0 {
+		ms := row.AvgLatency.Milliseconds()
+		r.AvgLatencyMS = &ms
+	}
+	return r
+}
+
+// WriteStats writes the summary as an aligned table or JSON
+func WriteStats(w io.Writer, format string, filter database.StatsFilter, stats database.Stats) error {
+	switch format {
+	case "table":
+		return writeStatsTable(w, filter, stats)
+	case "json":
+		report := StatsReport{By: filter.By, Total: statsRow(stats.Total)}
+		report.Total.Key = ""
+		if !filter.Since.IsZero() {
+			report.Since = &filter.Since
+		}
+		if !filter.Until.IsZero() {
+			report.Until = &filter.Until
+		}
+		for _, row := range stats.Groups {
+			report.Groups = append(report.Groups, statsRow(row))
+		}
+		return writeJSON(w, report)
+	default:
+		return fmt.Errorf("unknown stats format %q: must be one of %s", format, strings.Join(StatsFormats, ", "))
+	}
+}
+
+func writeStatsTable(w io.Writer, filter database.StatsFilter, stats database.Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	rows := append(slices.Clone(stats.Groups), stats.Total)
+	// The numbers are right-aligned; padding the keys keeps them on the left
+	key := strings.ToUpper(filter.By)
+	width := len(key)
+	for _, row := range rows {
+		width = max(width, len(row.Key))
+	}
+	fmt.Fprintf(tw, "%-*s\tCONVERSATIONS\tTURNS\tINPUT TOKENS\tOUTPUT TOKENS\tAVG LATENCY\t\n", width, key)
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%-*s\t%d\t%d\t%d\t%d\t%s\t\n",
+			width, row.Key, row.Conversations, row.Turns, row.InputTokens, row.OutputTokens, FormatLatency(row))
+	}
+	return tw.Flush()
+}
+
+// FormatLatency is a group's average latency for people, or "-" without one
+func FormatLatency(row database.StatsRow) string {
+	if row.Timed == 0 {
+		return "-"
+	}
+	return row.AvgLatency.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,56 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+var testStats = database.Stats{
+	Groups: []database.StatsRow{
+		{Key: "gpt-4o", Conversations: 2, Turns: 3, InputTokens: 80, OutputTokens: 800, AvgLatency: 1234 * time.Millisecond, Timed: 1},
+		{Key: "sonnet", Conversations: 1, Turns: 1, InputTokens: 20, OutputTokens: 200},
+	},
+	Total: database.StatsRow{Key: "total", Conversations: 3, Turns: 4, InputTokens: 100, OutputTokens: 1000, AvgLatency: 1234 * time.Millisecond, Timed: 1},
+}
+
+func TestWriteStatsTable(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, WriteStats(&buf, "table", database.StatsFilter{By: "model"}, testStats))
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	assert.Contains(t, lines[0], "MODEL")
+	assert.Contains(t, lines[0], "AVG LATENCY")
+	assert.Contains(t, lines[1], "gpt-4o")
+	assert.True(t, strings.HasSuffix(strings.TrimRight(lines[1], " "), "1.2s"))
+	assert.True(t, strings.HasSuffix(strings.TrimRight(lines[2], " "), "-"))
+	assert.Contains(t, lines[3], "total")
+	// The columns line up
+	assert.Equal(t, len(lines[0]), len(lines[1]))
+	assert.Equal(t, len(lines[1]), len(lines[3]))
+}
+
+func TestWriteStatsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, WriteStats(&buf, "json", database.StatsFilter{By: "model", Since: since}, testStats))
+
+	var report StatsReport
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, "model", report.By)
+	assert.Equal(t, since, *report.Since)
+	assert.Nil(t, report.Until)
+	assert.Len(t, report.Groups, 2)
+	assert.Equal(t, int64(1234), *report.Groups[0].AvgLatencyMS)
+	assert.Nil(t, report.Groups[1].AvgLatencyMS)
+	assert.Equal(t, "", report.Total.Key)
+	assert.Equal(t, int64(1000), report.Total.OutputTokens)
+
+	assert.ErrorContains(t, WriteStats(&buf, "csv", database.StatsFilter{}, testStats), "unknown stats format")
+}
@@ -0,0 +1,229 @@
+package httpclient
+
+// Builds the *http.Client handed to each provider from the `http:` config
+// block. The block can be set at the top level and/or per provider, eg:
+//
+// http:
+//   connect_timeout: 10s
+//   proxy: "http://proxy.corp:3128"
+// models:
+//   anthropic:
+//     http:
+//       idle_timeout: 60s
+//       ca_bundle: "~/certs/corp-ca.pem"
+//       headers:
+//         x-team: "platform"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/duluk/ask-ai/pkg/paths"
+)
+
+// Settings for one provider's HTTP client. Zero values mean "use the Go
+// default".
+type Settings struct {
+	// Time allowed for the TCP connection and TLS handshake
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// Longest gap allowed between bytes of a response (including the wait
+	// for the response headers) before the request is abandoned
+	IdleTimeout time.Duration     `mapstructure:"idle_timeout"`
+	Proxy       string            `mapstructure:"proxy"`
+	CABundle    string            `mapstructure:"ca_bundle"`
+	Headers     map[string]string `mapstructure:"headers"`
+}
+
+// IsZero reports whether nothing is configured, in which case SDK clients
+// can be left with their own defaults.
+func (s Settings) IsZero() bool {
+	return s.ConnectTimeout == 0 && s.IdleTimeout == 0 && s.Proxy == "" &&
+		s.CABundle == "" && len(s.Headers) == 0
+}
+
+// Merge returns s with any values set in override replacing its own. Headers
+// are combined, with override winning on conflicts.
+func (s Settings) Merge(override Settings) Settings {
+	merged := s
+	if override.ConnectTimeout != 0 {
+		merged.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.IdleTimeout != 0 {
+		merged.IdleTimeout = override.IdleTimeout
+	}
+	if override.Proxy != "" {
+		merged.Proxy = override.Proxy
+	}
+	if override.CABundle != "" {
+		merged.CABundle = override.CABundle
+	}
+	if len(s.Headers) > 0 || len(override.Headers) > 0 {
+		merged.Headers = make(map[string]string, len(s.Headers)+len(override.Headers))
+		for k, v := range s.Headers {
+			merged.Headers[k] = v
+		}
+		for k, v := range override.Headers {
+			merged.Headers[k] = v
+		}
+	}
+	return merged
+}
+
+// New returns an http.Client configured from s
+func New(s Settings) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if s.ConnectTimeout > 0 {
+		dialer := &net.Dialer{
+			Timeout:   s.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = s.ConnectTimeout
+	}
+
+	if s.IdleTimeout > 0 {
+		transport.ResponseHeaderTimeout = s.IdleTimeout
+	}
+
+	if s.Proxy != "" {
+		proxyURL, err := ParseProxy(s.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if s.CABundle != "" {
+		pool, err := loadCABundle(s.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	var rt http.RoundTripper = transport
+	if len(s.Headers) > 0 || s.IdleTimeout > 0 {
+		rt = &roundTripper{base: transport, headers: s.Headers, idleTimeout: s.IdleTimeout}
+	}
+
+	return &http.Client{Transport: rt}, nil
+}
+
+// ParseProxy parses a proxy URL, which needs its scheme: "proxy.corp:8080"
+// parses as a URL, but with "proxy.corp" as the scheme and no host
+func ParseProxy(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %q: %w", proxy, err)
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: needs a scheme and host, e.g. http://proxy.corp:3128", proxy)
+	}
+	return proxyURL, nil
+}
+
+// The bundle is added to the system roots so public endpoints keep working
+// alongside the corporate CA
+func loadCABundle(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(paths.ExpandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+type roundTripper struct {
+	base        http.RoundTripper
+	headers     map[string]string
+	idleTimeout time.Duration
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(rt.headers) > 0 {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		for k, v := range rt.headers {
+			req.Header.Set(k, v)
+		}
+	}
+
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil || rt.idleTimeout == 0 {
+		return resp, err
+	}
+
+	resp.Body = newIdleTimeoutBody(resp.Body, rt.idleTimeout)
+	return resp, nil
+}
+
+// ErrIdleTimeout is returned from a response body read once the stream has
+// been silent for longer than the idle timeout
+type ErrIdleTimeout struct {
+	Timeout time.Duration
+}
+
+func (e *ErrIdleTimeout) Error() string {
+	return fmt.Sprintf("stream idle for longer than %s", e.Timeout)
+}
+
+// idleTimeoutBody closes the underlying body if no Read completes within the
+// timeout, which unblocks a Read stuck waiting on a stalled stream.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, b.expire)
+	return b
+}
+
+func (b *idleTimeoutBody) expire() {
+	b.mu.Lock()
+	b.expired = true
+	b.mu.Unlock()
+	b.body.Close()
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	b.mu.Lock()
+	expired := b.expired
+	b.mu.Unlock()
+	if expired {
+		return n, &ErrIdleTimeout{Timeout: b.timeout}
+	}
+
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	return b.body.Close()
+}
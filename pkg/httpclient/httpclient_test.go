@@ -0,0 +1,168 @@
+package httpclient
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings_Merge(t *testing.T) {
+	global := Settings{
+		ConnectTimeout: 5 * time.Second,
+		Proxy:          "http://global:3128",
+		Headers:        map[string]string{"x-a": "1", "x-b": "2"},
+	}
+	provider := Settings{
+		IdleTimeout: time.Minute,
+		Proxy:       "http://provider:3128",
+		Headers:     map[string]string{"x-b": "override"},
+	}
+
+	merged := global.Merge(provider)
+	assert.Equal(t, 5*time.Second, merged.ConnectTimeout)
+	assert.Equal(t, time.Minute, merged.IdleTimeout)
+	assert.Equal(t, "http://provider:3128", merged.Proxy)
+	assert.Equal(t, map[string]string{"x-a": "1", "x-b": "override"}, merged.Headers)
+	// The originals are untouched
+	assert.Equal(t, "2", global.Headers["x-b"])
+}
+
+func TestSettings_IsZero(t *testing.T) {
+	assert.True(t, Settings{}.IsZero())
+	assert.False(t, Settings{Proxy: "http://p"}.IsZero())
+}
+
+func TestNew_Headers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Team")))
+	}))
+	defer server.Close()
+
+	client, err := New(Settings{Headers: map[string]string{"x-team": "platform"}})
+	assert.NoError(t, err)
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "platform", string(body))
+}
+
+func TestNew_Proxy(t *testing.T) {
+	// A plain HTTP proxy receives the absolute URL in the request line
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	client, err := New(Settings{Proxy: proxy.URL})
+	assert.NoError(t, err)
+
+	resp, err := client.Get("http://api.example.invalid/v1/chat")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "http://api.example.invalid/v1/chat", proxied)
+}
+
+func TestNew_InvalidProxy(t *testing.T) {
+	_, err := New(Settings{Proxy: "://nope"})
+	assert.Error(t, err)
+
+	// These parse, but without a host to connect to
+	for _, proxy := range []string{"proxy.corp:8080", "localhost:3128", "/proxy"} {
+		_, err = New(Settings{Proxy: proxy})
+		assert.ErrorContains(t, err, "needs a scheme and host", proxy)
+	}
+}
+
+func TestNew_CABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// Without the bundle the test server's self-signed cert is rejected
+	plain, err := New(Settings{})
+	assert.NoError(t, err)
+	_, err = plain.Get(server.URL)
+	assert.Error(t, err)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	os.WriteFile(bundle, certPEM, 0o644)
+
+	client, err := New(Settings{CABundle: bundle})
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+}
+
+func TestNew_CABundleErrors(t *testing.T) {
+	_, err := New(Settings{CABundle: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a cert"), 0o644)
+	_, err = New(Settings{CABundle: empty})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no certificates found")
+}
+
+func TestNew_IdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: first\n"))
+		w.(http.Flusher).Flush()
+		// Stall mid-stream
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := New(Settings{IdleTimeout: 100 * time.Millisecond})
+	assert.NoError(t, err)
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	start := time.Now()
+	_, err = io.ReadAll(resp.Body)
+	var idleErr *ErrIdleTimeout
+	assert.True(t, errors.As(err, &idleErr), "expected idle timeout, got %v", err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestNew_IdleTimeoutResetByData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Total time exceeds the idle timeout but no single gap does
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("tick\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(Settings{IdleTimeout: 150 * time.Millisecond})
+	assert.NoError(t, err)
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 25, len(body))
+}
@@ -0,0 +1,438 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/duluk/ask-ai/pkg/database"
+	"github.com/duluk/ask-ai/pkg/export"
+)
+
+// Sources are the export formats Import reads, as given to --from
+var Sources = []string{"chatgpt", "claude", "jsonl"}
+
+// Result counts what an import did
+type Result struct {
+	Imported int // new conversations
+	Skipped  int // conversations already imported by an earlier run
+}
+
+// ImportFile imports the conversations in the export at path. from is one of
+// Sources, or "" to work it out from the file. ChatGPT and Claude exports can
+// be given as the downloaded zip or the conversations.json inside it.
+func ImportFile(db *database.ChatDB, path, from string) (Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, err
+	}
+	data, err = unzip(data)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if from == "" {
+		if from, err = Detect(data); err != nil {
+			return Result{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	convs, err := Parse(data, from)
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return Import(db, convs)
+}
+
+// Import stores the conversations, skipping those imported before
+func Import(db *database.ChatDB, convs []database.ImportedConversation) (Result, error) {
+	var res Result
+	for _, conv := range convs {
+		_, added, err := db.ImportConversation(conv)
+		if err != nil {
+			return res, fmt.Errorf("error importing %q: %w", conv.Title, err)
+		}
+		if added {
+			res.Imported++
+		} else {
+			res.Skipped++
+		}
+	}
+	return res, nil
+}
+
+// Parse reads an export in the given format. Only the text of user and
+// assistant messages is kept; consecutive messages from the same side, like
+// an answer split around a tool call, are joined. Conversations with no text
+// left are dropped.
+func Parse(data []byte, from string) ([]database.ImportedConversation, error) {
+	var convs []database.ImportedConversation
+	var err error
+	switch from {
+	case "chatgpt":
+		convs, err = parseChatGPT(data)
+	case "claude":
+		convs, err = parseClaude(data)
+	case "jsonl":
+		convs, err = parseJSONL(data)
+	default:
+		return nil, fmt.Errorf("unknown import format %q: must be one of %s", from, strings.Join(Sources, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kept := convs[:0]
+	for _, conv := range convs {
+		conv.Messages = joinMessages(conv.Messages)
+		if len(conv.Messages) > 0 {
+			kept = append(kept, conv)
+		}
+	}
+	return kept, nil
+}
+
+// Detect works out which of Sources the export is in
+func Detect(data []byte) (string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "", errors.New("file is empty")
+	}
+	if trimmed[0] == '{' {
+		return "jsonl", nil
+	}
+
+	var items []map[string]json.RawMessage
+	if trimmed[0] != '[' || json.Unmarshal(trimmed, &items) != nil {
+		return "", errors.New("not a ChatGPT, Claude or JSONL export; use --from")
+	}
+	for _, item := range items {
+		switch {
+		case item["mapping"] != nil:
+			return "chatgpt", nil
+		case item["chat_messages"] != nil:
+			return "claude", nil
+		case item["messages"] != nil:
+			return "jsonl", nil
+		}
+	}
+	return "", errors.New("can't tell what kind of export this is; use --from")
+}
+
+// unzip returns conversations.json from a zipped export, or data unchanged
+// when it isn't a zip
+func unzip(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return data, nil
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != "conversations.json" && !strings.HasSuffix(f.Name, "/conversations.json") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, errors.New("zip has no conversations.json")
+}
+
+// joinMessages drops empty messages and joins consecutive ones from the same
+// side
+func joinMessages(msgs []database.ImportedMessage) []database.ImportedMessage {
+	var out []database.ImportedMessage
+	for _, m := range msgs {
+		m.Content = strings.TrimSpace(m.Content)
+		if m.Content == "" {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Role == m.Role {
+			out[n-1].Content += "\n\n" + m.Content
+			out[n-1].Tokens += m.Tokens
+			if m.Model != "" {
+				out[n-1].Model = m.Model
+			}
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// chatgptConversation is a conversation in ChatGPT's conversations.json.
+// Messages form a tree, since editing a prompt or regenerating an answer
+// branches it; current_node is the last message of the branch that was
+// showing.
+type chatgptConversation struct {
+	ID               string                 `json:"id"`
+	ConversationID   string                 `json:"conversation_id"`
+	Title            string                 `json:"title"`
+	CreateTime       float64                `json:"create_time"`
+	CurrentNode      string                 `json:"current_node"`
+	DefaultModelSlug string                 `json:"default_model_slug"`
+	Mapping          map[string]chatgptNode `json:"mapping"`
+}
+
+type chatgptNode struct {
+	Parent  *string         `json:"parent"`
+	Message *chatgptMessage `json:"message"`
+}
+
+type chatgptMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+func parseChatGPT(data []byte) ([]database.ImportedConversation, error) {
+	var raw []chatgptConversation
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid ChatGPT export: %w", err)
+	}
+
+	convs := make([]database.ImportedConversation, 0, len(raw))
+	for _, c := range raw {
+		conv := database.ImportedConversation{
+			Source:   "chatgpt",
+			SourceID: c.ConversationID,
+			Title:    c.Title,
+			Created:  unixTime(c.CreateTime),
+		}
+		if conv.SourceID == "" {
+			conv.SourceID = c.ID
+		}
+
+		// Walk up from the current node, then put the branch in order
+		var branch []*chatgptMessage
+		seen := make(map[string]bool)
+		for id := c.CurrentNode; id != "" && !seen[id]; {
+			seen[id] = true
+			node, ok := c.Mapping[id]
+			if !ok {
+				break
+			}
+			if node.Message != nil {
+				branch = append(branch, node.Message)
+			}
+			if node.Parent == nil {
+				break
+			}
+			id = *node.Parent
+		}
+		slices.Reverse(branch)
+
+		for _, m := range branch {
+			role := m.Author.Role
+			if (role != "user" && role != "assistant") || m.Metadata.Hidden {
+				continue
+			}
+			if ct := m.Content.ContentType; ct != "text" && ct != "multimodal_text" {
+				continue
+			}
+			msg := database.ImportedMessage{
+				Role:      role,
+				Content:   chatgptText(m.Content.Parts),
+				Timestamp: conv.Created,
+			}
+			if m.CreateTime != nil {
+				msg.Timestamp = unixTime(*m.CreateTime)
+			}
+			if role == "assistant" {
+				msg.Model = m.Metadata.ModelSlug
+				if msg.Model == "" {
+					msg.Model = c.DefaultModelSlug
+				}
+			}
+			conv.Messages = append(conv.Messages, msg)
+		}
+
+		if conv.SourceID == "" {
+			conv.SourceID = contentID(conv)
+		}
+		convs = append(convs, conv)
+	}
+	return convs, nil
+}
+
+// chatgptText joins the text parts of a message; other parts are images and
+// files, which aren't kept
+func chatgptText(parts []json.RawMessage) string {
+	var texts []string
+	for _, part := range parts {
+		var s string
+		if json.Unmarshal(part, &s) == nil && s != "" {
+			texts = append(texts, s)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// claudeConversation is a conversation in Claude's conversations.json
+type claudeConversation struct {
+	UUID      string `json:"uuid"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	Model     string `json:"model"`
+	Messages  []struct {
+		Sender    string `json:"sender"`
+		Text      string `json:"text"`
+		CreatedAt string `json:"created_at"`
+		Content   []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"chat_messages"`
+}
+
+func parseClaude(data []byte) ([]database.ImportedConversation, error) {
+	var raw []claudeConversation
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid Claude export: %w", err)
+	}
+
+	convs := make([]database.ImportedConversation, 0, len(raw))
+	for _, c := range raw {
+		conv := database.ImportedConversation{
+			Source:   "claude",
+			SourceID: c.UUID,
+			Title:    c.Name,
+			Created:  isoTime(c.CreatedAt),
+		}
+		for _, m := range c.Messages {
+			msg := database.ImportedMessage{Timestamp: isoTime(m.CreatedAt)}
+			switch m.Sender {
+			case "human":
+				msg.Role = "user"
+			case "assistant":
+				msg.Role = "assistant"
+				msg.Model = c.Model
+			default:
+				continue
+			}
+			// Newer exports split the message into typed blocks; text
+			// has all of it in older ones
+			var texts []string
+			for _, block := range m.Content {
+				if block.Type == "text" && block.Text != "" {
+					texts = append(texts, block.Text)
+				}
+			}
+			msg.Content = strings.Join(texts, "\n\n")
+			if msg.Content == "" {
+				msg.Content = m.Text
+			}
+			conv.Messages = append(conv.Messages, msg)
+		}
+
+		if conv.SourceID == "" {
+			conv.SourceID = contentID(conv)
+		}
+		convs = append(convs, conv)
+	}
+	return convs, nil
+}
+
+// parseJSONL reads conversations as --export writes them: one per line in
+// jsonl, or the object or array json writes. They're identified by their
+// content, since their IDs belong to another database.
+func parseJSONL(data []byte) ([]database.ImportedConversation, error) {
+	var raw []export.Conversation
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		err := dec.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSONL export: %w", err)
+		}
+
+		if bytes.HasPrefix(value, []byte("[")) {
+			var list []export.Conversation
+			if err := json.Unmarshal(value, &list); err != nil {
+				return nil, fmt.Errorf("invalid JSONL export: %w", err)
+			}
+			raw = append(raw, list...)
+			continue
+		}
+		var conv export.Conversation
+		if err := json.Unmarshal(value, &conv); err != nil {
+			return nil, fmt.Errorf("invalid JSONL export: %w", err)
+		}
+		raw = append(raw, conv)
+	}
+
+	convs := make([]database.ImportedConversation, 0, len(raw))
+	for _, c := range raw {
+		conv := database.ImportedConversation{
+			Source:  "jsonl",
+			Title:   c.Title,
+			Created: c.Created,
+		}
+		for _, m := range c.Messages {
+			if m.Role != "user" && m.Role != "assistant" {
+				continue
+			}
+			conv.Messages = append(conv.Messages, database.ImportedMessage{
+				Role:      m.Role,
+				Content:   m.Content,
+				Model:     m.Model,
+				Timestamp: m.Timestamp,
+				Tokens:    m.Tokens,
+			})
+		}
+		conv.SourceID = contentID(conv)
+		convs = append(convs, conv)
+	}
+	return convs, nil
+}
+
+// contentID identifies a conversation that has no ID of its own by its
+// creation time and messages
+func contentID(conv database.ImportedConversation) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", conv.Created.Unix())
+	for _, m := range conv.Messages {
+		fmt.Fprintf(h, "%s\n%d\n%s\n", m.Role, len(m.Content), m.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func unixTime(secs float64) time.Time {
+	if secs <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC()
+}
+
+func isoTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
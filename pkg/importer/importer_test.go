@@ -0,0 +1,159 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/database"
+	"github.com/duluk/ask-ai/pkg/export"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	assert.Nil(t, err)
+	return data
+}
+
+func TestParseChatGPT(t *testing.T) {
+	convs, err := Parse(readFixture(t, "chatgpt.json"), "chatgpt")
+	assert.Nil(t, err)
+	// The empty chat is dropped
+	assert.Len(t, convs, 1)
+
+	conv := convs[0]
+	assert.Equal(t, "chatgpt", conv.Source)
+	assert.Equal(t, "6632a1b0-0000-4000-8000-000000000001", conv.SourceID)
+	assert.Equal(t, "Sorting in Go", conv.Title)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 500000000, time.UTC), conv.Created)
+
+	// Only the current branch, without the hidden system message, the tool
+	// output or the image
+	assert.Len(t, conv.Messages, 4)
+	assert.Equal(t, "How do I sort a slice?", conv.Messages[0].Content)
+	assert.Equal(t, "user", conv.Messages[0].Role)
+	assert.Equal(t, "", conv.Messages[0].Model)
+	assert.Equal(t, "Use `slices.Sort(s)`.", conv.Messages[1].Content)
+	assert.Equal(t, "gpt-4o", conv.Messages[1].Model)
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 3, 0, time.UTC), conv.Messages[1].Timestamp)
+	assert.Equal(t, "And with this image?", conv.Messages[2].Content)
+	assert.Equal(t, "gpt-4o-mini", conv.Messages[3].Model)
+}
+
+func TestParseClaude(t *testing.T) {
+	convs, err := Parse(readFixture(t, "claude.json"), "claude")
+	assert.Nil(t, err)
+	assert.Len(t, convs, 1)
+
+	conv := convs[0]
+	assert.Equal(t, "claude", conv.Source)
+	assert.Equal(t, "1f0b7c3e-0000-4000-8000-000000000001", conv.SourceID)
+	assert.Equal(t, "Haiku about tea", conv.Title)
+	assert.Len(t, conv.Messages, 3)
+	assert.Equal(t, "Steam curls from the cup\n\nquiet leaves unfold", conv.Messages[1].Content)
+	assert.Equal(t, "assistant", conv.Messages[1].Role)
+	// Older messages only have text
+	assert.Equal(t, "Another", conv.Messages[2].Content)
+	assert.Equal(t, time.Date(2024, 6, 1, 8, 30, 5, 0, time.UTC), conv.Messages[1].Timestamp)
+}
+
+func TestParseJSONL(t *testing.T) {
+	ts := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+	conv := export.Conversation{ID: 3, Title: "Exported", Created: ts, Messages: []export.Message{
+		{Role: "user", Content: "q", Timestamp: ts},
+		{Role: "assistant", Content: "a", Model: "sonnet", Timestamp: ts, Tokens: 7},
+	}}
+	var buf bytes.Buffer
+	assert.Nil(t, export.Write(&buf, "jsonl", []export.Conversation{conv, conv}))
+
+	convs, err := Parse(buf.Bytes(), "jsonl")
+	assert.Nil(t, err)
+	assert.Len(t, convs, 2)
+	assert.Equal(t, "Exported", convs[0].Title)
+	assert.Equal(t, "sonnet", convs[0].Messages[1].Model)
+	assert.Equal(t, int32(7), convs[0].Messages[1].Tokens)
+	// Identified by content, so the same conversation twice is one
+	assert.Equal(t, convs[0].SourceID, convs[1].SourceID)
+
+	// The json export, an array, reads the same
+	buf.Reset()
+	assert.Nil(t, export.Write(&buf, "json", []export.Conversation{conv}))
+	convs, err = Parse(buf.Bytes(), "jsonl")
+	assert.Nil(t, err)
+	assert.Len(t, convs, 1)
+
+	_, err = Parse([]byte("{not json"), "jsonl")
+	assert.ErrorContains(t, err, "invalid JSONL export")
+	_, err = Parse(nil, "bard")
+	assert.ErrorContains(t, err, "unknown import format")
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{string(readFixture(t, "chatgpt.json")), "chatgpt"},
+		{string(readFixture(t, "claude.json")), "claude"},
+		{`{"id": 1, "messages": []}` + "\n", "jsonl"},
+		{`[{"id": 1, "messages": []}]`, "jsonl"},
+	}
+	for _, tt := range tests {
+		got, err := Detect([]byte(tt.data))
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := Detect([]byte("  "))
+	assert.ErrorContains(t, err, "empty")
+	_, err = Detect([]byte("hello"))
+	assert.ErrorContains(t, err, "use --from")
+}
+
+func TestImportFile(t *testing.T) {
+	db, err := database.InitializeDB(":memory:", "import_test")
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "mine", Response: "ours", Model: "m"}))
+
+	// ChatGPT exports arrive zipped
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	f, err := zw.Create("conversations.json")
+	assert.Nil(t, err)
+	_, err = f.Write(readFixture(t, "chatgpt.json"))
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+	zipPath := filepath.Join(t.TempDir(), "chatgpt-export.zip")
+	assert.Nil(t, os.WriteFile(zipPath, zipped.Bytes(), 0o644))
+
+	res, err := ImportFile(db, zipPath, "")
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Imported: 1}, res)
+	res, err = ImportFile(db, filepath.Join("testdata", "claude.json"), "claude")
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Imported: 1}, res)
+
+	// Running it again finds nothing new
+	res, err = ImportFile(db, zipPath, "chatgpt")
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Skipped: 1}, res)
+
+	// Imported conversations get new IDs and show up in list and search
+	ids, err := db.ListConversationIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 3}, ids)
+	results, err := db.Search("haiku")
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 3, results[0].ConvID)
+	assert.Equal(t, "Haiku about tea", results[0].Title)
+
+	_, err = ImportFile(db, filepath.Join("testdata", "missing.json"), "")
+	assert.NotNil(t, err)
+}
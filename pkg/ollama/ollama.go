@@ -1,7 +1,6 @@
 package ollama
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -9,6 +8,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+
+	"github.com/duluk/ask-ai/pkg/sse"
 )
 
 const OllamaBaseURL = "http://bamf.midgaard.xyz:11434/v1/chat/completions"
@@ -143,7 +144,7 @@ func (c *Client) ChatCompletionStream(req ChatCompletionRequest, handler StreamH
 		return fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	url, err := url.Parse(OllamaBaseURL)
+	url, err := url.Parse(c.BaseURL)
 	if err != nil {
 		return fmt.Errorf("failed to parse URL: %v", err)
 	}
@@ -172,48 +173,15 @@ func (c *Client) ChatCompletionStream(req ChatCompletionRequest, handler StreamH
 		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	reader := bufio.NewReader(resp.Body)
-	for {
-		line, err := reader.ReadBytes('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("error reading stream: %v", err)
-		}
-
-		line = bytes.TrimSpace(line)
-		if len(line) == 0 {
-			continue
-		}
-
-		// Create a file and write the line to it
-		// f, err := os.Create("raw_data.json")
-		// if err != nil {
-		// 	return fmt.Errorf("error creating file: %v", err)
-		// }
-		// defer f.Close()
-		// f.Write(line) // I don't care if it fails
-
-		// Strip "data: " prefix from SSE format
-		if bytes.HasPrefix(line, []byte("data: ")) {
-			line = bytes.TrimPrefix(line, []byte("data: "))
-		}
-
-		// Skip any "data: [DONE]" messages
-		if string(line) == "[DONE]" {
-			continue
-		}
-
+	return sse.Each(resp.Body, func(evt sse.Event) error {
 		var chunk ChatCompletionChunk
-		if err := json.Unmarshal(line, &chunk); err != nil {
-			return fmt.Errorf("error unmarshaling chunk: %v\nRaw data: %s", err, string(line))
+		if err := json.Unmarshal([]byte(evt.Data), &chunk); err != nil {
+			return fmt.Errorf("error unmarshaling chunk: %v\nRaw data: %s", err, evt.Data)
 		}
 
 		handler(chunk)
-	}
-
-	return nil
+		return nil
+	})
 }
 
 // Add this new method
@@ -2,12 +2,17 @@ package ollama
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/sse"
 )
 
 // stubTransport implements http.RoundTripper for testing
@@ -24,7 +29,7 @@ func TestChatCompletionStream_Success(t *testing.T) {
 	raw1 := `{"choices":[{"delta":{"content":"chunk1"}}]}`
 	raw2 := `{"choices":[{"delta":{"content":"chunk2"}}]}`
 	// Build SSE-like stream data
-	data := fmt.Sprintf("data: %s\n", raw1) + fmt.Sprintf("data: %s\n", raw2) + "data: [DONE]\n"
+	data := fmt.Sprintf("data: %s\n\n", raw1) + fmt.Sprintf("data: %s\n\n", raw2) + "data: [DONE]\n\n"
 
 	// Set up client with stub transport
 	client := NewClient("key", "unused")
@@ -78,3 +83,49 @@ func TestChatCompletionStream_UnmarshalError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "error unmarshaling chunk")
 }
+
+func stubStream(t *testing.T, recording string) *Client {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("key", "unused")
+	client.HTTPClient = &http.Client{Transport: &stubTransport{fn: func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewReader(data)),
+			Header:     make(http.Header),
+		}, nil
+	}}}
+	return client
+}
+
+func TestChatCompletionStream_Recorded(t *testing.T) {
+	client := stubStream(t, "chat_stream.txt")
+
+	var content string
+	var finish any
+	err := client.ChatCompletionStream(ChatCompletionRequest{}, func(chunk ChatCompletionChunk) {
+		content += chunk.Choices[0].Delta.Content
+		if chunk.Choices[0].FinishReason != nil {
+			finish = chunk.Choices[0].FinishReason
+		}
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "The sky", content)
+	assert.Equal(t, "stop", finish)
+}
+
+func TestChatCompletionStream_ErrorMidStream(t *testing.T) {
+	client := stubStream(t, "chat_stream_error.txt")
+
+	var content string
+	err := client.ChatCompletionStream(ChatCompletionRequest{}, func(chunk ChatCompletionChunk) {
+		content += chunk.Choices[0].Delta.Content
+	})
+	var streamErr *sse.StreamError
+	assert.True(t, errors.As(err, &streamErr))
+	assert.Contains(t, err.Error(), "more system memory")
+	assert.Equal(t, "Par", content)
+}
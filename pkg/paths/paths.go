@@ -0,0 +1,20 @@
+// Package paths resolves the file paths given in the config.
+package paths
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandHome replaces a leading ~ with the user's home directory and expands
+// $VAR and ${VAR} from the environment
+func ExpandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if u, err := user.Current(); err == nil {
+			path = filepath.Join(u.HomeDir, path[1:])
+		}
+	}
+	return os.ExpandEnv(path)
+}
@@ -0,0 +1,28 @@
+package paths
+
+import (
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandHome(t *testing.T) {
+	u, err := user.Current()
+	assert.NoError(t, err)
+	t.Setenv("ASK_AI_TEST_DIR", "/tmp/keys")
+
+	tests := map[string]string{
+		"~":                         u.HomeDir,
+		"~/.config/ask-ai":          filepath.Join(u.HomeDir, ".config/ask-ai"),
+		"$ASK_AI_TEST_DIR/openai":   "/tmp/keys/openai",
+		"${ASK_AI_TEST_DIR}/openai": "/tmp/keys/openai",
+		"/etc/ssl/ca.pem":           "/etc/ssl/ca.pem",
+		"relative/~/path":           "relative/~/path",
+		"~someone/else":             "~someone/else",
+	}
+	for path, want := range tests {
+		assert.Equal(t, want, ExpandHome(path), path)
+	}
+}
@@ -0,0 +1,238 @@
+package sse
+
+// A decoder for Server-Sent Events as described in the WHATWG HTML spec
+// (https://html.spec.whatwg.org/multipage/server-sent-events.html), for the
+// hand-written streaming clients (pkg/ollama, pkg/deepseek). The SDK clients
+// have their own.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DoneData is the OpenAI-style sentinel that ends a completion stream
+const DoneData = "[DONE]"
+
+// Event is a single dispatched event. Event is "message" unless the stream
+// named it; Data has the lines of a multi-line payload joined with "\n".
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry int // milliseconds, 0 if not sent
+}
+
+// Decoder reads events from an SSE stream
+type Decoder struct {
+	r      *bufio.Reader
+	lastID string
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next event, or io.EOF when the stream is finished.
+// Unlike the spec, an event still pending when the stream ends without a
+// trailing blank line is dispatched rather than dropped; servers that get
+// cut off mid-stream are better reported than silently ignored.
+func (d *Decoder) Next() (Event, error) {
+	var (
+		evt     Event
+		data    strings.Builder
+		hasData bool
+	)
+
+	dispatch := func() Event {
+		evt.ID = d.lastID
+		if evt.Event == "" {
+			evt.Event = "message"
+		}
+		evt.Data = data.String()
+		return evt
+	}
+
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			if err == io.EOF && hasData {
+				return dispatch(), nil
+			}
+			return Event{}, err
+		}
+
+		// A blank line dispatches the event; one with no data is discarded
+		if line == "" {
+			if hasData {
+				return dispatch(), nil
+			}
+			evt = Event{}
+			continue
+		}
+
+		// Comments are used as keep-alives
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+
+		switch field {
+		case "event":
+			evt.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastID = value
+			}
+		case "retry":
+			if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+				evt.Retry = n
+			}
+		default:
+			// Unknown fields are ignored per the spec
+		}
+	}
+}
+
+// Lines may end in "\r\n", "\n" or a lone "\r"
+func (d *Decoder) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+		switch b {
+		case '\n':
+			return string(line), nil
+		case '\r':
+			if next, err := d.r.Peek(1); err == nil && next[0] == '\n' {
+				d.r.ReadByte()
+			}
+			return string(line), nil
+		default:
+			line = append(line, b)
+		}
+	}
+}
+
+// StreamError is an error the provider sent in the middle of a stream,
+// either as an `event: error` or as a data payload with an "error" member
+type StreamError struct {
+	Type    string
+	Code    string
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	switch {
+	case e.Type != "" && e.Code != "":
+		return fmt.Sprintf("stream error (%s, %s): %s", e.Type, e.Code, e.Message)
+	case e.Type != "":
+		return fmt.Sprintf("stream error (%s): %s", e.Type, e.Message)
+	default:
+		return "stream error: " + e.Message
+	}
+}
+
+// Err returns a *StreamError if the event reports a provider error, nil
+// otherwise
+func (e Event) Err() error {
+	isErrorEvent := e.Event == "error"
+
+	// Cheap check before paying for a JSON decode of every chunk
+	if !isErrorEvent && !strings.Contains(e.Data, `"error"`) {
+		return nil
+	}
+
+	var payload struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(e.Data), &payload); err != nil {
+		if isErrorEvent {
+			return &StreamError{Message: strings.TrimSpace(e.Data)}
+		}
+		return nil
+	}
+
+	if len(payload.Error) > 0 && !bytes.Equal(payload.Error, []byte("null")) {
+		var detail struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"`
+		}
+		if err := json.Unmarshal(payload.Error, &detail); err == nil {
+			se := &StreamError{Type: detail.Type, Message: detail.Message}
+			if detail.Code != nil {
+				se.Code = fmt.Sprint(detail.Code)
+			}
+			return se
+		}
+		var msg string
+		if err := json.Unmarshal(payload.Error, &msg); err == nil {
+			return &StreamError{Type: payload.Type, Message: msg}
+		}
+	}
+
+	if isErrorEvent {
+		msg := payload.Message
+		if msg == "" {
+			msg = strings.TrimSpace(e.Data)
+		}
+		return &StreamError{Type: payload.Type, Message: msg}
+	}
+	return nil
+}
+
+// ErrStop can be returned from an Each callback to end the stream early
+// without an error
+var ErrStop = errors.New("stop stream")
+
+// Each calls fn for every event on the stream until it ends, the "[DONE]"
+// sentinel arrives or fn returns an error. Events carrying a provider error
+// end the stream with that error instead of being passed to fn.
+func Each(r io.Reader, fn func(Event) error) error {
+	dec := NewDecoder(r)
+	for {
+		evt, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading stream: %w", err)
+		}
+
+		if evt.Data == DoneData {
+			return nil
+		}
+		if err := evt.Err(); err != nil {
+			return err
+		}
+		if err := fn(evt); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+}
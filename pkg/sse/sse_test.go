@@ -0,0 +1,178 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openRecording(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func collect(t *testing.T, r io.Reader) []Event {
+	t.Helper()
+	var events []Event
+	dec := NewDecoder(r)
+	for {
+		evt, err := dec.Next()
+		if err == io.EOF {
+			return events
+		}
+		assert.NoError(t, err)
+		events = append(events, evt)
+	}
+}
+
+func TestDecoder_OpenAIRecording(t *testing.T) {
+	events := collect(t, openRecording(t, "openai_chat.txt"))
+	assert.Len(t, events, 5)
+	for _, evt := range events {
+		assert.Equal(t, "message", evt.Event)
+	}
+	assert.Equal(t, DoneData, events[4].Data)
+
+	var chunk struct {
+		Choices []struct {
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(events[1].Data), &chunk))
+	assert.Equal(t, "Hello", chunk.Choices[0].Delta.Content)
+}
+
+func TestDecoder_EventFields(t *testing.T) {
+	events := collect(t, openRecording(t, "anthropic_messages.txt"))
+	var types []string
+	for _, evt := range events {
+		types = append(types, evt.Event)
+	}
+	assert.Equal(t, []string{
+		"message_start", "content_block_start", "ping", "content_block_delta",
+		"content_block_delta", "content_block_stop", "message_stop",
+	}, types)
+}
+
+func TestDecoder_CommentsIgnored(t *testing.T) {
+	events := collect(t, openRecording(t, "deepseek_keepalive.txt"))
+	assert.Len(t, events, 4)
+	for _, evt := range events {
+		assert.False(t, strings.Contains(evt.Data, "keep-alive"))
+	}
+}
+
+func TestDecoder_MultilineAndLineEndings(t *testing.T) {
+	events := collect(t, openRecording(t, "multiline_crlf.txt"))
+	assert.Len(t, events, 3)
+
+	assert.Equal(t, "notes", events[0].Event)
+	assert.Equal(t, "first line\nsecond line\nthird line", events[0].Data)
+	assert.Equal(t, "7", events[0].ID)
+	assert.Equal(t, 3000, events[0].Retry)
+
+	// Lone CR line endings, and the last event ID carries over
+	assert.Equal(t, "lone cr", events[1].Data)
+	assert.Equal(t, "7", events[1].ID)
+	assert.Equal(t, "message", events[1].Event)
+
+	// A bare field name is a field with an empty value
+	assert.Equal(t, "", events[2].Data)
+}
+
+func TestDecoder_EventWithoutDataDiscarded(t *testing.T) {
+	events := collect(t, strings.NewReader("event: nothing\n\ndata: x\n\n"))
+	assert.Len(t, events, 1)
+	assert.Equal(t, "message", events[0].Event)
+	assert.Equal(t, "x", events[0].Data)
+}
+
+func TestDecoder_UnterminatedEventAtEOF(t *testing.T) {
+	events := collect(t, strings.NewReader("data: a\n\ndata: cut off"))
+	assert.Len(t, events, 2)
+	assert.Equal(t, "cut off", events[1].Data)
+}
+
+func TestEach_StopsAtDone(t *testing.T) {
+	var data []string
+	err := Each(openRecording(t, "openai_chat.txt"), func(evt Event) error {
+		data = append(data, evt.Data)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, data, 4)
+}
+
+func TestEach_ErrorEvent(t *testing.T) {
+	var seen int
+	err := Each(openRecording(t, "anthropic_overloaded.txt"), func(evt Event) error {
+		seen++
+		return nil
+	})
+	var streamErr *StreamError
+	assert.True(t, errors.As(err, &streamErr))
+	assert.Equal(t, "overloaded_error", streamErr.Type)
+	assert.Equal(t, "Overloaded", streamErr.Message)
+	// Events before the error were delivered
+	assert.Equal(t, 2, seen)
+}
+
+func TestEach_ErrorPayload(t *testing.T) {
+	err := Each(openRecording(t, "openai_error_payload.txt"), func(evt Event) error { return nil })
+	var streamErr *StreamError
+	assert.True(t, errors.As(err, &streamErr))
+	assert.Equal(t, "server_error", streamErr.Type)
+	assert.Contains(t, err.Error(), "The server had an error")
+}
+
+func TestEach_CallbackError(t *testing.T) {
+	boom := errors.New("boom")
+	err := Each(openRecording(t, "openai_chat.txt"), func(evt Event) error { return boom })
+	assert.ErrorIs(t, err, boom)
+
+	var calls int
+	err = Each(openRecording(t, "openai_chat.txt"), func(evt Event) error {
+		calls++
+		return ErrStop
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestEventErr(t *testing.T) {
+	tests := []struct {
+		name string
+		evt  Event
+		want string
+	}{
+		{"plain chunk", Event{Event: "message", Data: `{"choices":[]}`}, ""},
+		{"content mentioning error", Event{Event: "message", Data: `{"content":"the \"error\" was mine"}`}, ""},
+		{"null error member", Event{Event: "message", Data: `{"error":null}`}, ""},
+		{"string error", Event{Event: "message", Data: `{"error":"rate limited"}`}, "stream error: rate limited"},
+		{"error event with text", Event{Event: "error", Data: "upstream went away"}, "stream error: upstream went away"},
+		{"error event with code", Event{Event: "error", Data: `{"error":{"type":"invalid_request_error","code":400,"message":"bad"}}`}, "stream error (invalid_request_error, 400): bad"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.evt.Err()
+			if tt.want == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.want)
+			}
+		})
+	}
+}
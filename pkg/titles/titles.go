@@ -0,0 +1,154 @@
+// Package titles names conversations with a short title generated by the
+// model configured as defaults.title_model.
+package titles
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/duluk/ask-ai/pkg/LLM"
+	"github.com/duluk/ask-ai/pkg/config"
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+var ErrNoTitleModel = errors.New("no title model configured (defaults.title_model)")
+
+// Titles only need a few tokens unless the model's config asks for more,
+// e.g. for a reasoning model
+const defaultMaxTokens = 64
+
+// newClient is swapped out in tests
+var newClient = LLM.NewClient
+
+// Generate names the conversation from its first exchange and stores the
+// title, unless the conversation already has one. It returns the stored title.
+// A conversation the model couldn't name, or with nothing to name, is marked
+// so that Catchup passes over it.
+func Generate(opts *config.Options, db database.Store, convID int) (string, error) {
+	if opts.TitleModel == "" {
+		return "", ErrNoTitleModel
+	}
+
+	title, err := db.GetTitle(convID)
+	if err != nil || title != "" {
+		return title, err
+	}
+
+	msgs, err := db.LoadConversationFromDB(convID)
+	if err != nil {
+		return "", err
+	}
+	var prompt, response string
+	for _, msg := range msgs {
+		if msg.Role == "user" && prompt == "" {
+			prompt = msg.Content
+		} else if msg.Role == "assistant" && prompt != "" {
+			response = msg.Content
+			break
+		}
+	}
+	if response == "" {
+		return "", failed(db, convID, fmt.Errorf("conversation %d has no exchange to title", convID))
+	}
+
+	provider, _, modelConf, err := config.ResolveModel(opts.Config, opts.Config.Defaults.Provider, opts.TitleModel)
+	if err != nil {
+		return "", fmt.Errorf("title model: %w", err)
+	}
+	client, err := newClient(provider)
+	if err != nil {
+		return "", err
+	}
+
+	model := modelConf.ModelName
+	temperature := float32(modelConf.Temperature)
+	maxTokens := modelConf.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = defaultMaxTokens
+	}
+	thinking := modelConf.Thinking
+	if thinking == "" {
+		thinking = opts.Thinking
+	}
+	args := LLM.ClientArgs{
+		Model:       &model,
+		MaxTokens:   &maxTokens,
+		Temperature: &temperature,
+		Thinking:    &thinking,
+		ConvID:      &convID,
+	}
+
+	title, err = LLM.GenerateTitle(client, args, prompt, response)
+	if err != nil {
+		return "", failed(db, convID, err)
+	}
+	if ok, err := db.SetTitleIfEmpty(convID, title); err != nil || !ok {
+		// Titled by hand while we waited on the model
+		return db.GetTitle(convID)
+	}
+	return title, nil
+}
+
+// failed marks the conversation as one the model couldn't name and returns
+// err, the reason
+func failed(db database.Store, convID int, err error) error {
+	// A conversation with no turns yet has nothing to mark
+	if markErr := db.MarkTitleFailed(convID); markErr != nil && !errors.Is(markErr, database.ErrNotFound) {
+		return fmt.Errorf("%w (and marking it: %v)", err, markErr)
+	}
+	return err
+}
+
+// Backfill generates titles for every conversation without one, calling
+// report after each. A failure doesn't stop the others; the number titled is
+// returned.
+func Backfill(opts *config.Options, db database.Store, report func(convID int, title string, err error)) (int, error) {
+	return backfill(opts, db, 0, report)
+}
+
+// catchupLimit is how many untitled conversations Catchup looks at, so that
+// turning on a title model doesn't title the whole history behind the
+// user's back
+const catchupLimit = 5
+
+// Catchup titles the latest few conversations without one, such as one-shot
+// prompts, which exit without waiting for their title. Older ones, and ones
+// that failed to be titled before, are left to --retitle.
+func Catchup(opts *config.Options, db database.Store, report func(convID int, title string, err error)) (int, error) {
+	return backfill(opts, db, catchupLimit, report)
+}
+
+// backfill titles the latest limit untitled conversations that haven't
+// failed to be titled, or all untitled ones if limit is 0
+func backfill(opts *config.Options, db database.Store, limit int, report func(convID int, title string, err error)) (int, error) {
+	if opts.TitleModel == "" {
+		return 0, ErrNoTitleModel
+	}
+	ids, err := db.UntitledConversationIDs()
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 {
+		failedIDs, err := db.TitleFailedIDs()
+		if err != nil {
+			return 0, err
+		}
+		ids = slices.DeleteFunc(ids, func(id int) bool { return slices.Contains(failedIDs, id) })
+		if len(ids) > limit {
+			ids = ids[len(ids)-limit:]
+		}
+	}
+
+	titled := 0
+	for _, id := range ids {
+		title, err := Generate(opts, db, id)
+		if err == nil {
+			titled++
+		}
+		if report != nil {
+			report(id, title, err)
+		}
+	}
+	return titled, nil
+}
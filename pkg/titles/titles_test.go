@@ -0,0 +1,180 @@
+package titles
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/LLM"
+	"github.com/duluk/ask-ai/pkg/config"
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+// stubClient replies with a fixed title and records the model it was asked
+type stubClient struct {
+	reply  string
+	err    error
+	models []string
+}
+
+func (c *stubClient) Chat(args LLM.ClientArgs, termWidth int, tabWidth int) (LLM.ClientResponse, <-chan LLM.StreamResponse, error) {
+	c.models = append(c.models, *args.Model)
+	stream := make(chan LLM.StreamResponse, 2)
+	if c.err != nil {
+		stream <- LLM.StreamResponse{Error: c.err, Done: true}
+	} else {
+		stream <- LLM.StreamResponse{Content: c.reply}
+		stream <- LLM.StreamResponse{Done: true}
+	}
+	close(stream)
+	return LLM.ClientResponse{}, stream, nil
+}
+
+func (c *stubClient) ChatStream(args LLM.ClientArgs, termWidth int, tabWidth int, stream chan<- LLM.StreamResponse) (LLM.ClientResponse, error) {
+	return LLM.ClientResponse{}, nil
+}
+
+func setup(t *testing.T, reply string) (*config.Options, *database.ChatDB, *stubClient) {
+	db, err := database.InitializeDB(":memory:", "titles_test")
+	assert.NoError(t, err)
+	t.Cleanup(db.Close)
+
+	client := &stubClient{reply: reply}
+	var providers []string
+	orig := newClient
+	newClient = func(provider string) (LLM.Client, error) {
+		providers = append(providers, provider)
+		return client, nil
+	}
+	t.Cleanup(func() {
+		newClient = orig
+		assert.Subset(t, []string{"openai"}, providers)
+	})
+
+	cfg := &config.Config{Models: map[string]config.Provider{
+		"openai": {Models: map[string]config.ModelConfig{
+			"mini": {ModelName: "gpt-4o-mini"},
+		}},
+	}}
+	opts := &config.Options{Config: cfg, TitleModel: "mini"}
+	return opts, db, client
+}
+
+func TestGenerate(t *testing.T) {
+	opts, db, client := setup(t, "Chess Openings")
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "best opening?", Response: "the Reti", Model: "big"}))
+
+	title, err := Generate(opts, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Chess Openings", title)
+	assert.Equal(t, []string{"gpt-4o-mini"}, client.models)
+
+	stored, err := db.GetTitle(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Chess Openings", stored)
+
+	// An existing title is kept and the model isn't asked again
+	assert.NoError(t, db.SetTitle(1, "Mine"))
+	title, err = Generate(opts, db, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Mine", title)
+	assert.Len(t, client.models, 1)
+}
+
+func TestGenerateErrors(t *testing.T) {
+	opts, db, client := setup(t, "x")
+
+	// Nothing to title yet
+	id, err := db.NewConversationID()
+	assert.NoError(t, err)
+	_, err = Generate(opts, db, id)
+	assert.ErrorContains(t, err, "no exchange")
+
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: id, Prompt: "p", Response: "r"}))
+	client.err = errors.New("rate limited")
+	_, err = Generate(opts, db, id)
+	assert.ErrorContains(t, err, "rate limited")
+
+	opts.TitleModel = "nope"
+	_, err = Generate(opts, db, id)
+	assert.ErrorContains(t, err, "title model")
+
+	opts.TitleModel = ""
+	_, err = Generate(opts, db, id)
+	assert.ErrorIs(t, err, ErrNoTitleModel)
+}
+
+func TestBackfill(t *testing.T) {
+	opts, db, _ := setup(t, "Generated")
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "p1", Response: "r1"}))
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 2, Prompt: "p2", Response: "r2"}))
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 3, Prompt: "p3", Response: "r3"}))
+	assert.NoError(t, db.SetTitle(2, "Already"))
+
+	var reported []int
+	n, err := Backfill(opts, db, func(convID int, title string, err error) {
+		assert.NoError(t, err)
+		assert.Equal(t, "Generated", title)
+		reported = append(reported, convID)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []int{1, 3}, reported)
+
+	ids, err := db.UntitledConversationIDs()
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestCatchup(t *testing.T) {
+	opts, db, client := setup(t, "Generated")
+	for id := 1; id <= catchupLimit+2; id++ {
+		assert.NoError(t, db.InsertTurn(database.Turn{ConvID: id, Prompt: "p", Response: "r"}))
+	}
+
+	// Only the latest are titled
+	n, err := Catchup(opts, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, catchupLimit, n)
+	assert.Len(t, client.models, catchupLimit)
+	ids, err := db.UntitledConversationIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, ids)
+
+	opts.TitleModel = ""
+	_, err = Catchup(opts, db, nil)
+	assert.ErrorIs(t, err, ErrNoTitleModel)
+}
+
+func TestCatchupSkipsFailed(t *testing.T) {
+	opts, db, client := setup(t, "Generated")
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "p1", Response: "r1"}))
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 2, Prompt: "p2", Response: "r2"}))
+
+	// A failure is remembered, so the next session doesn't ask again
+	client.err = errors.New("rate limited")
+	_, err := Generate(opts, db, 2)
+	assert.ErrorContains(t, err, "rate limited")
+	failed, err := db.TitleFailedIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, failed)
+
+	client.err = nil
+	client.models = nil
+	n, err := Catchup(opts, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, client.models, 1)
+	ids, err := db.UntitledConversationIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2}, ids)
+
+	// --retitle still tries it
+	n, err = Backfill(opts, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	ids, err = db.UntitledConversationIDs()
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+}
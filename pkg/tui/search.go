@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
-	"github.com/duluk/ask-ai/pkg/LLM"
 	"github.com/duluk/ask-ai/pkg/config"
 	"github.com/duluk/ask-ai/pkg/database"
 )
@@ -23,11 +25,107 @@ type searchItem struct {
 
 func (i searchItem) Title() string       { return i.title }
 func (i searchItem) Description() string { return i.desc }
-func (i searchItem) FilterValue() string { return i.title + " " + i.desc }
+
+// FilterValue starts with the conversation's ID, which filterFunc reads back
+// since the list only hands it these strings
+func (i searchItem) FilterValue() string {
+	return strconv.Itoa(i.id) + "\t" + i.title + " " + stripHighlights(i.desc)
+}
+
+// filterValueID is the conversation ID a FilterValue starts with
+func filterValueID(value string) (int, bool) {
+	field, _, _ := strings.Cut(value, "\t")
+	id, err := strconv.Atoi(field)
+	return id, err == nil
+}
+
+var highlightStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("214"))
+
+// renderSnippet styles a search snippet, highlighting the terms the database
+// marked as matches
+func renderSnippet(snippet string, base lipgloss.Style) string {
+	var b strings.Builder
+	for {
+		start := strings.Index(snippet, database.HighlightStart)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(snippet[start:], database.HighlightEnd)
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(base.Render(snippet[:start]))
+		b.WriteString(highlightStyle.Inherit(base).Render(snippet[start+len(database.HighlightStart) : end]))
+		snippet = snippet[end+len(database.HighlightEnd):]
+	}
+	b.WriteString(base.Render(stripHighlights(snippet)))
+	return b.String()
+}
+
+func stripHighlights(s string) string {
+	s = strings.ReplaceAll(s, database.HighlightStart, "")
+	return strings.ReplaceAll(s, database.HighlightEnd, "")
+}
+
+// Choice is the conversation picked from a list, and whether to continue it
+// in the chat rather than show it
+type Choice struct {
+	ID       int
+	Continue bool
+}
 
 type listModel struct {
-	list       list.Model
-	selectedID int
+	list     list.Model
+	pages    *listPages
+	choice   Choice
+	choosing bool // a conversation was picked, to show or continue
+	err      error
+}
+
+// listPageSize is how many conversations the list loads at a time
+const listPageSize = 100
+
+// listPages loads the list's items a page at a time, as the selection nears
+// the end of what's loaded. It's shared by the copies of the model that
+// bubbletea makes.
+type listPages struct {
+	next    func(offset int) ([]list.Item, error) // the items from offset on; nil once all are loaded
+	ids     []int                                 // conversation IDs of the items loaded, in order
+	loading bool
+}
+
+// add records the items loaded, and whether that was all of them
+func (p *listPages) add(items []list.Item) {
+	for _, item := range items {
+		p.ids = append(p.ids, item.(searchItem).id)
+	}
+	if len(items) < listPageSize {
+		p.next = nil
+	}
+}
+
+type pageMsg struct {
+	items []list.Item
+	err   error
+}
+
+// loadMore loads the next page when the selection is near the end of the
+// list, or everything when filtering, so the filter sees every conversation
+func (m listModel) loadMore() tea.Cmd {
+	p := m.pages
+	if p.next == nil || p.loading {
+		return nil
+	}
+	if m.list.FilterState() == list.Unfiltered && m.list.Index() < len(p.ids)-listPageSize/2 {
+		return nil
+	}
+	p.loading = true
+	next, offset := p.next, len(p.ids)
+	return func() tea.Msg {
+		items, err := next(offset)
+		return pageMsg{items: items, err: err}
+	}
 }
 
 type inlineDelegate struct {
@@ -65,7 +163,7 @@ func (d inlineDelegate) Render(w io.Writer, m list.Model, index int, listItem li
 		lipgloss.Top,
 		titleStyle.Render(item.title),
 		lipgloss.NewStyle().Foreground(lipgloss.Color("238")).Render(" – "),
-		descStyle.Render(item.desc),
+		renderSnippet(item.desc, descStyle),
 	)
 	// Render inline, truncating to available width
 	style := lipgloss.NewStyle().Inline(true).MaxWidth(m.Width())
@@ -76,17 +174,32 @@ func (m listModel) Init() tea.Cmd { return nil }
 
 func (m listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case pageMsg:
+		m.pages.loading = false
+		if msg.err != nil {
+			m.err = msg.err
+			return m, tea.Quit
+		}
+		m.pages.add(msg.items)
+		cmd := m.list.SetItems(slices.Concat(m.list.Items(), msg.items))
+		return m, tea.Batch(cmd, m.loadMore())
 	case tea.KeyMsg:
+		if m.choosing {
+			return m.choose(msg)
+		}
 		switch msg.Type {
 		case tea.KeyCtrlC, tea.KeyEsc:
 			return m, tea.Quit
 		case tea.KeyEnter:
 			// If user is not currently editing the filter, select item
 			if !m.list.SettingFilter() {
-				if item, ok := m.list.SelectedItem().(searchItem); ok {
-					m.selectedID = item.id
+				item, ok := m.list.SelectedItem().(searchItem)
+				if !ok {
+					return m, tea.Quit
 				}
-				return m, tea.Quit
+				m.choice = Choice{ID: item.id}
+				m.choosing = true
+				return m, nil
 			}
 		}
 	}
@@ -94,37 +207,100 @@ func (m listModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Delegate all other messages (including filter input) to the list
 	var cmd tea.Cmd
 	m.list, cmd = m.list.Update(msg)
-	return m, cmd
+	return m, tea.Batch(cmd, m.loadMore())
+}
+
+// choose handles the keys once a conversation is picked: show it, continue
+// it, or go back to the list
+func (m listModel) choose(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.Type == tea.KeyCtrlC:
+		m.choice = Choice{}
+		return m, tea.Quit
+	case msg.Type == tea.KeyEsc:
+		m.choice = Choice{}
+		m.choosing = false
+	case msg.Type == tea.KeyEnter, msg.String() == "s":
+		return m, tea.Quit
+	case msg.String() == "c":
+		m.choice.Continue = true
+		return m, tea.Quit
+	}
+	return m, nil
 }
 
 func (m listModel) View() string {
-	return m.list.View() + lipgloss.NewStyle().Padding(1, 0).Render("Press ENTER to select a conversation, ESC to cancel.")
+	help := "Press ENTER to select a conversation, ESC to cancel."
+	if m.choosing {
+		help = fmt.Sprintf("Conversation %04d: ENTER or s to show it, c to continue it, ESC to go back.", m.choice.ID)
+	}
+	return m.list.View() + lipgloss.NewStyle().Padding(1, 0).Render(help)
 }
 
 // RunSearch launches an interactive list to select a conversation matching the keyword
-// Returns the selected conversation ID, or 0 if none selected or no matches.
-func RunSearch(opts *config.Options, db *database.ChatDB) (int, error) {
+// Returns the conversation chosen, with ID 0 if none was or nothing matched.
+func RunSearch(opts *config.Options, db database.Store) (Choice, error) {
+	return runConversationList(opts, db, opts.SearchKeyword, fmt.Sprintf("Search results for '%s'", opts.SearchKeyword))
+}
+
+// RunList launches an interactive list to select any conversation
+// Returns the conversation chosen, with ID 0 if none was or there are none.
+func RunList(opts *config.Options, db database.Store) (Choice, error) {
+	return runConversationList(opts, db, "", "Conversations")
+}
+
+// runConversationList shows the conversations matching the query and
+// opts.Filter and returns the one selected. Search results come ranked, with
+// the text that matched; without a query the list is every conversation, most
+// recent first, loaded as it's scrolled.
+func runConversationList(opts *config.Options, db database.Store, query, title string) (Choice, error) {
 	width := int(math.Max(float64(opts.ScreenWidth-10), 20))
 
-	ids, err := db.SearchForConversation(opts.SearchKeyword)
+	filter, err := opts.Filter.Resolve(opts.Config)
 	if err != nil {
-		return 0, err
-	}
-	if len(ids) == 0 {
-		return 0, nil
+		return Choice{}, err
 	}
-
-	var items []list.Item
-	for _, id := range ids {
-		convs, err := db.LoadConversationFromDB(id)
-		if err != nil {
-			return 0, err
+	filter.Query = query
+
+	pages := &listPages{}
+	if query == "" {
+		pages.next = func(offset int) ([]list.Item, error) {
+			summaries, err := db.ListSummaries(filter, offset, listPageSize)
+			if err != nil {
+				return nil, err
+			}
+			items := make([]list.Item, 0, len(summaries))
+			for _, s := range summaries {
+				items = append(items, summaryItem(s))
+			}
+			return items, nil
 		}
-
-		excerpt := excerptFromConvs(convs, opts.SearchKeyword, width)
-		title := fmt.Sprintf("%04d", id)
-		items = append(items, searchItem{title: title, desc: excerpt, id: id})
+	} else {
+		// Ranking needs every match, so they're all there from the start
+		pages.next = func(int) ([]list.Item, error) {
+			results, err := db.FindConversations(filter)
+			if err != nil {
+				return nil, err
+			}
+			items := make([]list.Item, 0, len(results))
+			for _, r := range results {
+				desc := r.Role + ": " + strings.Join(strings.Fields(r.Snippet), " ")
+				items = append(items, searchItem{title: listTitle(r.ConvID, r.Starred, r.Archived, r.Title, r.Tags), desc: desc, id: r.ConvID})
+			}
+			return items, nil
+		}
+	}
+	items, err := pages.next(0)
+	if err != nil {
+		return Choice{}, err
 	}
+	if len(items) == 0 {
+		return Choice{}, nil
+	}
+	if query != "" {
+		pages.next = nil
+	}
+	pages.add(items)
 
 	// limit height to a reasonable size
 	height := len(items) + 4
@@ -136,104 +312,125 @@ func RunSearch(opts *config.Options, db *database.ChatDB) (int, error) {
 		DefaultDelegate: list.NewDefaultDelegate(),
 	}
 	lst := list.New(items, delegate /*list.NewDefaultDelegate(),*/, width, height)
-	lst.Title = fmt.Sprintf("Search results for '%s'", opts.SearchKeyword)
+	lst.Title = title
+	lst.Filter = filterFunc(opts, db, query)
 
-	m := listModel{list: lst}
+	m := listModel{list: lst, pages: pages}
 	p := tea.NewProgram(m)
 	finalModel, err := p.Run()
 	if err != nil {
-		return 0, err
-	}
-	result := finalModel.(listModel).selectedID
-	return result, nil
-}
-
-// excerptFromConvs returns a snippet of the conversation messages
-// If keyword is non-empty, show context around its first occurrence; otherwise show leading text
-func excerptFromConvs(convs []LLM.LLMConversations, keyword string, maxLen int) string {
-	if len(convs) == 0 {
-		return ""
-	}
-	content := convs[0].Content
-
-	// If searching for keyword, show snippet around keyword
-	if keyword != "" {
-		lowerKW := strings.ToLower(keyword)
-		lowerContent := strings.ToLower(content)
-		if idx := strings.Index(lowerContent, lowerKW); idx >= 0 {
-			// Determine context window
-			half := maxLen / 2
-			start := idx - half
-			if start < 0 {
-				start = 0
-			}
-			end := idx + len(keyword) + half
-			if end > len(content) {
-				end = len(content)
-			}
-			snippet := content[start:end]
-
-			if start > 0 {
-				snippet = "..." + snippet
-			}
-			if end < len(content) {
-				snippet = snippet + "..."
-			}
+		return Choice{}, err
+	}
+	final := finalModel.(listModel)
+	return final.choice, final.err
+}
 
-			if len(snippet) > maxLen {
-				snippet = snippet[:maxLen-3] + "..."
-			}
-			return snippet
-		}
+// summaryItem is the list's item for a conversation: its title, or first
+// prompt if it has none, then how many turns it has, its model and when it
+// was last continued
+func summaryItem(s database.ConversationSummary) searchItem {
+	title := s.Title
+	if title == "" {
+		title = preview(strings.Join(strings.Fields(s.FirstPrompt), " "), 60)
+	}
+	desc := fmt.Sprintf("%d turns", s.Turns)
+	if s.Turns == 1 {
+		desc = "1 turn"
 	}
-	if len(content) > maxLen {
-		return content[:maxLen-3] + "..."
+	if s.Model != "" {
+		desc += " · " + s.Model
 	}
-	return content
+	desc += " · " + s.LastActivity.Local().Format("2006-01-02")
+	return searchItem{title: listTitle(s.ID, s.Starred, s.Archived, title, s.Tags), desc: desc, id: s.ID}
 }
 
-// RunList launches an interactive list to select any conversation
-// Returns the selected conversation ID, or 0 if none selected or no conversations.
-func RunList(opts *config.Options, db *database.ChatDB) (int, error) {
-	ids, err := db.ListConversationIDs()
-	if err != nil {
-		return 0, err
+// listTitle is the conversation's ID, a star if it's starred, its title and
+// its tags
+func listTitle(id int, starred, archived bool, title string, tags []string) string {
+	s := fmt.Sprintf("%04d", id)
+	if starred {
+		s += " ★"
+	}
+	if archived {
+		s += " [archived]"
 	}
-	if len(ids) == 0 {
-		return 0, nil
+	if title != "" {
+		s += " " + title
 	}
+	for _, tag := range tags {
+		s += " #" + tag
+	}
+	return s
+}
 
-	width := int(math.Max(float64(opts.ScreenWidth-10), 20))
+// filterFunc lets the list's filter input take the same filters as the
+// command line, as key:value terms (model:, provider:, since:, until:, in:,
+// regex:, tag:). They're looked up in the database on top of the filters the
+// list was opened with; the rest of the input is fuzzy matched as usual. The
+// list runs it off the main goroutine, so it only uses the targets it's given
+// and its own cache of what each filter matched.
+func filterFunc(opts *config.Options, db database.Store, query string) list.FilterFunc {
+	var mu sync.Mutex
+	cache := make(map[string]map[int]bool)
+
+	return func(term string, targets []string) []list.Rank {
+		spec, text := config.ParseFilterSpec(term)
+
+		var ranks []list.Rank
+		if text != "" {
+			ranks = list.DefaultFilter(text, targets)
+		} else {
+			for i := range targets {
+				ranks = append(ranks, list.Rank{Index: i})
+			}
+		}
+		if spec.IsZero() {
+			return ranks
+		}
 
-	var items []list.Item
-	for _, id := range ids {
-		convs, err := db.LoadConversationFromDB(id)
+		// An incomplete or invalid filter, e.g. a date still being typed,
+		// matches nothing
+		filter, err := opts.Filter.Merge(spec).Resolve(opts.Config)
 		if err != nil {
-			return 0, err
+			return nil
+		}
+		filter.Query = query
+		key := fmt.Sprintf("%+v", filter)
+		mu.Lock()
+		matched, ok := cache[key]
+		mu.Unlock()
+		if !ok {
+			if matched, err = matchingIDs(db, filter); err != nil {
+				return nil
+			}
+			mu.Lock()
+			cache[key] = matched
+			mu.Unlock()
 		}
 
-		excerpt := excerptFromConvs(convs, "", width)
-		title := fmt.Sprintf("%04d", id)
-		items = append(items, searchItem{title: title, desc: excerpt, id: id})
-	}
-
-	height := len(items) + 4
-	if max := opts.ScreenHeight - 5; height > max {
-		height = max
+		var kept []list.Rank
+		for _, r := range ranks {
+			if id, ok := filterValueID(targets[r.Index]); ok && matched[id] {
+				kept = append(kept, r)
+			}
+		}
+		return kept
 	}
+}
 
-	delegate := inlineDelegate{
-		DefaultDelegate: list.NewDefaultDelegate(),
+// matchingIDs is the set of conversations the filter matches
+func matchingIDs(db database.Store, filter database.SearchFilter) (map[int]bool, error) {
+	matched := make(map[int]bool)
+	if filter.Query != "" {
+		results, err := db.FindConversations(filter)
+		for _, r := range results {
+			matched[r.ConvID] = true
+		}
+		return matched, err
 	}
-	lst := list.New(items, delegate /*list.NewDefaultDelegate(),*/, width, height)
-	lst.Title = "Conversations"
-
-	m := listModel{list: lst}
-	p := tea.NewProgram(m)
-	finalModel, err := p.Run()
-	if err != nil {
-		return 0, err
+	summaries, err := db.ListSummaries(filter, 0, math.MaxInt32)
+	for _, s := range summaries {
+		matched[s.ID] = true
 	}
-	selected := finalModel.(listModel).selectedID
-	return selected, nil
+	return matched, err
 }
@@ -0,0 +1,146 @@
+package tui
+
+import (
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/config"
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+func TestFilterFunc(t *testing.T) {
+	db, err := database.InitializeDB(":memory:", "tui_search_test")
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Nil(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "chess openings", Response: "the Reti", Model: "gpt-4o"}))
+	assert.Nil(t, db.InsertTurn(database.Turn{ConvID: 2, Prompt: "chess engines", Response: "stockfish", Model: "sonnet"}))
+	assert.Nil(t, db.InsertTurn(database.Turn{ConvID: 3, Prompt: "weather", Response: "rainy", Model: "sonnet"}))
+
+	opts := &config.Options{Config: &config.Config{}}
+	var targets []string
+	for i, title := range []string{"chess openings", "chess engines", "weather"} {
+		targets = append(targets, searchItem{title: title, id: i + 1}.FilterValue())
+	}
+	counted := &countingStore{Store: db}
+	filter := filterFunc(opts, counted, "")
+
+	rankIDs := func(ranks []list.Rank) []int {
+		var got []int
+		for _, r := range ranks {
+			id, ok := filterValueID(targets[r.Index])
+			assert.True(t, ok)
+			got = append(got, id)
+		}
+		return got
+	}
+
+	assert.ElementsMatch(t, []int{1, 2}, rankIDs(filter("chess", targets)))
+	assert.ElementsMatch(t, []int{2, 3}, rankIDs(filter("model:sonnet", targets)))
+	assert.ElementsMatch(t, []int{2}, rankIDs(filter("model:sonnet chess", targets)))
+	assert.ElementsMatch(t, []int{2}, rankIDs(filter("regex:^stock in:response", targets)))
+	assert.Empty(t, filter("since:2024-0", targets))
+
+	assert.Nil(t, db.AddTags(3, "home"))
+	assert.ElementsMatch(t, []int{3}, rankIDs(filter("tag:home", targets)))
+
+	// Typing more text doesn't look the same filter up again
+	n := counted.lookups
+	assert.ElementsMatch(t, []int{2}, rankIDs(filter("model:sonnet chess e", targets)))
+	assert.Equal(t, n, counted.lookups)
+}
+
+// countingStore counts the lookups filterFunc makes
+type countingStore struct {
+	database.Store
+	lookups int
+}
+
+func (s *countingStore) ListSummaries(filter database.SearchFilter, offset, limit int) ([]database.ConversationSummary, error) {
+	s.lookups++
+	return s.Store.ListSummaries(filter, offset, limit)
+}
+
+func TestListTitle(t *testing.T) {
+	assert.Equal(t, "0007", listTitle(7, false, false, "", nil))
+	assert.Equal(t, "0007 ★ Chess #games #work", listTitle(7, true, false, "Chess", []string{"games", "work"}))
+}
+
+func TestSummaryItem(t *testing.T) {
+	last := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	item := summaryItem(database.ConversationSummary{ID: 3, FirstPrompt: "What's a\n  fianchetto?", Turns: 1, Model: "gpt-4o", LastActivity: last})
+	assert.Equal(t, "0003 What's a fianchetto?", item.title)
+	assert.Equal(t, "1 turn · gpt-4o · 2024-05-01", item.desc)
+
+	item = summaryItem(database.ConversationSummary{ID: 4, Title: "Openings", FirstPrompt: "ignored", Turns: 12, LastActivity: last})
+	assert.Equal(t, "0004 Openings", item.title)
+	assert.Equal(t, "12 turns · 2024-05-01", item.desc)
+}
+
+func TestListPages(t *testing.T) {
+	db, err := database.InitializeDB(":memory:", "tui_pages_test")
+	assert.Nil(t, err)
+	defer db.Close()
+	for id := 1; id <= listPageSize+10; id++ {
+		assert.Nil(t, db.InsertTurn(database.Turn{ConvID: id, Prompt: "p", Response: "r", Model: "m"}))
+	}
+	opts := &config.Options{Config: &config.Config{}}
+
+	pages := &listPages{next: func(offset int) ([]list.Item, error) {
+		summaries, err := db.ListSummaries(database.SearchFilter{}, offset, listPageSize)
+		var items []list.Item
+		for _, s := range summaries {
+			items = append(items, summaryItem(s))
+		}
+		return items, err
+	}}
+	items, err := pages.next(0)
+	assert.Nil(t, err)
+	pages.add(items)
+	m := listModel{list: list.New(items, list.NewDefaultDelegate(), 80, 20), pages: pages}
+	m.list.Filter = filterFunc(opts, db, "")
+
+	// Nowhere near the end yet
+	assert.Nil(t, m.loadMore())
+	m.list.Select(listPageSize - 1)
+	cmd := m.loadMore()
+	assert.NotNil(t, cmd)
+	assert.Nil(t, m.loadMore(), "already loading")
+
+	updated, _ := m.Update(cmd())
+	m = updated.(listModel)
+	assert.Len(t, m.list.Items(), listPageSize+10)
+	assert.Nil(t, pages.next, "everything is loaded")
+	assert.Equal(t, 1, pages.ids[len(pages.ids)-1])
+}
+
+func TestListChoice(t *testing.T) {
+	items := []list.Item{searchItem{title: "0001", id: 1}, searchItem{title: "0002", id: 2}}
+	m := listModel{list: list.New(items, list.NewDefaultDelegate(), 80, 20), pages: &listPages{}}
+	key := func(m listModel, msg tea.KeyMsg) listModel {
+		updated, _ := m.Update(msg)
+		return updated.(listModel)
+	}
+
+	m.list.Select(1)
+	m = key(m, tea.KeyMsg{Type: tea.KeyEnter})
+	assert.True(t, m.choosing)
+	assert.Contains(t, m.View(), "Conversation 0002")
+
+	// Esc goes back to the list
+	m = key(m, tea.KeyMsg{Type: tea.KeyEsc})
+	assert.False(t, m.choosing)
+	assert.Equal(t, Choice{}, m.choice)
+
+	m = key(m, tea.KeyMsg{Type: tea.KeyEnter})
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	assert.NotNil(t, cmd)
+	assert.Equal(t, Choice{ID: 2}, m.choice)
+
+	m = key(m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	assert.Equal(t, Choice{ID: 2, Continue: true}, m.choice)
+}
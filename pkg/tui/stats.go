@@ -0,0 +1,139 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/duluk/ask-ai/pkg/config"
+	"github.com/duluk/ask-ai/pkg/database"
+	"github.com/duluk/ask-ai/pkg/export"
+)
+
+// statsMetric is one of the numbers the stats view draws bars for
+type statsMetric struct {
+	name  string
+	value func(database.StatsRow) float64
+	label func(database.StatsRow) string
+}
+
+var statsMetrics = []statsMetric{
+	{"turns", func(r database.StatsRow) float64 { return float64(r.Turns) },
+		func(r database.StatsRow) string { return fmt.Sprint(r.Turns) }},
+	{"input tokens", func(r database.StatsRow) float64 { return float64(r.InputTokens) },
+		func(r database.StatsRow) string { return fmt.Sprint(r.InputTokens) }},
+	{"output tokens", func(r database.StatsRow) float64 { return float64(r.OutputTokens) },
+		func(r database.StatsRow) string { return fmt.Sprint(r.OutputTokens) }},
+	{"avg latency", func(r database.StatsRow) float64 { return float64(r.AvgLatency) }, export.FormatLatency},
+	{"conversations", func(r database.StatsRow) float64 { return float64(r.Conversations) },
+		func(r database.StatsRow) string { return fmt.Sprint(r.Conversations) }},
+}
+
+// statsKeys switch the view's grouping
+var statsKeys = map[string]string{
+	"m": database.StatsByModel,
+	"p": database.StatsByProvider,
+	"d": database.StatsByDay,
+	"r": database.StatsByRole,
+}
+
+var barStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(lipColorBlue))
+
+type statsModel struct {
+	db     *database.ChatDB
+	filter database.StatsFilter
+	stats  database.Stats
+	metric int
+	width  int
+	err    error
+}
+
+func (m *statsModel) load() {
+	m.stats, m.err = m.db.Stats(m.filter)
+}
+
+func (m statsModel) Init() tea.Cmd { return nil }
+
+func (m statsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case tea.KeyMsg:
+		switch key := msg.String(); key {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "tab", "right":
+			m.metric = (m.metric + 1) % len(statsMetrics)
+		case "shift+tab", "left":
+			m.metric = (m.metric + len(statsMetrics) - 1) % len(statsMetrics)
+		default:
+			if by, ok := statsKeys[key]; ok {
+				m.filter.By = by
+				m.load()
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m statsModel) View() string {
+	if m.err != nil {
+		return fmt.Sprintf("Error: %v\n", m.err)
+	}
+	metric := statsMetrics[m.metric]
+
+	var b strings.Builder
+	b.WriteString(lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("%s by %s", strings.ToUpper(metric.name[:1])+metric.name[1:], m.filter.By)))
+	b.WriteString("\n\n")
+	b.WriteString(statsBars(m.stats.Groups, metric, m.width))
+	total := m.stats.Total
+	b.WriteString(fmt.Sprintf("\n%d conversations, %d turns, %d input and %d output tokens, %s average latency\n",
+		total.Conversations, total.Turns, total.InputTokens, total.OutputTokens, export.FormatLatency(total)))
+	b.WriteString(lipgloss.NewStyle().Padding(1, 0).Render("m/p/d/r: by model, provider, day or role | Tab: next number | q: quit"))
+	return b.String()
+}
+
+// statsBars draws a bar for each group, scaled to the largest
+func statsBars(groups []database.StatsRow, metric statsMetric, width int) string {
+	if len(groups) == 0 {
+		return "No turns recorded\n"
+	}
+	keyWidth, labelWidth := 0, 0
+	var most float64
+	for _, g := range groups {
+		keyWidth = max(keyWidth, lipgloss.Width(g.Key))
+		labelWidth = max(labelWidth, len(metric.label(g)))
+		most = max(most, metric.value(g))
+	}
+	barWidth := width - keyWidth - labelWidth - 4
+	if barWidth < 10 {
+		barWidth = 40
+	}
+
+	var b strings.Builder
+	for _, g := range groups {
+		n := 0
+		if most > 0 {
+			n = int(metric.value(g) / most * float64(barWidth))
+		}
+		fmt.Fprintf(&b, "%-*s  %s %*s\n", keyWidth, g.Key,
+			barStyle.Render(strings.Repeat("█", n)+strings.Repeat(" ", barWidth-n)), labelWidth, metric.label(g))
+	}
+	return b.String()
+}
+
+// RunStats shows the usage statistics with bar charts
+func RunStats(opts *config.Options, db *database.ChatDB) error {
+	m := statsModel{db: db, filter: opts.StatsFilter, width: opts.ScreenWidth}
+	if m.filter.By == "" {
+		m.filter.By = database.StatsByModel
+	}
+	m.load()
+	if m.err != nil {
+		return m.err
+	}
+	_, err := tea.NewProgram(m).Run()
+	return err
+}
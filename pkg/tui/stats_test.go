@@ -0,0 +1,54 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/duluk/ask-ai/pkg/database"
+)
+
+func TestStatsBars(t *testing.T) {
+	groups := []database.StatsRow{
+		{Key: "gpt-4o", Turns: 4},
+		{Key: "sonnet", Turns: 2},
+		{Key: "llama", Turns: 0},
+	}
+	out := statsBars(groups, statsMetrics[0], 60)
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	assert.Len(t, lines, 3)
+	full := strings.Count(lines[0], "█")
+	assert.Equal(t, full/2, strings.Count(lines[1], "█"))
+	assert.Equal(t, 0, strings.Count(lines[2], "█"))
+	assert.True(t, strings.HasSuffix(lines[0], " 4"))
+
+	assert.Equal(t, "No turns recorded\n", statsBars(nil, statsMetrics[0], 60))
+}
+
+func TestStatsView(t *testing.T) {
+	db, err := database.InitializeDB(":memory:", "conversations")
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "gpt-4o", Provider: "openai", OutputTokens: 10, Latency: time.Second}))
+	assert.Nil(t, db.InsertTurn(database.Turn{ConvID: 2, Prompt: "p", Response: "r", Model: "sonnet", Provider: "anthropic", OutputTokens: 30}))
+
+	m := statsModel{db: db, filter: database.StatsFilter{By: database.StatsByModel}, width: 80}
+	m.load()
+	assert.Nil(t, m.err)
+	view := m.View()
+	assert.Contains(t, view, "Turns by model")
+	assert.Contains(t, view, "gpt-4o")
+	assert.Contains(t, view, "2 conversations, 2 turns")
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("p")})
+	m = updated.(statsModel)
+	assert.Contains(t, m.View(), "anthropic")
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = updated.(statsModel)
+	assert.Contains(t, m.View(), "Output tokens by provider")
+}
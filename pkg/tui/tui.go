@@ -7,7 +7,9 @@ import (
 	"regexp"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -19,6 +21,7 @@ import (
 	"github.com/duluk/ask-ai/pkg/database"
 	"github.com/duluk/ask-ai/pkg/linewrap"
 	"github.com/duluk/ask-ai/pkg/logger"
+	"github.com/duluk/ask-ai/pkg/titles"
 )
 
 const (
@@ -99,23 +102,38 @@ var (
 
 // Model represents the TUI state (this has nothing to do with LLMs)
 type Model struct {
-	viewport     viewport.Model
-	textInput    textinput.Model
-	content      string
-	opts         *config.Options
-	clientArgs   LLM.ClientArgs
-	db           *database.ChatDB
-	windowWidth  int
-	windowHeight int
-	ready        bool
-	processing   bool
-	statusMsg    string
-	streamChan   <-chan LLM.StreamResponse
-	fullResponse string
-	lineWrapper  *linewrap.LineWrapper
+	viewport      viewport.Model
+	textInput     textinput.Model
+	content       string
+	opts          *config.Options
+	clientArgs    LLM.ClientArgs
+	db            database.Store
+	sqlDB         *database.ChatDB       // db, when the history is kept in SQLite
+	recent        []LLM.LLMConversations // turns of other conversations given with --context
+	windowWidth   int
+	windowHeight  int
+	ready         bool
+	processing    bool
+	statusMsg     string
+	title         string // title of the current conversation, once it has one
+	confirmDelete bool   // /delete was entered once and needs repeating
+	replace       bool   // the prompt being answered replaces the last turn
+	turnStart     int    // where the last turn starts in content; -1 if not shown
+	streamChan    <-chan LLM.StreamResponse
+	fullResponse  string
+	finishReason  string    // why the model stopped the current response
+	requestStart  time.Time // when the current request was sent
+	lineWrapper   *linewrap.LineWrapper
+	afterRetry    *savedModel // what to go back to once a /retry with another model is saved
 }
 
-func Initialize(opts *config.Options, clientArgs LLM.ClientArgs, db *database.ChatDB) Model {
+// savedModel is a model and the provider it was resolved to
+type savedModel struct {
+	model    string
+	provider string
+}
+
+func Initialize(opts *config.Options, clientArgs LLM.ClientArgs, db database.Store) Model {
 	// log.Printf("Initializing with screen size: %dx%d", opts.ScreenWidth, opts.ScreenHeight)
 
 	// Note: textinput already has built-in Emacs-style keybindings
@@ -182,22 +200,51 @@ func Initialize(opts *config.Options, clientArgs LLM.ClientArgs, db *database.Ch
 	welcome := "Welcome to ask-ai!\n\n" +
 		"Type your question below and press Enter.\n" +
 		"Use /help for commands. Press Ctrl+C to exit.\n\n"
-	// Style and set the welcome content
-	vp.SetContent(lipgloss.NewStyle().Width(contentWidth).Render(welcome))
+	// A continued conversation shows its history after the welcome, with
+	// its last turn marked so it can be retried or flipped in place
+	content, turnStart := welcome, -1
+	// With --context, the conversation starts from other conversations'
+	// turns, which stay ahead of its own
+	var recent []LLM.LLMConversations
+	for _, turn := range clientArgs.Context {
+		switch {
+		case turn.ConvID != *clientArgs.ConvID:
+			recent = append(recent, turn)
+		case turn.Role == "user":
+			turnStart = len(content)
+			content += userStyle.Render("User: ") + strings.Trim(turn.Content, " \t\n") + "\n\n"
+		default:
+			content += assistantStyle.Render("Assistant: ") + turn.Content + "\n\n"
+		}
+	}
+	// Style and set the content
+	vp.SetContent(lipgloss.NewStyle().Width(contentWidth).Render(content))
+	vp.GotoBottom()
 	vp.YPosition = 0
 
 	// Wrap it up
 	lw := linewrap.NewLineWrapper(contentWidth, opts.TabWidth, linewrap.NilWriter)
 
+	// A continued conversation may already have a title
+	var title string
+	if db != nil && *clientArgs.ConvID != 0 {
+		title, _ = db.GetTitle(*clientArgs.ConvID)
+	}
+	sqlDB, _ := db.(*database.ChatDB)
+
 	return Model{
 		viewport:     vp,
 		textInput:    ti,
-		content:      welcome,
+		content:      content,
 		opts:         opts,
 		clientArgs:   clientArgs,
 		db:           db,
+		sqlDB:        sqlDB,
+		recent:       recent,
 		fullResponse: "",
-		statusMsg:    fmt.Sprintf("Model: %s | ConvID: %d | Ctrl+C: Exit | /help: Commands", *clientArgs.Model, *clientArgs.ConvID),
+		statusMsg:    fmt.Sprintf("Model: %s | ConvID: %s | Ctrl+C: Exit | /help: Commands", *clientArgs.Model, convLabel(*clientArgs.ConvID)),
+		title:        title,
+		turnStart:    turnStart,
 		windowWidth:  opts.ScreenWidth,
 		// windowHeight is the viewport height, not the total window height
 		windowHeight: viewportHeight,
@@ -240,24 +287,18 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 			m.textInput.SetValue("")
+			return m.send(prompt)
 
-			userMsg := userStyle.Render("User: ") + strings.Trim(prompt, " \t\n") + "\n\n"
-			m.content += userMsg
-
-			// Pre-wrap content using viewport width to handle Charm's wrapping problems
-			m.updateViewportContent()
-
-			// Add Assistant prefix before streaming starts
-			m.content += assistantStyle.Render("Assistant: ")
-			m.lineWrapper.SetCurrWidth(len("Assistant: "))
-
-			// TODO: add spinner
-			m.processing = true
-			m.statusMsg = "Processing..."
-
-			return m, tea.Batch(append(cmds, func() tea.Msg {
-				return promptMsg{prompt: prompt}
-			})...)
+		case tea.KeyShiftLeft, tea.KeyShiftRight:
+			if m.processing || m.db == nil {
+				return m, nil
+			}
+			if msg.Type == tea.KeyShiftLeft {
+				m.flipAlternative(-1)
+			} else {
+				m.flipAlternative(1)
+			}
+			return m, nil
 		}
 
 	case tea.WindowSizeMsg:
@@ -303,19 +344,32 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.err != nil {
 			m.content += "\n\n" + lipgloss.NewStyle().Foreground(lipgloss.Color(lipColorRed)).Render("Error: "+msg.err.Error()) + "\n\n"
 			m.processing = false
+			// A failed request is recorded too, with whatever arrived first
+			m.lineWrapper.Reset()
+			m.saveConversation(msg.err)
+			m.restoreModel()
 			m.statusMsg = fmt.Sprintf("Error | Model: %s | ConvID: %d", *m.clientArgs.Model, *m.clientArgs.ConvID)
+			m.updateContext()
 		} else {
 			// m.content is for the viewport and contains everything that has
 			// been displayed so far; m.fullResponse is for the current response only
 			m.content += msg.chunk
 			m.fullResponse += msg.chunk // Don't store the wrapped chunk in DB
+			if msg.finishReason != "" {
+				m.finishReason = msg.finishReason
+			}
 			if msg.done {
 				m.content += "\n\n"
 				m.processing = false
 				m.lineWrapper.Reset()
+				firstExchange := len(m.clientArgs.Context) == len(m.recent)
+				m.saveConversation(nil)
+				m.restoreModel()
 				m.statusMsg = fmt.Sprintf("Model: %s | ConvID: %d | /help for commands", *m.clientArgs.Model, *m.clientArgs.ConvID)
-				m.saveConversation()
 				m.updateContext()
+				if firstExchange && m.title == "" {
+					cmds = append(cmds, m.generateTitle())
+				}
 			}
 		}
 		m.updateViewportContent()
@@ -325,6 +379,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, tea.Batch(cmds...)
 
+	case titleMsg:
+		if msg.err != nil {
+			logger.Info("Could not title conversation", "convID", msg.convID, "error", msg.err)
+		} else if msg.convID == *m.clientArgs.ConvID {
+			m.title = msg.title
+		}
+		return m, nil
+
 	case responseMsg:
 		m.processing = false
 
@@ -471,7 +533,11 @@ func (m Model) View() string {
 
 	viewportBox := viewportStyle.Width(contentWidth).Height(vpHeight).Render(vp.View())
 
-	statusLine := statusStyle.Width(contentWidth).Padding(0, 1).Render(m.statusMsg)
+	status := m.statusMsg
+	if m.title != "" {
+		status = m.title + " | " + status
+	}
+	statusLine := statusStyle.Width(contentWidth).Padding(0, 1).Render(status)
 
 	// Assemble the final view
 	return lipgloss.JoinVertical(lipgloss.Center,
@@ -492,53 +558,42 @@ type responseMsg struct {
 
 // TODO: Does this need to be in types.go?
 type streamChunkMsg struct {
-	chunk string
-	done  bool
-	err   error
+	chunk        string
+	done         bool
+	err          error
+	finishReason string
 }
 
-func (m *Model) startStreaming() tea.Cmd {
-	// Determine provider and model key (support provider/model syntax)
-	spec := *m.clientArgs.Model
-	provider := m.opts.Provider
-	modelKey := spec
-	// Check for explicit provider in spec
-	if idx := strings.Index(spec, "/"); idx >= 0 {
-		provider = spec[:idx]
-		modelKey = spec[idx+1:]
-		m.opts.Provider = provider
-		// update clientArgs.Model to the pure model key for recording
-		*m.clientArgs.Model = modelKey
-	} else {
-		// Infer provider from modelKey if unambiguous
-		var matches []string
-		for provName, prov := range m.opts.Config.Models {
-			// Direct model key match
-			if _, ok := prov.Models[modelKey]; ok {
-				matches = append(matches, provName)
-			} else {
-				// Alias match
-				for _, mConf := range prov.Models {
-					if slices.Contains(mConf.Aliases, modelKey) {
-						matches = append(matches, provName)
-					}
-				}
-			}
-		}
-		if len(matches) == 1 {
-			provider = matches[0]
-			m.opts.Provider = provider
-		} else if len(matches) > 1 {
-			panic(fmt.Sprintf("model %q is ambiguous across providers: %v", modelKey, matches))
-		}
+type titleMsg struct {
+	convID int
+	title  string
+	err    error
+}
+
+// generateTitle names the conversation in the background after its first
+// exchange, if a title model is configured
+func (m *Model) generateTitle() tea.Cmd {
+	if m.opts.TitleModel == "" || m.opts.NoRecord || m.db == nil {
+		return nil
+	}
+	opts, db, convID := m.opts, m.db, *m.clientArgs.ConvID
+	return func() tea.Msg {
+		title, err := titles.Generate(opts, db, convID)
+		return titleMsg{convID: convID, title: title, err: err}
 	}
-	// Load model configuration from config file
-	modelConf, err := config.GetModelConfig(m.opts.Config, provider, modelKey)
+}
+
+func (m *Model) startStreaming() tea.Cmd {
+	// The model is "provider/modelKey", or a key or alias one provider has
+	provider, modelKey, modelConf, err := config.ResolveModel(m.opts.Config, m.opts.Provider, *m.clientArgs.Model)
 	if err != nil {
 		return func() tea.Msg {
-			return streamChunkMsg{err: fmt.Errorf("model %q not found for provider %q", modelKey, provider), done: true}
+			return streamChunkMsg{err: err, done: true}
 		}
 	}
+	m.opts.Provider = provider
+	// Record the pure model key
+	*m.clientArgs.Model = modelKey
 	// Override args with API-specific configuration
 	apiModel := modelConf.ModelName
 	m.clientArgs.Model = &apiModel
@@ -546,25 +601,16 @@ func (m *Model) startStreaming() tea.Cmd {
 	m.clientArgs.Temperature = &apiTemp
 	apiMax := modelConf.MaxTokens
 	m.clientArgs.MaxTokens = &apiMax
-	// Initialize the LLM client based on provider
-	var client LLM.Client
-	switch provider {
-	case "openai":
-		client = LLM.NewOpenAI("openai", "https://api.openai.com/v1/")
-	case "claude", "anthropic":
-		client = LLM.NewAnthropic()
-	case "gemini", "google":
-		client = LLM.NewGoogle()
-	case "ollama":
-		client = LLM.NewOllama()
-	case "grok", "xai":
-		client = LLM.NewOpenAI("xai", "https://api.x.ai/v1/")
-	default:
+	// Initialize the LLM client based on provider; a missing API key comes
+	// back as an error here rather than ending the program
+	client, err := LLM.NewClient(provider)
+	if err != nil {
 		return func() tea.Msg {
-			return streamChunkMsg{err: fmt.Errorf("unknown provider: %q", provider), done: true}
+			return streamChunkMsg{err: err, done: true}
 		}
 	}
 	// Start the chat stream
+	m.requestStart = time.Now()
 	_, streamChan, err := client.Chat(m.clientArgs, m.opts.ScreenTextWidth, m.opts.TabWidth)
 	if err != nil {
 		return func() tea.Msg {
@@ -576,10 +622,12 @@ func (m *Model) startStreaming() tea.Cmd {
 	return waitForStreamChunk(m, m.streamChan)
 }
 
-func (m *Model) saveConversation() {
-	if m.opts.NoRecord {
-		return
-	}
+// saveConversation records the turn just answered, or that failed with
+// streamErr. With --no-record the store is in memory, so the turn is only
+// kept for the session's context.
+func (m *Model) saveConversation(streamErr error) {
+	replace := m.replace
+	m.replace = false
 
 	// TODO: get actual counts if the API provides them
 	inputTokens := LLM.EstimateTokens(*m.clientArgs.Prompt)
@@ -590,16 +638,45 @@ func (m *Model) saveConversation() {
 	ansiEscapeRegex := regexp.MustCompile(`\x1b\[[0-9;]*m`)
 	m.fullResponse = ansiEscapeRegex.ReplaceAllString(m.fullResponse, "")
 
-	// Save to the database
-	dbErr := m.db.InsertConversation(
-		*m.clientArgs.Prompt,
-		m.fullResponse,
-		*m.clientArgs.Model,
-		*m.clientArgs.Temperature,
-		inputTokens,
-		outputTokens,
-		*m.clientArgs.ConvID,
-	)
+	// Save to the database, as the first turn of a new conversation gives it
+	// its ID
+	if err := database.StartConversation(m.db, m.clientArgs.ConvID); err != nil {
+		m.statusMsg = fmt.Sprintf("Error allocating conversation ID: %v", err)
+		return
+	}
+	turn := database.Turn{
+		ConvID:       *m.clientArgs.ConvID,
+		Prompt:       *m.clientArgs.Prompt,
+		Response:     m.fullResponse,
+		Model:        *m.clientArgs.Model,
+		Temperature:  *m.clientArgs.Temperature,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Role:         m.opts.Role,
+		Provider:     m.opts.Provider,
+		FinishReason: m.finishReason,
+	}
+	if !m.requestStart.IsZero() {
+		turn.Latency = time.Since(m.requestStart)
+	}
+	if m.clientArgs.SystemPrompt != nil {
+		turn.SystemPrompt = *m.clientArgs.SystemPrompt
+	}
+	if m.clientArgs.Thinking != nil {
+		turn.Thinking = *m.clientArgs.Thinking
+	}
+	if m.clientArgs.MaxTokens != nil {
+		turn.MaxTokens = *m.clientArgs.MaxTokens
+	}
+	if streamErr != nil {
+		turn.Error = streamErr.Error()
+	}
+	var dbErr error
+	if replace {
+		dbErr = m.db.ReplaceLastTurn(turn)
+	} else {
+		dbErr = m.db.InsertTurn(turn)
+	}
 	if dbErr != nil {
 		// TODO: Log the error
 		m.statusMsg = fmt.Sprintf("Error saving to DB: %v", dbErr)
@@ -607,20 +684,145 @@ func (m *Model) saveConversation() {
 	logger.Debug("Inserted conversation into database", "convID", *m.clientArgs.ConvID, "prompt", *m.clientArgs.Prompt, "response", m.fullResponse)
 }
 
+// restoreModel goes back to the model and provider in use before a /retry
+// with another model
+func (m *Model) restoreModel() {
+	if m.afterRetry == nil {
+		return
+	}
+	model := m.afterRetry.model
+	m.clientArgs.Model = &model
+	m.opts.Provider = m.afterRetry.provider
+	m.afterRetry = nil
+}
+
+// send shows the prompt and asks the model to answer it
+func (m Model) send(prompt string) (tea.Model, tea.Cmd) {
+	m.turnStart = len(m.content)
+	m.fullResponse = ""
+	m.finishReason = ""
+	userMsg := userStyle.Render("User: ") + strings.Trim(prompt, " \t\n") + "\n\n"
+	m.content += userMsg
+
+	// Pre-wrap content using viewport width to handle Charm's wrapping problems
+	m.updateViewportContent()
+
+	// Add Assistant prefix before streaming starts
+	m.content += assistantStyle.Render("Assistant: ")
+	m.lineWrapper.SetCurrWidth(len("Assistant: "))
+
+	// TODO: add spinner
+	m.processing = true
+	m.statusMsg = "Processing..."
+
+	return m, func() tea.Msg {
+		return promptMsg{prompt: prompt}
+	}
+}
+
+// flipAlternative shows the previous (-1) or next (1) version of the last
+// turn, which then counts as the conversation's history
+func (m *Model) flipAlternative(delta int) {
+	if m.sqlDB == nil {
+		m.statusMsg = database.NeedsSQLite("Flipping between versions").Error()
+		return
+	}
+	convID := *m.clientArgs.ConvID
+	alts, err := m.sqlDB.Alternatives(convID)
+	if err != nil {
+		m.statusMsg = fmt.Sprintf("Error loading alternatives: %v", err)
+		return
+	}
+	if len(alts) == 0 {
+		m.statusMsg = "The last turn has no other versions; /retry or /edit make some"
+		return
+	}
+	i := len(alts) - 1
+	for j, a := range alts {
+		if a.Chosen {
+			i = j
+		}
+	}
+	if i+delta < 0 || i+delta >= len(alts) {
+		m.statusMsg = fmt.Sprintf("Version %d of %d | ConvID: %d", i+1, len(alts), convID)
+		return
+	}
+	m.chooseAlternative(alts, i+delta)
+}
+
+// chooseAlternative makes version i of the last turn its history and shows
+// it in place of the turn on screen
+func (m *Model) chooseAlternative(alts []database.Alternative, i int) {
+	convID := *m.clientArgs.ConvID
+	a := alts[i]
+	if err := m.sqlDB.ChooseAlternative(convID, a.ID); err != nil {
+		m.statusMsg = fmt.Sprintf("Error choosing alternative: %v", err)
+		return
+	}
+	m.updateContext()
+
+	if m.turnStart >= 0 && m.turnStart <= len(m.content) {
+		m.content = m.content[:m.turnStart]
+	} else {
+		m.turnStart = len(m.content)
+	}
+	m.content += userStyle.Render("User: ") + a.Prompt + "\n\n" +
+		assistantStyle.Render("Assistant: ") + a.Response + "\n\n"
+	m.updateViewportContent()
+	m.statusMsg = fmt.Sprintf("Version %d of %d (%s) | ConvID: %d | Shift+←/→: Flip", i+1, len(alts), a.Model, convID)
+}
+
+// preview is the first line of s, cut to n runes
+func preview(s string, n int) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
+
 func (m *Model) updateContext() {
 	m.opts.ContinueChat = true
 	promptContext, err := m.db.LoadConversationFromDB(*m.clientArgs.ConvID)
 	if err == nil {
-		m.clientArgs.Context = promptContext
+		m.clientArgs.Context = append(slices.Clip(m.recent), promptContext...)
 	} else {
 		m.clientArgs.Context = nil
 		m.statusMsg = fmt.Sprintf("Error loading context: %v", err)
 	}
 }
 
+// sqliteCommands need the history kept in SQLite
+var sqliteCommands = []string{"/tag", "/star", "/archive", "/alt", "/fork", "/delete"}
+
+// startedCommands act on the conversation, which a new one only has once
+// its first prompt is recorded
+var startedCommands = []string{"/title", "/tag", "/star", "/archive", "/fork", "/delete"}
+
+// convLabel shows a conversation's ID, or that it's new and has none yet
+func convLabel(convID int) string {
+	if convID == 0 {
+		return "new"
+	}
+	return strconv.Itoa(convID)
+}
+
 func (m Model) handleSlashCommand(cmd string) (tea.Model, tea.Cmd) {
 	parts := strings.SplitN(cmd, " ", 2)
 	command := parts[0]
+	if command != "/delete" {
+		m.confirmDelete = false
+	}
+	if m.sqlDB == nil && slices.Contains(sqliteCommands, command) {
+		m.statusMsg = database.NeedsSQLite(command).Error()
+		m.textInput.SetValue("")
+		return m, nil
+	}
+	if *m.clientArgs.ConvID == 0 && slices.Contains(startedCommands, command) {
+		m.statusMsg = database.NotStarted(command).Error()
+		m.textInput.SetValue("")
+		return m, nil
+	}
 
 	switch command {
 	case "/exit", "/quit":
@@ -636,6 +838,20 @@ Available commands:
   /id          - Show conversation ID
   /clear       - Clear the conversation history
   /new, /reset - Start a new conversation (clear context and new conversation ID)
+  /title       - Show the conversation title
+  /title TEXT  - Set the conversation title to TEXT
+  /tag         - Show the conversation's tags
+  /tag TAGS    - Add tags, or remove those prefixed with '-' (eg /tag work -draft)
+  /star        - Star or unstar the conversation
+  /archive     - Archive or unarchive the conversation (hidden from --list)
+  /fork        - Continue in a copy of the conversation
+  /fork TURN   - Continue in a copy of the conversation up to TURN
+  /delete      - Delete the conversation for good (asks to repeat it first)
+  /retry       - Ask the last prompt again
+  /retry MODEL - Ask the last prompt again with MODEL
+  /edit        - Revise the last prompt and ask it again
+  /alt         - List the versions of the last turn
+  /alt N       - Use version N of the last turn (Shift+←/→ flip between them)
   /context     - Show the current context
   /models      - List available models
 `
@@ -651,7 +867,7 @@ Available commands:
 		if len(parts) > 1 && parts[1] != "" {
 			newModel := strings.TrimSpace(parts[1])
 			*m.clientArgs.Model = newModel
-			m.statusMsg = fmt.Sprintf("Model changed to: %s | ConvID: %d", newModel, *m.clientArgs.ConvID)
+			m.statusMsg = fmt.Sprintf("Model changed to: %s | ConvID: %s", newModel, convLabel(*m.clientArgs.ConvID))
 		} else {
 			m.content += fmt.Sprintf("Current model: %s\n\n", *m.clientArgs.Model)
 			// Deal with Charm's wrapping problems
@@ -660,13 +876,18 @@ Available commands:
 		m.textInput.SetValue("")
 
 	case "/id":
-		m.content += fmt.Sprintf("Conversation ID: %d\n\n", *m.clientArgs.ConvID)
+		if *m.clientArgs.ConvID == 0 {
+			m.content += "This conversation gets an ID with its first prompt.\n\n"
+		} else {
+			m.content += fmt.Sprintf("Conversation ID: %d\n\n", *m.clientArgs.ConvID)
+		}
 		// Deal with Charm's wrapping problems
 		m.updateViewportContent()
 		m.textInput.SetValue("")
 
 	case "/clear":
 		m.clientArgs.Context = nil
+		m.recent = nil
 		m.content = ""
 		m.viewport.SetContent(m.content)
 		m.textInput.SetValue("")
@@ -719,22 +940,181 @@ Available commands:
 		m.updateViewportContent()
 		m.textInput.SetValue("")
 
-	case "/new", "/reset":
-		// Start a new conversation: clear context and allocate a new conversation ID
-		lastID, err := m.db.GetLastConversationID()
+	case "/title":
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			title := LLM.CleanTitle(parts[1])
+			if err := m.db.SetTitle(*m.clientArgs.ConvID, title); err != nil {
+				m.statusMsg = fmt.Sprintf("Error setting title: %v", err)
+			} else {
+				m.title = title
+				m.statusMsg = fmt.Sprintf("Title set | ConvID: %d", *m.clientArgs.ConvID)
+			}
+		} else if m.title != "" {
+			m.content += fmt.Sprintf("Title: %s\n\n", m.title)
+			m.updateViewportContent()
+		} else {
+			m.content += "This conversation has no title yet.\n\n"
+			m.updateViewportContent()
+		}
+		m.textInput.SetValue("")
+
+	case "/tag":
+		args := ""
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+		tags, err := m.sqlDB.EditTags(*m.clientArgs.ConvID, args)
 		if err != nil {
-			m.statusMsg = fmt.Sprintf("Error getting last conversation ID: %v", err)
+			m.statusMsg = fmt.Sprintf("Error tagging conversation: %v", err)
+		} else if len(tags) == 0 {
+			m.content += "This conversation has no tags.\n\n"
+			m.updateViewportContent()
 		} else {
-			newID := lastID + 1
-			*m.clientArgs.ConvID = newID
-			m.clientArgs.Context = nil
-			m.fullResponse = ""
-			m.content = ""
-			m.viewport.SetContent(m.content)
-			m.statusMsg = fmt.Sprintf("Started new conversation. ConvID: %d", newID)
+			m.content += fmt.Sprintf("Tags: %s\n\n", strings.Join(tags, ", "))
+			m.updateViewportContent()
+		}
+		m.textInput.SetValue("")
+
+	case "/star":
+		starred, err := m.sqlDB.ToggleStarred(*m.clientArgs.ConvID)
+		switch {
+		case err != nil:
+			m.statusMsg = fmt.Sprintf("Error starring conversation: %v", err)
+		case starred:
+			m.statusMsg = fmt.Sprintf("Starred | ConvID: %d", *m.clientArgs.ConvID)
+		default:
+			m.statusMsg = fmt.Sprintf("Unstarred | ConvID: %d", *m.clientArgs.ConvID)
+		}
+		m.textInput.SetValue("")
+
+	case "/archive":
+		archived, err := m.sqlDB.ToggleArchived(*m.clientArgs.ConvID)
+		switch {
+		case err != nil:
+			m.statusMsg = fmt.Sprintf("Error archiving conversation: %v", err)
+		case archived:
+			m.statusMsg = fmt.Sprintf("Archived | ConvID: %d", *m.clientArgs.ConvID)
+		default:
+			m.statusMsg = fmt.Sprintf("Unarchived | ConvID: %d", *m.clientArgs.ConvID)
 		}
 		m.textInput.SetValue("")
 
+	case "/retry", "/edit":
+		before, last, ok := LLM.SplitLastTurn(m.clientArgs.Context)
+		// A prompt from --context isn't this conversation's to ask again
+		if !ok || len(before) < len(m.recent) {
+			m.statusMsg = "There's no prompt to ask again yet"
+			m.textInput.SetValue("")
+			break
+		}
+		arg := ""
+		if len(parts) > 1 {
+			arg = strings.TrimSpace(parts[1])
+		}
+		if command == "/edit" {
+			if arg == "" {
+				// Edit the prompt in place; Enter sends it
+				m.textInput.SetValue("/edit " + last)
+				m.textInput.CursorEnd()
+				break
+			}
+			last = arg
+		} else if arg != "" {
+			// Only this turn is asked of the other model
+			m.afterRetry = &savedModel{model: *m.clientArgs.Model, provider: m.opts.Provider}
+			m.clientArgs.Model = &arg
+		}
+		m.textInput.SetValue("")
+		// The replaced answer is kept as an alternative
+		m.clientArgs.Context = before
+		m.replace = true
+		return m.send(last)
+
+	case "/alt":
+		m.textInput.SetValue("")
+		alts, err := m.sqlDB.Alternatives(*m.clientArgs.ConvID)
+		if err != nil {
+			m.statusMsg = fmt.Sprintf("Error loading alternatives: %v", err)
+			break
+		}
+		if len(alts) == 0 {
+			m.statusMsg = "The last turn has no other versions; /retry or /edit make some"
+			break
+		}
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || n < 1 || n > len(alts) {
+				m.statusMsg = fmt.Sprintf("Usage: /alt [1-%d]", len(alts))
+				break
+			}
+			m.chooseAlternative(alts, n-1)
+			break
+		}
+		m.content += "Versions of the last turn:\n"
+		for i, a := range alts {
+			mark := " "
+			if a.Chosen {
+				mark = "*"
+			}
+			m.content += fmt.Sprintf("%s %d. [%s] %s\n", mark, i+1, a.Model, preview(a.Response, 60))
+		}
+		m.content += "\n"
+		m.updateViewportContent()
+
+	case "/fork":
+		turn := 0
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil {
+				m.statusMsg = "Usage: /fork [turn]"
+				m.textInput.SetValue("")
+				break
+			}
+			turn = n
+		}
+		convID := *m.clientArgs.ConvID
+		forkID, err := m.sqlDB.ForkConversation(convID, turn)
+		if err != nil {
+			m.statusMsg = fmt.Sprintf("Error forking conversation: %v", err)
+			m.textInput.SetValue("")
+			break
+		}
+		*m.clientArgs.ConvID = forkID
+		m.recent = nil
+		m.updateContext()
+		m.title, _ = m.db.GetTitle(forkID)
+		m.content += fmt.Sprintf("Forked conversation %d into %d; carrying on in the fork.\n\n", convID, forkID)
+		m.updateViewportContent()
+		m.statusMsg = fmt.Sprintf("Forked | ConvID: %d", forkID)
+		m.textInput.SetValue("")
+
+	case "/delete":
+		convID := *m.clientArgs.ConvID
+		if !m.confirmDelete {
+			m.confirmDelete = true
+			m.statusMsg = fmt.Sprintf("Enter /delete again to delete conversation %d for good", convID)
+			m.textInput.SetValue("")
+			break
+		}
+		m.confirmDelete = false
+		if err := m.sqlDB.DeleteConversation(convID); err != nil {
+			m.statusMsg = fmt.Sprintf("Error deleting conversation: %v", err)
+			m.textInput.SetValue("")
+			break
+		}
+		freed, err := m.sqlDB.Vacuum()
+		if err != nil {
+			logger.Error("Error vacuuming database", "error", err)
+		}
+		m.startNewConversation()
+		m.statusMsg = fmt.Sprintf("Deleted conversation %d, freed %s | Started a new one", convID, database.FormatSize(freed))
+		m.textInput.SetValue("")
+
+	case "/new", "/reset":
+		m.startNewConversation()
+		m.statusMsg = "Started new conversation"
+		m.textInput.SetValue("")
+
 	default:
 		m.content += fmt.Sprintf("Unknown command: %s\n\n", command)
 		// Deal with Charm's wrapping problems
@@ -745,7 +1125,19 @@ Available commands:
 	return m, nil
 }
 
-func Run(opts *config.Options, clientArgs LLM.ClientArgs, db *database.ChatDB) error {
+// startNewConversation clears the context and screen; the new conversation
+// gets its ID with its first prompt
+func (m *Model) startNewConversation() {
+	*m.clientArgs.ConvID = 0
+	m.title = ""
+	m.clientArgs.Context = nil
+	m.recent = nil
+	m.fullResponse = ""
+	m.content = ""
+	m.viewport.SetContent(m.content)
+}
+
+func Run(opts *config.Options, clientArgs LLM.ClientArgs, db database.Store) error {
 	m := Initialize(opts, clientArgs, db)
 	logger.Debug("Starting TUI program", "opts", opts, "clientArgs", clientArgs)
 
@@ -782,6 +1174,6 @@ func waitForStreamChunk(m *Model, sub <-chan LLM.StreamResponse) tea.Cmd {
 		}
 
 		wrappedChunk := m.lineWrapper.Wrap([]byte(resp.Content))
-		return streamChunkMsg{chunk: wrappedChunk, done: resp.Done, err: resp.Error}
+		return streamChunkMsg{chunk: wrappedChunk, done: resp.Done, err: resp.Error, finishReason: resp.FinishReason}
 	}
 }
@@ -1,6 +1,8 @@
 package tui
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -77,3 +79,378 @@ func TestUpdateWindowSize(t *testing.T) {
 	assert.Equal(t, expHeight, m2.viewport.Height)
 	assert.Equal(t, expWidth, m2.textInput.Width)
 }
+
+func TestTitleInStatusBar(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 1
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID}
+	db, err := database.InitializeDB(":memory:", "tui_title_test")
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.InsertConversation("p", "r", "m", 0.5, 1, 1, 1))
+	assert.NoError(t, db.SetTitle(1, "Stored Title"))
+
+	// A continued conversation shows its stored title
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+	assert.Contains(t, m.View(), "Stored Title")
+
+	// A generated title for another conversation is ignored
+	updated, _ = m.Update(titleMsg{convID: 2, title: "Other"})
+	m = updated.(Model)
+	assert.Equal(t, "Stored Title", m.title)
+
+	m.textInput.SetValue("/title  Renamed by hand ")
+	updated, _ = m.handleSlashCommand(m.textInput.Value())
+	m = updated.(Model)
+	assert.Equal(t, "Renamed by hand", m.title)
+	title, err := db.GetTitle(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed by hand", title)
+}
+
+func TestDeleteCommand(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 1
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID}
+	db, err := database.InitializeDB(":memory:", "tui_delete_test")
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.InsertConversation("secret", "r", "m", 0.5, 1, 1, 1))
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+
+	// Another command in between cancels the first /delete
+	updated, _ = m.handleSlashCommand("/delete")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "again")
+	updated, _ = m.handleSlashCommand("/id")
+	m = updated.(Model)
+	updated, _ = m.handleSlashCommand("/delete")
+	m = updated.(Model)
+	ids, err := db.ListConversationIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, ids)
+
+	updated, _ = m.handleSlashCommand("/delete")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "Deleted conversation 1")
+	ids, err = db.ListConversationIDs()
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.Zero(t, *m.clientArgs.ConvID, "the new conversation has no ID until its first prompt")
+}
+
+func TestNewConversationStartsWithFirstTurn(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 0
+	var temperature float32 = 0.5
+	prompt := "first"
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID, Temperature: &temperature, Prompt: &prompt}
+	db, err := database.InitializeDB(":memory:", "tui_new_test")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m := Initialize(opts, clientArgs, db)
+	assert.Contains(t, m.statusMsg, "ConvID: new")
+	updated, _ := m.handleSlashCommand("/star")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "nothing to star yet")
+	updated, _ = m.handleSlashCommand("/new")
+	m = updated.(Model)
+
+	// Nothing is stored until the first turn is
+	last, err := db.GetLastConversationID()
+	assert.NoError(t, err)
+	assert.Zero(t, last)
+	_, err = db.GetConversation(1)
+	assert.Error(t, err)
+
+	m.fullResponse = "one"
+	m.saveConversation(nil)
+	assert.Equal(t, 1, convID)
+	msgs, err := db.Messages(1)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+}
+
+func TestSQLiteOnlyCommands(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 1
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID}
+	db := database.NewMemoryStore()
+	assert.NoError(t, db.InsertTurn(database.Turn{ConvID: 1, Prompt: "p", Response: "r", Model: "m"}))
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.handleSlashCommand("/star")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "/star needs the sqlite database backend")
+
+	// What every store has still works
+	updated, _ = m.handleSlashCommand("/title Memory")
+	m = updated.(Model)
+	title, err := db.GetTitle(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Memory", title)
+}
+
+func TestForkCommand(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 1
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID}
+	db, err := database.InitializeDB(":memory:", "tui_fork_test")
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.InsertConversation("first", "one", "m", 0.5, 1, 1, 1))
+	assert.NoError(t, db.InsertConversation("second", "two", "m", 0.5, 1, 1, 1))
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+
+	updated, _ = m.handleSlashCommand("/fork x")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "Usage")
+	assert.Equal(t, 1, *m.clientArgs.ConvID)
+
+	updated, _ = m.handleSlashCommand("/fork 1")
+	m = updated.(Model)
+	assert.Equal(t, 2, *m.clientArgs.ConvID)
+	assert.Contains(t, m.content, "Forked conversation 1 into 2")
+	assert.Len(t, m.clientArgs.Context, 2)
+
+	updated, _ = m.handleSlashCommand("/fork 5")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "has only 1 turns")
+	assert.Equal(t, 2, *m.clientArgs.ConvID)
+}
+
+func TestRecentContext(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 2
+	db, err := database.InitializeDB(":memory:", "tui_recent_test")
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.InsertConversation("earlier", "answer", "m", 0.5, 1, 1, 1))
+	recent, err := db.LoadRecentTurns(1)
+	assert.NoError(t, err)
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID, Context: recent}
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+
+	// The turn from another conversation isn't this one's to retry
+	updated, _ = m.handleSlashCommand("/retry")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "no prompt")
+
+	// It stays ahead of the conversation's own turns
+	assert.NoError(t, db.InsertConversation("now", "then", "m", 0.5, 1, 1, 2))
+	m.updateContext()
+	assert.Len(t, m.clientArgs.Context, 4)
+	assert.Equal(t, "earlier", m.clientArgs.Context[0].Content)
+	assert.Equal(t, "now", m.clientArgs.Context[2].Content)
+
+	updated, _ = m.handleSlashCommand("/new")
+	m = updated.(Model)
+	assert.Empty(t, m.clientArgs.Context)
+}
+
+func TestRetryAndAlternatives(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 1
+	temperature := float32(0.5)
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID, Temperature: &temperature}
+	db, err := database.InitializeDB(":memory:", "tui_retry_test")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+
+	updated, _ = m.handleSlashCommand("/retry")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "no prompt")
+
+	assert.NoError(t, db.InsertConversation("first", "one", "m", 0.5, 1, 1, 1))
+	assert.NoError(t, db.InsertConversation("secnod", "huh?", "m", 0.5, 1, 1, 1))
+	m.updateContext()
+
+	// /edit without text puts the last prompt up for editing
+	updated, _ = m.handleSlashCommand("/edit")
+	m = updated.(Model)
+	assert.Equal(t, "/edit secnod", m.textInput.Value())
+
+	updated, cmd := m.handleSlashCommand("/edit second")
+	m = updated.(Model)
+	assert.NotNil(t, cmd)
+	assert.True(t, m.replace)
+	assert.True(t, m.processing)
+	assert.Len(t, m.clientArgs.Context, 2)
+	assert.Equal(t, promptMsg{prompt: "second"}, cmd())
+
+	// Stand in for the model's answer
+	prompt := "second"
+	m.clientArgs.Prompt = &prompt
+	updated, _ = m.Update(streamChunkMsg{chunk: "two", done: true})
+	m = updated.(Model)
+	assert.False(t, m.replace)
+	assert.Len(t, m.clientArgs.Context, 4)
+	assert.Equal(t, "two", m.clientArgs.Context[3].Content)
+
+	alts, err := db.Alternatives(1)
+	assert.NoError(t, err)
+	assert.Len(t, alts, 2)
+
+	// Flipping back makes the original answer the history again
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyShiftLeft})
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "Version 1 of 2")
+	assert.Equal(t, "huh?", m.clientArgs.Context[3].Content)
+	assert.NotContains(t, m.content, "two\n")
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyShiftLeft})
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "Version 1 of 2")
+
+	updated, _ = m.handleSlashCommand("/alt")
+	m = updated.(Model)
+	assert.Contains(t, m.content, "* 1. [m] huh?")
+	assert.Contains(t, m.content, "  2. [m] two")
+
+	updated, _ = m.handleSlashCommand("/alt 2")
+	m = updated.(Model)
+	assert.Equal(t, "two", m.clientArgs.Context[3].Content)
+	updated, _ = m.handleSlashCommand("/alt 3")
+	m = updated.(Model)
+	assert.Contains(t, m.statusMsg, "Usage")
+}
+
+func TestRetryWithAnotherModel(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4, Provider: "openai"}
+	modelName := "m"
+	convID := 1
+	temperature := float32(0.5)
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID, Temperature: &temperature}
+	db, err := database.InitializeDB(":memory:", "tui_retry_model_test")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+	assert.NoError(t, db.InsertConversation("first", "one", "m", 0.5, 1, 1, 1))
+	m.updateContext()
+
+	updated, _ = m.handleSlashCommand("/retry other")
+	m = updated.(Model)
+	assert.Equal(t, "other", *m.clientArgs.Model)
+	// Stand in for the model's answer, from the provider it resolved to
+	m.opts.Provider = "anthropic"
+	prompt := "first"
+	m.clientArgs.Prompt = &prompt
+	updated, _ = m.Update(streamChunkMsg{chunk: "uno", done: true})
+	m = updated.(Model)
+
+	msgs, err := db.Messages(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", msgs[1].Model)
+	assert.Equal(t, "anthropic", msgs[1].Provider)
+	// The conversation goes on with the model it had
+	assert.Equal(t, "m", *m.clientArgs.Model)
+	assert.Equal(t, "openai", m.opts.Provider)
+	assert.Equal(t, "m", modelName)
+}
+
+func TestSaveConversationMetadata(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4,
+		Provider: "anthropic", Role: "coder"}
+	modelName := "m"
+	convID := 1
+	temperature := float32(0.5)
+	maxTokens := 256
+	thinking := "low"
+	system := "be brief"
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID, Temperature: &temperature,
+		MaxTokens: &maxTokens, Thinking: &thinking, SystemPrompt: &system}
+	db, err := database.InitializeDB(":memory:", "tui_metadata_test")
+	assert.NoError(t, err)
+	defer db.Close()
+
+	m := Initialize(opts, clientArgs, db)
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	m = updated.(Model)
+
+	updated, _ = m.send("first")
+	m = updated.(Model)
+	prompt := "first"
+	m.clientArgs.Prompt = &prompt
+	updated, _ = m.Update(streamChunkMsg{chunk: "one"})
+	m = updated.(Model)
+	updated, _ = m.Update(streamChunkMsg{done: true, finishReason: "end_turn"})
+	m = updated.(Model)
+
+	// A request that fails partway is recorded with its error
+	updated, _ = m.send("second")
+	m = updated.(Model)
+	prompt = "second"
+	updated, _ = m.Update(streamChunkMsg{chunk: "tw"})
+	m = updated.(Model)
+	updated, _ = m.Update(streamChunkMsg{done: true, err: errors.New("overloaded")})
+	m = updated.(Model)
+	assert.False(t, m.processing)
+	assert.Len(t, m.clientArgs.Context, 4)
+
+	msgs, err := db.Messages(1)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+	assert.Equal(t, "anthropic", msgs[1].Provider)
+	assert.Equal(t, "coder", msgs[1].RoleName)
+	assert.Equal(t, "be brief", msgs[1].SystemPrompt)
+	assert.Equal(t, "low", msgs[1].Thinking)
+	assert.Equal(t, 256, msgs[1].MaxTokens)
+	assert.Equal(t, "end_turn", msgs[1].FinishReason)
+	assert.Equal(t, "", msgs[1].Error)
+	assert.Equal(t, "tw", msgs[3].Content)
+	assert.Equal(t, "", msgs[3].FinishReason)
+	assert.Equal(t, "overloaded", msgs[3].Error)
+}
+
+func TestContinuedHistory(t *testing.T) {
+	opts := &config.Options{ScreenWidth: 120, ScreenTextWidth: 100, ScreenHeight: 40, TabWidth: 4}
+	modelName := "m"
+	convID := 2
+	db, err := database.InitializeDB(":memory:", "tui_history_test")
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.InsertConversation("elsewhere", "not shown", "m", 0.5, 1, 1, 1))
+	assert.NoError(t, db.InsertConversation("first", "one", "m", 0.5, 1, 1, 2))
+	assert.NoError(t, db.InsertConversation("second", "two", "m", 0.5, 1, 1, 2))
+	// As with --context, another conversation's turn comes first
+	other, err := db.LoadConversationFromDB(1)
+	assert.NoError(t, err)
+	context, err := db.LoadConversationFromDB(convID)
+	assert.NoError(t, err)
+	clientArgs := LLM.ClientArgs{Model: &modelName, ConvID: &convID, Context: append(other, context...)}
+
+	m := Initialize(opts, clientArgs, db)
+	assert.True(t, strings.HasPrefix(m.content, "Welcome to ask-ai!"))
+	assert.NotContains(t, m.content, "elsewhere")
+	assert.Less(t, strings.Index(m.content, "first"), strings.Index(m.content, "second"))
+	assert.Contains(t, m.content, "two")
+	// The last turn is the one a flip replaces
+	assert.Contains(t, m.content[m.turnStart:], "second")
+	assert.NotContains(t, m.content[m.turnStart:], "first")
+}